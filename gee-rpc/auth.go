@@ -0,0 +1,57 @@
+package geerpc
+
+import (
+	"errors"
+	"fmt"
+	"geerpc/codec"
+	"io"
+	"strings"
+)
+
+// ErrUnauthorized 鉴权失败时返回的错误，可以用 errors.Is 判断
+var ErrUnauthorized = errors.New("rpc: unauthorized")
+
+// AuthFunc 在交换完 Option 之后调用，token 为客户端 Option.AuthToken 的值，返回错误表示拒绝这个连接。
+// JSON-RPC 和网关的每个 HTTP 请求也会调用，token 从 AuthHeader 中读取，conn 为 nil
+type AuthFunc func(token string, conn io.ReadWriteCloser) error
+
+// authenticate 校验客户端的 token，并把结果作为握手响应发送给客户端，
+// 握手响应的 Seq 为 0，正常请求的 Seq 从 1 开始，所以不会冲突
+func (server *Server) authenticate(cc codec.Codec, opt *Option, conn io.ReadWriteCloser) error {
+	var err error
+	if server.AuthFunc != nil {
+		err = server.AuthFunc(opt.AuthToken, conn)
+	}
+	h := &codec.Header{}
+	if err != nil {
+		err = fmt.Errorf("%w: %s", ErrUnauthorized, err)
+		setError(h, err)
+	}
+	if werr := cc.Write(h, invalidRequest); werr != nil {
+		return werr
+	}
+	return err
+}
+
+// readHandshake 客户端读取服务端的握手响应
+func readHandshake(cc codec.Codec) error {
+	var h codec.Header
+	if err := cc.ReadHeader(&h); err != nil {
+		return err
+	}
+	if err := cc.ReadBody(nil); err != nil {
+		return err
+	}
+	if h.Error != "" {
+		return connError(h.Error)
+	}
+	return nil
+}
+
+// connError 把服务端发送的连接级别的错误信息还原成 error，鉴权失败的错误可以用 errors.Is(err, ErrUnauthorized) 判断
+func connError(msg string) error {
+	if strings.HasPrefix(msg, ErrUnauthorized.Error()) {
+		return fmt.Errorf("%w%s", ErrUnauthorized, strings.TrimPrefix(msg, ErrUnauthorized.Error()))
+	}
+	return errors.New(msg)
+}
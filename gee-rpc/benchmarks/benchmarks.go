@@ -0,0 +1,207 @@
+// Package benchmarks 测量 geerpc 在不同编解码方式、连接数和数据大小下的吞吐量和延迟，
+// 用于比较对象池、工作池这类性能相关的改动前后的差异，cmd/geerpc-bench 是它的命令行入口
+package benchmarks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"geerpc"
+	"geerpc/codec"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// Bench 是压测使用的服务，Echo 原样返回请求的数据
+type Bench struct{}
+
+func (Bench) Echo(args []byte, reply *[]byte) error {
+	*reply = args
+	return nil
+}
+
+// NewServer 返回注册了 Bench 服务的 Server，不输出日志
+func NewServer() *geerpc.Server {
+	server := geerpc.NewServer()
+	server.SetLogger(geerpc.NewLogger(geerpc.LevelOff))
+	_ = server.Register(Bench{})
+	return server
+}
+
+// Config 一次压测的参数
+type Config struct {
+	Addr        string     // 服务端地址，格式为 protocol@addr，为空时在本进程中启动一个服务端
+	Codec       codec.Type // 编解码方式，为空时使用 gob
+	Conns       int        // 连接数，默认 1
+	Concurrency int        // 并发调用的 goroutine 数，平均分配到各个连接上，默认等于 Conns
+	PayloadSize int        // 每次调用的请求和响应的字节数
+	Duration    time.Duration
+	Requests    int // 大于 0 时发送这么多请求之后结束，否则持续 Duration，默认 1s
+}
+
+func (cfg Config) String() string {
+	return fmt.Sprintf("codec=%s conns=%d concurrency=%d payload=%d", cfg.Codec, cfg.Conns, cfg.Concurrency, cfg.PayloadSize)
+}
+
+// Result 一次压测的结果
+type Result struct {
+	Config
+	Requests int           // 成功的调用数
+	Errors   int           // 失败的调用数
+	Elapsed  time.Duration // 实际压测的时间
+	P50      time.Duration
+	P90      time.Duration
+	P99      time.Duration
+	Max      time.Duration
+}
+
+// Throughput 每秒成功的调用数
+func (r *Result) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Requests) / r.Elapsed.Seconds()
+}
+
+// Run 按照 cfg 进行一次压测，ctx 结束之后提前停止
+func Run(ctx context.Context, cfg Config) (*Result, error) {
+	if cfg.Codec == "" {
+		cfg.Codec = codec.GobType
+	}
+	if codec.NewCodecFuncMap[cfg.Codec] == nil {
+		return nil, fmt.Errorf("rpc bench: unknown codec %s", cfg.Codec)
+	}
+	if cfg.Conns <= 0 {
+		cfg.Conns = 1
+	}
+	if cfg.Concurrency < cfg.Conns {
+		cfg.Concurrency = cfg.Conns
+	}
+	if cfg.Requests <= 0 && cfg.Duration <= 0 {
+		cfg.Duration = time.Second
+	}
+	addr := cfg.Addr
+	if addr == "" {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		defer func() { _ = l.Close() }()
+		go NewServer().Accept(l)
+		addr = "tcp@" + l.Addr().String()
+	}
+
+	clients := make([]*geerpc.Client, cfg.Conns)
+	defer func() {
+		for _, client := range clients {
+			if client != nil {
+				_ = client.Close()
+			}
+		}
+	}()
+	for i := range clients {
+		client, err := geerpc.XDial(addr, &geerpc.Option{CodecType: cfg.Codec, Logger: geerpc.NewLogger(geerpc.LevelOff)})
+		if err != nil {
+			return nil, err
+		}
+		clients[i] = client
+	}
+
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+	payload := make([]byte, cfg.PayloadSize)
+	var sent, errs int64
+	latencies := make([][]time.Duration, cfg.Concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			client := clients[i%len(clients)]
+			for ctx.Err() == nil {
+				if cfg.Requests > 0 && atomic.AddInt64(&sent, 1) > int64(cfg.Requests) {
+					return
+				}
+				var reply []byte
+				begin := time.Now()
+				err := client.Call(ctx, "Bench.Echo", payload, &reply)
+				if err != nil {
+					// 压测时间到了之后正在进行的调用被取消，不算作失败
+					if ctx.Err() == nil {
+						atomic.AddInt64(&errs, 1)
+					}
+					continue
+				}
+				latencies[i] = append(latencies[i], time.Since(begin))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	r := &Result{Config: cfg, Errors: int(errs), Elapsed: time.Since(start)}
+	var all []time.Duration
+	for _, l := range latencies {
+		all = append(all, l...)
+	}
+	r.Requests = len(all)
+	if r.Requests == 0 {
+		return r, errors.New("rpc bench: no successful calls")
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	percentile := func(p float64) time.Duration {
+		return all[int(float64(len(all)-1)*p)]
+	}
+	r.P50, r.P90, r.P99, r.Max = percentile(0.5), percentile(0.9), percentile(0.99), all[len(all)-1]
+	return r, nil
+}
+
+// Matrix 返回 codecs、conns 和 sizes 的所有组合，其他参数和 base 相同，codecs 为空时使用所有注册的编解码方式
+func Matrix(base Config, codecs []codec.Type, conns, sizes []int) []Config {
+	if len(codecs) == 0 {
+		for t := range codec.NewCodecFuncMap {
+			codecs = append(codecs, t)
+		}
+		sort.Slice(codecs, func(i, j int) bool { return codecs[i] < codecs[j] })
+	}
+	if len(conns) == 0 {
+		conns = []int{base.Conns}
+	}
+	if len(sizes) == 0 {
+		sizes = []int{base.PayloadSize}
+	}
+	var configs []Config
+	for _, t := range codecs {
+		for _, n := range conns {
+			for _, size := range sizes {
+				cfg := base
+				cfg.Codec, cfg.Conns, cfg.PayloadSize = t, n, size
+				if base.Concurrency > 0 && base.Concurrency < n {
+					cfg.Concurrency = n
+				}
+				configs = append(configs, cfg)
+			}
+		}
+	}
+	return configs
+}
+
+// WriteReport 把多次压测的结果输出成对齐的表格，方便比较
+func WriteReport(w io.Writer, results []*Result) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	_, _ = fmt.Fprintln(tw, "codec\tconns\tconcurrency\tpayload\trequests\terrors\tqps\tp50\tp90\tp99\tmax\t")
+	for _, r := range results {
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%.0f\t%s\t%s\t%s\t%s\t\n",
+			r.Codec, r.Conns, r.Concurrency, r.PayloadSize, r.Requests, r.Errors, r.Throughput(),
+			r.P50, r.P90, r.P99, r.Max)
+	}
+	return tw.Flush()
+}
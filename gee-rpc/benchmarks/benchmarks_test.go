@@ -0,0 +1,128 @@
+package benchmarks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"geerpc"
+	"geerpc/codec"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	configs := Matrix(Config{Requests: 50}, nil, []int{1, 2}, []int{16, 1024})
+	if len(configs) != 4*len(codec.NewCodecFuncMap) {
+		t.Fatalf("expect 4 configs per codec, got %d", len(configs))
+	}
+	var results []*Result
+	for _, cfg := range configs {
+		r, err := Run(context.Background(), cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Requests != 50 || r.Errors != 0 || r.P50 > r.P99 || r.P99 > r.Max {
+			t.Fatalf("unexpected result %+v", r)
+		}
+		results = append(results, r)
+	}
+	var buf bytes.Buffer
+	_ = WriteReport(&buf, results)
+	if lines := strings.Count(buf.String(), "\n"); lines != len(results)+1 {
+		t.Fatalf("expect a header and one line per result, got:\n%s", buf.String())
+	}
+}
+
+// BenchmarkEcho 在本进程中启动服务端，比较不同编解码方式和数据大小下单个连接的性能
+func BenchmarkEcho(b *testing.B) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go NewServer().Accept(l)
+	for t := range codec.NewCodecFuncMap {
+		for _, size := range []int{16, 1024, 64 * 1024} {
+			b.Run(fmt.Sprintf("%s/%d", t, size), func(b *testing.B) {
+				client, err := geerpc.Dial("tcp", l.Addr().String(), &geerpc.Option{CodecType: t})
+				if err != nil {
+					b.Fatal(err)
+				}
+				defer func() { _ = client.Close() }()
+				payload := make([]byte, size)
+				b.SetBytes(int64(size))
+				b.ResetTimer()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						var reply []byte
+						if err := client.Call(context.Background(), "Bench.Echo", payload, &reply); err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				})
+			})
+		}
+	}
+}
+
+// writeCounter 统计服务端连接上 Write 的调用次数，每次 Write 对应一次 write 系统调用
+type writeCounter struct {
+	net.Listener
+	writes int64
+}
+
+func (l *writeCounter) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &countedConn{Conn: conn, writes: &l.writes}, nil
+}
+
+type countedConn struct {
+	net.Conn
+	writes *int64
+}
+
+func (c *countedConn) Write(p []byte) (int, error) {
+	atomic.AddInt64(c.writes, 1)
+	return c.Conn.Write(p)
+}
+
+// BenchmarkCoalesce 比较开启写合并前后，大量并发调用共用一个连接时服务端每个回复的 write 次数（writes/op）
+func BenchmarkCoalesce(b *testing.B) {
+	for _, c := range []struct {
+		name   string
+		policy *geerpc.CoalescePolicy
+	}{
+		{"off", nil},
+		{"on", &geerpc.CoalescePolicy{}},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			server := NewServer()
+			server.Coalesce = c.policy
+			l, _ := net.Listen("tcp", "127.0.0.1:0")
+			lis := &writeCounter{Listener: l}
+			defer func() { _ = l.Close() }()
+			go server.Accept(lis)
+			client, err := geerpc.Dial("tcp", l.Addr().String())
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer func() { _ = client.Close() }()
+			payload := make([]byte, 16)
+			b.SetParallelism(64)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					var reply []byte
+					if err := client.Call(context.Background(), "Bench.Echo", payload, &reply); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.ReportMetric(float64(atomic.LoadInt64(&lis.writes))/float64(b.N), "writes/op")
+		})
+	}
+}
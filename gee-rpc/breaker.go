@@ -0,0 +1,184 @@
+package geerpc
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrBreakerOpen 熔断器打开期间的调用不会发送到服务端，直接返回这个错误
+var ErrBreakerOpen = errors.New("rpc client: circuit breaker is open")
+
+// BreakerState 熔断器的状态
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // 正常放行所有调用，统计失败率
+	BreakerOpen                         // 失败率过高，所有调用直接失败
+	BreakerHalfOpen                     // 打开一段时间之后放行少量探测调用，全部成功才关闭
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	default:
+		return "half-open"
+	}
+}
+
+// BreakerPolicy 设置在 Option.Breaker 中之后，Client.Call 和 XClient 对每个服务端的调用都会经过熔断器，
+// 后端频繁出错时快速失败，而不是让每个请求都等到超时
+type BreakerPolicy struct {
+	FailureRate float64       // 窗口内失败率达到这个值时打开熔断器，默认 0.5
+	MinRequests int           // 窗口内至少有这么多次调用才计算失败率，默认 10
+	Window      time.Duration // 统计失败率的时间窗口，默认 10s
+	OpenTimeout time.Duration // 打开之后经过这么久进入半开状态，默认 5s
+	// HalfOpenRequests 半开状态下放行的探测调用数，默认 1
+	HalfOpenRequests int
+	// IsFailure 判断错误是否计入失败，为空时连接错误、服务端过载和超时算作失败，服务方法返回的业务错误不算
+	IsFailure func(err error) bool
+	// OnStateChange 在熔断器状态变化时调用，调用时持有熔断器的锁，不能在其中调用 Breaker 的方法
+	OnStateChange func(from, to BreakerState)
+}
+
+// Breaker 是一个按照失败率打开的熔断器，零值不可用，使用 NewBreaker 创建
+type Breaker struct {
+	policy BreakerPolicy
+
+	mu          sync.Mutex
+	state       BreakerState
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probes      int // 半开状态下已经放行的探测调用数
+	successes   int // 半开状态下成功的探测调用数
+}
+
+// NewBreaker 按照 policy 创建熔断器，policy 中的零值使用默认值
+func NewBreaker(policy BreakerPolicy) *Breaker {
+	if policy.FailureRate <= 0 {
+		policy.FailureRate = 0.5
+	}
+	if policy.MinRequests <= 0 {
+		policy.MinRequests = 10
+	}
+	if policy.Window <= 0 {
+		policy.Window = 10 * time.Second
+	}
+	if policy.OpenTimeout <= 0 {
+		policy.OpenTimeout = 5 * time.Second
+	}
+	if policy.HalfOpenRequests <= 0 {
+		policy.HalfOpenRequests = 1
+	}
+	if policy.IsFailure == nil {
+		policy.IsFailure = isBreakerFailure
+	}
+	return &Breaker{policy: policy, windowStart: time.Now()}
+}
+
+// isBreakerFailure 默认的失败判断，除了 IsTransient 之外，超时也说明后端有问题
+func isBreakerFailure(err error) bool {
+	if IsTransient(err) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "deadline exceeded") || strings.Contains(msg, "timeout")
+}
+
+// State 返回熔断器当前的状态
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance(time.Now())
+	return b.state
+}
+
+// Do 在熔断器允许的时候执行 f 并记录结果，b 为空时直接执行 f
+func (b *Breaker) Do(f func() error) error {
+	if b == nil {
+		return f()
+	}
+	if !b.allow() {
+		return ErrBreakerOpen
+	}
+	err := f()
+	b.record(err != nil && b.policy.IsFailure(err))
+	return err
+}
+
+// advance 根据时间推进状态：打开超时之后进入半开，关闭状态下窗口到期之后重新计数，调用方需要持有 mu
+func (b *Breaker) advance(now time.Time) {
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) >= b.policy.OpenTimeout {
+			b.setState(BreakerHalfOpen)
+		}
+	case BreakerClosed:
+		if now.Sub(b.windowStart) >= b.policy.Window {
+			b.windowStart, b.requests, b.failures = now, 0, 0
+		}
+	}
+}
+
+func (b *Breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance(time.Now())
+	switch b.state {
+	case BreakerOpen:
+		return false
+	case BreakerHalfOpen:
+		if b.probes >= b.policy.HalfOpenRequests {
+			return false
+		}
+		b.probes++
+	}
+	return true
+}
+
+func (b *Breaker) record(failed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerHalfOpen:
+		if failed {
+			b.setState(BreakerOpen)
+			return
+		}
+		if b.successes++; b.successes >= b.policy.HalfOpenRequests {
+			b.setState(BreakerClosed)
+		}
+	case BreakerClosed:
+		b.requests++
+		if failed {
+			b.failures++
+		}
+		if b.requests >= b.policy.MinRequests && float64(b.failures) >= b.policy.FailureRate*float64(b.requests) {
+			b.setState(BreakerOpen)
+		}
+	}
+}
+
+// setState 切换状态并重置对应的计数，调用方需要持有 mu
+func (b *Breaker) setState(state BreakerState) {
+	from := b.state
+	b.state = state
+	now := time.Now()
+	switch state {
+	case BreakerOpen:
+		b.openedAt = now
+	case BreakerHalfOpen:
+		b.probes, b.successes = 0, 0
+	case BreakerClosed:
+		b.windowStart, b.requests, b.failures = now, 0, 0
+	}
+	if b.policy.OnStateChange != nil && from != state {
+		b.policy.OnStateChange(from, state)
+	}
+}
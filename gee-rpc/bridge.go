@@ -0,0 +1,51 @@
+package geerpc
+
+import (
+	"context"
+	"reflect"
+
+	"geerpc/codec"
+)
+
+// PreparedCall 是一次在进程内发起的调用，供 gRPC 这类其他协议的适配层使用：
+// 先把请求解码到 Args 中，再调用 Do，和普通请求一样经过鉴权、参数校验和中间件
+type PreparedCall struct {
+	Args     interface{}       // 参数的指针，调用 Do 之前把请求解码到这里
+	Metadata map[string]string // 请求的 Metadata，服务方法通过 IncomingMetadata 读取
+	server   *Server
+	req      *request
+}
+
+// Prepare 为 serviceMethod 创建一次进程内调用，找不到方法或者是流式方法时返回 CodeNotFound
+func (server *Server) Prepare(serviceMethod string) (*PreparedCall, error) {
+	svc, mtype, err := server.findService(serviceMethod)
+	if err != nil {
+		return nil, err
+	}
+	if mtype.stream {
+		return nil, Errorf(CodeNotFound, "rpc server: stream method can't be called in process: %s", serviceMethod)
+	}
+	req := &request{
+		h:      &codec.Header{ServiceMethod: serviceMethod},
+		svc:    svc,
+		mtype:  mtype,
+		argv:   mtype.newArgv(),
+		replyv: mtype.newReplyv(),
+	}
+	args := req.argv.Interface()
+	if req.argv.Kind() != reflect.Ptr {
+		args = req.argv.Addr().Interface()
+	}
+	return &PreparedCall{Args: args, server: server, req: req}, nil
+}
+
+// Do 调用服务方法，返回回复的指针，ctx 的截止时间和取消同样会传递给服务方法
+func (c *PreparedCall) Do(ctx context.Context) (interface{}, error) {
+	c.req.ctx = ctx
+	c.req.h.Metadata = c.Metadata
+	ensureRequestID(c.req.h)
+	if err := c.server.invoke(c.req); err != nil {
+		return nil, err
+	}
+	return c.req.replyv.Interface(), nil
+}
@@ -0,0 +1,123 @@
+package geerpc
+
+import (
+	"container/list"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// CachePolicy 设置一个方法的响应缓存，只适合结果只取决于参数的幂等方法，比如查询配置、读取热点数据
+type CachePolicy struct {
+	TTL  time.Duration // 结果缓存的时间，必须大于 0
+	Size int           // 最多缓存的结果数，超过之后淘汰最久没有使用的，默认 1000
+	// Key 根据参数计算缓存的 key，返回空字符串表示这次调用不使用缓存，
+	// 为空时使用 fmt.Sprintf("%#v", args)，参数中有指针、map 这类字段时需要自己设置
+	Key func(args interface{}) string
+}
+
+// SetCache 为 serviceMethod 开启响应缓存，policy 为空表示关闭。命中缓存时不会执行中间件和服务方法，
+// 直接回复缓存的结果，多个回复共享同一个结果，所以服务方法返回之后不能再修改 reply。
+// 缓存在鉴权和参数校验之后检查，流式方法不会缓存
+func (server *Server) SetCache(serviceMethod string, policy *CachePolicy) {
+	if policy == nil || policy.TTL <= 0 {
+		server.caches.Delete(serviceMethod)
+		return
+	}
+	server.caches.Store(serviceMethod, newResponseCache(*policy))
+}
+
+// responseCache 是一个带有过期时间的 LRU
+type responseCache struct {
+	policy  CachePolicy
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List // 最近使用的在前面
+}
+
+type cacheEntry struct {
+	key     string
+	reply   reflect.Value
+	expires time.Time
+}
+
+func newResponseCache(policy CachePolicy) *responseCache {
+	if policy.Size <= 0 {
+		policy.Size = 1000
+	}
+	return &responseCache{policy: policy, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+func (c *responseCache) key(argv reflect.Value) string {
+	args := argv.Interface()
+	if c.policy.Key != nil {
+		return c.policy.Key(args)
+	}
+	if argv.Kind() == reflect.Ptr {
+		args = argv.Elem().Interface()
+	}
+	return fmt.Sprintf("%#v", args)
+}
+
+func (c *responseCache) get(key string) (reflect.Value, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return reflect.Value{}, false
+	}
+	entry := e.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.lru.Remove(e)
+		delete(c.entries, key)
+		return reflect.Value{}, false
+	}
+	c.lru.MoveToFront(e)
+	return entry.reply, true
+}
+
+func (c *responseCache) add(key string, reply reflect.Value) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &cacheEntry{key: key, reply: reply, expires: time.Now().Add(c.policy.TTL)}
+	if e, ok := c.entries[key]; ok {
+		e.Value = entry
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.policy.Size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// cachedInvoke 在 req 对应的方法开启了缓存时先查找缓存，没有命中再调用 call，成功之后保存结果
+func (server *Server) cachedInvoke(req *request, call func() error) error {
+	if req.mtype.stream {
+		return call()
+	}
+	ci, ok := server.caches.Load(req.h.ServiceMethod)
+	if !ok {
+		return call()
+	}
+	cache := ci.(*responseCache)
+	key := cache.key(req.argv)
+	if key == "" {
+		return call()
+	}
+	if reply, ok := cache.get(key); ok {
+		req.replyv.Elem().Set(reply)
+		return nil
+	}
+	if err := call(); err != nil {
+		return err
+	}
+	// 保存结果的副本，和这个请求的 replyv 互不影响
+	reply := reflect.New(req.replyv.Elem().Type()).Elem()
+	reply.Set(req.replyv.Elem())
+	cache.add(key, reply)
+	return nil
+}
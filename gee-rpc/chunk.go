@@ -0,0 +1,85 @@
+package geerpc
+
+import (
+	"errors"
+	"geerpc/codec"
+	"sync"
+)
+
+// sendBody 发送 req 的回复，超过 Server.ChunkSize 时分块发送，返回写入的字节数
+func (server *Server) sendBody(cc codec.Codec, req *request, body interface{}, sending *sync.Mutex) int {
+	if data := server.chunkedBody(cc, req, body); data != nil {
+		return server.sendChunks(cc, req, data, sending)
+	}
+	return server.sendResponse(cc, req.h, body, sending)
+}
+
+// chunkedBody 需要分块发送时返回回复编码之后的数据，否则返回 nil
+func (server *Server) chunkedBody(cc codec.Codec, req *request, body interface{}) []byte {
+	if server.ChunkSize <= 0 || req.h.Error != "" || req.cs == nil || !req.cs.chunked {
+		return nil
+	}
+	m, ok := cc.(codec.BodyMarshaler)
+	if !ok {
+		return nil
+	}
+	// 编码失败时按照原来的方式发送，由 sendResponse 报告错误
+	data, err := m.MarshalBody(body)
+	if err != nil || len(data) <= server.ChunkSize {
+		return nil
+	}
+	return data
+}
+
+// sendChunks 把 data 拆成 ChunkSize 大小的帧发送，每一帧单独加锁，最后一帧使用 req.h 并带有 FlagEnd，
+// 请求被取消之后不再发送剩下的帧，客户端会丢弃已经收到的部分
+func (server *Server) sendChunks(cc codec.Codec, req *request, data []byte, sending *sync.Mutex) int {
+	var n int
+	for len(data) > server.ChunkSize {
+		if req.isCanceled() {
+			return n
+		}
+		h := &codec.Header{Seq: req.h.Seq, Flags: codec.FlagChunk}
+		n += server.sendResponse(cc, h, data[:server.ChunkSize], sending)
+		data = data[server.ChunkSize:]
+	}
+	req.h.Flags |= codec.FlagChunk | codec.FlagEnd
+	return n + server.sendResponse(cc, req.h, data, sending)
+}
+
+// receiveChunk 接收分块发送的回复，收到带有 FlagEnd 的最后一帧之后解码完整的回复，
+// 已经被取消或者超时的调用的帧直接丢弃
+func (client *Client) receiveChunk(h *codec.Header) error {
+	var part []byte
+	if err := client.cc.ReadBody(&part); err != nil {
+		return err
+	}
+	if h.Flags&codec.FlagEnd == 0 {
+		client.mu.Lock()
+		call := client.pending[h.Seq]
+		client.mu.Unlock()
+		if call != nil {
+			call.chunks = append(call.chunks, part...)
+		}
+		return nil
+	}
+	call := client.removeCall(h.Seq)
+	if call == nil {
+		return nil
+	}
+	data := append(call.chunks, part...)
+	call.chunks = nil
+	m, ok := client.cc.(codec.BodyMarshaler)
+	switch {
+	case h.Error != "":
+		call.Error = headerError(h)
+	case !ok:
+		call.Error = errors.New("rpc client: codec doesn't support chunked replies")
+	default:
+		if err := m.UnmarshalBody(data, call.Reply); err != nil {
+			call.Error = errors.New("reading body " + withTypeHint(err).Error())
+		}
+	}
+	call.done()
+	return nil
+}
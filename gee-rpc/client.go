@@ -3,6 +3,7 @@ package geerpc
 import (
 	"bufio"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -13,6 +14,7 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -24,10 +26,22 @@ type Call struct {
 	Reply         interface{} // 函数返回值
 	Error         error       // 如果发生错误，将错误设置在这个变量上
 	Done          chan *Call  // 用于接收当 Call 完成，用于支持异步调用
+	// Metadata 随请求头一起发送给服务端，拦截器可以在发送之前修改
+	Metadata map[string]string
+	// Priority 请求的优先级，服务端的工作池优先执行高优先级的请求，参考 WithPriority
+	Priority Priority
+	timeout  time.Duration // 发送给服务端的剩余超时时间
+	deadline time.Duration // WithTimeout 设置的这次调用的超时时间
+	finished chan struct{} // Go 和 GoContext 创建，Call 完成时关闭，用于 Await 和取消
+	chunks   []byte        // 已经收到的分块回复，只在 receive 中访问
+	stream   *ClientStream // 不为空表示这是一个流式调用
 }
 
 // 当 Call 执行完成的时候，调用该函数通知调用方告知 Call 已经执行完成
 func (call *Call) done() {
+	if call.finished != nil {
+		close(call.finished)
+	}
 	call.Done <- call
 }
 
@@ -40,52 +54,211 @@ type Client struct {
 	sending  sync.Mutex
 	header   codec.Header
 	mu       sync.Mutex
-	seq      uint64           // 用于给发送的请求编号，每个请求有唯一编号
+	seqGen   SeqGenerator     // 用于给发送的请求编号，每个请求有唯一编号
 	pending  map[uint64]*Call // 每个序列号标记独一无二的Call，Q：如果序列号用完了呢？
 	closing  bool             // 用户调用了关闭函数 Call
 	shutdown bool             // server 端告知用户关闭，如果这个设置成 true 了，一般是有错误发生的
+
+	interceptors []CallInterceptor
+
+	lastRecv int64 // 最近一次收到数据的时间，UnixNano
+	closeErr error // 心跳超时或者服务端即将关闭时设置，receive 结束时代替读取连接的错误
+	// goingAway 收到了服务端的 FlagGoAway，不再发起新的调用，未完成的调用都结束之后关闭连接
+	goingAway bool
+	// draining 正在 CloseContext 中等待未完成的调用，不再发起新的调用，drained 在 pending 为空时关闭
+	draining bool
+	drained  chan struct{}
+	quit     chan struct{} // receive 结束时关闭，通知心跳停止
+
+	closed chan struct{}               // 用户调用 Close 时关闭，用于停止重连
+	redial func() (codec.Codec, error) // 不为空表示开启了自动重连
+
+	breaker *Breaker // 设置了 Option.Breaker 时创建，重连之后继续使用
+
+	slots chan struct{} // 设置了 Option.MaxPendingCalls 时创建，pending 中的每个 Call 占用一个名额
 }
 
 var _ io.Closer = (*Client)(nil)
 
+// ClientInterface 是 Client 的同步调用、异步调用和关闭，依赖 geerpc 的代码使用它代替 *Client，
+// 测试时可以替换为 MockClient，不需要启动服务端
+type ClientInterface interface {
+	Call(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error
+	Go(serviceMethod string, args, reply interface{}, done chan *Call, opts ...CallOption) *Call
+	Close() error
+}
+
+var _ ClientInterface = (*Client)(nil)
+
 var ErrShutdown = errors.New("connection is shut down")
 
-// Close 客户端调用关闭链接
-func (client *Client) Close() error {
+// ErrGoingAway 服务端正在关闭，已经通知客户端不要在这个连接上发起新的调用，换一个连接重试就可以
+var ErrGoingAway = NewError(CodeUnavailable, "rpc client: server is going away")
+
+// goAway 处理服务端的 FlagGoAway，之后的调用直接返回 ErrGoingAway，已经发出的调用正常完成，
+// 没有未完成的调用时立即断开，开启了自动重连时会重新连接
+func (client *Client) goAway() {
 	client.mu.Lock()
 	defer client.mu.Unlock()
+	if client.goingAway {
+		return
+	}
+	client.opt.logger().Infof("rpc client: server is going away, %d calls in flight", len(client.pending))
+	client.goingAway = true
+	client.closeErr = ErrGoingAway
+	if len(client.pending) == 0 {
+		_ = client.cc.Close()
+	}
+}
+
+// ErrTooManyPending 等待回复的请求数达到了 Option.MaxPendingCalls，并且开启了 FailFastOnMaxPending
+var ErrTooManyPending = errors.New("rpc client: too many pending calls")
+
+// Close 立即关闭连接，和 CloseNow 相同，需要等待未完成的调用时使用 CloseContext
+func (client *Client) Close() error {
+	return client.CloseNow()
+}
+
+// CloseNow 立即关闭连接，还没有完成的调用以 ErrShutdown 结束，重复关闭时返回 ErrShutdown
+func (client *Client) CloseNow() error {
+	client.mu.Lock()
 	if client.closing {
+		client.mu.Unlock()
 		return ErrShutdown
 	}
 	client.closing = true
-	return client.cc.Close()
+	client.closeErr = ErrShutdown
+	close(client.closed)
+	err := client.cc.Close()
+	client.mu.Unlock()
+	if client.opt.OnClosed != nil {
+		client.opt.OnClosed(client)
+	}
+	return err
+}
+
+// CloseContext 优雅地关闭连接：之后的调用直接返回 ErrShutdown，等待已经发出的调用（包括流式调用）都完成之后再关闭，
+// ctx 先结束时调用 CloseNow 结束剩下的调用，并返回 ctx 的错误
+func (client *Client) CloseContext(ctx context.Context) error {
+	client.mu.Lock()
+	if client.closing || client.draining {
+		client.mu.Unlock()
+		return ErrShutdown
+	}
+	client.draining = true
+	drained := make(chan struct{})
+	if len(client.pending) == 0 {
+		close(drained)
+	} else {
+		client.drained = drained
+	}
+	client.mu.Unlock()
+	select {
+	case <-drained:
+		return client.CloseNow()
+	case <-ctx.Done():
+		_ = client.CloseNow()
+		return ctx.Err()
+	}
+}
+
+// checkDrained 在 CloseContext 等待的调用都完成之后通知它，调用方需要持有 client.mu
+func (client *Client) checkDrained() {
+	if client.drained != nil && len(client.pending) == 0 {
+		close(client.drained)
+		client.drained = nil
+	}
+}
+
+// cancel 放弃一个还没有完成的请求，并通知服务端不需要再处理，之后收到的回复会被直接丢弃，
+// 请求已经完成时返回 false
+func (client *Client) cancel(call *Call) bool {
+	if client.removeCall(call.Seq) == nil {
+		return false
+	}
+	_ = client.write(&codec.Header{ServiceMethod: call.ServiceMethod, Seq: call.Seq, Flags: codec.FlagCancel}, invalidRequest)
+	return true
+}
+
+// numPending 返回还没有完成的请求数量
+func (client *Client) numPending() int {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return len(client.pending)
 }
 
 func (client *Client) IsAvailable() bool {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	return !client.shutdown && !client.closing
+	return !client.shutdown && !client.closing && !client.goingAway && !client.draining
+}
+
+// acquire 为一个请求占用 pending 的名额，名额用完时等待其他请求完成，
+// 开启了 FailFastOnMaxPending 时直接返回 ErrTooManyPending，没有设置 MaxPendingCalls 时什么也不做
+func (client *Client) acquire(ctx context.Context) error {
+	if client.slots == nil {
+		return nil
+	}
+	select {
+	case client.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if client.opt.FailFastOnMaxPending {
+		return ErrTooManyPending
+	}
+	select {
+	case client.slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return errors.New("rpc client:" + ctx.Err().Error())
+	case <-client.closed:
+		return ErrShutdown
+	}
+}
+
+// release 归还 acquire 占用的名额，Call 离开 pending 或者没有注册成功时调用
+func (client *Client) release() {
+	if client.slots != nil {
+		<-client.slots
+	}
 }
 
-// 注册一个请求，核心要点就是把 Call 放到 pending 中
+// 注册一个请求，核心要点就是把 Call 放到 pending 中，调用之前需要先通过 acquire 占用名额
 func (client *Client) registerCall(call *Call) (uint64, error) {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	if client.closing || client.shutdown {
+	if client.closing || client.shutdown || client.draining {
+		client.release()
 		return 0, ErrShutdown
 	}
-	call.Seq = client.seq
-	client.pending[call.Seq] = call
-	client.seq++
-	return call.Seq, nil
+	if client.goingAway {
+		client.release()
+		return 0, ErrGoingAway
+	}
+	seq, err := client.nextSeq()
+	if err != nil {
+		client.release()
+		return 0, err
+	}
+	call.Seq = seq
+	client.pending[seq] = call
+	return seq, nil
 }
 
 // 删除制定请求，从 pending 中删除就可以了
 func (client *Client) removeCall(seq uint64) *Call {
 	client.mu.Lock()
 	defer client.mu.Unlock()
-	call := client.pending[seq]
-	delete(client.pending, seq)
+	call, ok := client.pending[seq]
+	if ok {
+		delete(client.pending, seq)
+		client.release()
+		if client.goingAway && len(client.pending) == 0 {
+			_ = client.cc.Close() // 服务端即将关闭，最后一个调用完成之后主动断开
+		}
+		client.checkDrained()
+	}
 	return call
 }
 
@@ -98,32 +271,65 @@ func (client *Client) terminateCalls(err error) {
 	client.shutdown = true
 	for _, call := range client.pending {
 		call.Error = err
+		if call.stream != nil {
+			call.stream.recv.finish(err)
+		}
 		call.done()
+		client.release()
 	}
+	// 已经结束的 Call 不能留在 pending 中，否则之后的取消和超时会再次结束它们
+	client.pending = make(map[uint64]*Call)
+	client.checkDrained()
 }
 
-func (client *Client) send(call *Call) {
+// send 占用 pending 的名额之后发送请求，等待名额时不持有 sending 锁
+func (client *Client) send(ctx context.Context, call *Call) {
+	if err := client.acquire(ctx); err != nil {
+		call.Error = err
+		call.done()
+		return
+	}
 	client.sending.Lock()
 	defer client.sending.Unlock()
+	client.writeCall(call, client.cc.Write)
+}
 
+// writeCall 注册并写入一个请求，调用方需要持有 sending 锁，写入失败时直接结束这个 Call
+func (client *Client) writeCall(call *Call, write func(*codec.Header, interface{}) error) bool {
 	seq, err := client.registerCall(call)
 	if err != nil {
 		call.Error = err
 		call.done()
-		return
+		return false
 	}
 
 	client.header.ServiceMethod = call.ServiceMethod
 	client.header.Seq = call.Seq
 	client.header.Error = ""
+	client.header.Code = 0
+	if call.Metadata[RequestIDKey] == "" {
+		if call.Metadata == nil {
+			call.Metadata = make(map[string]string, 1)
+		}
+		call.Metadata[RequestIDKey] = newRequestID()
+	}
+	client.header.Metadata = call.Metadata
+	client.header.Timeout = call.timeout
+	client.header.Flags = 0
+	client.header.Priority = uint8(call.Priority)
+	if call.stream != nil && call.stream.sendClosed {
+		client.header.Flags = codec.FlagEnd
+	}
 
-	if err := client.cc.Write(&client.header, call.Args); err != nil {
+	if err := write(&client.header, call.Args); err != nil {
 		call := client.removeCall(seq)
 		if call != nil {
-			call.Error = err
+			call.Error = withTypeHint(err)
 			call.done()
 		}
+		return false
 	}
+	return true
 }
 
 // 接收请求，可能有三种情况
@@ -137,26 +343,69 @@ func (client *Client) receive() {
 		if err = client.cc.ReadHeader(&h); err != nil {
 			break
 		}
+		atomic.StoreInt64(&client.lastRecv, time.Now().UnixNano())
+		if h.Flags&codec.FlagPong != 0 {
+			err = client.cc.ReadBody(nil)
+			continue
+		}
+		if h.Flags&codec.FlagGoAway != 0 {
+			if err = client.cc.ReadBody(nil); err == nil {
+				client.goAway()
+			}
+			continue
+		}
+		if h.Flags&codec.FlagStream != 0 {
+			err = client.receiveStream(&h)
+			continue
+		}
+		if h.Flags&codec.FlagChunk != 0 {
+			err = client.receiveChunk(&h)
+			continue
+		}
 		call := client.removeCall(h.Seq)
 		switch {
+		case h.Seq == 0 && h.Error != "":
+			// Seq 为 0 的是服务端发来的连接级别的错误，比如鉴权失败
+			_ = client.cc.ReadBody(nil)
+			err = connError(h.Error)
 		case call == nil:
 			err = client.cc.ReadBody(nil)
-		case h.Error != "":
-			call.Error = fmt.Errorf(h.Error)
+		case call.stream != nil:
+			// 流式调用在开始之前就失败了，比如找不到服务或者被限流
 			err = client.cc.ReadBody(nil)
+			call.Error = headerError(&h)
+			call.stream.recv.finish(call.Error)
+			call.done()
+		case h.Error != "":
+			call.Error = headerError(&h)
+			err = client.readErrorDetails(&h, call.Error)
 			call.done()
 		default:
 			err = client.cc.ReadBody(call.Reply)
 			if err != nil {
-				call.Error = errors.New("reading body " + err.Error())
+				call.Error = errors.New("reading body " + withTypeHint(err).Error())
 			}
 			call.done()
 		}
 	}
+	client.mu.Lock()
+	if client.closeErr != nil {
+		err = client.closeErr
+	}
+	closing := client.closing
+	client.mu.Unlock()
 	client.terminateCalls(err)
+	close(client.quit)
+	// 用户主动关闭时通过 OnClosed 通知，不算作错误
+	if !closing && client.opt.OnError != nil {
+		client.opt.OnError(client, err)
+	}
+	if client.redial != nil {
+		go client.reconnect(err)
+	}
 }
 
-func (client *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+func (client *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call, opts ...CallOption) *Call {
 	if done == nil {
 		done = make(chan *Call, 10)
 	} else if cap(done) == 0 {
@@ -167,11 +416,90 @@ func (client *Client) Go(serviceMethod string, args, reply interface{}, done cha
 		Args:          args,
 		Reply:         reply,
 		Done:          done,
+		finished:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(call)
+	}
+	call.timeout = call.deadline
+	client.send(context.Background(), call)
+	if call.deadline > 0 {
+		d := call.deadline
+		time.AfterFunc(d, func() {
+			// 已经完成的 Call 不在 pending 中，这里什么也不会做
+			if client.cancel(call) {
+				call.Error = fmt.Errorf("rpc client: call timeout: expect within %s", d)
+				call.done()
+			}
+		})
 	}
-	client.send(call)
 	return call
 }
 
+// GoContext 与 Go 相同，ctx 中的 Metadata 和截止时间会发送给服务端，
+// ctx 结束时如果调用还没有完成，会通知服务端取消，并且以 ctx 的错误结束这个 Call
+func (client *Client) GoContext(ctx context.Context, serviceMethod string, args, reply interface{}, done chan *Call, opts ...CallOption) *Call {
+	if done == nil {
+		done = make(chan *Call, 10)
+	} else if cap(done) == 0 {
+		log.Panic("rpc client: done channel is unbuffered")
+	}
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          done,
+		Metadata:      copyMetadata(MetadataFromContext(ctx)),
+		finished:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(call)
+	}
+	if call.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, call.deadline)
+		return client.goContext(ctx, cancel, call)
+	}
+	return client.goContext(ctx, func() {}, call)
+}
+
+// goContext 发送请求并在 ctx 结束时取消，cancel 在 Call 完成之后调用
+func (client *Client) goContext(ctx context.Context, cancel context.CancelFunc, call *Call) *Call {
+	if deadline, ok := ctx.Deadline(); ok {
+		if call.timeout = time.Until(deadline); call.timeout <= 0 {
+			cancel()
+			call.Error = errors.New("rpc client:" + context.DeadlineExceeded.Error())
+			call.done()
+			return call
+		}
+	}
+	if ctx.Done() == nil {
+		client.send(ctx, call)
+		return call
+	}
+	client.send(ctx, call)
+	go func() {
+		defer cancel()
+		select {
+		case <-call.finished:
+		case <-ctx.Done():
+			if client.cancel(call) {
+				call.Error = errors.New("rpc client:" + ctx.Err().Error())
+				call.done()
+			}
+		}
+	}()
+	return call
+}
+
+// WithTimeout 为这次调用设置超时时间，不需要传入带有截止时间的 ctx，
+// 对 Call 来说和 ctx 的截止时间一起生效，以先到的为准，超时时间同样会传递给服务端
+func WithTimeout(d time.Duration) CallOption {
+	return func(call *Call) {
+		call.deadline = d
+	}
+}
+
 /*
 // Day 1 ~ Day 3
 func (client *Client) Call(serviceMethod string, args, reply interface{}) error {
@@ -180,75 +508,238 @@ func (client *Client) Call(serviceMethod string, args, reply interface{}) error
 }
 */
 
-func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	call := client.Go(serviceMethod, args, reply, make(chan *Call, 1))
+func (client *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error {
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Metadata:      copyMetadata(MetadataFromContext(ctx)),
+	}
+	for _, opt := range opts {
+		opt(call)
+	}
+	if call.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, call.deadline)
+		defer cancel()
+	}
+	invoke := client.chain(client.invoke)
+	return client.opt.Retry.Do(ctx, serviceMethod, func() error {
+		return client.breaker.Do(func() error { return invoke(ctx, call) })
+	})
+}
+
+// Invoke 是带有类型检查的 Call，参数和返回值的类型由 Req 和 Resp 决定，不需要自己创建 reply 的指针，比如：
+//
+//	sum, err := geerpc.Invoke[Args, int](ctx, client, "Foo.Sum", Args{Num1: 1, Num2: 2})
+func Invoke[Req, Resp any](ctx context.Context, client *Client, serviceMethod string, req Req, opts ...CallOption) (Resp, error) {
+	var resp Resp
+	err := client.Call(ctx, serviceMethod, req, &resp, opts...)
+	return resp, err
+}
+
+// invoke 是拦截器链的最后一环，每次调用都会重新发送请求，所以拦截器可以多次调用来实现重试
+func (client *Client) invoke(ctx context.Context, call *Call) error {
+	call.Error = nil
+	call.Done = make(chan *Call, 1)
+	// 把 ctx 剩余的时间告诉服务端，服务端可以跳过已经超时的请求
+	call.timeout = 0
+	if deadline, ok := ctx.Deadline(); ok {
+		if call.timeout = time.Until(deadline); call.timeout <= 0 {
+			return errors.New("rpc client:" + context.DeadlineExceeded.Error())
+		}
+	}
+	client.send(ctx, call)
 	select {
 	case <-ctx.Done():
-		client.removeCall(call.Seq)
+		client.cancel(call)
 		return errors.New("rpc client:" + ctx.Err().Error())
 	case call := <-call.Done:
 		return call.Error
 	}
 }
 
-func parseOptions(opts ...*Option) (*Option, error) {
-	if len(opts) == 0 || (opts[0] == nil) {
-		return DefaultOption, nil
+// CallBatch 在一次加锁和一次 Flush 中发送多个请求，然后等待所有请求完成，
+// 每个 Call 的结果分别保存在各自的 Error 和 Reply 中，返回的错误只表示 ctx 在完成之前结束了，
+// 调用方不需要再读取 Done，没有设置 Metadata 的 Call 会使用 ctx 中的 Metadata，CallBatch 不经过拦截器
+func (client *Client) CallBatch(ctx context.Context, calls []*Call) error {
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		if timeout = time.Until(deadline); timeout <= 0 {
+			return errors.New("rpc client:" + context.DeadlineExceeded.Error())
+		}
 	}
-	if len(opts) != 1 {
-		return nil, errors.New("number of options is more than 1")
+	md := MetadataFromContext(ctx)
+	for _, call := range calls {
+		call.Seq = 0 // 没有注册成功的 Call 不能带着上一次的 Seq，否则取消时会删掉别的请求
+		call.Error = nil
+		call.Done = make(chan *Call, 1)
+		call.timeout = timeout
+		if call.Metadata == nil {
+			call.Metadata = copyMetadata(md)
+		}
 	}
-	opt := opts[0]
-	opt.MagicNumber = DefaultOption.MagicNumber
-	if opt.CodecType == "" {
-		opt.CodecType = DefaultOption.CodecType
+
+	// 先占用所有 Call 的名额再加锁发送，超过 MaxPendingCalls 的部分不可能同时等待回复，直接返回 ErrTooManyPending
+	n := len(calls)
+	for i := range calls {
+		err := ErrTooManyPending
+		if client.slots == nil || i < cap(client.slots) {
+			err = client.acquire(ctx)
+		}
+		if err != nil {
+			for _, call := range calls[i:] {
+				call.Error = err
+				call.done()
+			}
+			n = i
+			break
+		}
 	}
-	return opt, nil
+
+	client.sending.Lock()
+	write, flush := client.cc.Write, func() error { return nil }
+	if bw, ok := client.cc.(codec.BufferedWriter); ok {
+		write, flush = bw.WriteBuffered, bw.Flush
+	}
+	sent := make([]*Call, 0, len(calls))
+	for _, call := range calls[:n] {
+		if client.writeCall(call, write) {
+			sent = append(sent, call)
+		}
+	}
+	if err := flush(); err != nil {
+		for _, call := range sent {
+			if client.removeCall(call.Seq) != nil {
+				call.Error = err
+				call.done()
+			}
+		}
+	}
+	client.sending.Unlock()
+
+	for i, call := range calls {
+		select {
+		case <-ctx.Done():
+			err := errors.New("rpc client:" + ctx.Err().Error())
+			for _, call := range calls[i:] {
+				if client.removeCall(call.Seq) != nil {
+					call.Error = err
+					call.done()
+				}
+				<-call.Done
+			}
+			return err
+		case <-call.Done:
+		}
+	}
+	return nil
 }
 
 // NewClient 创建 Client 实例，最开始需要交换下 Option 的内容，协商好编解码方式后
 // newClientCodec 会开启一个 goroutine 去接收
 func NewClient(conn net.Conn, opt *Option) (*Client, error) {
+	cc, err := handshake(conn, opt)
+	if err != nil {
+		return nil, err
+	}
+	return newClientCodec(cc, opt, nil), nil
+}
+
+// handshake 发送 Option 并等待鉴权结果，返回协商好的编解码器，重连的时候也会重新执行
+func handshake(conn net.Conn, opt *Option) (codec.Codec, error) {
 	f := codec.NewCodecFuncMap[opt.CodecType]
 	if f == nil {
 		err := fmt.Errorf("invalid codec type %s", opt.CodecType)
-		log.Println("rpc client: codec error", err)
+		opt.logger().Errorf("rpc client: codec error %v", err)
 		return nil, err
 	}
-	if err := json.NewEncoder(conn).Encode(opt); err != nil {
-		log.Println("rpc client: options error:", err)
+	o := *opt
+	o.ChunkedReplies = true // 客户端总是支持分块的回复
+	if err := json.NewEncoder(conn).Encode(&o); err != nil {
+		opt.logger().Errorf("rpc client: options error: %v", err)
 		_ = conn.Close()
 		return nil, err
 	}
-	return newClientCodec(f(conn), opt), nil
+	cc := f(conn)
+	if opt.AuthToken != "" || opt.Namespace != "" {
+		if err := readHandshake(cc); err != nil {
+			opt.logger().Errorf("rpc client: handshake error: %v", err)
+			_ = cc.Close()
+			return nil, err
+		}
+	}
+	return opt.CodecDebug.wrap(cc, remoteAddr(conn), opt.logger()), nil
 }
 
-func newClientCodec(cc codec.Codec, opt *Option) *Client {
+// newClientCodec 创建 Client，redial 不为空时连接断开之后会按照 opt.Reconnect 自动重连
+func newClientCodec(cc codec.Codec, opt *Option, redial func() (codec.Codec, error)) *Client {
 	client := &Client{
-		seq:     1,
-		cc:      cc,
-		opt:     opt,
-		pending: make(map[uint64]*Call),
+		seqGen:   opt.newSeqGenerator(),
+		cc:       cc,
+		opt:      opt,
+		pending:  make(map[uint64]*Call),
+		lastRecv: time.Now().UnixNano(),
+		quit:     make(chan struct{}),
+		closed:   make(chan struct{}),
+		redial:   redial,
 	}
-	go client.receive()
+	if opt.Breaker != nil {
+		client.breaker = NewBreaker(*opt.Breaker)
+	}
+	if opt.MaxPendingCalls > 0 {
+		client.slots = make(chan struct{}, opt.MaxPendingCalls)
+	}
+	client.start()
+	client.connected()
 	return client
 }
 
+// connected 通知 Option.OnConnected 连接已经可以使用了
+func (client *Client) connected() {
+	if client.opt.OnConnected != nil {
+		client.opt.OnConnected(client)
+	}
+}
+
+// start 在新的连接上开始接收数据和发送心跳
+func (client *Client) start() {
+	go client.receive()
+	if client.opt.HeartbeatInterval > 0 {
+		go client.heartbeat(client.cc, client.quit)
+	}
+}
+
+// NewHTTPClient 在 conn 上发送 CONNECT 请求，Host 头使用 conn 的远端地址
 func NewHTTPClient(conn net.Conn, opt *Option) (*Client, error) {
-	_, _ = io.WriteString(conn, fmt.Sprintf("CONNECT %s HTTP/1.0\n\n", defaultRPCPath))
+	cc, err := httpHandshake(conn, opt, conn.RemoteAddr().String())
+	if err != nil {
+		return nil, err
+	}
+	return newClientCodec(cc, opt, nil), nil
+}
 
+// httpHandshake 发送 CONNECT 请求，等服务端回复 connected 之后再交换 Option，
+// 请求行和头部使用 CRLF 结尾，带上 Host，中间的七层设施才能正确识别
+func httpHandshake(conn net.Conn, opt *Option, host string) (codec.Codec, error) {
+	if _, err := io.WriteString(conn, fmt.Sprintf("CONNECT %s HTTP/1.0\r\nHost: %s\r\n\r\n", opt.rpcPath(), host)); err != nil {
+		return nil, err
+	}
 	resp, err := http.ReadResponse(bufio.NewReader(conn), &http.Request{Method: "CONNECT"})
 	if err == nil && resp != nil && resp.Status == connected {
-		return NewClient(conn, opt)
+		return handshake(conn, opt)
 	}
 	if err == nil && resp != nil {
-		err = errors.New("unexpected HTTP response" + resp.Status)
+		err = errors.New("unexpected HTTP response " + resp.Status)
 	}
 	return nil, err
 }
 
-func DialHTTP(network, address string, opts ...*Option) (*Client, error) {
-	return dialTimeout(NewHTTPClient, network, address, opts...)
+// DialHTTP 通过 HTTP CONNECT 连接到 RPC Server，请求的路径由 Option.RPCPath 指定
+func DialHTTP(network, address string, opts ...DialOption) (*Client, error) {
+	return dialTimeout(func(conn net.Conn, opt *Option) (codec.Codec, error) {
+		return httpHandshake(conn, opt, address)
+	}, network, address, opts...)
 }
 
 /*
@@ -271,62 +762,191 @@ func Dial(network, address string, opts ...*Option) (client *Client, err error)
 	return NewClient(conn, opt)
 } */
 
+// DialFunc 建立到 address 的连接，ctx 中带有 ConnectTimeout 的超时时间
+type DialFunc func(ctx context.Context, address string, opt *Option) (net.Conn, error)
+
+var dialers = map[string]DialFunc{}
+
+// RegisterDialer 注册 network 对应的连接方式，之后 Dial 和 XDial 遇到该 network 时使用 dial 建立连接，
+// 注册需要在 init 中完成，比如 geerpc/quic 注册了 quic@addr
+func RegisterDialer(network string, dial DialFunc) {
+	dialers[network] = dial
+}
+
 type clientResult struct {
-	client *Client
-	err    error
+	cc  codec.Codec
+	err error
 }
 
-type newClientFunc func(conn net.Conn, opt *Option) (client *Client, err error)
+type handshakeFunc func(conn net.Conn, opt *Option) (cc codec.Codec, err error)
 
-func dialTimeout(f newClientFunc, network, address string, opts ...*Option) (client *Client, err error) {
+func dialTimeout(f handshakeFunc, network, address string, opts ...DialOption) (*Client, error) {
 	opt, err := parseOptions(opts...)
 	if err != nil {
 		return nil, err
 	}
-	// 使用具有超时处理的 Dial 函数
-	conn, err := net.DialTimeout(network, address, opt.ConnectTimeout)
+	cc, err := dialCodec(f, network, address, opt)
+	if err != nil {
+		return nil, err
+	}
+	var redial func() (codec.Codec, error)
+	if opt.Reconnect != nil {
+		redial = func() (codec.Codec, error) { return dialCodec(f, network, address, opt) }
+	}
+	return newClientCodec(cc, opt, redial), nil
+}
+
+// dialCodec 建立连接并完成握手
+func dialCodec(f handshakeFunc, network, address string, opt *Option) (cc codec.Codec, err error) {
+	ctx := context.Background()
+	if opt.ConnectTimeout > 0 { // 0表示的话没有超时，阻塞等待结果
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opt.ConnectTimeout)
+		defer cancel()
+	}
+	var conn net.Conn
+	if dial, ok := dialers[network]; ok {
+		// 通过 RegisterDialer 注册的传输层自行处理加密，比如 QUIC 本身就基于 TLS 1.3
+		conn, err = dial(ctx, address, opt)
+	} else {
+		// 使用具有超时处理的 Dial 函数，设置了代理时先连接到代理
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, network, opt.dialAddr(address))
+	}
 	if err != nil {
 		return nil, err
 	}
+	if err = opt.TCP.apply(conn); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
 	defer func() {
 		if err != nil {
 			_ = conn.Close()
 		}
 	}()
-	// 这里的超时处理用的很巧妙，开启一个Goroutine，然后将执行结果发送到ch中
-	// 但是这样有个问题，就是当超时了之后，没有办法通知这个Goroutine退出
-	// TODO: 这里可以使用context来改造
+	// 握手在 goroutine 中进行，超时返回的时候关闭连接，goroutine 中阻塞的读写会立即出错返回，
+	// 如果握手恰好在超时之后完成，goroutine 会通过 ctx 发现没有人在等待结果，关闭编解码器之后退出
 	ch := make(chan clientResult)
 	go func() {
-		client, err := f(conn, opt)
-		ch <- clientResult{client: client, err: err}
+		var result clientResult
+		c := conn
+		// 代理的 CONNECT 隧道和 TLS 握手同样受 ConnectTimeout 的限制
+		if _, ok := dialers[network]; !ok {
+			if opt.Proxy != nil {
+				result.err = proxyConnect(c, address, opt)
+			}
+			if result.err == nil && opt.TLSConfig != nil {
+				tlsConn := tls.Client(c, tlsClientConfig(opt.TLSConfig, address))
+				if err := tlsConn.Handshake(); err != nil {
+					result.err = fmt.Errorf("rpc client: tls handshake error: %s", err)
+				}
+				c = tlsConn
+			}
+		}
+		if result.err == nil {
+			result.cc, result.err = f(c, opt)
+		}
+		select {
+		case ch <- result:
+		case <-ctx.Done():
+			if result.cc != nil {
+				_ = result.cc.Close()
+			}
+		}
 	}()
-	if opt.ConnectTimeout == 0 { // 0表示的话没有超时，阻塞等待结果
-		result := <-ch
-		return result.client, result.err
-	}
 	select {
-	case <-time.After(opt.ConnectTimeout):
+	case <-ctx.Done():
 		return nil, fmt.Errorf("rpc client: connect timeout: expect within %s", opt.ConnectTimeout)
 	case result := <-ch:
-		return result.client, result.err
+		return result.cc, result.err
+	}
+}
+
+func Dial(network, address string, opts ...DialOption) (*Client, error) {
+	return dialTimeout(handshake, network, address, opts...)
+}
+
+// DialTLS 使用 TLS 加密连接到 RPC Server，如果服务端要求双向 TLS，在 config.Certificates 中放入客户端证书
+func DialTLS(network, address string, config *tls.Config, opts ...DialOption) (*Client, error) {
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
 	}
+	opt.TLSConfig = config
+	return dialTimeout(handshake, network, address, opt)
 }
 
-func Dial(network, address string, opts ...*Option) (*Client, error) {
-	return dialTimeout(NewClient, network, address, opts...)
+// tlsClientConfig 在没有指定 ServerName 的时候，使用地址中的主机名来校验服务端证书
+func tlsClientConfig(config *tls.Config, address string) *tls.Config {
+	if config.ServerName != "" {
+		return config
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	c := config.Clone()
+	c.ServerName = host
+	return c
 }
 
-func XDial(rpcAdr string, opts ...*Option) (*Client, error) {
-	parts := strings.Split(rpcAdr, "@")
-	if len(parts) != 2 {
+// SchemeFunc 使用 XDial 地址中 @ 之后的部分建立连接
+type SchemeFunc func(addr string, opts ...DialOption) (*Client, error)
+
+var (
+	schemesMu sync.RWMutex
+	schemes   = map[string]SchemeFunc{
+		"http": func(addr string, opts ...DialOption) (*Client, error) { return DialHTTP("tcp", addr, opts...) },
+		// 多个 Client 通过 HTTP/2 复用同一个 TCP 连接
+		"h2c": DialH2C,
+		"tcp": func(addr string, opts ...DialOption) (*Client, error) { return Dial("tcp", addr, opts...) },
+		// 同一台机器上的服务可以使用 unix 域套接字，省去 TCP 协议栈的开销
+		"unix": func(addr string, opts ...DialOption) (*Client, error) { return Dial("unix", addr, opts...) },
+		"tls":  dialTLSScheme,
+	}
+)
+
+// RegisterScheme 注册 XDial 中 scheme 对应的连接方式，已经存在的 scheme 会被覆盖，
+// 比如 RegisterScheme("ws", dialWebSocket) 之后就可以使用 XDial("ws@10.0.0.1:8080/rpc")
+func RegisterScheme(scheme string, dial SchemeFunc) {
+	schemesMu.Lock()
+	defer schemesMu.Unlock()
+	schemes[scheme] = dial
+}
+
+// dialTLSScheme 处理 tls@addr，使用 Option.TLSConfig，没有设置时使用系统的根证书校验服务端
+func dialTLSScheme(addr string, opts ...DialOption) (*Client, error) {
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	config := opt.TLSConfig
+	if config == nil {
+		config = &tls.Config{}
+	}
+	return DialTLS("tcp", addr, config, opt)
+}
+
+// XDial 根据 rpcAdr 中的协议选择不同的连接方式，rpcAdr 的格式为 protocol@addr，比如：
+// http@10.0.0.1:7001, h2c@10.0.0.1:7001, tcp@10.0.0.1:9999, tls@10.0.0.1:9999, unix@/var/run/geerpc.sock，
+// 引入 geerpc/quic 之后也可以使用 quic@10.0.0.1:9999。其他的协议通过 RegisterScheme 注册，
+// 没有注册的协议直接作为 network 交给 Dial
+func XDial(rpcAdr string, opts ...DialOption) (*Client, error) {
+	// 只按照第一个 @ 切分，Linux 的抽象 unix 套接字以 @ 开头，比如 unix@@geerpc
+	parts := strings.SplitN(rpcAdr, "@", 2)
+	if len(parts) != 2 || parts[1] == "" {
 		return nil, fmt.Errorf("rpc client err: wrong format '%s', expect protocol@adr", rpcAdr)
 	}
 	protocol, addr := parts[0], parts[1]
-	switch protocol {
-	case "http":
-		return DialHTTP("tcp", addr, opts...)
-	default:
-		return Dial(protocol, addr, opts...)
+	schemesMu.RLock()
+	dial, ok := schemes[protocol]
+	schemesMu.RUnlock()
+	if ok {
+		return dial(addr, opts...)
 	}
+	return Dial(protocol, addr, opts...)
 }
+
+// RawMessage 作为 Call 的 reply 时，客户端不解码回复，而是保存原始的数据，参考 codec.RawMessage
+type RawMessage = codec.RawMessage
@@ -1,10 +1,29 @@
 package geerpc
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/gob"
+	"errors"
+	"geerpc/codec"
+	"io"
+	"io/ioutil"
+	"math/big"
 	"net"
+	"net/http"
+	"net/url"
 	"os"
 	"runtime"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -13,17 +32,17 @@ func TestClient_dialTimeout(t *testing.T) {
 	t.Parallel()
 	l, _ := net.Listen("tcp", ":0")
 
-	f := func(conn net.Conn, opt *Option) (client *Client, err error) {
+	f := func(conn net.Conn, opt *Option) (cc codec.Codec, err error) {
 		_ = conn.Close()
 		time.Sleep(time.Second * 2)
 		return nil, nil
 	}
 	t.Run("timeout", func(t *testing.T) {
-		_, err := dialTimeout(f, "tcp", l.Addr().String(), &Option{ConnectTimeout: time.Second})
+		_, err := dialCodec(f, "tcp", l.Addr().String(), &Option{ConnectTimeout: time.Second})
 		_assert(err != nil && strings.Contains(err.Error(), "connect timeout"), "expect a timeout error")
 	})
 	t.Run("0", func(t *testing.T) {
-		_, err := dialTimeout(f, "tcp", l.Addr().String(), &Option{ConnectTimeout: 0})
+		_, err := dialCodec(f, "tcp", l.Addr().String(), &Option{ConnectTimeout: 0})
 		_assert(err == nil, "0 means no limit")
 	})
 }
@@ -36,7 +55,9 @@ func TestXDial(t *testing.T) {
 			_ = os.Remove(addr)
 			l, err := net.Listen("unix", addr)
 			if err != nil {
-				t.Fatal("failed to listen unix socket")
+				t.Error("failed to listen unix socket")
+				close(ch)
+				return
 			}
 			ch <- struct{}{}
 			Accept(l)
@@ -44,5 +65,1174 @@ func TestXDial(t *testing.T) {
 		<-ch
 		_, err := XDial("unix@" + addr)
 		_assert(err == nil, "failed to connect unix socket")
+
+		// 抽象 unix 套接字的地址以 @ 开头
+		abstract, err := net.Listen("unix", "@geerpc-test")
+		if err != nil {
+			t.Fatal("failed to listen abstract unix socket")
+		}
+		defer func() { _ = abstract.Close() }()
+		go Accept(abstract)
+		_, err = XDial("unix@@geerpc-test")
+		_assert(err == nil, "failed to connect abstract unix socket")
+	}
+	_, err := XDial("unix@")
+	_assert(err != nil, "expect an error for empty address")
+}
+
+// newTestTLSConfig 生成一个测试用的 CA，并签发服务端和客户端证书，用于双向 TLS
+func newTestTLSConfig(t *testing.T) (serverConfig, clientConfig *tls.Config) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "geerpc test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal("failed to create ca certificate:", err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	issue := func(serial int64, usage x509.ExtKeyUsage) tls.Certificate {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: "geerpc test"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+			IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal("failed to create certificate:", err)
+		}
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+	serverConfig = &tls.Config{
+		Certificates: []tls.Certificate{issue(2, x509.ExtKeyUsageServerAuth)},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	clientConfig = &tls.Config{
+		Certificates: []tls.Certificate{issue(3, x509.ExtKeyUsageClientAuth)},
+		RootCAs:      pool,
+	}
+	return
+}
+
+func TestDialTLS(t *testing.T) {
+	serverConfig, clientConfig := newTestTLSConfig(t)
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	_ = server.RegisterFunc("Peer.Info", func(ctx context.Context, _ int, reply *string) error {
+		info, ok := ConnInfoFromContext(ctx)
+		if !ok || info.TLS == nil || len(info.TLS.PeerCertificates) == 0 || info.ConnectedAt.IsZero() {
+			return errors.New("missing peer info")
+		}
+		*reply = info.TLS.PeerCertificates[0].Subject.CommonName + "@" + info.RemoteAddr
+		return nil
+	})
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.AcceptTLS(l, serverConfig)
+
+	t.Run("mutual tls", func(t *testing.T) {
+		client, err := DialTLS("tcp", l.Addr().String(), clientConfig)
+		_assert(err == nil, "failed to dial tls: %v", err)
+		defer func() { _ = client.Close() }()
+		var reply int
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "failed to call Foo.Sum over tls")
+	})
+	t.Run("conn info", func(t *testing.T) {
+		client, err := DialTLS("tcp", l.Addr().String(), clientConfig)
+		_assert(err == nil, "failed to dial tls: %v", err)
+		defer func() { _ = client.Close() }()
+		var peer string
+		err = client.Call(context.Background(), "Peer.Info", 0, &peer)
+		_assert(err == nil && strings.HasPrefix(peer, "geerpc test@127.0.0.1:"), "expect peer certificate in conn info, got %q %v", peer, err)
+	})
+	t.Run("option", func(t *testing.T) {
+		client, err := Dial("tcp", l.Addr().String(), &Option{TLSConfig: clientConfig})
+		_assert(err == nil, "failed to dial with Option.TLSConfig: %v", err)
+		_ = client.Close()
+	})
+	t.Run("missing client certificate", func(t *testing.T) {
+		config := &tls.Config{RootCAs: clientConfig.RootCAs}
+		client, err := DialTLS("tcp", l.Addr().String(), config, &Option{ConnectTimeout: time.Second})
+		if err == nil {
+			var reply int
+			err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		}
+		_assert(err != nil, "expect an error without client certificate")
+	})
+	t.Run("xdial", func(t *testing.T) {
+		client, err := XDial("tls@"+l.Addr().String(), &Option{TLSConfig: clientConfig})
+		_assert(err == nil, "failed to dial tls@: %v", err)
+		_ = client.Close()
+	})
+}
+
+func TestRegisterScheme(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	addr := startTestServer(server)
+	var dialed string
+	RegisterScheme("test", func(addr string, opts ...DialOption) (*Client, error) {
+		dialed = addr
+		return Dial("tcp", addr, opts...)
+	})
+	client, err := XDial("test@" + addr)
+	_assert(err == nil && dialed == addr, "expect registered scheme to be used, got %q %v", dialed, err)
+	_ = client.Close()
+	_, err = XDial("nosuch@" + addr)
+	_assert(err != nil, "expect an error for unknown scheme")
+}
+
+func TestClient_Use(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	attempts := 0
+	client.Use(func(ctx context.Context, call *Call, next CallHandler) error {
+		// 第一次调用失败后重试
+		var err error
+		for i := 0; i < 2; i++ {
+			attempts++
+			if err = next(ctx, call); err == nil {
+				return nil
+			}
+		}
+		return err
+	}, func(ctx context.Context, call *Call, next CallHandler) error {
+		if call.ServiceMethod == "Foo.Add" {
+			call.ServiceMethod = "Foo.Sum"
+		}
+		if attempts == 1 {
+			return errors.New("transient error")
+		}
+		return next(ctx, call)
+	})
+	var reply int
+	err := client.Call(context.Background(), "Foo.Add", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call through interceptors: %v", err)
+	_assert(attempts == 2, "expect 2 attempts, got %d", attempts)
+}
+
+func TestClient_CallBatch(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	replies := make([]int, 10)
+	calls := make([]*Call, 0, 11)
+	for i := range replies {
+		calls = append(calls, &Call{ServiceMethod: "Foo.Sum", Args: Args{Num1: i, Num2: i}, Reply: &replies[i]})
+	}
+	calls = append(calls, &Call{ServiceMethod: "Foo.Unknown", Args: Args{}, Reply: new(int)})
+	err := client.CallBatch(context.Background(), calls)
+	_assert(err == nil, "failed to call batch: %v", err)
+	for i := range replies {
+		_assert(calls[i].Error == nil && replies[i] == 2*i, "call %d: expect %d, got %d %v", i, 2*i, replies[i], calls[i].Error)
 	}
+	_assert(calls[10].Error != nil, "expect error for unknown method")
+}
+
+func TestClient_Heartbeat(t *testing.T) {
+	opt := &Option{HeartbeatInterval: 20 * time.Millisecond, IdleTimeout: 100 * time.Millisecond}
+
+	t.Run("alive", func(t *testing.T) {
+		server := NewServer()
+		var foo Foo
+		_ = server.Register(&foo)
+		client, _ := Dial("tcp", startTestServer(server), opt)
+		defer func() { _ = client.Close() }()
+		time.Sleep(300 * time.Millisecond)
+		var reply int
+		err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "idle connection with heartbeat should stay open: %v", err)
+	})
+	t.Run("half open", func(t *testing.T) {
+		// 只接受连接，不回复任何数据，模拟对端已经不存在的连接
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		defer func() { _ = l.Close() }()
+		go func() {
+			conn, err := l.Accept()
+			if err == nil {
+				_, _ = io.Copy(ioutil.Discard, conn)
+			}
+		}()
+		client, err := Dial("tcp", l.Addr().String(), opt)
+		_assert(err == nil, "failed to dial: %v", err)
+		defer func() { _ = client.Close() }()
+		call := client.Go("Foo.Sum", Args{Num1: 1, Num2: 2}, new(int), nil)
+		select {
+		case <-call.Done:
+			_assert(errors.Is(call.Error, ErrHeartbeatTimeout), "expect heartbeat timeout, got %v", call.Error)
+		case <-time.After(time.Second):
+			t.Fatal("pending call should fail once the heartbeat times out")
+		}
+	})
+}
+
+func TestClient_Reconnect(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	conns := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+			go server.ServerConn(conn)
+		}
+	}()
+
+	states := make(chan ConnState, 2)
+	client, err := Dial("tcp", l.Addr().String(), &Option{Reconnect: &ReconnectPolicy{
+		MinBackoff:    10 * time.Millisecond,
+		MaxBackoff:    50 * time.Millisecond,
+		OnStateChange: func(state ConnState, err error) { states <- state },
+	}})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	// 服务端断开连接之后，客户端应该自动重连
+	_ = (<-conns).Close()
+	_assert(<-states == StateDisconnected, "expect disconnected first")
+	select {
+	case state := <-states:
+		_assert(state == StateConnected, "expect connected, got %s", state)
+	case <-time.After(time.Second):
+		t.Fatal("client should reconnect")
+	}
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call after reconnect: %v", err)
+
+	p := &ReconnectPolicy{MinBackoff: time.Second, MaxBackoff: 4 * time.Second}
+	for attempt := 0; attempt < 5; attempt++ {
+		d := p.backoff(attempt)
+		_assert(d >= time.Second/2 && d <= 4*time.Second, "backoff %d out of range: %s", attempt, d)
+	}
+}
+
+func TestDialMulti(t *testing.T) {
+	startNode := func(name string) (*Server, string) {
+		server := NewServer()
+		_ = server.RegisterFunc("Node.Name", func(_ int, reply *string) error {
+			*reply = name
+			return nil
+		})
+		return server, startTestServer(server)
+	}
+	dead, _ := net.Listen("tcp", "127.0.0.1:0")
+	deadAddr := dead.Addr().String()
+	_ = dead.Close()
+	primary, primaryAddr := startNode("primary")
+	backupServer, backupAddr := startNode("backup")
+
+	states := make(chan ConnState, 8)
+	client, err := DialMulti("tcp", []string{deadAddr, primaryAddr, backupAddr}, &Option{Reconnect: &ReconnectPolicy{
+		MinBackoff:    10 * time.Millisecond,
+		MaxBackoff:    50 * time.Millisecond,
+		OnStateChange: func(state ConnState, err error) { states <- state },
+	}})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var name string
+	err = client.Call(context.Background(), "Node.Name", 0, &name)
+	_assert(err == nil && name == "primary", "expect to skip the dead address, got %q %v", name, err)
+
+	// 主节点关闭之后切换到下一个地址
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_ = primary.Drain(ctx)
+	_assert(<-states == StateDisconnected, "expect disconnected first")
+	select {
+	case state := <-states:
+		_assert(state == StateConnected, "expect connected, got %s", state)
+	case <-time.After(time.Second):
+		t.Fatal("client should fail over")
+	}
+	err = client.Call(context.Background(), "Node.Name", 0, &name)
+	_assert(err == nil && name == "backup", "expect to fail over to backup, got %q %v", name, err)
+
+	// 服务端 Shutdown 时发送 FlagGoAway，不需要等到连接被关闭就会切换到其他地址
+	_, primaryAddr = startNode("primary")
+	states2 := make(chan ConnState, 2)
+	client2, err := DialMulti("tcp", []string{backupAddr, primaryAddr}, &Option{Reconnect: &ReconnectPolicy{
+		MinBackoff:    10 * time.Millisecond,
+		MaxBackoff:    50 * time.Millisecond,
+		OnStateChange: func(state ConnState, err error) { states2 <- state },
+	}})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client2.Close() }()
+	_ = backupServer.Shutdown(context.Background())
+	_assert(<-states2 == StateDisconnected && <-states2 == StateConnected, "expect to fail over after go away")
+	err = client2.Call(context.Background(), "Node.Name", 0, &name)
+	_assert(err == nil && name == "primary", "expect to fail over to primary, got %q %v", name, err)
+
+	_, err = DialMulti("tcp", []string{deadAddr})
+	_assert(err != nil, "expect error when no address is reachable")
+	_, err = DialMulti("tcp", nil)
+	_assert(err != nil, "expect error without addresses")
+}
+
+func TestClient_Hooks(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	conns := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+			go server.ServerConn(conn)
+		}
+	}()
+
+	events := make(chan string, 10)
+	client, err := Dial("tcp", l.Addr().String(), &Option{
+		Reconnect:   &ReconnectPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond},
+		OnConnected: func(*Client) { events <- "connected" },
+		OnError:     func(_ *Client, err error) { events <- "error" },
+		OnClosed:    func(*Client) { events <- "closed" },
+	})
+	_assert(err == nil, "failed to dial: %v", err)
+	expect := func(event string) {
+		select {
+		case e := <-events:
+			_assert(e == event, "expect %s, got %s", event, e)
+		case <-time.After(time.Second):
+			t.Fatalf("expect %s", event)
+		}
+	}
+	expect("connected")
+
+	// 服务端断开连接时通知错误，重连成功之后再次通知已连接
+	_ = (<-conns).Close()
+	expect("error")
+	expect("connected")
+
+	// 主动关闭不算作错误
+	_ = client.Close()
+	expect("closed")
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event %s after Close", e)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPool(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	addr := "tcp@" + startTestServer(server)
+
+	for _, mode := range []PoolMode{PoolRoundRobin, PoolLeastPending} {
+		pool := NewPool(addr, 3, mode, nil)
+		var wg sync.WaitGroup
+		for i := 0; i < 30; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				var reply int
+				err := pool.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: i}, &reply)
+				_assert(err == nil && reply == 2*i, "failed to call through pool: %v", err)
+			}(i)
+		}
+		wg.Wait()
+		pool.mu.Lock()
+		for i, client := range pool.clients {
+			_assert(client != nil, "mode %d: connection %d should be used", mode, i)
+		}
+		// 断开的连接在下一次被选中时重新建立
+		broken := pool.clients[0]
+		pool.mu.Unlock()
+		_ = broken.Close()
+		for i := 0; i < 3; i++ {
+			call := <-pool.Go("Foo.Sum", Args{Num1: 1, Num2: 2}, new(int), nil).Done
+			_assert(call.Error == nil, "broken connection should be evicted: %v", call.Error)
+		}
+		_ = pool.Close()
+	}
+
+	pool := NewPool("tcp@127.0.0.1:1", 1, PoolRoundRobin, &Option{ConnectTimeout: time.Second})
+	call := <-pool.Go("Foo.Sum", Args{}, new(int), nil).Done
+	_assert(call.Error != nil, "expect dial error")
+	_ = pool.Close()
+	_assert(pool.Call(context.Background(), "Foo.Sum", Args{}, new(int)) == ErrShutdown, "expect ErrShutdown after Close")
+}
+
+var (
+	slowDialStarted = make(chan struct{})
+	slowDialGate    = make(chan struct{})
+	slowDials       int32
+)
+
+func init() {
+	// slowpool 的第一次连接阻塞到 slowDialGate 关闭，之后的连接直接建立
+	RegisterDialer("slowpool", func(ctx context.Context, address string, opt *Option) (net.Conn, error) {
+		if atomic.AddInt32(&slowDials, 1) == 1 {
+			close(slowDialStarted)
+			<-slowDialGate
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", address)
+	})
+}
+
+func TestPool_SlowDial(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	pool := NewPool("slowpool@"+startTestServer(server), 2, PoolRoundRobin, nil)
+	defer func() { _ = pool.Close() }()
+
+	first := make(chan error, 1)
+	go func() { first <- pool.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 1}, new(int)) }()
+	<-slowDialStarted
+	// 第一条连接还在建立，选中第二条连接的调用不需要等待它
+	var reply int
+	done := make(chan error, 1)
+	go func() { done <- pool.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply) }()
+	select {
+	case err := <-done:
+		_assert(err == nil && reply == 3, "call on the healthy connection failed: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("call on the healthy connection is blocked by a slow dial")
+	}
+	close(slowDialGate)
+	err := <-first
+	_assert(err == nil, "slow dial should finish after the gate opens: %v", err)
+}
+
+func TestClient_WithTimeout(t *testing.T) {
+	server := NewServer()
+	var slow Slow
+	var budget Budget
+	_ = server.Register(&slow)
+	_ = server.Register(&budget)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err := client.Call(context.Background(), "Slow.Sleep", 500, &reply, WithTimeout(50*time.Millisecond))
+	_assert(err != nil && strings.Contains(err.Error(), "deadline exceeded"), "expect a timeout error, got %v", err)
+
+	var remaining int64
+	err = client.Call(context.Background(), "Budget.Remaining", 0, &remaining, WithTimeout(time.Second))
+	_assert(err == nil && remaining > 0 && remaining <= 1000, "timeout should be sent to the server, got %d", remaining)
+
+	start := time.Now()
+	call := <-client.Go("Slow.Sleep", 500, &reply, nil, WithTimeout(50*time.Millisecond)).Done
+	_assert(call.Error != nil && strings.Contains(call.Error.Error(), "call timeout"), "expect a timeout error, got %v", call.Error)
+	_assert(time.Since(start) < 400*time.Millisecond, "Go should return once the timeout fires")
+}
+
+func TestClient_Retry(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	server.SetRateLimiter("Foo", NewTokenBucket(20, 1))
+	addr := startTestServer(server)
+
+	t.Run("idempotent", func(t *testing.T) {
+		client, _ := Dial("tcp", addr, &Option{Retry: &RetryPolicy{MaxAttempts: 5, MinBackoff: 50 * time.Millisecond, Idempotent: []string{"Foo.Sum"}}})
+		defer func() { _ = client.Close() }()
+		var reply int
+		for i := 0; i < 3; i++ {
+			err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+			_assert(err == nil && reply == 3, "rate limited calls should be retried: %v", err)
+		}
+	})
+	t.Run("not idempotent", func(t *testing.T) {
+		client, _ := Dial("tcp", addr, &Option{Retry: &RetryPolicy{MaxAttempts: 5, Idempotent: []string{"Bar"}}})
+		defer func() { _ = client.Close() }()
+		var reply int
+		var err error
+		for i := 0; i < 3 && err == nil; i++ {
+			err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		}
+		_assert(err != nil && IsTransient(err), "calls not marked idempotent shouldn't be retried")
+	})
+	_assert(!IsTransient(errors.New("rpc server: can't find service Foo")), "unknown service isn't transient")
+	_assert(IsTransient(ErrShutdown), "ErrShutdown is transient")
+}
+
+type closeNotifyCodec struct {
+	codec.Codec
+	closed chan struct{}
+}
+
+func (c *closeNotifyCodec) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestClient_dialTimeoutCleanup(t *testing.T) {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	cc := &closeNotifyCodec{closed: make(chan struct{})}
+	f := func(conn net.Conn, opt *Option) (codec.Codec, error) {
+		time.Sleep(200 * time.Millisecond)
+		return cc, nil
+	}
+	_, err := dialCodec(f, "tcp", l.Addr().String(), &Option{ConnectTimeout: 50 * time.Millisecond})
+	_assert(err != nil && strings.Contains(err.Error(), "connect timeout"), "expect a timeout error")
+	select {
+	case <-cc.closed:
+	case <-time.After(time.Second):
+		t.Fatal("codec created after the timeout should be closed")
+	}
+}
+
+func TestClient_GoContext(t *testing.T) {
+	server := NewServer()
+	waiter := make(Waiter, 1)
+	_ = server.Register(waiter)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var reply int
+	call := client.GoContext(ctx, "Waiter.Wait", 2000, &reply, nil)
+	time.AfterFunc(50*time.Millisecond, cancel)
+	<-call.Done
+	_assert(call.Error != nil && strings.Contains(call.Error.Error(), "canceled"), "expect a cancellation error, got %v", call.Error)
+	_assert(errors.Is(<-waiter, context.Canceled), "handler should be canceled")
+
+	call = <-client.GoContext(context.Background(), "Waiter.Wait", 1, &reply, nil).Done
+	_assert(call.Error == nil && <-waiter == nil, "failed to call: %v", call.Error)
+}
+
+func TestClient_HealthCheck(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	_assert(client.HealthCheck(context.Background()) == nil, "server should be serving")
+	var status HealthStatus
+	_ = client.Call(context.Background(), "Health.Check", "Foo", &status)
+	_assert(status == HealthServing, "Foo should be serving, got %s", status)
+	_ = client.Call(context.Background(), "Health.Check", "Bar", &status)
+	_assert(status == HealthUnknown, "Bar should be unknown, got %s", status)
+
+	server.SetServingStatus("", HealthNotServing)
+	err := client.HealthCheck(context.Background())
+	_assert(err != nil && strings.Contains(err.Error(), string(HealthNotServing)), "expect not serving, got %v", err)
+}
+
+// countingListener 记录接受的 TCP 连接数
+type countingListener struct {
+	net.Listener
+	accepted int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		atomic.AddInt32(&l.accepted, 1)
+	}
+	return conn, err
+}
+
+func TestClient_H2C(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	inner, _ := net.Listen("tcp", "127.0.0.1:0")
+	l := &countingListener{Listener: inner}
+	defer func() { _ = l.Close() }()
+	mux := http.NewServeMux()
+	mux.Handle(defaultRPCPath, server)
+	go func() { _ = ServeH2C(l, mux) }()
+
+	var clients []*Client
+	for i := 0; i < 3; i++ {
+		client, err := XDial("h2c@" + l.Addr().String())
+		_assert(err == nil, "failed to dial: %v", err)
+		defer func() { _ = client.Close() }()
+		clients = append(clients, client)
+	}
+	for i, client := range clients {
+		var reply int
+		err := client.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 1}, &reply)
+		_assert(err == nil && reply == i+1, "expect %d, got %d %v", i+1, reply, err)
+	}
+	n := atomic.LoadInt32(&l.accepted)
+	_assert(n == 1, "clients should share one connection, got %d", n)
+
+	// 普通的 HTTP/1.0 CONNECT 仍然可用
+	client, err := XDial("http@" + l.Addr().String())
+	_assert(err == nil, "failed to dial http: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	_assert(client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 1}, &reply) == nil && reply == 2, "failed to call over http")
+}
+
+// startTestProxy 启动一个只支持 CONNECT 的 HTTP 代理，校验 Proxy-Authorization 之后转发数据
+func startTestProxy(t *testing.T, auth string, headers chan<- http.Header) string {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil || req.Method != http.MethodConnect {
+					return
+				}
+				headers <- req.Header
+				if req.Header.Get("Proxy-Authorization") != auth {
+					_, _ = io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					return
+				}
+				target, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					_, _ = io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer func() { _ = target.Close() }()
+				_, _ = io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				go func() { _, _ = io.Copy(target, conn) }()
+				_, _ = io.Copy(conn, target)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestClient_Proxy(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	addr := startTestServer(server)
+	mux := http.NewServeMux()
+	server.HandleHTTPOn(mux, defaultRPCPath, defaultDebugPath)
+	httpLis, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = httpLis.Close() }()
+	go func() { _ = http.Serve(httpLis, mux) }()
+
+	headers := make(chan http.Header, 8)
+	proxy, _ := url.Parse("http://user:secret@" + startTestProxy(t, "Basic dXNlcjpzZWNyZXQ=", headers))
+	opt := func() *Option {
+		return &Option{Proxy: proxy, ProxyHeader: http.Header{"X-Tenant": {"geerpc"}}}
+	}
+	for _, dial := range []func() (*Client, error){
+		func() (*Client, error) { return Dial("tcp", addr, opt()) },
+		func() (*Client, error) { return DialHTTP("tcp", httpLis.Addr().String(), opt()) },
+	} {
+		client, err := dial()
+		_assert(err == nil, "failed to dial through proxy: %v", err)
+		var reply int
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "expect 3, got %d %v", reply, err)
+		_ = client.Close()
+		_assert((<-headers).Get("X-Tenant") == "geerpc", "extra header should be sent to the proxy")
+	}
+
+	badProxy := *proxy
+	badProxy.User = url.UserPassword("user", "wrong")
+	_, err := Dial("tcp", addr, &Option{Proxy: &badProxy})
+	_assert(err != nil && strings.Contains(err.Error(), "407"), "expect 407, got %v", err)
+}
+
+func TestBreaker(t *testing.T) {
+	var transitions []string
+	b := NewBreaker(BreakerPolicy{
+		MinRequests:   4,
+		OpenTimeout:   50 * time.Millisecond,
+		OnStateChange: func(from, to BreakerState) { transitions = append(transitions, from.String()+"->"+to.String()) },
+	})
+	fail := func() error { return ErrServerBusy }
+	ok := func() error { return nil }
+	business := func() error { return errors.New("insufficient balance") }
+
+	for _, f := range []func() error{ok, business, business, fail} {
+		_ = b.Do(f)
+	}
+	_assert(b.State() == BreakerClosed, "business errors shouldn't open the breaker")
+	for i := 0; i < 4; i++ {
+		_ = b.Do(fail)
+	}
+	_assert(b.State() == BreakerOpen, "expect open, got %s", b.State())
+	_assert(b.Do(ok) == ErrBreakerOpen, "calls should be rejected while open")
+
+	time.Sleep(60 * time.Millisecond)
+	_assert(b.State() == BreakerHalfOpen, "expect half-open, got %s", b.State())
+	_ = b.Do(fail)
+	_assert(b.State() == BreakerOpen, "failed probe should reopen the breaker")
+	time.Sleep(60 * time.Millisecond)
+	_ = b.Do(ok)
+	_assert(b.State() == BreakerClosed, "successful probe should close the breaker, got %s", b.State())
+	expect := "closed->open,open->half-open,half-open->open,open->half-open,half-open->closed"
+	_assert(strings.Join(transitions, ",") == expect, "unexpected transitions %v", transitions)
+
+	var nilBreaker *Breaker
+	_assert(nilBreaker.Do(fail) == ErrServerBusy, "nil breaker should just call f")
+}
+
+func TestClient_Breaker(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	server.SetRateLimiter("Foo", NewTokenBucket(1, 1))
+	client, _ := Dial("tcp", startTestServer(server), &Option{Breaker: &BreakerPolicy{MinRequests: 3, OpenTimeout: time.Minute}})
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	var err error
+	for i := 0; i < 10 && err != ErrBreakerOpen; i++ {
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	}
+	_assert(err == ErrBreakerOpen, "rate limited backend should open the breaker, got %v", err)
+	_assert(IsTransient(ErrBreakerOpen), "ErrBreakerOpen is transient so that XClient tries another server")
+}
+
+func TestClient_ErrorCode(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	_ = server.RegisterFunc("Code.Unavailable", func(_ int, _ *int) error {
+		return Errorf(CodeUnavailable, "try again later")
+	})
+	_ = server.RegisterFunc("Code.Plain", func(_ int, _ *int) error {
+		return errors.New("plain error")
+	})
+	server.SetRateLimiter("Foo", NewTokenBucket(0.001, 1))
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err := client.Call(context.Background(), "Bar.Sum", 0, &reply)
+	_assert(errors.Is(err, ErrNotFound) && CodeOf(err) == CodeNotFound, "expect not found, got %v", err)
+	_assert(strings.Contains(err.Error(), "can't find service"), "message should be kept, got %v", err)
+
+	err = client.Call(context.Background(), "Code.Unavailable", 0, &reply)
+	var rpcErr *Error
+	_assert(errors.As(err, &rpcErr) && rpcErr.Code == CodeUnavailable && rpcErr.Message == "try again later", "expect unavailable, got %v", err)
+	_assert(IsTransient(err), "unavailable errors are transient")
+
+	err = client.Call(context.Background(), "Code.Plain", 0, &reply)
+	_assert(CodeOf(err) == CodeUnknown && err.Error() == "plain error", "expect unknown, got %v", err)
+	_assert(!errors.Is(err, ErrInternal), "unknown shouldn't match internal")
+
+	_ = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(errors.Is(err, ErrRateLimited) && errors.Is(err, ErrUnavailable), "expect rate limited, got %v", err)
+
+	_ = server.RegisterFunc("Code.Sleep", func(ctx context.Context, _ int, _ *int) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	slowClient, _ := Dial("tcp", startTestServer(server), &Option{HandleTimeout: 50 * time.Millisecond})
+	defer func() { _ = slowClient.Close() }()
+	err = slowClient.Call(context.Background(), "Code.Sleep", 0, &reply)
+	_assert(errors.Is(err, ErrTimeout), "expect handle timeout, got %v", err)
+	_assert(CodeOf(nil) == CodeOK, "nil error has no code")
+}
+
+func TestClient_MaxPendingCalls(t *testing.T) {
+	server := NewServer()
+	release := make(chan struct{})
+	_ = server.RegisterFunc("Slow.Wait", func(n int, reply *int) error {
+		<-release
+		*reply = n
+		return nil
+	})
+	addr := startTestServer(server)
+	client, _ := Dial("tcp", addr, &Option{MaxPendingCalls: 2})
+	defer func() { _ = client.Close() }()
+
+	first := client.Go("Slow.Wait", 1, new(int), nil)
+	second := client.Go("Slow.Wait", 2, new(int), nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var reply int
+	err := client.Call(ctx, "Slow.Wait", 3, &reply)
+	_assert(err != nil && strings.Contains(err.Error(), "deadline"), "expect blocked call to time out, got %v", err)
+	_assert(client.numPending() == 2, "expect 2 pending calls, got %d", client.numPending())
+
+	// 有请求完成之后阻塞的调用可以继续
+	done := make(chan error, 1)
+	go func() { done <- client.Call(context.Background(), "Slow.Wait", 3, &reply) }()
+	close(release)
+	_assert((<-first.Done).Error == nil && (<-second.Done).Error == nil, "pending calls should succeed")
+	_assert(<-done == nil && reply == 3, "blocked call should succeed, got %d", reply)
+
+	fastClient, _ := Dial("tcp", addr, &Option{MaxPendingCalls: 1, FailFastOnMaxPending: true})
+	defer func() { _ = fastClient.Close() }()
+	calls := []*Call{
+		{ServiceMethod: "Slow.Wait", Args: 1, Reply: new(int)},
+		{ServiceMethod: "Slow.Wait", Args: 2, Reply: new(int)},
+	}
+	err = fastClient.CallBatch(context.Background(), calls)
+	_assert(err == nil, "failed to call batch: %v", err)
+	_assert(calls[0].Error == nil && errors.Is(calls[1].Error, ErrTooManyPending), "expect too many pending, got %v %v", calls[0].Error, calls[1].Error)
+	_assert(fastClient.numPending() == 0, "slots should be released, got %d pending", fastClient.numPending())
+	_assert(len(fastClient.slots) == 0, "slots should be released, got %d", len(fastClient.slots))
+}
+
+func TestDialOptions(t *testing.T) {
+	server := NewServer(WithHandleTimeout(50*time.Millisecond), WithConnectTimeout(time.Second))
+	_assert(server.HandleTimeout == 50*time.Millisecond && server.HandshakeTimeout == time.Second, "server options should be applied")
+	var foo Foo
+	_ = server.Register(&foo)
+	_ = server.RegisterFunc("Slow.Sleep", func(ctx context.Context, _ int, _ *int) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	addr := startTestServer(server)
+
+	opt := &Option{AuthToken: "token"}
+	client, err := Dial("tcp", addr, opt, WithCodec(codec.GobType), WithConnectTimeout(time.Second))
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	_assert(client.opt.ConnectTimeout == time.Second && client.opt.AuthToken == "token", "options should be merged")
+	_assert(opt.MagicNumber == 0 && opt.CodecType == "", "caller's Option should not be modified")
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+	err = client.Call(context.Background(), "Slow.Sleep", 0, &reply)
+	_assert(errors.Is(err, ErrTimeout), "expect server default handle timeout, got %v", err)
+
+	for _, tc := range []struct {
+		name string
+		opts []DialOption
+	}{
+		{"conflicting codecs", []DialOption{WithCodec(codec.JsonType), WithCodec(codec.GobType)}},
+		{"conflict with Option", []DialOption{&Option{CodecType: codec.JsonType}, WithCodec(codec.GobType)}},
+		{"Option after funcs", []DialOption{WithCodec(codec.JsonType), &Option{}}},
+		{"unknown codec", []DialOption{WithCodec("application/unknown")}},
+		{"bad magic number", []DialOption{&Option{MagicNumber: 1}}},
+		{"idle timeout without heartbeat", []DialOption{&Option{IdleTimeout: time.Second}}},
+		{"fail fast without limit", []DialOption{&Option{FailFastOnMaxPending: true}}},
+	} {
+		_, err := Dial("tcp", addr, tc.opts...)
+		_assert(err != nil, "%s: expect an error", tc.name)
+	}
+	client, err = Dial("tcp", addr, WithCodec(codec.GobType), WithCodec(codec.GobType))
+	_assert(err == nil, "setting the same value twice is allowed: %v", err)
+	_ = client.Close()
+}
+
+func TestFuture(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	release := make(chan struct{})
+	_ = server.RegisterFunc("Slow.Wait", func(_ int, _ *int) error {
+		<-release
+		return nil
+	})
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	call := client.Go("Foo.Sum", Args{Num1: 1, Num2: 2}, new(int), nil)
+	_assert(call.Await(ctx) == nil && call.Await(ctx) == nil, "Await can be called more than once")
+	_assert(*call.Reply.(*int) == 3, "expect 3, got %d", *call.Reply.(*int))
+
+	// 1+2=3，再用 3+3=6
+	f := client.GoFuture(ctx, "Foo.Sum", Args{Num1: 1, Num2: 2}, new(int)).Then(func(reply interface{}) *Future {
+		n := *reply.(*int)
+		return client.GoFuture(ctx, "Foo.Sum", Args{Num1: n, Num2: n}, new(int))
+	})
+	reply, err := f.Await(ctx)
+	_assert(err == nil && *reply.(*int) == 6, "expect 6, got %v %v", reply, err)
+
+	failed := client.GoFuture(ctx, "Foo.Unknown", Args{}, new(int)).Then(func(interface{}) *Future {
+		t.Fatal("Then should not be called after an error")
+		return nil
+	})
+	_, err = failed.Await(ctx)
+	_assert(err != nil, "expect error to propagate through Then")
+
+	slow := client.GoFuture(ctx, "Slow.Wait", 0, new(int))
+	fast := client.GoFuture(ctx, "Foo.Sum", Args{Num1: 1, Num2: 1}, new(int))
+	i, err := WaitAny(ctx, slow, fast)
+	_assert(i == 1 && err == nil, "expect the fast future first, got %d %v", i, err)
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	err = WaitAll(timeout, slow, fast)
+	_assert(errors.Is(err, context.DeadlineExceeded), "expect deadline exceeded, got %v", err)
+	close(release)
+	_assert(WaitAll(ctx, slow, fast) == nil, "all futures should succeed")
+	_assert(WaitAll(ctx, slow, failed) != nil, "expect the failed future's error")
+}
+
+func TestInvoke(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+	sum, err := Invoke[Args, int](context.Background(), client, "Foo.Sum", Args{Num1: 1, Num2: 2})
+	_assert(err == nil && sum == 3, "expect 3, got %d %v", sum, err)
+	_, err = Invoke[Args, int](context.Background(), client, "Foo.Sum", Args{}, WithTimeout(time.Nanosecond))
+	_assert(err != nil, "call options should be applied")
+	_, err = Invoke[Args, string](context.Background(), client, "Foo.Sum", Args{Num1: 1, Num2: 2})
+	_assert(err != nil, "expect an error for mismatched reply type")
+}
+
+func TestClient_RawMessage(t *testing.T) {
+	type Point struct{ X, Y int }
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	_ = server.RegisterFunc("Geo.Point", func(n int, reply *Point) error {
+		*reply = Point{X: n, Y: -n}
+		return nil
+	})
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	// 第二次调用时类型定义已经在之前的回复中发送过了，RawMessage 仍然可以单独解码
+	for i := 1; i <= 2; i++ {
+		var raw RawMessage
+		err := client.Call(context.Background(), "Geo.Point", i, &raw)
+		_assert(err == nil, "failed to call with raw reply: %v", err)
+		var p Point
+		err = gob.NewDecoder(bytes.NewReader(raw)).Decode(&p)
+		_assert(err == nil && p == Point{X: i, Y: -i}, "failed to decode raw reply: %+v %v", p, err)
+
+		var sum int
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 2}, &raw)
+		_ = gob.NewDecoder(bytes.NewReader(raw)).Decode(&sum)
+		_assert(err == nil && sum == i+2, "failed to decode raw int reply: %d %v", sum, err)
+	}
+	var p Point
+	err := client.Call(context.Background(), "Geo.Point", 3, &p)
+	_assert(err == nil && p == Point{X: 3, Y: -3}, "normal reply should still work after raw replies: %+v %v", p, err)
+}
+
+type fixedSeq uint64
+
+func (s fixedSeq) Next() uint64 { return uint64(s) }
+
+func TestClient_SeqStrategy(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	release := make(chan struct{})
+	_ = server.RegisterFunc("Wait.Done", func(_ int, reply *int) error {
+		<-release
+		return nil
+	})
+	addr := startTestServer(server)
+
+	client, err := Dial("tcp", addr, WithSeqStrategy(SeqRandom))
+	_assert(err == nil, "failed to dial: %v", err)
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var reply int
+			err := client.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 1}, &reply)
+			_assert(err == nil && reply == i+1, "failed to call with random seq: %v", err)
+		}(i)
+	}
+	wg.Wait()
+	_ = client.Close()
+
+	// 生成的序列号一直和未完成的请求重复时调用失败，不会覆盖之前的请求
+	client, err = Dial("tcp", addr, &Option{
+		MagicNumber:     MagicNumber,
+		CodecType:       codec.GobType,
+		SeqStrategy:     SeqRandom,
+		NewSeqGenerator: func() SeqGenerator { return fixedSeq(7) },
+	})
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	first := client.Go("Wait.Done", 0, new(int), nil)
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(errors.Is(err, ErrSeqCollision), "expect seq collision, got %v", err)
+	close(release)
+	<-first.Done
+	_assert(first.Error == nil, "first call should not be affected: %v", first.Error)
+
+	_, err = Dial("tcp", addr, WithSeqStrategy(SeqStrategy(9)))
+	_assert(err != nil, "expect unknown seq strategy to be rejected")
+}
+
+func TestNewLocalClientServer(t *testing.T) {
+	client, server, err := NewLocalClientServer(WithAuthToken("token"), WithHeartbeat(10*time.Millisecond, time.Second))
+	_assert(err == nil, "new local client server error: %v", err)
+	defer func() { _ = client.Close() }()
+	var foo Foo
+	_ = server.Register(&foo)
+	sum, err := Invoke[Args, int](context.Background(), client, "Foo.Sum", Args{Num1: 1, Num2: 2})
+	_assert(err == nil && sum == 3, "expect 3, got %d %v", sum, err)
+
+	t.Run("listen pipe", func(t *testing.T) {
+		lis, err := ListenPipe("test-pipe")
+		_assert(err == nil, "listen pipe error: %v", err)
+		_, err = ListenPipe("test-pipe")
+		_assert(err != nil, "expect an error for a pipe in use")
+		go server.Accept(lis)
+		c, err := XDial("pipe@test-pipe", WithAuthToken("token"))
+		_assert(err == nil, "xdial pipe error: %v", err)
+		defer func() { _ = c.Close() }()
+		var reply int
+		err = c.Call(context.Background(), "Foo.Sum", Args{Num1: 2, Num2: 3}, &reply)
+		_assert(err == nil && reply == 5, "expect 5, got %d %v", reply, err)
+		_ = lis.Close()
+		_, err = Dial("pipe", "test-pipe")
+		_assert(err != nil, "expect an error after the pipe is closed")
+	})
+
+	// Shutdown 之后客户端收到 FlagGoAway，不再可用
+	_ = server.Shutdown(context.Background())
+	for i := 0; i < 100 && client.IsAvailable(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	_assert(!client.IsAvailable(), "expect the client to be unavailable after shutdown")
+}
+
+func TestMockClient(t *testing.T) {
+	var client ClientInterface = NewMockClient()
+	mock := client.(*MockClient)
+	mock.On("Foo.Sum",
+		MockResponse{Err: NewError(CodeUnavailable, "down")},
+		MockResponse{Reply: 3},
+	)
+	mock.On("Foo.Slow", MockResponse{Reply: 1, Latency: time.Second})
+	mock.On("Foo.Double", MockResponse{Func: func(args, reply interface{}) error {
+		*reply.(*int) = args.(int) * 2
+		return nil
+	}})
+
+	var reply int
+	err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(errors.Is(err, ErrUnavailable), "expect unavailable, got %v", err)
+	for i := 0; i < 2; i++ {
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply, WithMetadata(map[string]string{"k": "v"}))
+		_assert(err == nil && reply == 3, "expect 3, got %d %v", reply, err)
+	}
+	calls := mock.Calls("Foo.Sum")
+	_assert(len(calls) == 3 && calls[2].Metadata["k"] == "v", "expect 3 recorded calls with metadata, got %d", len(calls))
+
+	call := <-client.Go("Foo.Double", 4, &reply, nil).Done
+	_assert(call.Error == nil && reply == 8, "expect 8, got %d %v", reply, call.Error)
+
+	start := time.Now()
+	err = client.Call(context.Background(), "Foo.Slow", 0, &reply, WithTimeout(10*time.Millisecond))
+	_assert(err != nil && time.Since(start) < time.Second, "expect the latency to be cut by the timeout, got %v", err)
+	err = client.Call(context.Background(), "Foo.Unknown", 0, &reply)
+	_assert(errors.Is(err, ErrNotFound), "expect not found, got %v", err)
+	var s string
+	err = client.Call(context.Background(), "Foo.Sum", 0, &s)
+	_assert(err != nil, "expect an error for mismatched reply type")
+
+	_ = client.Close()
+	err = client.Call(context.Background(), "Foo.Sum", 0, &reply)
+	_assert(errors.Is(err, ErrShutdown), "expect ErrShutdown after close, got %v", err)
+	_assert(len(mock.Calls("")) == 8, "expect 8 recorded calls, got %d", len(mock.Calls("")))
+}
+
+func TestTCPOptions(t *testing.T) {
+	tcp := &TCPOptions{DisableNoDelay: true, KeepAlive: time.Minute, ReadBuffer: 1 << 20, WriteBuffer: 1 << 20}
+	server := NewServer(WithTCPOptions(tcp))
+	_assert(server.TCP == tcp, "expect NewServer to use the TCP options")
+	var foo Foo
+	_ = server.Register(&foo)
+	addr := startTestServer(server)
+	client, err := Dial("tcp", addr, WithTCPOptions(tcp))
+	_assert(err == nil, "dial with TCP options error: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect 3, got %d %v", reply, err)
+
+	_, err = Dial("tcp", addr, WithTCPOptions(&TCPOptions{ReadBuffer: -1}))
+	_assert(err != nil, "expect an error for negative buffer size")
+	conn, err := net.Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = conn.Close() }()
+	_assert((&TCPOptions{KeepAlive: -1}).apply(conn) == nil, "expect to disable keepalive")
+	c1, c2 := net.Pipe()
+	defer func() { _ = c1.Close(); _ = c2.Close() }()
+	_assert(tcp.apply(c1) == nil, "expect non-TCP connections to be ignored")
+}
+
+func TestClient_CloseContext(t *testing.T) {
+	server := NewServer()
+	_ = server.RegisterFunc("Slow.Sleep", func(ms int, reply *int) error {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		*reply = ms
+		return nil
+	})
+	addr := startTestServer(server)
+
+	t.Run("graceful", func(t *testing.T) {
+		client, _ := Dial("tcp", addr)
+		var reply int
+		inflight := client.Go("Slow.Sleep", 100, &reply, nil)
+		done := make(chan error, 1)
+		go func() { done <- client.CloseContext(context.Background()) }()
+		time.Sleep(20 * time.Millisecond)
+		err := client.Call(context.Background(), "Slow.Sleep", 1, nil)
+		_assert(errors.Is(err, ErrShutdown), "expect ErrShutdown for new calls while draining, got %v", err)
+		_assert(<-done == nil, "expect CloseContext to return nil after pending calls complete")
+		<-inflight.Done
+		_assert(inflight.Error == nil && reply == 100, "in-flight call should complete: %v", inflight.Error)
+		_assert(errors.Is(client.CloseContext(context.Background()), ErrShutdown), "expect ErrShutdown for closing twice")
+	})
+	t.Run("deadline", func(t *testing.T) {
+		client, _ := Dial("tcp", addr)
+		inflight := client.Go("Slow.Sleep", 2000, nil, nil)
+		time.Sleep(10 * time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		err := client.CloseContext(ctx)
+		_assert(errors.Is(err, context.DeadlineExceeded), "expect deadline exceeded, got %v", err)
+		<-inflight.Done
+		_assert(errors.Is(inflight.Error, ErrShutdown), "expect pending calls to end with ErrShutdown, got %v", inflight.Error)
+	})
+	t.Run("close now", func(t *testing.T) {
+		client, _ := Dial("tcp", addr)
+		inflight := client.Go("Slow.Sleep", 2000, nil, nil)
+		time.Sleep(10 * time.Millisecond)
+		_ = client.CloseNow()
+		<-inflight.Done
+		_assert(errors.Is(inflight.Error, ErrShutdown), "expect ErrShutdown, got %v", inflight.Error)
+		_assert(errors.Is(client.Close(), ErrShutdown), "expect ErrShutdown for closing twice")
+	})
 }
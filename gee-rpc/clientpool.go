@@ -0,0 +1,162 @@
+package geerpc
+
+import (
+	"context"
+	"io"
+	"log"
+	"sync"
+)
+
+// PoolMode 决定 Pool 把调用分配到哪一条连接上
+type PoolMode int
+
+const (
+	PoolRoundRobin   PoolMode = iota // 依次使用每一条连接
+	PoolLeastPending                 // 使用未完成请求最少的连接
+)
+
+// Pool 维护到同一个服务端的多条连接，单条 TCP 连接成为瓶颈时使用，
+// 连接在第一次使用时建立，断开的连接会在下一次被选中时重新建立，
+// 建立连接时不持有锁，一条连接重连很慢不会影响选中其他连接的调用
+type Pool struct {
+	rpcAddr string // 格式与 XDial 相同，比如 tcp@10.0.0.1:9999
+	mode    PoolMode
+	opt     *Option
+	mu      sync.Mutex
+	clients []*Client
+	dialing []*poolDial // 正在建立的连接，选中同一条连接的调用等待同一次 XDial
+	next    int
+	closed  bool
+}
+
+// poolDial 是一次正在进行的 XDial，done 关闭之后 client 和 err 才可以读取
+type poolDial struct {
+	done   chan struct{}
+	client *Client
+	err    error
+}
+
+var (
+	_ io.Closer       = (*Pool)(nil)
+	_ ClientInterface = (*Pool)(nil)
+)
+
+// NewPool 创建一个最多有 size 条连接的 Pool
+func NewPool(rpcAddr string, size int, mode PoolMode, opt *Option) *Pool {
+	if size <= 0 {
+		size = 1
+	}
+	return &Pool{rpcAddr: rpcAddr, mode: mode, opt: opt, clients: make([]*Client, size), dialing: make([]*poolDial, size)}
+}
+
+// Close 关闭所有的连接，之后的调用返回 ErrShutdown，正在建立的连接完成之后也会被关闭
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	for i, client := range p.clients {
+		if client != nil {
+			_ = client.Close()
+			p.clients[i] = nil
+		}
+	}
+	return nil
+}
+
+// get 按照 mode 选出一条连接，不可用的连接会被关闭并重新建立
+func (p *Pool) get() (*Client, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrShutdown
+	}
+	i := p.pick()
+	client := p.clients[i]
+	if client != nil && client.IsAvailable() {
+		p.mu.Unlock()
+		return client, nil
+	}
+	if client != nil {
+		p.logger().Debugf("rpc pool: connection %d to %s is unavailable, redial", i, p.rpcAddr)
+		_ = client.Close()
+		p.clients[i] = nil
+	}
+	d := p.dialing[i]
+	if d != nil {
+		p.mu.Unlock()
+		<-d.done
+		return d.client, d.err
+	}
+	d = &poolDial{done: make(chan struct{})}
+	p.dialing[i] = d
+	p.mu.Unlock()
+
+	client, err := XDial(p.rpcAddr, p.opt)
+	p.mu.Lock()
+	p.dialing[i] = nil
+	if err == nil && p.closed {
+		_ = client.Close()
+		client, err = nil, ErrShutdown
+	}
+	if err == nil {
+		p.clients[i] = client
+	}
+	p.mu.Unlock()
+	d.client, d.err = client, err
+	close(d.done)
+	return client, err
+}
+
+func (p *Pool) pick() int {
+	if p.mode == PoolLeastPending {
+		best, min := 0, -1
+		for i, client := range p.clients {
+			if client == nil || !client.IsAvailable() {
+				if p.dialing[i] != nil {
+					continue // 正在重连，有可用的连接时不等待它
+				}
+				// 还没有建立的连接没有任何请求，优先使用
+				return i
+			}
+			if n := client.numPending(); min < 0 || n < min {
+				best, min = i, n
+			}
+		}
+		return best
+	}
+	i := p.next
+	p.next = (p.next + 1) % len(p.clients)
+	return i
+}
+
+func (p *Pool) logger() Logger {
+	if p.opt == nil {
+		return DefaultLogger
+	}
+	return p.opt.logger()
+}
+
+// Call 与 Client.Call 相同，会经过被选中的连接上的拦截器
+func (p *Pool) Call(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error {
+	client, err := p.get()
+	if err != nil {
+		return err
+	}
+	return client.Call(ctx, serviceMethod, args, reply, opts...)
+}
+
+// Go 与 Client.Go 相同，建立连接失败时返回的 Call 已经结束，Error 为失败的原因
+func (p *Pool) Go(serviceMethod string, args, reply interface{}, done chan *Call, opts ...CallOption) *Call {
+	client, err := p.get()
+	if err != nil {
+		if done == nil {
+			done = make(chan *Call, 10)
+		} else if cap(done) == 0 {
+			log.Panic("rpc pool: done channel is unbuffered")
+		}
+		call := &Call{ServiceMethod: serviceMethod, Args: args, Reply: reply, Error: err, Done: done}
+		call.done()
+		return call
+	}
+	return client.Go(serviceMethod, args, reply, done, opts...)
+}
@@ -0,0 +1,84 @@
+// geerpc-bench 对 geerpc 进行压测，输出各种编解码方式、连接数和数据大小组合下的吞吐量和延迟。
+//
+// 用法：
+//
+//	geerpc-bench -conns 1,4,16 -size 16,1024 -duration 5s        # 在本进程中启动服务端
+//	geerpc-bench -serve tcp@:9999                                 # 只启动服务端
+//	geerpc-bench -addr tcp@10.0.0.1:9999 -concurrency 64          # 压测其他机器上的服务端
+package main
+
+import (
+	"context"
+	"flag"
+	"geerpc"
+	"geerpc/benchmarks"
+	"geerpc/codec"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func main() {
+	serve := flag.String("serve", "", "only start a bench server on this address, like tcp@:9999")
+	addr := flag.String("addr", "", "address of the bench server, start one in this process if empty")
+	codecs := flag.String("codec", "", "comma-separated list of codec types, default all registered codecs")
+	conns := flag.String("conns", "1", "comma-separated list of connection counts")
+	sizes := flag.String("size", "16", "comma-separated list of payload sizes in bytes")
+	concurrency := flag.Int("concurrency", 0, "number of concurrent callers, default the number of connections")
+	duration := flag.Duration("duration", 3*time.Second, "duration of each run")
+	requests := flag.Int("requests", 0, "number of requests of each run, overrides -duration")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *serve != "" {
+		log.Printf("geerpc-bench: serving on %s", *serve)
+		if err := geerpc.ListenAndServe(*serve, geerpc.WithServer(benchmarks.NewServer()), geerpc.WithContext(ctx)); err != nil {
+			log.Fatal("geerpc-bench: ", err)
+		}
+		return
+	}
+
+	var types []codec.Type
+	for _, t := range split(*codecs) {
+		types = append(types, codec.Type(t))
+	}
+	base := benchmarks.Config{Addr: *addr, Concurrency: *concurrency, Duration: *duration, Requests: *requests}
+	var results []*benchmarks.Result
+	for _, cfg := range benchmarks.Matrix(base, types, ints(*conns), ints(*sizes)) {
+		r, err := benchmarks.Run(ctx, cfg)
+		if err != nil {
+			log.Fatalf("geerpc-bench: %s: %v", cfg, err)
+		}
+		results = append(results, r)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	_ = benchmarks.WriteReport(os.Stdout, results)
+}
+
+func split(s string) []string {
+	var parts []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return parts
+}
+
+func ints(s string) []int {
+	var values []int
+	for _, p := range split(s) {
+		n, err := strconv.Atoi(p)
+		if err != nil || n <= 0 {
+			log.Fatalf("geerpc-bench: invalid number %q", p)
+		}
+		values = append(values, n)
+	}
+	return values
+}
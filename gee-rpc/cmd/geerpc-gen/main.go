@@ -0,0 +1,269 @@
+// geerpc-gen 为一个包中的服务生成强类型的客户端，调用时不再需要手写 "Foo.Sum" 这样的字符串。
+//
+// 用法：
+//
+//	geerpc-gen [-type Foo,Bar] [-o foo_geerpc.go] [dir]
+//
+// 服务方法的格式和 Server.Register 的要求相同，func (t *T) Method([ctx context.Context,] args A, reply *R) error，
+// 对每个服务 T 生成 TClient，通过 NewTClient(*geerpc.Client) 或者 NewTXClient(*xclient.XClient) 创建，
+// 生成的方法为 func (c *TClient) Method(ctx context.Context, args A) (R, error)。
+// 流式方法不会生成。也可以在服务所在的文件中加上 //go:generate geerpc-gen -type T
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+const defaultOutput = "geerpc_client.go"
+
+func main() {
+	types := flag.String("type", "", "comma-separated list of service types, default all types with rpc methods")
+	output := flag.String("o", defaultOutput, "output file name, relative to dir")
+	flag.Parse()
+	dir := "."
+	if flag.NArg() > 0 {
+		dir = flag.Arg(0)
+	}
+	var names []string
+	if *types != "" {
+		names = strings.Split(*types, ",")
+	}
+	out := *output
+	if !filepath.IsAbs(out) {
+		out = filepath.Join(dir, out)
+	}
+	src, err := generate(dir, names, filepath.Base(out))
+	if err != nil {
+		log.Fatal("geerpc-gen: ", err)
+	}
+	if err := os.WriteFile(out, src, 0644); err != nil {
+		log.Fatal("geerpc-gen: ", err)
+	}
+}
+
+type genService struct {
+	Name    string
+	Methods []genMethod
+}
+
+type genMethod struct {
+	Name  string
+	Args  string // 输入参数的类型
+	Reply string // 输出参数指向的类型
+}
+
+type genFile struct {
+	Package  string
+	Imports  []string
+	Services []*genService
+}
+
+// generate 解析 dir 中的 Go 文件，为 types 中的服务生成客户端代码，types 为空时为所有包含服务方法的类型生成，
+// output 为生成的文件名，解析时会跳过它
+func generate(dir string, types []string, output string) ([]byte, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && fi.Name() != output
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expect exactly one package in %s, got %d", dir, len(pkgs))
+	}
+	var pkg *ast.Package
+	for _, p := range pkgs {
+		pkg = p
+	}
+
+	want := make(map[string]bool, len(types))
+	for _, t := range types {
+		want[strings.TrimSpace(t)] = true
+	}
+	services := make(map[string]*genService)
+	imports := make(map[string]bool)
+	for _, file := range pkg.Files {
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Recv == nil || !fn.Name.IsExported() {
+				continue
+			}
+			recv := receiverName(fn.Recv.List[0].Type)
+			if recv == "" || !ast.IsExported(recv) || (len(want) > 0 && !want[recv]) {
+				continue
+			}
+			m, ok := rpcMethod(fset, fn)
+			if !ok {
+				continue
+			}
+			s := services[recv]
+			if s == nil {
+				s = &genService{Name: recv}
+				services[recv] = s
+			}
+			s.Methods = append(s.Methods, m)
+			for _, path := range usedImports(file, fn.Type.Params) {
+				imports[path] = true
+			}
+		}
+	}
+	for t := range want {
+		if services[t] == nil {
+			return nil, fmt.Errorf("type %s has no rpc methods", t)
+		}
+	}
+	if len(services) == 0 {
+		return nil, fmt.Errorf("no rpc methods found in %s", dir)
+	}
+
+	f := &genFile{Package: pkg.Name}
+	for _, s := range services {
+		sort.Slice(s.Methods, func(i, j int) bool { return s.Methods[i].Name < s.Methods[j].Name })
+		f.Services = append(f.Services, s)
+	}
+	sort.Slice(f.Services, func(i, j int) bool { return f.Services[i].Name < f.Services[j].Name })
+	for _, path := range []string{"context", "geerpc", "geerpc/xclient"} {
+		imports[path] = true
+	}
+	for path := range imports {
+		f.Imports = append(f.Imports, path)
+	}
+	sort.Strings(f.Imports)
+
+	var buf bytes.Buffer
+	if err := clientTemplate.Execute(&buf, f); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
+
+// receiverName 返回接收者的类型名，T 和 *T 都返回 T
+func receiverName(expr ast.Expr) string {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// rpcMethod 判断 fn 是否符合服务方法的格式，流式方法和回复参数不是指针的方法不生成
+func rpcMethod(fset *token.FileSet, fn *ast.FuncDecl) (genMethod, bool) {
+	var params []ast.Expr
+	for _, field := range fn.Type.Params.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			params = append(params, field.Type)
+		}
+	}
+	if len(params) == 3 && isSelector(params[0], "context", "Context") {
+		params = params[1:]
+	}
+	results := fn.Type.Results
+	if len(params) != 2 || results == nil || len(results.List) != 1 || len(results.List[0].Names) > 1 {
+		return genMethod{}, false
+	}
+	if ident, ok := results.List[0].Type.(*ast.Ident); !ok || ident.Name != "error" {
+		return genMethod{}, false
+	}
+	reply, ok := params[1].(*ast.StarExpr)
+	if !ok || isSelector(reply.X, "geerpc", "ServerStream") {
+		return genMethod{}, false
+	}
+	return genMethod{Name: fn.Name.Name, Args: exprString(fset, params[0]), Reply: exprString(fset, reply.X)}, true
+}
+
+func isSelector(expr ast.Expr, pkg, name string) bool {
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+	x, ok := sel.X.(*ast.Ident)
+	return ok && x.Name == pkg && sel.Sel.Name == name
+}
+
+func exprString(fset *token.FileSet, expr ast.Expr) string {
+	var buf bytes.Buffer
+	_ = printer.Fprint(&buf, fset, expr)
+	return buf.String()
+}
+
+// usedImports 返回参数类型中引用到的其他包的导入路径
+func usedImports(file *ast.File, params *ast.FieldList) []string {
+	names := make(map[string]bool)
+	ast.Inspect(params, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if x, ok := sel.X.(*ast.Ident); ok {
+				names[x.Name] = true
+			}
+		}
+		return true
+	})
+	var paths []string
+	for _, spec := range file.Imports {
+		path, _ := strconv.Unquote(spec.Path.Value)
+		name := path[strings.LastIndex(path, "/")+1:]
+		if spec.Name != nil {
+			name = spec.Name.Name
+		}
+		if names[name] && path != "context" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+const clientText = `// Code generated by geerpc-gen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+{{- range .Imports}}
+	"{{.}}"
+{{- end}}
+)
+{{range .Services}}
+// {{.Name}}Client 是 {{.Name}} 服务的客户端
+type {{.Name}}Client struct {
+	call func(ctx context.Context, serviceMethod string, args, reply interface{}) error
+}
+
+// New{{.Name}}Client 通过 c 调用 {{.Name}} 服务
+func New{{.Name}}Client(c *geerpc.Client) *{{.Name}}Client {
+	return &{{.Name}}Client{call: func(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+		return c.Call(ctx, serviceMethod, args, reply)
+	}}
+}
+
+// New{{.Name}}XClient 通过 xc 调用 {{.Name}} 服务，按照 xc 的负载均衡策略选择实例
+func New{{.Name}}XClient(xc *xclient.XClient) *{{.Name}}Client {
+	return &{{.Name}}Client{call: xc.Call}
+}
+{{$service := .Name}}{{range .Methods}}
+// {{.Name}} 调用 {{$service}}.{{.Name}}
+func (c *{{$service}}Client) {{.Name}}(ctx context.Context, args {{.Args}}) ({{.Reply}}, error) {
+	var reply {{.Reply}}
+	err := c.call(ctx, "{{$service}}.{{.Name}}", args, &reply)
+	return reply, err
+}
+{{end}}{{end}}`
+
+var clientTemplate = template.Must(template.New("geerpc-gen").Parse(clientText))
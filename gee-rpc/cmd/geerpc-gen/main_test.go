@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	src, err := generate("testdata/arith", nil, defaultOutput)
+	if err != nil {
+		t.Fatal(err)
+	}
+	golden, err := os.ReadFile("testdata/arith/geerpc_client.go.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, golden) {
+		t.Fatalf("generated code differs from the golden file:\n%s", src)
+	}
+
+	src, err = generate("testdata/arith", []string{"Echo"}, defaultOutput)
+	if err != nil || strings.Contains(string(src), "ArithClient") || strings.Contains(string(src), `"time"`) {
+		t.Fatalf("expect only EchoClient, got %v\n%s", err, src)
+	}
+	if _, err := generate("testdata/arith", []string{"Args"}, defaultOutput); err == nil {
+		t.Fatal("expect an error for a type without rpc methods")
+	}
+}
@@ -0,0 +1,41 @@
+package arith
+
+import (
+	"context"
+	"geerpc"
+	"time"
+)
+
+type Args struct {
+	Num1, Num2 int
+}
+
+type Arith int
+
+func (a *Arith) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func (a Arith) Sleep(ctx context.Context, d time.Duration, reply *time.Time) error {
+	time.Sleep(d)
+	*reply = time.Now()
+	return nil
+}
+
+// Count 是流式方法，不会生成
+func (a *Arith) Count(n int, stream *geerpc.ServerStream) error {
+	return nil
+}
+
+// add 没有导出，不会生成
+func (a *Arith) add(args Args, reply *int) error {
+	return nil
+}
+
+type Echo struct{}
+
+func (e Echo) Echo(msg string, reply *string) error {
+	*reply = msg
+	return nil
+}
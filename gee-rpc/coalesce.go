@@ -0,0 +1,139 @@
+package geerpc
+
+import (
+	"errors"
+	"geerpc/codec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CoalescePolicy 开启服务端的写合并：回复先写入缓冲区，等待 Delay 或者缓冲了 MaxBatch 个回复之后一起发送，
+// 客户端大量并发调用（pipelining）时可以把很多次 write 系统调用合并成一次，代价是每个回复最多增加 Delay 的延迟。
+// 只对实现了 codec.BufferedWriter 的编解码器生效，比如 gob
+type CoalescePolicy struct {
+	Delay    time.Duration // 第一个回复写入缓冲区之后最多等待的时间，默认 100µs
+	MaxBatch int           // 缓冲区中的回复达到这么多个时立即发送，默认 64
+}
+
+const (
+	defaultCoalesceDelay    = 100 * time.Microsecond
+	defaultCoalesceMaxBatch = 64
+)
+
+// coalescingCodec 包装连接的编解码器，Write 只写入缓冲区，由 MaxBatch 或者定时器触发发送
+type coalescingCodec struct {
+	codec.Codec
+	bw       codec.BufferedWriter
+	delay    time.Duration
+	maxBatch int
+
+	mu      sync.Mutex // 定时器在 sending 之外发送，和 Write 互斥
+	pending int        // 缓冲区中还没有发送的帧数
+	timer   *time.Timer
+	closed  int32
+}
+
+var (
+	_ codec.Sizer         = (*coalescingCodec)(nil)
+	_ codec.BodyMarshaler = (*coalescingCodec)(nil)
+)
+
+// coalesce 按照 Server.Coalesce 包装 cc，没有开启写合并或者 cc 不支持缓冲写入时返回 cc 本身
+func (server *Server) coalesce(cc codec.Codec) codec.Codec {
+	p := server.Coalesce
+	bw, ok := cc.(codec.BufferedWriter)
+	if p == nil || !ok {
+		return cc
+	}
+	c := &coalescingCodec{Codec: cc, bw: bw, delay: p.Delay, maxBatch: p.MaxBatch}
+	if c.delay <= 0 {
+		c.delay = defaultCoalesceDelay
+	}
+	if c.maxBatch <= 0 {
+		c.maxBatch = defaultCoalesceMaxBatch
+	}
+	return c
+}
+
+// Write 写入缓冲区，返回 nil 不代表已经发送，发送的错误会关闭连接，由读取请求的一方发现
+func (c *coalescingCodec) Write(h *codec.Header, body interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return ErrShutdown
+	}
+	if err := c.bw.WriteBuffered(h, body); err != nil {
+		return err
+	}
+	c.pending++
+	if c.pending >= c.maxBatch {
+		return c.flushLocked()
+	}
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.delay, c.flush)
+	} else if c.pending == 1 {
+		c.timer.Reset(c.delay)
+	}
+	return nil
+}
+
+func (c *coalescingCodec) flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if atomic.LoadInt32(&c.closed) == 0 {
+		_ = c.flushLocked()
+	}
+}
+
+func (c *coalescingCodec) flushLocked() error {
+	if c.pending == 0 {
+		return nil
+	}
+	c.pending = 0
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	return c.bw.Flush()
+}
+
+// Close 发送缓冲区中剩余的回复之后关闭连接，正在发送时直接关闭，让阻塞的写入出错返回
+func (c *coalescingCodec) Close() error {
+	if c.mu.TryLock() {
+		if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+			_ = c.flushLocked()
+		}
+		c.mu.Unlock()
+	}
+	atomic.StoreInt32(&c.closed, 1)
+	return c.Codec.Close()
+}
+
+func (c *coalescingCodec) BytesRead() uint64 {
+	return bytesRead(c.Codec)
+}
+
+func (c *coalescingCodec) BytesWritten() uint64 {
+	return bytesWritten(c.Codec)
+}
+
+func (c *coalescingCodec) BodySize(body interface{}) (int, error) {
+	if s, ok := c.Codec.(codec.Sizer); ok {
+		return s.BodySize(body)
+	}
+	return 0, nil
+}
+
+func (c *coalescingCodec) MarshalBody(body interface{}) ([]byte, error) {
+	if m, ok := c.Codec.(codec.BodyMarshaler); ok {
+		return m.MarshalBody(body)
+	}
+	return nil, errors.New("rpc server: codec doesn't support MarshalBody")
+}
+
+func (c *coalescingCodec) UnmarshalBody(data []byte, body interface{}) error {
+	if m, ok := c.Codec.(codec.BodyMarshaler); ok {
+		return m.UnmarshalBody(data, body)
+	}
+	return errors.New("rpc server: codec doesn't support UnmarshalBody")
+}
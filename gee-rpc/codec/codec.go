@@ -1,13 +1,38 @@
 package codec
 
-import "io"
+import (
+	"encoding/gob"
+	"io"
+	"time"
+)
 
 type Header struct {
-	ServiceMethod string // 调用服务方法的格式为："Service.Method"
-	Seq           uint64 // 由客户端选择相应的序列号
-	Error         string
+	ServiceMethod string            // 调用服务方法的格式为："Service.Method"
+	Seq           uint64            // 由客户端选择相应的序列号
+	Error         string            // 错误信息，为空表示调用成功
+	Code          uint32            // 错误码，取值见 geerpc.Code，Error 为空时为 0
+	Metadata      map[string]string // 附加的键值对信息，比如 trace 信息
+	Timeout       time.Duration     // 客户端剩余的超时时间，服务端据此控制处理时间，0 表示不限制
+	Flags         Flag              // 帧的类型，普通的请求和响应为 0
+	Priority      uint8             // 请求的优先级，取值见 geerpc.Priority，0 为普通优先级
+	Details       bool              // Error 不为空时表示 body 是错误的详细信息，参考 geerpc.ErrorDetailer
 }
 
+// Flag 标记一帧数据的类型，一个流式调用的所有帧使用同一个 Seq，
+// 发起流式调用的请求和普通请求一样，之后双方发送的帧都带有 FlagStream
+type Flag uint8
+
+const (
+	FlagStream Flag = 1 << iota // 属于一个流式调用
+	FlagEnd                     // 这个方向的最后一帧，Error 不为空时表示出错，出现在发起调用的请求中表示客户端不再发送数据
+	FlagWindow                  // 窗口更新，body 为对方可以继续发送的帧数
+	FlagPing                    // 客户端发送的心跳，Seq 为 0
+	FlagPong                    // 服务端对心跳的回复，Seq 为 0
+	FlagCancel                  // 客户端放弃了 Seq 对应的请求，服务端不需要再处理和回复
+	FlagGoAway                  // 服务端即将关闭，客户端不要在这个连接上发起新的调用，Seq 为 0
+	FlagChunk                   // 很大的回复被拆成多帧发送，body 是 []byte 的一段，带有 FlagEnd 的最后一帧带有完整的 Header
+)
+
 // Codec 定义编码的工厂接口
 // 因为要支持多种编解码方式，所以这抽象出一个Codec接口
 type Codec interface {
@@ -17,6 +42,34 @@ type Codec interface {
 	Write(*Header, interface{}) error
 }
 
+// BufferedWriter 由支持缓冲写入的 Codec 实现，WriteBuffered 只写入缓冲区，调用 Flush 之后才会发送，
+// 客户端批量发送请求时用来减少系统调用的次数
+type BufferedWriter interface {
+	WriteBuffered(*Header, interface{}) error
+	Flush() error
+}
+
+// BodyMarshaler 由能够单独编码 body 的 Codec 实现，服务端把很大的回复编码之后拆成多帧发送，
+// 客户端收到所有的帧之后拼接起来使用 UnmarshalBody 解码，参考 FlagChunk
+type BodyMarshaler interface {
+	MarshalBody(body interface{}) ([]byte, error)
+	UnmarshalBody(data []byte, body interface{}) error
+}
+
+// RawMessage 作为 ReadBody 的参数时，编解码器不解码 body，而是保存原始的数据，
+// 网关和代理不需要知道回复的具体类型就可以转发。对于 gob 编码，RawMessage 是一个完整的 gob 流，
+// 包括值依赖的类型定义，可以使用 gob.NewDecoder(bytes.NewReader(raw)).Decode 解码
+type RawMessage []byte
+
+// Sizer 由能够统计编码大小的 Codec 实现，服务端据此统计每个方法请求和回复的大小，
+// BytesRead 和 BytesWritten 是累计值，调用方在读写前后取差值就是一帧 Header 和 Body 的大小
+type Sizer interface {
+	BytesRead() uint64
+	BytesWritten() uint64
+	// BodySize 返回 body 编码之后的字节数，不会写入连接，用于在发送之前检查回复的大小
+	BodySize(body interface{}) (int, error)
+}
+
 // NewCodecFunc 定义工厂方法返回的内容，返回的不是一个示例，而是一个构造函数
 type NewCodecFunc func(io.ReadWriteCloser) Codec
 
@@ -29,7 +82,15 @@ const (
 
 var NewCodecFuncMap map[Type]NewCodecFunc
 
+// RegisterTypeFunc 在编解码器中登记接口字段中可能出现的具体类型，参考 geerpc.RegisterType
+type RegisterTypeFunc func(value interface{})
+
+// RegisterTypeFuncMap 保存每种编解码方式登记类型的方法，不需要登记类型的编解码方式可以不提供
+var RegisterTypeFuncMap map[Type]RegisterTypeFunc
+
 func init() {
 	NewCodecFuncMap = make(map[Type]NewCodecFunc)
 	NewCodecFuncMap[GobType] = NewGobCodec
+	RegisterTypeFuncMap = make(map[Type]RegisterTypeFunc)
+	RegisterTypeFuncMap[GobType] = gob.Register
 }
@@ -2,9 +2,10 @@ package codec
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/gob"
 	"io"
-	"log"
+	"sync/atomic"
 )
 
 // 具体实现一个编码对象，在Codec.go中相当于建立一个编码组件的抽象，然后可以具体实现为JSON编解码，或者是这里的Gob编解码
@@ -15,46 +16,224 @@ type GobCodec struct {
 	buf  *bufio.Writer      // 防止阻塞创建一个带缓冲的 buf, 一般这么做可以提升性能
 	dec  *gob.Decoder
 	enc  *gob.Encoder
+	in   *countingByteReader
+	out  *countingWriter
+	// types 是连接上收到的所有类型定义消息，读取 RawMessage 时放在值的前面，
+	// 这样 RawMessage 本身就是一个完整的 gob 流，不依赖这条连接的解码状态
+	types []byte
 }
 
 var _ Codec = (*GobCodec)(nil)
+var _ BufferedWriter = (*GobCodec)(nil)
+var _ Sizer = (*GobCodec)(nil)
+var _ BodyMarshaler = (*GobCodec)(nil)
 
 func NewGobCodec(conn io.ReadWriteCloser) Codec {
 	buf := bufio.NewWriter(conn) // 初始化的时候传入 conn
+	// gob 对不是 io.ByteReader 的输入本来也会套一层 bufio.Reader，这里自己套上，
+	// 统计的就是解码实际消耗的字节数，而不是预读的字节数
+	in := &countingByteReader{r: bufio.NewReader(conn)}
+	out := &countingWriter{w: buf}
 	return &GobCodec{
 		conn: conn,
 		buf:  buf,
-		dec:  gob.NewDecoder(conn),
-		enc:  gob.NewEncoder(conn),
+		dec:  gob.NewDecoder(in),
+		enc:  gob.NewEncoder(out), // 编码结果先写入 buf，Flush 时再一起发送
+		in:   in,
+		out:  out,
 	}
 }
 
+// BytesRead 返回解码消耗的字节数
+func (c *GobCodec) BytesRead() uint64 {
+	return atomic.LoadUint64(&c.in.n)
+}
+
+// BytesWritten 返回编码写入的字节数，包括还在缓冲区中没有发送的部分
+func (c *GobCodec) BytesWritten() uint64 {
+	return atomic.LoadUint64(&c.out.n)
+}
+
+// BodySize 使用一个新的 Encoder 编码 body，结果包括类型信息，所以比在连接上发送时略大
+func (c *GobCodec) BodySize(body interface{}) (int, error) {
+	w := &countingWriter{w: io.Discard}
+	if err := gob.NewEncoder(w).Encode(body); err != nil {
+		return 0, err
+	}
+	return int(w.n), nil
+}
+
+// MarshalBody 使用一个新的 Encoder 编码 body，结果是包括类型定义的完整 gob 流，不依赖连接的编码状态
+func (c *GobCodec) MarshalBody(body interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(body); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBody 解码 MarshalBody 的结果，body 为 *RawMessage 时直接保存 data，和 ReadBody 的格式相同
+func (c *GobCodec) UnmarshalBody(data []byte, body interface{}) error {
+	if raw, ok := body.(*RawMessage); ok {
+		*raw = append((*raw)[:0], data...)
+		return nil
+	}
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(body)
+}
+
 func (c *GobCodec) ReadHeader(h *Header) error {
-	return c.dec.Decode(h)
+	_, err := c.decode(h)
+	return err
 }
 
+// ReadBody body 为 *RawMessage 时不解码，保存这个值的原始数据和它依赖的类型定义，
+// 可以使用 gob.NewDecoder(bytes.NewReader(raw)).Decode 解码
 func (c *GobCodec) ReadBody(body interface{}) error {
-	return c.dec.Decode(body)
+	raw, ok := body.(*RawMessage)
+	if !ok {
+		_, err := c.decode(body)
+		return err
+	}
+	value, err := c.decode(nil)
+	if err != nil {
+		return err
+	}
+	*raw = append(append((*raw)[:0], c.types...), value...)
+	return nil
+}
+
+// maxRecordSize 记录解码数据的缓冲区超过这个大小之后不再复用，避免一个很大的回复一直占用内存
+const maxRecordSize = 64 << 10
+
+// decode 解码一个值，同时记录新出现的类型定义，返回值消息的原始数据，在下一次解码之前有效
+func (c *GobCodec) decode(v interface{}) ([]byte, error) {
+	c.in.record = c.in.record[:0]
+	err := c.dec.Decode(v)
+	var value []byte
+	for data := c.in.record; len(data) > 0; {
+		msg, id, ok := nextGobMessage(data)
+		if !ok {
+			break
+		}
+		if id < 0 {
+			c.types = append(c.types, msg...) // 类型定义消息的类型 ID 为负数
+		} else {
+			value = msg
+		}
+		data = data[len(msg):]
+	}
+	if cap(c.in.record) > maxRecordSize {
+		c.in.record = nil // value 仍然引用原来的缓冲区，下一次解码使用新的缓冲区
+	}
+	return value, err
+}
+
+// nextGobMessage 拆分出 data 中的第一个 gob 消息，格式为 | 长度 | 类型 ID | 数据 |，
+// 长度和类型 ID 使用 gob 的整数编码，消息不完整时返回 false
+func nextGobMessage(data []byte) (msg []byte, id int64, ok bool) {
+	n, width, ok := decodeGobUint(data)
+	if !ok || uint64(len(data)-width) < n {
+		return nil, 0, false
+	}
+	msg = data[:width+int(n)]
+	u, _, ok := decodeGobUint(msg[width:])
+	if !ok {
+		return nil, 0, false
+	}
+	if u&1 == 1 {
+		return msg, ^int64(u >> 1), true
+	}
+	return msg, int64(u >> 1), true
+}
+
+// decodeGobUint 解码 gob 的无符号整数，小于 128 的数只占一个字节，
+// 否则第一个字节是后面字节数的相反数，之后是大端序的数值
+func decodeGobUint(data []byte) (x uint64, width int, ok bool) {
+	if len(data) == 0 {
+		return 0, 0, false
+	}
+	if data[0] < 0x80 {
+		return uint64(data[0]), 1, true
+	}
+	n := -int(int8(data[0]))
+	if n > 8 || len(data) < n+1 {
+		return 0, 0, false
+	}
+	for _, b := range data[1 : n+1] {
+		x = x<<8 | uint64(b)
+	}
+	return x, n + 1, true
 }
 
-func (c *GobCodec) Write(h *Header, body interface{}) (err error) {
+func (c *GobCodec) Write(h *Header, body interface{}) error {
+	if err := c.WriteBuffered(h, body); err != nil {
+		return err
+	}
+	return c.Flush()
+}
+
+func (c *GobCodec) WriteBuffered(h *Header, body interface{}) (err error) {
 	defer func() {
-		_ = c.buf.Flush() // 将缓冲区写入io中
 		if err != nil {
 			_ = c.Close()
 		}
 	}()
 	if err = c.enc.Encode(h); err != nil {
-		log.Println("rpc: gob error encoding header:", err)
+		currentLogger().Errorf("rpc: gob error encoding header: %v", err)
 		return err
 	}
 	if err = c.enc.Encode(body); err != nil {
-		log.Println("rpc: gob error encoding body:", err)
+		currentLogger().Errorf("rpc: gob error encoding body: %v", err)
 		return err
 	}
 	return
 }
 
+// Flush 将缓冲区写入io中
+func (c *GobCodec) Flush() error {
+	if err := c.buf.Flush(); err != nil {
+		_ = c.Close()
+		return err
+	}
+	return nil
+}
+
 func (c *GobCodec) Close() error {
 	return c.conn.Close()
 }
+
+// countingByteReader 统计经过它读取的字节数，并把读取的数据记录在 record 中，
+// 实现了 io.ByteReader，gob 不会再额外做缓冲
+type countingByteReader struct {
+	r      *bufio.Reader
+	n      uint64
+	record []byte
+}
+
+func (r *countingByteReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	atomic.AddUint64(&r.n, uint64(n))
+	r.record = append(r.record, p[:n]...)
+	return n, err
+}
+
+func (r *countingByteReader) ReadByte() (byte, error) {
+	b, err := r.r.ReadByte()
+	if err == nil {
+		atomic.AddUint64(&r.n, 1)
+		r.record = append(r.record, b)
+	}
+	return b, err
+}
+
+// countingWriter 统计经过它写入的字节数
+type countingWriter struct {
+	w io.Writer
+	n uint64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	atomic.AddUint64(&w.n, uint64(n))
+	return n, err
+}
@@ -0,0 +1,36 @@
+package codec
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// Logger 是编解码器输出日志使用的接口，geerpc.Logger 满足这个接口
+type Logger interface {
+	Errorf(format string, v ...interface{})
+}
+
+type stdLogger struct{}
+
+func (stdLogger) Errorf(format string, v ...interface{}) { log.Printf(format, v...) }
+
+type loggerHolder struct{ Logger }
+
+var logger atomic.Value // loggerHolder
+
+func init() {
+	logger.Store(loggerHolder{stdLogger{}})
+}
+
+// SetLogger 设置编解码器输出日志使用的 Logger，比如 codec.SetLogger(geerpc.DefaultLogger)，
+// 默认直接使用标准库的 log 输出，logger 为空时恢复默认
+func SetLogger(l Logger) {
+	if l == nil {
+		l = stdLogger{}
+	}
+	logger.Store(loggerHolder{l})
+}
+
+func currentLogger() Logger {
+	return logger.Load().(loggerHolder).Logger
+}
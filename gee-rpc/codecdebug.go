@@ -0,0 +1,244 @@
+package geerpc
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"geerpc/codec"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CodecDebug 开启编解码器的调试模式：统计每个连接上编解码的帧数和耗时，并且可以输出每一帧的 hex dump，
+// 用于排查不同编解码器实现之间的互通问题。服务端设置 Server.CodecDebug，客户端设置 Option.CodecDebug，
+// 统计信息通过 ConnInfo.CodecStats 和 Client.CodecStats 读取。调试模式会降低性能，不要在生产环境中一直开启
+type CodecDebug struct {
+	// Dump 为 true 时输出每一帧的 Header 和 body，body 使用编解码器重新编码之后输出 hex dump，
+	// 和连接上的数据不一定逐字节相同（比如 gob 会带上类型定义），编解码器不支持单独编码 body 时输出 %+v
+	Dump bool
+	// Output 是 Dump 写入的位置，为空时使用 Logger 的 Debugf 输出
+	Output io.Writer
+	// RedactHeader 在输出之前修改 Header 的副本，比如去掉 Metadata 中的令牌
+	RedactHeader func(h *codec.Header)
+	// RedactBody 返回代替 body 输出的值，比如把密码字段清空的副本，不能修改 body 本身，返回 nil 时不输出 body 的内容
+	RedactBody func(h *codec.Header, body interface{}) interface{}
+}
+
+// WithCodecDebug 开启编解码器的调试模式，也可以传给 NewServer 设置服务端的 CodecDebug
+func WithCodecDebug(debug *CodecDebug) OptionFunc {
+	return func(s *optionSetter) error {
+		return s.set("CodecDebug", debug, func(opt *Option) { opt.CodecDebug = debug })
+	}
+}
+
+// CodecStats 是一个连接上编解码的统计信息
+type CodecStats struct {
+	FramesRead    uint64
+	FramesWritten uint64
+	// DecodeTime 是读取 body 的总耗时，不包括等待下一帧 Header 的时间
+	DecodeTime time.Duration
+	// EncodeTime 是发送帧的总耗时，包括写入连接的时间，开启写合并时只包括写入缓冲区的时间
+	EncodeTime   time.Duration
+	BytesRead    uint64
+	BytesWritten uint64
+}
+
+// dumpMu 让不同连接输出的帧不会交错在一起
+var dumpMu sync.Mutex
+
+// debugCodec 包装连接的编解码器，统计编解码的耗时并按照 CodecDebug 输出每一帧
+type debugCodec struct {
+	codec.Codec
+	debug  *CodecDebug
+	peer   string
+	logger Logger
+
+	framesRead    uint64
+	framesWritten uint64
+	decodeNanos   int64
+	encodeNanos   int64
+	header        codec.Header // 最近读取的 Header，只在读取的 goroutine 中访问
+}
+
+var (
+	_ codec.Sizer          = (*debugCodec)(nil)
+	_ codec.BodyMarshaler  = (*debugCodec)(nil)
+	_ codec.BufferedWriter = (*debugCodec)(nil)
+)
+
+// wrap 按照 d 包装 cc，d 为空时返回 cc 本身，peer 是对端地址，用于区分不同连接的输出
+func (d *CodecDebug) wrap(cc codec.Codec, peer string, logger Logger) codec.Codec {
+	if d == nil {
+		return cc
+	}
+	return &debugCodec{Codec: cc, debug: d, peer: peer, logger: logger}
+}
+
+func (c *debugCodec) ReadHeader(h *codec.Header) error {
+	if err := c.Codec.ReadHeader(h); err != nil {
+		return err
+	}
+	atomic.AddUint64(&c.framesRead, 1)
+	c.header = *h
+	if c.debug.Dump {
+		c.dump("<", h, nil, true)
+	}
+	return nil
+}
+
+func (c *debugCodec) ReadBody(body interface{}) error {
+	start := time.Now()
+	err := c.Codec.ReadBody(body)
+	atomic.AddInt64(&c.decodeNanos, int64(time.Since(start)))
+	if c.debug.Dump && err == nil {
+		c.dump("<", &c.header, body, false)
+	}
+	return err
+}
+
+func (c *debugCodec) Write(h *codec.Header, body interface{}) error {
+	return c.write(h, body, c.Codec.Write)
+}
+
+// WriteBuffered 在 cc 不支持缓冲写入时直接发送
+func (c *debugCodec) WriteBuffered(h *codec.Header, body interface{}) error {
+	if bw, ok := c.Codec.(codec.BufferedWriter); ok {
+		return c.write(h, body, bw.WriteBuffered)
+	}
+	return c.Write(h, body)
+}
+
+func (c *debugCodec) Flush() error {
+	if bw, ok := c.Codec.(codec.BufferedWriter); ok {
+		return bw.Flush()
+	}
+	return nil
+}
+
+func (c *debugCodec) write(h *codec.Header, body interface{}, write func(*codec.Header, interface{}) error) error {
+	if c.debug.Dump {
+		c.dump(">", h, body, true)
+	}
+	start := time.Now()
+	err := write(h, body)
+	atomic.AddInt64(&c.encodeNanos, int64(time.Since(start)))
+	atomic.AddUint64(&c.framesWritten, 1)
+	return err
+}
+
+// dump 输出一帧，direction 为 "<" 表示读取，">" 表示发送。
+// 读取时 Header 和 body 分两次输出，header 为 false 时只输出 body，body 为 nil 时只输出 Header
+func (c *debugCodec) dump(direction string, h *codec.Header, body interface{}, header bool) {
+	hc := *h
+	if hc.Metadata != nil {
+		hc.Metadata = copyMetadata(hc.Metadata)
+	}
+	if c.debug.RedactHeader != nil {
+		c.debug.RedactHeader(&hc)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "rpc codec: %s %s seq=%d", direction, c.peer, hc.Seq)
+	if header {
+		fmt.Fprintf(&b, " header %+v\n", hc)
+	}
+	if body != nil || !header {
+		if body != nil && c.debug.RedactBody != nil {
+			body = c.debug.RedactBody(&hc, body)
+		}
+		if !header {
+			b.WriteString(" ")
+		}
+		b.WriteString("body ")
+		c.dumpBody(&b, body)
+	}
+	if c.debug.Output == nil {
+		c.logger.Debugf("%s", strings.TrimSuffix(b.String(), "\n"))
+		return
+	}
+	dumpMu.Lock()
+	defer dumpMu.Unlock()
+	_, _ = io.WriteString(c.debug.Output, b.String())
+}
+
+func (c *debugCodec) dumpBody(b *strings.Builder, body interface{}) {
+	if body == nil {
+		b.WriteString("<nil>\n")
+		return
+	}
+	fmt.Fprintf(b, "%T\n", body)
+	if raw, ok := body.(*RawMessage); ok {
+		b.WriteString(hex.Dump(*raw))
+		return
+	}
+	if m, ok := c.Codec.(codec.BodyMarshaler); ok {
+		if data, err := m.MarshalBody(body); err == nil {
+			b.WriteString(hex.Dump(data))
+			return
+		}
+	}
+	fmt.Fprintf(b, "%+v\n", body)
+}
+
+// stats 返回统计信息的快照
+func (c *debugCodec) stats() CodecStats {
+	return CodecStats{
+		FramesRead:    atomic.LoadUint64(&c.framesRead),
+		FramesWritten: atomic.LoadUint64(&c.framesWritten),
+		DecodeTime:    time.Duration(atomic.LoadInt64(&c.decodeNanos)),
+		EncodeTime:    time.Duration(atomic.LoadInt64(&c.encodeNanos)),
+		BytesRead:     bytesRead(c.Codec),
+		BytesWritten:  bytesWritten(c.Codec),
+	}
+}
+
+func (c *debugCodec) BytesRead() uint64 {
+	return bytesRead(c.Codec)
+}
+
+func (c *debugCodec) BytesWritten() uint64 {
+	return bytesWritten(c.Codec)
+}
+
+func (c *debugCodec) BodySize(body interface{}) (int, error) {
+	if s, ok := c.Codec.(codec.Sizer); ok {
+		return s.BodySize(body)
+	}
+	return 0, nil
+}
+
+func (c *debugCodec) MarshalBody(body interface{}) ([]byte, error) {
+	if m, ok := c.Codec.(codec.BodyMarshaler); ok {
+		return m.MarshalBody(body)
+	}
+	return nil, errors.New("rpc: codec doesn't support MarshalBody")
+}
+
+func (c *debugCodec) UnmarshalBody(data []byte, body interface{}) error {
+	if m, ok := c.Codec.(codec.BodyMarshaler); ok {
+		return m.UnmarshalBody(data, body)
+	}
+	return errors.New("rpc: codec doesn't support UnmarshalBody")
+}
+
+// CodecStats 返回连接上编解码的统计信息，服务端没有设置 CodecDebug 时返回 false
+func (c *ConnInfo) CodecStats() (CodecStats, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.codec == nil {
+		return CodecStats{}, false
+	}
+	return c.codec.stats(), true
+}
+
+// CodecStats 返回当前连接上编解码的统计信息，重连之后从 0 开始，没有设置 Option.CodecDebug 时返回 false
+func (client *Client) CodecStats() (CodecStats, bool) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	if c, ok := client.cc.(*debugCodec); ok {
+		return c.stats(), true
+	}
+	return CodecStats{}, false
+}
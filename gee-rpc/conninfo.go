@@ -0,0 +1,74 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ConnInfo 描述服务端的一个客户端连接，Server.OnConnect、Server.OnDisconnect 和服务方法拿到的是同一个对象，
+// Set 和 Value 用来保存连接级别的数据，比如按 IP 统计的配额、鉴权之后的用户信息
+type ConnInfo struct {
+	RemoteAddr  string // 对端地址，不是 net.Conn 时为 "unknown"
+	LocalAddr   string
+	ConnectedAt time.Time
+	Identity    string // 连接通过鉴权之后调用方的身份，参考 Server.IdentityFunc
+	// TLS 是 TLS 握手完成之后的连接状态，可以从 PeerCertificates 中读取客户端证书，不是 TLS 连接时为 nil
+	TLS *tls.ConnectionState
+	// Namespace 是客户端在 Option.Namespace 中指定的命名空间，参考 Server.Mount
+	Namespace string
+
+	mu     sync.RWMutex
+	values map[interface{}]interface{}
+	codec  *debugCodec // 服务端设置了 CodecDebug 时用于读取统计信息
+}
+
+func newConnInfo(conn io.ReadWriteCloser) *ConnInfo {
+	info := &ConnInfo{RemoteAddr: remoteAddr(conn), LocalAddr: "unknown", ConnectedAt: time.Now()}
+	if c, ok := conn.(net.Conn); ok {
+		info.LocalAddr = c.LocalAddr().String()
+	}
+	return info
+}
+
+// httpConnInfo 为 JSON-RPC 和网关这类每个 HTTP 请求都是一次调用的场景创建 ConnInfo
+func httpConnInfo(r *http.Request) *ConnInfo {
+	info := &ConnInfo{RemoteAddr: r.RemoteAddr, LocalAddr: "unknown", ConnectedAt: time.Now(), TLS: r.TLS}
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		info.LocalAddr = addr.String()
+	}
+	return info
+}
+
+// Set 在连接上保存一个值，可以并发调用
+func (c *ConnInfo) Set(key, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.values == nil {
+		c.values = make(map[interface{}]interface{})
+	}
+	c.values[key] = value
+}
+
+// Value 返回 Set 保存的值，没有时返回 nil
+func (c *ConnInfo) Value(key interface{}) interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.values[key]
+}
+
+type connInfoKey struct{}
+
+func contextWithConnInfo(ctx context.Context, info *ConnInfo) context.Context {
+	return context.WithValue(ctx, connInfoKey{}, info)
+}
+
+// ConnInfoFromContext 在服务方法和中间件中返回请求所在的连接
+func ConnInfoFromContext(ctx context.Context) (*ConnInfo, bool) {
+	info, ok := ctx.Value(connInfoKey{}).(*ConnInfo)
+	return info, ok
+}
@@ -0,0 +1,285 @@
+// Package consul 使用 Consul 做服务注册和发现，服务端使用 Register 注册到本机的 Consul agent，
+// 通过 TTL 或者 HTTP 健康检查维持健康状态，客户端使用 ConsulDiscovery 获取通过健康检查的实例
+//
+// 服务使用的协议（tcp、http、unix 等，和 XDial 的格式一致）保存在 Meta 的 geerpc-protocol 中，
+// 服务的 Weights.Passing 会转换为 ?weight=N，配合 WeightedRoundRobinSelect 使用，
+// 服务的 Tags 以及 Meta 中的 version、zone 会转换为 xclient.ServerInfo 中的元数据，可以用于 XClient 的路由规则
+package consul
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"geerpc"
+	"geerpc/xclient"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	protocolMeta    = "geerpc-protocol"
+	defaultProtocol = "tcp"
+	// retryInterval 是查询失败之后重试的间隔
+	retryInterval = time.Second
+	// waitTime 是阻塞查询等待变化的最长时间
+	waitTime = time.Minute
+)
+
+// ConsulDiscovery 使用 Consul 的阻塞查询监听服务的健康实例，实例变化之后更新服务列表
+type ConsulDiscovery struct {
+	*xclient.MultiServersDiscovery
+	client  *api.Client
+	service string
+	tag     string
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	mu     sync.Mutex
+	logger geerpc.Logger
+}
+
+var _ xclient.Discovery = (*ConsulDiscovery)(nil)
+
+// NewConsulDiscovery 获取 service 通过健康检查的实例并开始监听，tag 不为空时只返回带有这个 tag 的实例，
+// 第一次获取失败时返回错误，使用完之后需要调用 Close
+func NewConsulDiscovery(client *api.Client, service, tag string) (*ConsulDiscovery, error) {
+	d := &ConsulDiscovery{
+		MultiServersDiscovery: xclient.NewMultiServersDiscovery(nil),
+		client:                client,
+		service:               service,
+		tag:                   tag,
+		done:                  make(chan struct{}),
+	}
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	go d.watch(ctx)
+	return d, nil
+}
+
+// Refresh 立即查询一次健康的实例，正常情况下服务列表由后台的阻塞查询更新，不需要调用
+func (d *ConsulDiscovery) Refresh() error {
+	_, err := d.fetch(context.Background(), 0)
+	return err
+}
+
+// Close 停止监听，不会关闭 Consul 的 client
+func (d *ConsulDiscovery) Close() error {
+	d.cancel()
+	<-d.done
+	return nil
+}
+
+// fetch 查询健康的实例并更新服务列表，index 不为 0 时是阻塞查询，直到实例变化或者超过 waitTime 才返回
+func (d *ConsulDiscovery) fetch(ctx context.Context, index uint64) (uint64, error) {
+	q := (&api.QueryOptions{WaitIndex: index, WaitTime: waitTime}).WithContext(ctx)
+	entries, meta, err := d.client.Health().Service(d.service, d.tag, true, q)
+	if err != nil {
+		return 0, err
+	}
+	servers := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		servers = append(servers, entryAddr(entry))
+	}
+	sort.Strings(servers) // 保持轮询的顺序稳定
+	_ = d.MultiServersDiscovery.Update(servers)
+	return meta.LastIndex, nil
+}
+
+// SetLogger 设置输出查询错误使用的 Logger，默认使用 geerpc.DefaultLogger
+func (d *ConsulDiscovery) SetLogger(logger geerpc.Logger) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.logger = logger
+}
+
+func (d *ConsulDiscovery) getLogger() geerpc.Logger {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.logger == nil {
+		return geerpc.DefaultLogger
+	}
+	return d.logger
+}
+
+func (d *ConsulDiscovery) watch(ctx context.Context) {
+	defer close(d.done)
+	var index uint64
+	for ctx.Err() == nil {
+		next, err := d.fetch(ctx, index)
+		if err != nil {
+			if ctx.Err() == nil {
+				d.getLogger().Errorf("rpc consul: query service error: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+			case <-time.After(retryInterval):
+			}
+			continue
+		}
+		// index 变小说明 Consul 的状态被重置了，需要从头开始查询
+		if next < index {
+			next = 0
+		}
+		index = next
+	}
+}
+
+// entryAddr 把 Consul 的实例转换为 XDial 使用的地址
+func entryAddr(entry *api.ServiceEntry) string {
+	host := entry.Service.Address
+	if host == "" {
+		host = entry.Node.Address
+	}
+	protocol := entry.Service.Meta[protocolMeta]
+	if protocol == "" {
+		protocol = defaultProtocol
+	}
+	return xclient.ServerInfo{
+		Addr:    protocol + "@" + net.JoinHostPort(host, strconv.Itoa(entry.Service.Port)),
+		Weight:  entry.Service.Weights.Passing,
+		Version: entry.Service.Meta["version"],
+		Zone:    entry.Service.Meta["zone"],
+		Tags:    entry.Service.Tags,
+	}.String()
+}
+
+// RegisterOption 设置注册到 Consul 的服务
+type RegisterOption struct {
+	Name   string   // 服务名
+	ID     string   // 实例 ID，为空时使用 Name-Addr
+	Addr   string   // 服务地址，格式和 XDial 相同，比如 tcp@10.0.0.1:9999
+	Tags   []string // 服务的 tag，ConsulDiscovery 可以按 tag 过滤
+	Weight int      // 权重，大于 0 时设置 Weights.Passing
+	// Meta 是服务的元数据，其中的 version 和 zone 会被 ConsulDiscovery 用于路由
+	Meta map[string]string
+
+	// TTL 大于 0 时使用 TTL 健康检查，Register 在后台每隔 TTL/2 上报一次健康状态，
+	// 否则 HTTPCheck 不为空时由 Consul 每隔 CheckInterval（默认 10s）请求一次这个地址，两者都为空时不做健康检查
+	TTL           time.Duration
+	HTTPCheck     string
+	CheckInterval time.Duration
+	// DeregisterAfter 健康检查失败超过这个时间之后 Consul 自动删除这个实例，默认 1 分钟
+	DeregisterAfter time.Duration
+	// Logger 用于输出上报健康状态的错误，默认使用 geerpc.DefaultLogger
+	Logger geerpc.Logger
+}
+
+// Registration 表示一个注册在 Consul 中的实例，使用 TTL 健康检查时在 Close 之前一直上报健康状态
+type Registration struct {
+	client  *api.Client
+	id      string
+	checkID string
+	logger  geerpc.Logger
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// Register 把服务注册到 client 对应的 Consul agent，服务端退出之前调用 Close 注销
+func Register(client *api.Client, opt RegisterOption) (*Registration, error) {
+	protocol, address, ok := strings.Cut(opt.Addr, "@")
+	if !ok {
+		return nil, fmt.Errorf("rpc consul: wrong format '%s', expect protocol@addr", opt.Addr)
+	}
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("rpc consul: invalid port in '%s'", opt.Addr)
+	}
+	if opt.Name == "" {
+		return nil, errors.New("rpc consul: service name is required")
+	}
+	if opt.ID == "" {
+		opt.ID = opt.Name + "-" + address
+	}
+	if opt.DeregisterAfter <= 0 {
+		opt.DeregisterAfter = time.Minute
+	}
+	reg := &api.AgentServiceRegistration{
+		ID:      opt.ID,
+		Name:    opt.Name,
+		Tags:    opt.Tags,
+		Address: host,
+		Port:    port,
+		Meta:    map[string]string{protocolMeta: protocol},
+	}
+	for key, value := range opt.Meta {
+		reg.Meta[key] = value
+	}
+	if opt.Weight > 0 {
+		reg.Weights = &api.AgentWeights{Passing: opt.Weight, Warning: 1}
+	}
+	if opt.Logger == nil {
+		opt.Logger = geerpc.DefaultLogger
+	}
+	r := &Registration{client: client, id: opt.ID, logger: opt.Logger, done: make(chan struct{})}
+	switch {
+	case opt.TTL > 0:
+		r.checkID = "service:" + opt.ID
+		reg.Check = &api.AgentServiceCheck{
+			CheckID:                        r.checkID,
+			TTL:                            opt.TTL.String(),
+			DeregisterCriticalServiceAfter: opt.DeregisterAfter.String(),
+		}
+	case opt.HTTPCheck != "":
+		if opt.CheckInterval <= 0 {
+			opt.CheckInterval = 10 * time.Second
+		}
+		reg.Check = &api.AgentServiceCheck{
+			HTTP:                           opt.HTTPCheck,
+			Interval:                       opt.CheckInterval.String(),
+			DeregisterCriticalServiceAfter: opt.DeregisterAfter.String(),
+		}
+	}
+	if err := client.Agent().ServiceRegister(reg); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	if r.checkID == "" {
+		close(r.done)
+		return r, nil
+	}
+	// 注册之后立即上报一次，不用等到第一个 TTL/2 才变为健康
+	r.pass()
+	go r.heartbeat(ctx, opt.TTL/2)
+	return r, nil
+}
+
+func (r *Registration) pass() {
+	if err := r.client.Agent().UpdateTTL(r.checkID, "", api.HealthPassing); err != nil {
+		r.logger.Errorf("rpc consul: update ttl error: %v", err)
+	}
+}
+
+func (r *Registration) heartbeat(ctx context.Context, interval time.Duration) {
+	defer close(r.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.pass()
+		}
+	}
+}
+
+// Close 停止上报健康状态并从 Consul 中注销
+func (r *Registration) Close() error {
+	r.cancel()
+	<-r.done
+	return r.client.Agent().ServiceDeregister(r.id)
+}
@@ -0,0 +1,133 @@
+package consul
+
+import (
+	"encoding/json"
+	"geerpc/xclient"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+// fakeAgent 实现了测试需要的 Consul HTTP 接口：注册、注销、更新 TTL 和查询健康的实例
+type fakeAgent struct {
+	mu       sync.Mutex
+	index    uint64
+	services map[string]*api.AgentServiceRegistration
+	passing  map[string]bool // checkID -> 是否健康
+}
+
+func (a *fakeAgent) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/v1/agent/service/register"):
+		var reg api.AgentServiceRegistration
+		_ = json.NewDecoder(r.Body).Decode(&reg)
+		a.services[reg.ID] = &reg
+		a.index++
+	case strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/"):
+		delete(a.services, strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/"))
+		a.index++
+	case strings.HasPrefix(r.URL.Path, "/v1/agent/check/update/"):
+		var update struct{ Status string }
+		_ = json.NewDecoder(r.Body).Decode(&update)
+		a.passing[strings.TrimPrefix(r.URL.Path, "/v1/agent/check/update/")] = update.Status == api.HealthPassing
+		a.index++
+	case strings.HasPrefix(r.URL.Path, "/v1/health/service/"):
+		// 阻塞查询：等到 index 变化或者超时
+		wait, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+		for deadline := time.Now().Add(time.Second); a.index <= wait && time.Now().Before(deadline); {
+			a.mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			a.mu.Lock()
+		}
+		name, tag := strings.TrimPrefix(r.URL.Path, "/v1/health/service/"), r.URL.Query().Get("tag")
+		entries := make([]*api.ServiceEntry, 0)
+		for _, s := range a.services {
+			if s.Name != name || (tag != "" && !contains(s.Tags, tag)) || (s.Check != nil && s.Check.TTL != "" && !a.passing[s.Check.CheckID]) {
+				continue
+			}
+			svc := &api.AgentService{ID: s.ID, Service: s.Name, Tags: s.Tags, Address: s.Address, Port: s.Port, Meta: s.Meta}
+			if s.Weights != nil {
+				svc.Weights = *s.Weights
+			}
+			entries = append(entries, &api.ServiceEntry{Node: &api.Node{Address: "127.0.0.1"}, Service: svc})
+		}
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(a.index, 10))
+		_ = json.NewEncoder(w).Encode(entries)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+	a.mu.Unlock()
+}
+
+func contains(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func TestConsul(t *testing.T) {
+	ts := httptest.NewServer(&fakeAgent{index: 1, services: make(map[string]*api.AgentServiceRegistration), passing: make(map[string]bool)})
+	defer ts.Close()
+	client, err := api.NewClient(&api.Config{Address: strings.TrimPrefix(ts.URL, "http://")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := Register(client, RegisterOption{Name: "Foo", Addr: "tcp@10.0.0.1:1", Tags: []string{"v1"}, TTL: 40 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = a.Close() }()
+	if _, err := Register(client, RegisterOption{Name: "Foo", Addr: "10.0.0.1:1"}); err == nil {
+		t.Fatal("expect an error for address without protocol")
+	}
+
+	d, err := NewConsulDiscovery(client, "Foo", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = d.Close() }()
+	v2, err := NewConsulDiscovery(client, "Foo", "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = v2.Close() }()
+
+	waitFor := func(d *ConsulDiscovery, expect string) {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			all, _ := d.GetAll()
+			if got := strings.Join(all, ","); got == expect {
+				return
+			} else if time.Now().After(deadline) {
+				t.Fatalf("expect %q, got %q", expect, got)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor(d, "tcp@10.0.0.1:1")
+
+	b, err := Register(client, RegisterOption{Name: "Foo", Addr: "http@10.0.0.2:1", Tags: []string{"v2"}, Weight: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(d, "http@10.0.0.2:1,tcp@10.0.0.1:1")
+	waitFor(v2, "http@10.0.0.2:1")
+	if s, _ := v2.Get(xclient.WeightedRoundRobinSelect); s != "http@10.0.0.2:1" {
+		t.Fatalf("weight should be stripped by Get, got %s", s)
+	}
+
+	_ = b.Close()
+	waitFor(d, "tcp@10.0.0.1:1")
+	waitFor(v2, "")
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
+	"sort"
 )
 
 const debugText = `<html>
@@ -14,11 +15,18 @@ const debugText = `<html>
 	Service {{.Name}}
 	<hr>
 		<table>
-		<th align=center>Method</th><th align=center>Calls</th>
-		{{range $name, $mtype := .Method}}
+		<th align=center>Method</th><th align=center>Calls</th><th align=center>Errors</th><th align=center>In Flight</th>
+		<th align=center>Min</th><th align=center>Avg</th><th align=center>P99</th><th align=center>Max</th>
+		{{range .Methods}}
 			<tr>
-			<td align=left font=fixed>{{$name}}({{$mtype.ArgType}}, {{$mtype.ReplyType}}) error</td>
-			<td align=center>{{$mtype.NumCalls}}</td>
+			<td align=left font=fixed>{{.Signature}}</td>
+			<td align=center>{{.Calls}}</td>
+			<td align=center>{{.Errors}}</td>
+			<td align=center>{{.InFlight}}</td>
+			<td align=center>{{.Latency.Min}}</td>
+			<td align=center>{{.Latency.Avg}}</td>
+			<td align=center>{{.Latency.P99}}</td>
+			<td align=center>{{.Latency.Max}}</td>
 			</tr>
 		{{end}}
 		</table>
@@ -33,20 +41,46 @@ type debugHTTP struct {
 }
 
 type debugService struct {
-	Name   string
-	Method map[string]*methodType
+	Name    string
+	Methods []debugMethod
 }
 
+type debugMethod struct {
+	Signature string
+	Calls     uint64
+	Errors    uint64
+	InFlight  int64
+	Latency   LatencyStats
+}
+
+// signature 返回方法的签名，比如 Sum(context.Context, main.Args, *int) error
+func (m *methodType) signature(name string) string {
+	if m.withCtx {
+		return fmt.Sprintf("%s(context.Context, %s, %s) error", name, m.ArgType, m.ReplyType)
+	}
+	return fmt.Sprintf("%s(%s, %s) error", name, m.ArgType, m.ReplyType)
+}
+
+// ServeHTTP 按照名字排序列出所有的服务和方法，以及每个方法的调用次数
 func (server debugHTTP) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	var services []debugService
 	server.serviceMap.Range(func(namei, svci interface{}) bool {
 		svc := svci.(*service)
-		services = append(services, debugService{
-			Name:   namei.(string),
-			Method: svc.method,
-		})
+		ds := debugService{Name: namei.(string)}
+		for _, name := range svc.methodNames() {
+			m := svc.method[name]
+			ds.Methods = append(ds.Methods, debugMethod{
+				Signature: m.signature(name),
+				Calls:     m.NumCalls(),
+				Errors:    m.NumErrors(),
+				InFlight:  m.InFlight(),
+				Latency:   m.Latency(),
+			})
+		}
+		services = append(services, ds)
 		return true
 	})
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
 	err := debug.Execute(w, services)
 	if err != nil {
 		_, _ = fmt.Fprintln(w, "rpc: error executing template:", err.Error())
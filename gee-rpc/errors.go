@@ -0,0 +1,204 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"geerpc/codec"
+)
+
+// Code 是错误的分类，随响应的 Header 一起发送，客户端可以据此判断失败的原因，而不需要匹配错误信息
+type Code uint32
+
+const (
+	CodeOK               Code = iota // 没有错误
+	CodeUnknown                      // 服务方法返回的普通错误，或者老版本的服务端没有发送错误码
+	CodeNotFound                     // 找不到服务或者方法
+	CodeInvalidArgument              // 请求格式错误，或者参数无法解码
+	CodeTimeout                      // 服务端处理超时，或者请求在服务端排队时已经超过了客户端的截止时间
+	CodeUnavailable                  // 服务端过载、限流或者正在关闭，换一个实例或者稍后重试可能成功
+	CodeUnauthenticated              // 鉴权失败
+	CodeCanceled                     // 调用被取消
+	CodeInternal                     // 服务端内部错误
+	CodePermissionDenied             // 调用方没有权限调用这个方法，参考 Server.Authorize
+)
+
+func (c Code) String() string {
+	switch c {
+	case CodeOK:
+		return "ok"
+	case CodeNotFound:
+		return "not found"
+	case CodeInvalidArgument:
+		return "invalid argument"
+	case CodeTimeout:
+		return "timeout"
+	case CodeUnavailable:
+		return "unavailable"
+	case CodeUnauthenticated:
+		return "unauthenticated"
+	case CodeCanceled:
+		return "canceled"
+	case CodeInternal:
+		return "internal"
+	case CodePermissionDenied:
+		return "permission denied"
+	default:
+		return "unknown"
+	}
+}
+
+// Error 是带有错误码的错误，服务方法可以返回 *Error 指定错误码，客户端收到的服务端错误都是 *Error，
+// 可以用 errors.As 取出错误码，或者用 errors.Is 和 ErrNotFound 这类只有错误码的值比较
+type Error struct {
+	Code    Code
+	Message string
+	// Details 是错误的结构化详细信息，服务端返回 ErrorDetailer 时客户端收到的 *Error 带有它的详细信息
+	Details interface{}
+}
+
+// ErrorDetailer 由带有结构化详细信息的错误实现，比如配额不足时告诉调用方剩余的配额和重置时间。
+// 服务方法返回的错误（或者它包装的错误）实现了 ErrorDetailer 时，详细信息和错误码一起发送给客户端，
+// 客户端收到的 *Error 的 Details 就是解码之后的值，使用 ErrorDetailsOf 取出。
+// 详细信息的具体类型需要在服务端和客户端都通过 RegisterType 注册，没有注册时只发送错误码和错误信息
+type ErrorDetailer interface {
+	error
+	ErrorDetails() interface{}
+}
+
+// errorDetails 是带有详细信息的错误响应的 body，Details 是接口类型，解码之后还原为注册过的具体类型
+type errorDetails struct {
+	Details interface{}
+}
+
+// 只有错误码的 Error，用于 errors.Is 判断错误的类别，比如 errors.Is(err, geerpc.ErrUnavailable)
+var (
+	ErrNotFound    = &Error{Code: CodeNotFound}
+	ErrTimeout     = &Error{Code: CodeTimeout}
+	ErrUnavailable = &Error{Code: CodeUnavailable}
+	ErrInternal    = &Error{Code: CodeInternal}
+
+	ErrPermissionDenied = &Error{Code: CodePermissionDenied}
+)
+
+// NewError 创建错误码为 code 的错误
+func NewError(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Errorf 按照格式创建错误码为 code 的错误
+func Errorf(code Code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// ErrorDetails 返回 Details，*Error 本身也是 ErrorDetailer
+func (e *Error) ErrorDetails() interface{} {
+	return e.Details
+}
+
+// ErrorDetailsOf 返回 err 携带的详细信息，没有时返回 nil，客户端可以使用类型断言得到具体的类型，比如：
+//
+//	if quota, ok := geerpc.ErrorDetailsOf(err).(*QuotaFailure); ok { ... }
+func ErrorDetailsOf(err error) interface{} {
+	var d ErrorDetailer
+	if errors.As(err, &d) {
+		return d.ErrorDetails()
+	}
+	return nil
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return "rpc: " + e.Code.String()
+	}
+	return e.Message
+}
+
+// Is 错误码相同并且 target 没有错误信息或者错误信息相同时匹配，
+// target 不是 *Error 时比较错误信息，这样客户端也可以用 errors.Is(err, ErrServerBusy) 判断服务端返回的错误
+func (e *Error) Is(target error) bool {
+	var t *Error
+	if errors.As(target, &t) {
+		return e.Code == t.Code && (t.Message == "" || t.Message == e.Message)
+	}
+	return target.Error() == e.Message
+}
+
+// CodeOf 返回 err 的错误码，err 为空时返回 CodeOK，没有错误码时返回 CodeUnknown
+func CodeOf(err error) Code {
+	if err == nil {
+		return CodeOK
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return CodeUnknown
+}
+
+// errorCode 服务端根据 err 选择发送给客户端的错误码
+func errorCode(err error) Code {
+	var e *Error
+	switch {
+	case errors.As(err, &e):
+		return e.Code
+	case errors.Is(err, ErrServerBusy), errors.Is(err, ErrRateLimited),
+		errors.Is(err, ErrTooManyPendingRequests), errors.Is(err, ErrTooManyConnections):
+		return CodeUnavailable
+	case errors.Is(err, ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return CodeTimeout
+	case errors.Is(err, context.Canceled):
+		return CodeCanceled
+	case errors.Is(err, ErrUnauthorized):
+		return CodeUnauthenticated
+	default:
+		return CodeUnknown
+	}
+}
+
+// setError 把 err 的错误信息和错误码写入响应的 Header
+func setError(h *codec.Header, err error) {
+	h.Error, h.Code = err.Error(), uint32(errorCode(err))
+}
+
+// errorDetailsBody 返回带有 req.details 的错误响应的 body，详细信息无法编码时（比如具体类型没有注册）
+// 记录日志并返回 body，客户端仍然能收到错误码和错误信息。
+// 编码失败会关闭连接，所以 codec 实现了 codec.Sizer 时先检查一次
+func (server *Server) errorDetailsBody(cc codec.Codec, req *request, body interface{}) interface{} {
+	details := &errorDetails{Details: req.details}
+	if sizer, ok := cc.(codec.Sizer); ok {
+		if _, err := sizer.BodySize(details); err != nil {
+			server.requestLogger(req.h).Errorf("rpc server: encode error details of %s: %v",
+				req.h.ServiceMethod, withTypeHint(err))
+			return body
+		}
+	}
+	req.h.Details = true
+	return details
+}
+
+// readErrorDetails 读取错误响应的 body，带有详细信息时保存在 err 中。
+// 详细信息无法解码不影响连接，只记录日志，调用仍然返回错误码和错误信息
+func (client *Client) readErrorDetails(h *codec.Header, err error) error {
+	if !h.Details {
+		return client.cc.ReadBody(nil)
+	}
+	var details errorDetails
+	if rerr := client.cc.ReadBody(&details); rerr != nil {
+		client.opt.logger().Errorf("rpc client: decode error details of %s: %v", h.ServiceMethod, withTypeHint(rerr))
+		return nil
+	}
+	if e, ok := err.(*Error); ok {
+		e.Details = details.Details
+	}
+	return nil
+}
+
+// headerError 客户端把响应 Header 中的错误还原为 *Error
+func headerError(h *codec.Header) error {
+	code := Code(h.Code)
+	if code == CodeOK {
+		code = CodeUnknown
+	}
+	return &Error{Code: code, Message: h.Error}
+}
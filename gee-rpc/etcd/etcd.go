@@ -0,0 +1,264 @@
+// Package etcd 使用 etcd 做服务注册和发现，服务端把地址写到 prefix 下带租约的 key 中并持续续约，
+// 进程退出或者租约过期之后 key 自动删除，客户端使用 EtcdDiscovery 监听 prefix 的变化更新服务列表
+//
+// key 的格式为 prefix/addr，value 为 addr，addr 和 XDial 的格式相同，可以带 ?weight=N 设置权重
+package etcd
+
+import (
+	"context"
+	"errors"
+	"geerpc"
+	"geerpc/xclient"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	defaultTTL     = 10 * time.Second
+	requestTimeout = 5 * time.Second
+	// retryInterval 是监听中断或者续约失败之后重试的间隔
+	retryInterval = time.Second
+)
+
+// EtcdDiscovery 监听 etcd 中 prefix 下的所有 key，key 变化之后更新服务列表
+type EtcdDiscovery struct {
+	*xclient.MultiServersDiscovery
+	client *clientv3.Client
+	prefix string
+
+	mu      sync.Mutex
+	servers map[string]string // key -> addr
+	logger  geerpc.Logger
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+var _ xclient.Discovery = (*EtcdDiscovery)(nil)
+
+// NewEtcdDiscovery 读取 prefix 下的服务列表并开始监听，读取失败时返回错误，使用完之后需要调用 Close
+func NewEtcdDiscovery(client *clientv3.Client, prefix string) (*EtcdDiscovery, error) {
+	d := &EtcdDiscovery{
+		MultiServersDiscovery: xclient.NewMultiServersDiscovery(nil),
+		client:                client,
+		prefix:                strings.TrimSuffix(prefix, "/") + "/",
+		done:                  make(chan struct{}),
+	}
+	rev, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	go d.watch(ctx, rev)
+	return d, nil
+}
+
+// Refresh 重新读取 prefix 下的所有 key，正常情况下服务列表由监听更新，不需要调用
+func (d *EtcdDiscovery) Refresh() error {
+	_, err := d.load()
+	return err
+}
+
+// SetLogger 设置输出监听错误使用的 Logger，默认使用 geerpc.DefaultLogger
+func (d *EtcdDiscovery) SetLogger(logger geerpc.Logger) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.logger = logger
+}
+
+func (d *EtcdDiscovery) getLogger() geerpc.Logger {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.logger == nil {
+		return geerpc.DefaultLogger
+	}
+	return d.logger
+}
+
+// Close 停止监听，不会关闭 etcd 的 client
+func (d *EtcdDiscovery) Close() error {
+	d.cancel()
+	<-d.done
+	return nil
+}
+
+// load 读取 prefix 下的所有 key 并替换服务列表，返回读取时的 revision，从下一个 revision 开始监听
+func (d *EtcdDiscovery) load() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	resp, err := d.client.Get(ctx, d.prefix, clientv3.WithPrefix())
+	if err != nil {
+		return 0, err
+	}
+	servers := make(map[string]string, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		servers[string(kv.Key)] = string(kv.Value)
+	}
+	d.mu.Lock()
+	d.servers = servers
+	d.update()
+	d.mu.Unlock()
+	return resp.Header.Revision, nil
+}
+
+// update 把 d.servers 同步到 MultiServersDiscovery，调用方需要持有 d.mu
+func (d *EtcdDiscovery) update() {
+	servers := make([]string, 0, len(d.servers))
+	for _, addr := range d.servers {
+		servers = append(servers, addr)
+	}
+	sort.Strings(servers) // 保持轮询的顺序稳定
+	_ = d.MultiServersDiscovery.Update(servers)
+}
+
+// watch 从 rev 之后开始监听，监听中断（比如 revision 已经被压缩）之后重新读取全部 key 再继续监听
+func (d *EtcdDiscovery) watch(ctx context.Context, rev int64) {
+	defer close(d.done)
+	for ctx.Err() == nil {
+		wch := d.client.Watch(clientv3.WithRequireLeader(ctx), d.prefix, clientv3.WithPrefix(), clientv3.WithRev(rev+1))
+		for resp := range wch {
+			if err := resp.Err(); err != nil {
+				d.getLogger().Errorf("rpc etcd: watch error: %v", err)
+				break
+			}
+			d.mu.Lock()
+			for _, ev := range resp.Events {
+				if ev.Type == clientv3.EventTypeDelete {
+					delete(d.servers, string(ev.Kv.Key))
+				} else {
+					d.servers[string(ev.Kv.Key)] = string(ev.Kv.Value)
+				}
+			}
+			d.update()
+			d.mu.Unlock()
+			rev = resp.Header.Revision
+		}
+		for ctx.Err() == nil {
+			var err error
+			if rev, err = d.load(); err == nil {
+				break
+			}
+			d.getLogger().Errorf("rpc etcd: reload servers error: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(retryInterval):
+			}
+		}
+	}
+}
+
+// Registration 表示一个注册在 etcd 中的服务地址，Close 之前会一直续约
+type Registration struct {
+	client *clientv3.Client
+	key    string
+	addr   string
+	ttl    int64
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	mu     sync.Mutex
+	lease  clientv3.LeaseID
+	logger geerpc.Logger
+}
+
+// Register 把 addr 注册到 prefix 下，租约时间为 ttl（为 0 时使用 10s，最少 1s），
+// 租约在后台持续续约，续约失败（比如 etcd 重启导致租约丢失）时重新注册，服务端退出之前调用 Close 删除注册
+func Register(client *clientv3.Client, prefix, addr string, ttl time.Duration) (*Registration, error) {
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Registration{
+		client: client,
+		key:    strings.TrimSuffix(prefix, "/") + "/" + addr,
+		addr:   addr,
+		ttl:    int64(ttl / time.Second),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	keepAlive, err := r.register(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	go r.keepAlive(ctx, keepAlive)
+	return r, nil
+}
+
+// register 创建租约并写入 key，返回续约的应答
+func (r *Registration) register(ctx context.Context) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	reqCtx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	lease, err := r.client.Grant(reqCtx, r.ttl)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.client.Put(reqCtx, r.key, r.addr, clientv3.WithLease(lease.ID)); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	r.lease = lease.ID
+	r.mu.Unlock()
+	return r.client.KeepAlive(ctx, lease.ID)
+}
+
+// SetLogger 设置输出续约错误使用的 Logger，默认使用 geerpc.DefaultLogger
+func (r *Registration) SetLogger(logger geerpc.Logger) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logger = logger
+}
+
+func (r *Registration) getLogger() geerpc.Logger {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.logger == nil {
+		return geerpc.DefaultLogger
+	}
+	return r.logger
+}
+
+func (r *Registration) keepAlive(ctx context.Context, ch <-chan *clientv3.LeaseKeepAliveResponse) {
+	defer close(r.done)
+	for {
+		for range ch {
+		}
+		// ch 关闭说明续约已经停止，Close 之外的原因都需要重新注册
+		for {
+			if ctx.Err() != nil {
+				return
+			}
+			var err error
+			if ch, err = r.register(ctx); err == nil {
+				break
+			}
+			r.getLogger().Errorf("rpc etcd: register error: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(retryInterval):
+			}
+		}
+	}
+}
+
+// Close 停止续约并撤销租约，key 会被立即删除
+func (r *Registration) Close() error {
+	r.cancel()
+	<-r.done
+	r.mu.Lock()
+	lease := r.lease
+	r.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
+	if _, err := r.client.Revoke(ctx, lease); err != nil {
+		return errors.New("rpc etcd: revoke lease error: " + err.Error())
+	}
+	return nil
+}
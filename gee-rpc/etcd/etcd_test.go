@@ -0,0 +1,66 @@
+package etcd
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// 需要一个可用的 etcd，通过 GEERPC_ETCD_ENDPOINTS 设置地址，多个地址用逗号分隔
+func newTestClient(t *testing.T) *clientv3.Client {
+	endpoints := os.Getenv("GEERPC_ETCD_ENDPOINTS")
+	if endpoints == "" {
+		t.Skip("GEERPC_ETCD_ENDPOINTS is not set")
+	}
+	client, err := clientv3.New(clientv3.Config{Endpoints: strings.Split(endpoints, ","), DialTimeout: 5 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestEtcd(t *testing.T) {
+	client := newTestClient(t)
+	prefix := "/geerpc-test/" + t.Name()
+
+	a, err := Register(client, prefix, "tcp@a:1", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = a.Close() }()
+	d, err := NewEtcdDiscovery(client, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = d.Close() }()
+
+	waitFor := func(expect string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			all, _ := d.GetAll()
+			if got := strings.Join(all, ","); got == expect {
+				return
+			} else if time.Now().After(deadline) {
+				t.Fatalf("expect %q, got %q", expect, got)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor("tcp@a:1")
+
+	b, err := Register(client, prefix, "tcp@b:1?weight=2", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor("tcp@a:1,tcp@b:1")
+
+	// 续约会让注册一直有效，直到 Close
+	time.Sleep(2 * time.Second)
+	waitFor("tcp@a:1,tcp@b:1")
+	_ = b.Close()
+	waitFor("tcp@a:1")
+}
@@ -0,0 +1,122 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+)
+
+// Await 等待 Call 完成并返回 Call.Error，ctx 先结束时返回 ctx 的错误，但是不会取消这个 Call。
+// Go 和 GoContext 返回的 Call 可以多次 Await，也不会读取 Done，
+// 其他方式创建的 Call 只能从 Done 中读取结果，所以只能 Await 一次
+func (call *Call) Await(ctx context.Context) error {
+	if call.finished == nil {
+		select {
+		case <-call.Done:
+			return call.Error
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	select {
+	case <-call.finished:
+		return call.Error
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Future 表示一个异步调用的结果，调用方不需要自己处理 Done 的缓冲，可以多次等待，也可以用 Then 串联多个调用
+type Future struct {
+	done  <-chan struct{}
+	call  *Call // 不为空时结果保存在 call 中
+	reply interface{}
+	err   error
+}
+
+// GoFuture 和 GoContext 一样发起异步调用，返回 Future
+func (client *Client) GoFuture(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) *Future {
+	call := client.GoContext(ctx, serviceMethod, args, reply, make(chan *Call, 1), opts...)
+	return &Future{done: call.finished, call: call}
+}
+
+// Done 在调用完成时关闭
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+func (f *Future) result() (interface{}, error) {
+	if f.call != nil {
+		return f.call.Reply, f.call.Error
+	}
+	return f.reply, f.err
+}
+
+// Await 等待调用完成，返回 reply 和调用的错误，ctx 先结束时返回 ctx 的错误
+func (f *Future) Await(ctx context.Context) (interface{}, error) {
+	select {
+	case <-f.done:
+		return f.result()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Then 在 f 成功之后使用它的 reply 调用 fn 发起下一个调用，返回的 Future 在下一个调用完成时完成，
+// f 失败时不会调用 fn，返回的 Future 直接以 f 的错误结束。fn 返回 nil 表示没有后续调用，此时结果就是 f 的结果
+func (f *Future) Then(fn func(reply interface{}) *Future) *Future {
+	done := make(chan struct{})
+	next := &Future{done: done}
+	go func() {
+		defer close(done)
+		<-f.done
+		next.reply, next.err = f.result()
+		if next.err != nil {
+			return
+		}
+		if g := fn(next.reply); g != nil {
+			<-g.done
+			next.reply, next.err = g.result()
+		}
+	}()
+	return next
+}
+
+// WaitAll 等待所有的 Future 完成，返回第一个出错的 Future 的错误，ctx 先结束时返回 ctx 的错误
+func WaitAll(ctx context.Context, futures ...*Future) error {
+	var first error
+	for _, f := range futures {
+		if _, err := f.Await(ctx); err != nil && first == nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			first = err
+		}
+	}
+	return first
+}
+
+// WaitAny 等待任意一个 Future 完成，返回它的下标和错误，没有 Future 时返回 -1
+func WaitAny(ctx context.Context, futures ...*Future) (int, error) {
+	if len(futures) == 0 {
+		return -1, errors.New("rpc client: no future to wait")
+	}
+	done := make(chan int, len(futures))
+	stop := make(chan struct{})
+	defer close(stop)
+	for i, f := range futures {
+		go func() {
+			select {
+			case <-f.done:
+				done <- i
+			case <-stop:
+			}
+		}()
+	}
+	select {
+	case i := <-done:
+		_, err := futures[i].result()
+		return i, err
+	case <-ctx.Done():
+		return -1, ctx.Err()
+	}
+}
@@ -0,0 +1,147 @@
+package geerpc
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Gateway 把注册的服务暴露为 REST 风格的 HTTP 接口，POST {prefix}/Foo/Sum 会调用 Foo.Sum，
+// 请求体是 JSON 编码的参数，响应默认也是 JSON，Accept 为 application/x-gob 时使用 gob 编码，
+// 调用失败时根据错误码返回对应的状态码（参考 gatewayStatus），响应体为 {"error":{"code":...,"message":...}}。
+// 请求和 JSON-RPC 一样需要通过 IPFilter、OnConnect 和 AuthFunc，令牌放在 AuthHeader 中，
+// 调用经过限流、工作池、HandleTimeout 和大小限制，body 不能超过 Server.MaxHTTPBody
+type Gateway struct {
+	server *Server
+	prefix string
+}
+
+const gobContentType = "application/x-gob"
+
+// NewGateway 创建 server 的 REST 网关，prefix 为路由前缀，比如 /rpc，
+// 使用 http.Handle(prefix+"/", gateway) 注册
+func NewGateway(server *Server, prefix string) *Gateway {
+	return &Gateway{server: server, prefix: strings.TrimSuffix(prefix, "/")}
+}
+
+type gatewayError struct {
+	Error *JSONRPCError `json:"error"`
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		g.fail(w, http.StatusMethodNotAllowed, JSONRPCInvalidRequest, "405 must POST")
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, g.prefix+"/")
+	slash := strings.LastIndex(path, "/")
+	if path == r.URL.Path || slash <= 0 || slash == len(path)-1 {
+		g.fail(w, http.StatusNotFound, JSONRPCMethodNotFound, "rpc gateway: expect "+g.prefix+"/Service/Method, got "+r.URL.Path)
+		return
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		if mt, _, err := mime.ParseMediaType(ct); err != nil || mt != "application/json" {
+			g.fail(w, http.StatusUnsupportedMediaType, JSONRPCInvalidRequest, "rpc gateway: unsupported content type "+ct)
+			return
+		}
+	}
+	contentType, ok := negotiate(r.Header.Get("Accept"))
+	if !ok {
+		g.fail(w, http.StatusNotAcceptable, JSONRPCInvalidRequest, "rpc gateway: can't produce "+r.Header.Get("Accept"))
+		return
+	}
+	ctx, done, status, err := g.server.admitHTTP(r)
+	if err != nil {
+		g.fail(w, status, JSONRPCInvalidRequest, err.Error())
+		return
+	}
+	defer done()
+	body, status, err := g.server.readHTTPBody(w, r)
+	if err != nil {
+		g.fail(w, status, JSONRPCParseError, err.Error())
+		return
+	}
+	serviceMethod := path[:slash] + "." + path[slash+1:]
+	result, rpcErr := g.server.callJSON(ctx, serviceMethod, body)
+	if rpcErr != nil {
+		g.writeError(w, gatewayStatus(rpcErr), rpcErr)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	if contentType == gobContentType {
+		err = gob.NewEncoder(w).Encode(result)
+	} else {
+		err = json.NewEncoder(w).Encode(result)
+	}
+	if err != nil {
+		g.server.logger.Errorf("rpc gateway: write response error: %v", err)
+	}
+}
+
+// negotiate 根据 Accept 选择响应的编码方式，按照出现的顺序选择第一个支持的类型，忽略 q 值
+func negotiate(accept string) (string, bool) {
+	if accept == "" {
+		return "application/json", true
+	}
+	for _, part := range strings.Split(accept, ",") {
+		mt, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		switch mt {
+		case "application/json", "application/*", "*/*":
+			return "application/json", true
+		case gobContentType:
+			return gobContentType, true
+		}
+	}
+	return "", false
+}
+
+// gatewayStatus 把调用的错误映射为 HTTP 状态码，服务端的错误按照 geerpc 的错误码映射，
+// 限流返回 429，其他的过载返回 503
+func gatewayStatus(rpcErr *JSONRPCError) int {
+	switch rpcErr.Code {
+	case JSONRPCMethodNotFound:
+		return http.StatusNotFound
+	case JSONRPCInvalidParams, JSONRPCParseError, JSONRPCInvalidRequest:
+		return http.StatusBadRequest
+	}
+	if rpcErr.cause == nil {
+		return http.StatusInternalServerError
+	}
+	switch errorCode(rpcErr.cause) {
+	case CodeUnavailable:
+		if errors.Is(rpcErr.cause, ErrRateLimited) {
+			return http.StatusTooManyRequests
+		}
+		return http.StatusServiceUnavailable
+	case CodeTimeout:
+		return http.StatusGatewayTimeout
+	case CodePermissionDenied:
+		return http.StatusForbidden
+	case CodeUnauthenticated:
+		return http.StatusUnauthorized
+	case CodeInvalidArgument:
+		return http.StatusBadRequest
+	case CodeNotFound:
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// fail 错误总是使用 JSON 返回，方便 curl 等工具直接查看
+func (g *Gateway) fail(w http.ResponseWriter, status, code int, message string) {
+	g.writeError(w, status, &JSONRPCError{Code: code, Message: message})
+}
+
+func (g *Gateway) writeError(w http.ResponseWriter, status int, rpcErr *JSONRPCError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(gatewayError{Error: rpcErr})
+}
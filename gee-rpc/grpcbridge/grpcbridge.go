@@ -0,0 +1,164 @@
+// Package grpcbridge 让 geerpc 和 gRPC 可以互相调用，方便在两套框架之间迁移：
+// RegisterService 把 geerpc 服务注册到 grpc.Server 上，作为 proto 文件中定义的 unary 方法对外提供，
+// Client 则使用 geerpc 风格的 Call 调用 gRPC 后端。
+//
+// 两个方向都要求参数和回复是 protoc 生成的消息类型，geerpc 的方法签名为
+// func (t *T) Method(args *pb.Request, reply *pb.Response) error
+package grpcbridge
+
+import (
+	"context"
+	"errors"
+	"geerpc"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// RegisterService 按照 desc 把 server 上的 geerpc 服务 target 注册到 gs 上，desc 一般是 protoc 生成的
+// pb.Xxx_ServiceDesc，只会使用其中的服务名和 unary 方法名，gRPC 方法 Sum 对应 geerpc 方法 target.Sum，
+// target 为空时使用 desc.ServiceName 中最后一个 . 之后的部分。
+// gRPC 的 Metadata 会转换为 geerpc 的 Metadata，服务方法通过 geerpc.IncomingMetadata 读取
+func RegisterService(gs grpc.ServiceRegistrar, desc *grpc.ServiceDesc, server *geerpc.Server, target string) {
+	if target == "" {
+		target = desc.ServiceName[strings.LastIndex(desc.ServiceName, ".")+1:]
+	}
+	sd := &grpc.ServiceDesc{
+		ServiceName: desc.ServiceName,
+		HandlerType: (*interface{})(nil),
+		Metadata:    desc.Metadata,
+	}
+	for _, m := range desc.Methods {
+		sd.Methods = append(sd.Methods, grpc.MethodDesc{
+			MethodName: m.MethodName,
+			Handler:    handler(server, desc.ServiceName, target+"."+m.MethodName),
+		})
+	}
+	gs.RegisterService(sd, server)
+}
+
+// handler 返回转发到 serviceMethod 的 gRPC 方法，支持 grpc.Server 上设置的 unary 拦截器
+func handler(server *geerpc.Server, grpcService, serviceMethod string) func(interface{}, context.Context, func(interface{}) error, grpc.UnaryServerInterceptor) (interface{}, error) {
+	fullMethod := "/" + grpcService + "/" + serviceMethod[strings.LastIndex(serviceMethod, ".")+1:]
+	return func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+		call, err := server.Prepare(serviceMethod)
+		if err != nil {
+			return nil, toStatus(err)
+		}
+		if err := dec(call.Args); err != nil {
+			return nil, err
+		}
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			call.Metadata = fromGRPCMetadata(md)
+		}
+		do := func(ctx context.Context, _ interface{}) (interface{}, error) {
+			reply, err := call.Do(ctx)
+			if err != nil {
+				return nil, toStatus(err)
+			}
+			return reply, nil
+		}
+		if interceptor == nil {
+			return do(ctx, call.Args)
+		}
+		return interceptor(ctx, call.Args, &grpc.UnaryServerInfo{Server: srv, FullMethod: fullMethod}, do)
+	}
+}
+
+// Client 使用 geerpc 风格的 "Service.Method" 调用 gRPC 后端
+type Client struct {
+	cc  grpc.ClientConnInterface
+	pkg string
+}
+
+// NewClient 创建调用 cc 的 Client，pkg 是 proto 文件中的 package，
+// "Foo.Sum" 会调用 gRPC 方法 /pkg.Foo/Sum，pkg 为空时调用 /Foo/Sum
+func NewClient(cc grpc.ClientConnInterface, pkg string) *Client {
+	if pkg != "" {
+		pkg += "."
+	}
+	return &Client{cc: cc, pkg: pkg}
+}
+
+// Call 调用 serviceMethod，args 和 reply 必须是 proto 消息，ctx 中的 geerpc.ContextWithMetadata 和
+// opts 中的 Metadata 会作为 gRPC 的 Metadata 发送。返回的错误是 *geerpc.Error，可以使用 geerpc.CodeOf 判断
+func (c *Client) Call(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...geerpc.CallOption) error {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return geerpc.Errorf(geerpc.CodeInvalidArgument, "rpc client: service/method request ill-formed: %s", serviceMethod)
+	}
+	call := &geerpc.Call{ServiceMethod: serviceMethod, Metadata: geerpc.MetadataFromContext(ctx)}
+	for _, opt := range opts {
+		opt(call)
+	}
+	if len(call.Metadata) > 0 {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.New(call.Metadata))
+	}
+	method := "/" + c.pkg + serviceMethod[:dot] + "/" + serviceMethod[dot+1:]
+	if err := c.cc.Invoke(ctx, method, args, reply); err != nil {
+		return fromStatus(err)
+	}
+	return nil
+}
+
+// fromGRPCMetadata 把 gRPC 的 Metadata 转换为 geerpc 的 Metadata，一个 key 有多个值时只保留第一个
+func fromGRPCMetadata(md metadata.MD) map[string]string {
+	result := make(map[string]string, len(md))
+	for k, v := range md {
+		if len(v) > 0 {
+			result[k] = v[0]
+		}
+	}
+	return result
+}
+
+var codeToGRPC = map[geerpc.Code]codes.Code{
+	geerpc.CodeOK:               codes.OK,
+	geerpc.CodeUnknown:          codes.Unknown,
+	geerpc.CodeNotFound:         codes.Unimplemented,
+	geerpc.CodeInvalidArgument:  codes.InvalidArgument,
+	geerpc.CodeTimeout:          codes.DeadlineExceeded,
+	geerpc.CodeUnavailable:      codes.Unavailable,
+	geerpc.CodeUnauthenticated:  codes.Unauthenticated,
+	geerpc.CodeCanceled:         codes.Canceled,
+	geerpc.CodeInternal:         codes.Internal,
+	geerpc.CodePermissionDenied: codes.PermissionDenied,
+}
+
+// toStatus 把 geerpc 的错误转换为 gRPC 的 status，找不到方法对应 codes.Unimplemented
+func toStatus(err error) error {
+	code, ok := codeToGRPC[geerpc.CodeOf(err)]
+	if !ok {
+		code = codes.Unknown
+	}
+	var e *geerpc.Error
+	if errors.As(err, &e) {
+		return status.Error(code, e.Message)
+	}
+	return status.Error(code, err.Error())
+}
+
+// fromStatus 把 gRPC 的错误转换为 *geerpc.Error
+func fromStatus(err error) error {
+	s, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	code := geerpc.CodeUnknown
+	for c, g := range codeToGRPC {
+		if g == s.Code() {
+			code = c
+			break
+		}
+	}
+	switch s.Code() {
+	case codes.NotFound:
+		code = geerpc.CodeNotFound
+	case codes.ResourceExhausted, codes.Aborted:
+		code = geerpc.CodeUnavailable
+	}
+	return geerpc.NewError(code, s.Message())
+}
@@ -0,0 +1,89 @@
+package grpcbridge
+
+import (
+	"context"
+	"errors"
+	"geerpc"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type Calc int
+
+func (c *Calc) Double(ctx context.Context, args *wrapperspb.Int64Value, reply *wrapperspb.Int64Value) error {
+	if args.Value < 0 {
+		return geerpc.Errorf(geerpc.CodeInvalidArgument, "negative value %d", args.Value)
+	}
+	reply.Value = args.Value * 2
+	if geerpc.IncomingMetadata(ctx)["x-tenant"] == "big" {
+		reply.Value *= 10
+	}
+	return nil
+}
+
+// calcServiceDesc 相当于 protoc 为下面的定义生成的 ServiceDesc：
+//
+//	package calc;
+//	service Calc { rpc Double(google.protobuf.Int64Value) returns (google.protobuf.Int64Value); }
+var calcServiceDesc = grpc.ServiceDesc{
+	ServiceName: "calc.Calc",
+	Methods:     []grpc.MethodDesc{{MethodName: "Double"}, {MethodName: "Missing"}},
+}
+
+func startBridge(t *testing.T, opts ...grpc.ServerOption) *grpc.ClientConn {
+	server := geerpc.NewServer()
+	var calc Calc
+	_ = server.Register(&calc)
+	gs := grpc.NewServer(opts...)
+	RegisterService(gs, &calcServiceDesc, server, "")
+	l := bufconn.Listen(1 << 20)
+	go func() { _ = gs.Serve(l) }()
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return l.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn
+}
+
+func TestRegisterService(t *testing.T) {
+	var intercepted []string
+	conn := startBridge(t, grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		intercepted = append(intercepted, info.FullMethod)
+		return handler(ctx, req)
+	}))
+
+	// 使用原生的 gRPC 客户端调用 geerpc 服务
+	reply := new(wrapperspb.Int64Value)
+	err := conn.Invoke(context.Background(), "/calc.Calc/Double", wrapperspb.Int64(21), reply)
+	if err != nil || reply.Value != 42 {
+		t.Fatalf("expect 42, got %v %v", reply.Value, err)
+	}
+	if len(intercepted) != 1 || intercepted[0] != "/calc.Calc/Double" {
+		t.Fatalf("expect interceptor to be called, got %v", intercepted)
+	}
+
+	client := NewClient(conn, "calc")
+	err = client.Call(context.Background(), "Calc.Double", wrapperspb.Int64(1), reply, geerpc.WithMetadata(map[string]string{"x-tenant": "big"}))
+	if err != nil || reply.Value != 20 {
+		t.Fatalf("expect metadata to reach the service, got %v %v", reply.Value, err)
+	}
+
+	err = client.Call(context.Background(), "Calc.Double", wrapperspb.Int64(-1), reply)
+	if geerpc.CodeOf(err) != geerpc.CodeInvalidArgument {
+		t.Fatalf("expect invalid argument, got %v", err)
+	}
+	err = client.Call(context.Background(), "Calc.Missing", wrapperspb.Int64(1), reply)
+	if !errors.Is(err, geerpc.ErrNotFound) {
+		t.Fatalf("expect not found, got %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// 除了 HTTP/1.0 的 CONNECT + Hijack 之外，RPC 的数据帧也可以跑在 HTTP/2 的一个流上：
+// 客户端向 Option.RPCPath 发起 POST，请求体是客户端发往服务端的数据，响应体是服务端返回的数据，
+// 这样多个 Client 可以复用同一个 TCP 连接，并且能穿过只认识标准 HTTP/2 的七层负载均衡和网关
+
+// h2cTransport 被所有 h2c 客户端共享，同一个地址上的 Client 会复用同一个 TCP 连接
+var h2cTransport = &http2.Transport{
+	AllowHTTP: true,
+	DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	},
+}
+
+func init() {
+	RegisterDialer("h2c", dialH2C)
+}
+
+// DialH2C 通过 h2c（明文 HTTP/2）连接到 ServeH2C 启动的服务
+func DialH2C(address string, opts ...DialOption) (*Client, error) {
+	return Dial("h2c", address, opts...)
+}
+
+func dialH2C(ctx context.Context, address string, opt *Option) (net.Conn, error) {
+	if opt.TLSConfig != nil {
+		return nil, errors.New("rpc client: h2c doesn't support TLSConfig")
+	}
+	// 请求在整个连接的生命周期内都存在，不能直接使用 ctx，只在拿到响应头之前受 ctx 控制
+	reqCtx, cancel := context.WithCancel(context.Background())
+	stop := context.AfterFunc(ctx, cancel)
+	pr, pw := io.Pipe()
+	req, err := http.NewRequestWithContext(reqCtx, http.MethodPost, "http://"+address+opt.rpcPath(), pr)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp, err := h2cTransport.RoundTrip(req)
+	if !stop() {
+		err = ctx.Err()
+	}
+	if err == nil && resp.StatusCode != http.StatusOK {
+		err = errors.New("unexpected HTTP response " + resp.Status)
+	}
+	if err != nil {
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+		_ = pw.Close()
+		cancel()
+		return nil, err
+	}
+	return &h2Conn{
+		r:      resp.Body,
+		w:      pw,
+		local:  h2Addr("client"),
+		remote: h2Addr(address),
+		close: func() {
+			_ = pw.Close()
+			cancel()
+		},
+	}, nil
+}
+
+// ServeH2C 在 lis 上同时提供 HTTP/1.x 和 h2c 服务，handler 为空时使用 http.DefaultServeMux，
+// 配合 HandleHTTP 使用
+func ServeH2C(lis net.Listener, handler http.Handler) error {
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	return http.Serve(lis, h2c.NewHandler(handler, &http2.Server{}))
+}
+
+// serveHTTP2 把 HTTP/2 的请求体和响应体当作一个连接来处理，handler 返回之后流就结束了
+func (server *Server) serveHTTP2(w http.ResponseWriter, req *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	// 先发送响应头，客户端收到之后才会认为连接已经建立
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	// serveConn 等所有请求处理完成之后才返回，所以 handler 返回之后不会再有写入
+	server.serveConn(&h2Conn{
+		r:      req.Body,
+		w:      w,
+		flush:  flusher.Flush,
+		local:  h2Addr(req.Host),
+		remote: h2Addr(req.RemoteAddr),
+	})
+}
+
+type h2Addr string
+
+func (a h2Addr) Network() string { return "h2c" }
+func (a h2Addr) String() string  { return string(a) }
+
+// h2Conn 把 HTTP/2 流的两个方向包装为 net.Conn，不支持设置超时
+type h2Conn struct {
+	r      io.ReadCloser
+	w      io.Writer
+	flush  func()
+	local  net.Addr
+	remote net.Addr
+	close  func() // 关闭写的方向
+	once   sync.Once
+}
+
+var _ net.Conn = (*h2Conn)(nil)
+
+func (c *h2Conn) Read(b []byte) (int, error) { return c.r.Read(b) }
+
+func (c *h2Conn) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	if err == nil && c.flush != nil {
+		c.flush()
+	}
+	return n, err
+}
+
+func (c *h2Conn) Close() error {
+	var err error
+	c.once.Do(func() {
+		err = c.r.Close()
+		if c.close != nil {
+			c.close()
+		}
+	})
+	return err
+}
+
+func (c *h2Conn) LocalAddr() net.Addr                { return c.local }
+func (c *h2Conn) RemoteAddr() net.Addr               { return c.remote }
+func (c *h2Conn) SetDeadline(t time.Time) error      { return nil }
+func (c *h2Conn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *h2Conn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,186 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"geerpc/codec"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// inheritedFDsEnv 记录新进程从旧进程继承的 listener 数量，文件描述符从 3 开始依次排列
+const inheritedFDsEnv = "GEERPC_INHERITED_FDS"
+
+var (
+	inheritOnce sync.Once
+	inheritMu   sync.Mutex
+	inherited   []net.Listener // 还没有被 Listen 取走的 listener
+)
+
+// loadInherited 在第一次调用 Listen 时读取从父进程继承的 listener
+func loadInherited() {
+	n, _ := strconv.Atoi(os.Getenv(inheritedFDsEnv))
+	for i := 0; i < n; i++ {
+		f := os.NewFile(uintptr(3+i), "geerpc-listener-"+strconv.Itoa(i))
+		lis, err := net.FileListener(f)
+		_ = f.Close() // FileListener 复制了文件描述符
+		if err != nil {
+			DefaultLogger.Errorf("rpc server: inherit listener %d error: %v", i, err)
+			continue
+		}
+		inherited = append(inherited, lis)
+	}
+	_ = os.Unsetenv(inheritedFDsEnv)
+}
+
+// Listen 和 net.Listen 相同，但是进程由 StartProcess 启动并且继承了同一个地址的 listener 时直接使用它，
+// 热重启的新进程因此不需要重新绑定端口，端口也从来没有关闭过。ListenAndServe 和 ListenAndServeHTTP 都使用 Listen
+func Listen(network, address string) (net.Listener, error) {
+	inheritOnce.Do(loadInherited)
+	inheritMu.Lock()
+	for i, lis := range inherited {
+		if sameAddr(network, address, lis.Addr()) {
+			inherited = append(inherited[:i], inherited[i+1:]...)
+			inheritMu.Unlock()
+			return lis, nil
+		}
+	}
+	inheritMu.Unlock()
+	return net.Listen(network, address)
+}
+
+// sameAddr 判断 addr 是否是监听 network 和 address 得到的地址，address 中的 IP 为空时可以匹配任意 IP
+func sameAddr(network, address string, addr net.Addr) bool {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		want, err := net.ResolveTCPAddr(network, address)
+		got, ok := addr.(*net.TCPAddr)
+		if err != nil || !ok || want.Port != got.Port {
+			return false
+		}
+		return want.IP == nil || want.IP.IsUnspecified() || want.IP.Equal(got.IP)
+	default:
+		return addr.Network() == network && addr.String() == address
+	}
+}
+
+type filer interface {
+	File() (*os.File, error)
+}
+
+// StartProcess 使用当前进程的参数和环境变量启动一个新的进程，并把 listeners 交给它，
+// 新进程中的 Listen 会直接使用这些 listener。listener 需要是 *net.TCPListener 或者 *net.UnixListener，
+// 使用 WithTLSConfig 时在新进程中重新包装 TLS 即可。通常的热重启流程是旧进程收到信号之后调用 Server.Restart
+func StartProcess(listeners ...net.Listener) (*os.Process, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	files := make([]*os.File, 0, len(listeners))
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	for _, lis := range listeners {
+		l, ok := lis.(filer)
+		if !ok {
+			return nil, fmt.Errorf("rpc server: listener %s can't be handed over", lis.Addr())
+		}
+		f, err := l.File()
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), inheritedFDsEnv+"="+strconv.Itoa(len(files)))
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmd.Process, nil
+}
+
+// Restart 热重启：启动一个新的进程并把 Serve 正在使用的 listener 交给它，然后调用 Drain 等待已有的连接处理完成，
+// 返回之后旧进程就可以退出了。新进程启动之后马上开始 Accept，端口一直可以连接，客户端感知不到重启
+func (server *Server) Restart(ctx context.Context) (*os.Process, error) {
+	server.mu.Lock()
+	listeners := make([]net.Listener, 0, len(server.listeners))
+	for lis := range server.listeners {
+		listeners = append(listeners, lis)
+	}
+	server.mu.Unlock()
+	if len(listeners) == 0 {
+		return nil, errors.New("rpc server: no listener to hand over")
+	}
+	p, err := StartProcess(listeners...)
+	if err != nil {
+		return nil, err
+	}
+	return p, server.Drain(ctx)
+}
+
+// Drain 先调用 Shutdown 停止接受新连接，然后关闭已经没有未完成请求的连接，等待所有连接结束。
+// 客户端在连接关闭之后重新连接，就会连到新的进程或者其他实例。ctx 结束时强制关闭剩下的连接并返回 ctx 的错误
+func (server *Server) Drain(ctx context.Context) error {
+	err := server.Shutdown(ctx)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		server.mu.Lock()
+		n := len(server.conns)
+		for cs := range server.conns {
+			if !cs.busy() {
+				_ = cs.cc.Close()
+			}
+		}
+		server.mu.Unlock()
+		if n == 0 {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			server.mu.Lock()
+			for cs := range server.conns {
+				_ = cs.cc.Close()
+			}
+			server.mu.Unlock()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// trackConn 记录一个正在处理请求的连接，Drain 时逐个关闭，已经 Shutdown 时通知客户端服务端即将关闭
+func (server *Server) trackConn(cs *connState) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.conns == nil {
+		server.conns = make(map[*connState]struct{})
+	}
+	server.conns[cs] = struct{}{}
+	if server.shutdown {
+		go server.goAway(cs)
+	}
+}
+
+// goAway 发送 FlagGoAway，客户端收到之后不再在这个连接上发起新的调用，已经发出的调用正常完成，
+// Drain 可能已经关闭了连接，所以不记录写入的错误
+func (server *Server) goAway(cs *connState) {
+	cs.sending.Lock()
+	defer cs.sending.Unlock()
+	_ = cs.cc.Write(&codec.Header{Flags: codec.FlagGoAway}, invalidRequest)
+}
+
+func (server *Server) untrackConn(cs *connState) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	delete(server.conns, cs)
+}
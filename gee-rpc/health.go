@@ -0,0 +1,83 @@
+package geerpc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// HealthStatus 健康检查的结果
+type HealthStatus string
+
+const (
+	HealthServing    HealthStatus = "SERVING"
+	HealthNotServing HealthStatus = "NOT_SERVING"
+	HealthUnknown    HealthStatus = "SERVICE_UNKNOWN" // 没有注册这个服务
+)
+
+// HealthServiceName 内置的健康检查服务的名字，每个 Server 都会自动注册，
+// 负载均衡和服务发现可以统一调用 Health.Check 探测服务是否可用
+const HealthServiceName = "Health"
+
+// health 内置的健康检查服务
+type health struct {
+	server   *Server
+	mu       sync.RWMutex
+	statuses map[string]HealthStatus // 通过 SetServingStatus 设置的状态，"" 表示整个 Server
+}
+
+func newHealthService(server *Server) *service {
+	s, err := newNamedService(&health{server: server, statuses: make(map[string]HealthStatus)}, HealthServiceName)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
+
+// Check 返回 service 的状态，service 为空时返回整个 Server 的状态
+func (h *health) Check(service string, reply *HealthStatus) error {
+	h.mu.RLock()
+	status, ok := h.statuses[service]
+	h.mu.RUnlock()
+	switch {
+	case ok:
+		*reply = status
+	case service == "":
+		*reply = HealthServing
+	default:
+		if _, found := h.server.serviceMap.Load(service); found {
+			*reply = HealthServing
+		} else {
+			*reply = HealthUnknown
+		}
+	}
+	return nil
+}
+
+// SetServingStatus 手动设置服务的状态，比如在下线之前设置为 HealthNotServing，
+// 让负载均衡先摘掉流量，name 为空表示整个 Server
+func (server *Server) SetServingStatus(name string, status HealthStatus) {
+	svci, ok := server.serviceMap.Load(HealthServiceName)
+	if !ok {
+		return
+	}
+	h, ok := svci.(*service).rcvr.Interface().(*health)
+	if !ok {
+		return // Health 被用户替换了
+	}
+	h.mu.Lock()
+	h.statuses[name] = status
+	h.mu.Unlock()
+}
+
+// HealthCheck 调用服务端内置的健康检查服务，Server 不是 HealthServing 状态时返回错误
+func (client *Client) HealthCheck(ctx context.Context) error {
+	var status HealthStatus
+	if err := client.Call(ctx, HealthServiceName+".Check", "", &status, WithPriority(PriorityHigh)); err != nil {
+		return err
+	}
+	if status != HealthServing {
+		return fmt.Errorf("rpc client: server is %s", status)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package geerpc
+
+import (
+	"errors"
+	"geerpc/codec"
+	"sync/atomic"
+	"time"
+)
+
+// ErrHeartbeatTimeout 开启心跳之后，超过 IdleTimeout 没有收到服务端的任何数据
+var ErrHeartbeatTimeout = errors.New("rpc client: heartbeat timeout")
+
+// heartbeat 定期发送心跳，并检查最近一次收到数据的时间，
+// 连接半开的时候写入可能不会报错，只能靠读不到数据来发现，关闭连接之后 receive 会结束所有的 Call
+func (client *Client) heartbeat(cc codec.Codec, quit chan struct{}) {
+	interval, timeout := client.opt.HeartbeatInterval, client.opt.idleTimeout()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+		last := time.Unix(0, atomic.LoadInt64(&client.lastRecv))
+		if time.Since(last) > timeout {
+			client.opt.logger().Errorf("rpc client: no data received for %s, closing connection", time.Since(last))
+			client.mu.Lock()
+			client.closeErr = ErrHeartbeatTimeout
+			client.mu.Unlock()
+			_ = cc.Close()
+			return
+		}
+		_ = client.write(&codec.Header{Flags: codec.FlagPing}, invalidRequest)
+	}
+}
@@ -0,0 +1,126 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// IdempotencyKey 是 Metadata 中幂等键使用的 key，同一个方法带有相同幂等键的请求在服务端只会执行一次，
+// 之后的请求直接返回第一次执行的结果，即使客户端重连之后重试也是如此，参考 WithIdempotencyKey
+const IdempotencyKey = "x-idempotency-key"
+
+// ErrDuplicateSeq 同一个连接上收到了重复或者回退的 Seq，这个请求不会被执行
+var ErrDuplicateSeq = errors.New("rpc server: duplicate seq")
+
+// defaultIdempotencyTTL 没有设置 Server.IdempotencyTTL 时结果保存的时间
+const defaultIdempotencyTTL = 5 * time.Minute
+
+// WithIdempotencyKey 为这次调用设置幂等键，key 由调用方生成并保证唯一，比如订单号，
+// 重试时使用同一个 key，服务端就不会重复执行有副作用的操作。
+// 幂等键只在同一个调用方（ConnInfo 的 Identity 和 Namespace 相同）之间生效，不同的调用方使用相同的 key 互不影响
+func WithIdempotencyKey(key string) CallOption {
+	return WithMetadata(map[string]string{IdempotencyKey: key})
+}
+
+// idempotencyStore 保存带有幂等键的请求的结果，正在执行的请求也会记录，相同的请求等待它完成
+type idempotencyStore struct {
+	mu        sync.Mutex
+	entries   map[idempotencyScope]*idempotentEntry
+	lastSweep time.Time
+}
+
+// idempotencyScope 是保存结果使用的 key，按照调用方隔离，避免一个调用方猜到别人的幂等键之后拿到别人的结果
+type idempotencyScope struct {
+	namespace     string
+	identity      string
+	serviceMethod string
+	key           string
+}
+
+type idempotentEntry struct {
+	done    chan struct{} // 执行完成时关闭
+	reply   reflect.Value
+	err     error
+	expires time.Time
+}
+
+// sweep 删除过期的结果，调用方需要持有锁，每个 ttl 最多清理一次
+func (s *idempotencyStore) sweep(now time.Time, ttl time.Duration) {
+	if now.Sub(s.lastSweep) < ttl {
+		return
+	}
+	s.lastSweep = now
+	for key, e := range s.entries {
+		if !e.expires.IsZero() && now.After(e.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+func (server *Server) idempotencyTTL() time.Duration {
+	if server.IdempotencyTTL > 0 {
+		return server.IdempotencyTTL
+	}
+	return defaultIdempotencyTTL
+}
+
+// idempotentInvoke 请求带有幂等键时，相同方法和幂等键的请求只执行一次 call，
+// 之后的请求返回同样的结果，第一次执行还没有完成时等待它完成或者 ctx 结束
+func (server *Server) idempotentInvoke(ctx context.Context, req *request, call func() error) error {
+	key := req.h.Metadata[IdempotencyKey]
+	if key == "" || req.mtype.stream {
+		return call()
+	}
+	scope := idempotencyScope{serviceMethod: req.h.ServiceMethod, key: key}
+	if info, ok := ConnInfoFromContext(ctx); ok {
+		scope.namespace, scope.identity = info.Namespace, info.Identity
+	}
+	ttl := server.idempotencyTTL()
+	s := &server.idempotency
+	now := time.Now()
+	s.mu.Lock()
+	if s.entries == nil {
+		s.entries = make(map[idempotencyScope]*idempotentEntry)
+	}
+	s.sweep(now, ttl)
+	e, ok := s.entries[scope]
+	if ok && !e.expires.IsZero() && now.After(e.expires) {
+		ok = false
+	}
+	if !ok {
+		e = &idempotentEntry{done: make(chan struct{})}
+		s.entries[scope] = e
+	}
+	s.mu.Unlock()
+
+	if ok {
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if e.err == nil {
+			req.replyv.Elem().Set(e.reply)
+		}
+		return e.err
+	}
+
+	err := call()
+	e.err = err
+	if err == nil {
+		e.reply = reflect.New(req.replyv.Elem().Type()).Elem()
+		e.reply.Set(req.replyv.Elem())
+	}
+	s.mu.Lock()
+	if ctx.Err() != nil && s.entries[scope] == e {
+		// 第一次执行时请求已经被取消或者超时，结果不完整，之后的重试需要重新执行
+		delete(s.entries, scope)
+	}
+	e.expires = time.Now().Add(ttl)
+	s.mu.Unlock()
+	close(e.done)
+	return err
+}
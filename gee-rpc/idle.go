@@ -0,0 +1,44 @@
+package geerpc
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// touch 记录连接最近一次活动的时间，收到任何一帧数据或者一个请求处理完成都算作活动
+func (cs *connState) touch() {
+	atomic.StoreInt64(&cs.lastActive, time.Now().UnixNano())
+}
+
+// busy 连接上是否还有没有回复的请求或者没有结束的流
+func (cs *connState) busy() bool {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return len(cs.calls) > 0 || len(cs.streams) > 0
+}
+
+// reapIdle 在设置了 Server.IdleTimeout 时启动一个定时器，连接空闲超过 IdleTimeout 之后关闭它，
+// 让 readRequest 返回从而释放这个连接占用的 goroutine 和文件描述符，返回的函数用于停止定时器
+func (server *Server) reapIdle(cs *connState) (stop func()) {
+	timeout := server.IdleTimeout
+	if timeout <= 0 {
+		return func() {}
+	}
+	cs.touch()
+	var timer *time.Timer
+	timer = time.AfterFunc(timeout, func() {
+		idle := time.Since(time.Unix(0, atomic.LoadInt64(&cs.lastActive)))
+		if cs.busy() {
+			timer.Reset(timeout) // 请求完成时会更新 lastActive，之后再重新计算
+			return
+		}
+		if idle < timeout {
+			timer.Reset(timeout - idle)
+			return
+		}
+		info, _ := ConnInfoFromContext(cs.ctx)
+		server.logger.Infof("rpc server: connection from %s idle for %s, closing", info.RemoteAddr, idle.Truncate(time.Millisecond))
+		_ = cs.cc.Close()
+	})
+	return func() { timer.Stop() }
+}
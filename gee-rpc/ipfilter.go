@@ -0,0 +1,91 @@
+package geerpc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+)
+
+// ErrIPRejected 客户端的地址被 Server.IPFilter 拒绝，连接会被直接关闭
+var ErrIPRejected = errors.New("rpc server: ip rejected")
+
+// IPFilter 按照 CIDR 规则过滤客户端的地址，Deny 优先于 Allow，Allow 为空时允许所有不在 Deny 中的地址。
+// 设置在 Server.IPFilter 中之后，被拒绝的连接在握手之前就会被关闭，并输出一行日志记录对端的地址
+type IPFilter struct {
+	Allow []netip.Prefix
+	Deny  []netip.Prefix
+}
+
+// NewIPFilter 解析 allow 和 deny 中的规则，每条规则可以是 CIDR，比如 10.0.0.0/8，也可以是单个 IP
+func NewIPFilter(allow, deny []string) (*IPFilter, error) {
+	f := &IPFilter{}
+	var err error
+	if f.Allow, err = parsePrefixes(allow); err != nil {
+		return nil, err
+	}
+	if f.Deny, err = parsePrefixes(deny); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func parsePrefixes(rules []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(rules))
+	for _, rule := range rules {
+		rule = strings.TrimSpace(rule)
+		if !strings.Contains(rule, "/") {
+			addr, err := netip.ParseAddr(rule)
+			if err != nil {
+				return nil, fmt.Errorf("rpc server: invalid ip rule %q: %v", rule, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		p, err := netip.ParsePrefix(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rpc server: invalid ip rule %q: %v", rule, err)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+	return prefixes, nil
+}
+
+// Allowed 判断 addr 是否允许连接，IPv4 映射的 IPv6 地址按照 IPv4 处理
+func (f *IPFilter) Allowed(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range f.Deny {
+		if p.Contains(addr) {
+			return false
+		}
+	}
+	if len(f.Allow) == 0 {
+		return true
+	}
+	for _, p := range f.Allow {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowConn 检查 conn 的对端地址，无法取得地址时只有没有设置 Allow 才允许连接
+func (f *IPFilter) allowConn(conn io.ReadWriteCloser) bool {
+	c, ok := conn.(net.Conn)
+	if !ok {
+		return len(f.Allow) == 0
+	}
+	return f.allowAddr(c.RemoteAddr().String())
+}
+
+// allowAddr 检查 "host:port" 格式的地址，比如 http.Request.RemoteAddr
+func (f *IPFilter) allowAddr(addr string) bool {
+	ap, err := netip.ParseAddrPort(addr)
+	if err != nil {
+		return len(f.Allow) == 0
+	}
+	return f.Allowed(ap.Addr())
+}
@@ -0,0 +1,309 @@
+package geerpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"geerpc/codec"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// JSON-RPC 2.0 的 HTTP 入口，非 Go 的客户端不需要实现 Option 握手和 gob 编码，
+// 直接 POST 一个 JSON 就可以调用注册的服务，比如：
+// curl -d '{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1,"Num2":2},"id":1}' http://localhost:9999/_geerpc_/jsonrpc
+
+const (
+	jsonRPCSuffix      = "/jsonrpc"
+	defaultJSONRPCPath = defaultRPCPath + jsonRPCSuffix
+	// defaultMaxHTTPBody 没有设置 Server.MaxHTTPBody 时请求 body 的最大字节数
+	defaultMaxHTTPBody = 4 << 20
+)
+
+// AuthHeader 是 JSON-RPC 和网关请求携带鉴权令牌的 HTTP 头部，格式为 "Bearer <token>"，
+// 令牌和 Option.AuthToken 一样交给 Server.AuthFunc 校验，再由 Server.IdentityFunc 转换成调用方的身份
+const AuthHeader = "Authorization"
+
+// JSON-RPC 2.0 规范中预定义的错误码
+const (
+	JSONRPCParseError     = -32700
+	JSONRPCInvalidRequest = -32600
+	JSONRPCMethodNotFound = -32601
+	JSONRPCInvalidParams  = -32602
+	JSONRPCInternalError  = -32603
+	JSONRPCServerError    = -32000 // 服务方法返回的错误
+)
+
+// JSONRPCError 是 JSON-RPC 2.0 响应中的 error 对象
+type JSONRPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"` // 服务方法返回的错误的详细信息，参考 ErrorDetailer
+
+	cause error // 服务端的原始错误，网关据此选择 HTTP 状态码
+}
+
+func (e *JSONRPCError) Error() string {
+	return fmt.Sprintf("jsonrpc error %d: %s", e.Code, e.Message)
+}
+
+type jsonRPCRequest struct {
+	Version string           `json:"jsonrpc"`
+	Method  string           `json:"method"`
+	Params  json.RawMessage  `json:"params"`
+	ID      *json.RawMessage `json:"id"` // 没有 id 的请求是通知，不需要回复
+}
+
+type jsonRPCResponse struct {
+	Version string           `json:"jsonrpc"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *JSONRPCError    `json:"error,omitempty"`
+	ID      *json.RawMessage `json:"id"`
+}
+
+// callJSON 使用 JSON 编码的参数调用 serviceMethod，和普通请求一样经过限流、工作池、HandleTimeout、
+// 大小限制和中间件，ctx 需要带有 admitHTTP 返回的 ConnInfo
+func (server *Server) callJSON(ctx context.Context, serviceMethod string, params json.RawMessage) (interface{}, *JSONRPCError) {
+	svc, mtype, err := server.findService(serviceMethod)
+	if err != nil {
+		return nil, &JSONRPCError{Code: JSONRPCMethodNotFound, Message: err.Error()}
+	}
+	if mtype.stream {
+		return nil, &JSONRPCError{Code: JSONRPCMethodNotFound, Message: "rpc server: stream method can't be called over json: " + serviceMethod}
+	}
+	req := &request{
+		h:      &codec.Header{ServiceMethod: serviceMethod},
+		svc:    svc,
+		mtype:  mtype,
+		argv:   mtype.newArgv(),
+		replyv: mtype.newReplyv(),
+		ctx:    ctx,
+	}
+	limit := server.sizeLimit(req)
+	if limit != nil && limit.MaxRequest > 0 && len(params) > limit.MaxRequest {
+		return nil, &JSONRPCError{Code: JSONRPCInvalidParams, Message: fmt.Sprintf("rpc server: request of %s is %d bytes, exceeds limit %d",
+			serviceMethod, len(params), limit.MaxRequest)}
+	}
+	if err := decodeParams(params, req.argv); err != nil {
+		return nil, &JSONRPCError{Code: JSONRPCInvalidParams, Message: err.Error()}
+	}
+	if !server.allow(nil, req) {
+		return nil, jsonRPCServerError(ErrRateLimited)
+	}
+	if err := server.invokeHTTP(ctx, req, server.handleTimeout(req, server.HandleTimeout)); err != nil {
+		return nil, jsonRPCServerError(err)
+	}
+	result := req.replyv.Elem().Interface()
+	if limit != nil && limit.MaxResponse > 0 {
+		if data, err := json.Marshal(result); err == nil && len(data) > limit.MaxResponse {
+			server.logger.Errorf("rpc server: response of %s is %d bytes, exceeds limit %d", serviceMethod, len(data), limit.MaxResponse)
+			return nil, &JSONRPCError{Code: JSONRPCInternalError, Message: fmt.Sprintf("rpc server: response of %s is %d bytes, exceeds limit %d",
+				serviceMethod, len(data), limit.MaxResponse)}
+		}
+	}
+	return result, nil
+}
+
+// invokeHTTP 和 serveCodec 一样把请求交给工作池执行，超过 timeout 或者 HTTP 请求结束时不再等待，
+// 方法的 ctx 随之结束
+func (server *Server) invokeHTTP(ctx context.Context, req *request, timeout time.Duration) error {
+	if timeout > 0 {
+		req.deadline = time.Now().Add(timeout)
+	}
+	done := make(chan error, 1)
+	task := func() { done <- server.invoke(req) }
+	if pool := server.workerPool(req); pool == nil {
+		go task()
+	} else if !pool.SubmitPriority(requestPriority(req), task) {
+		return ErrServerBusy
+	}
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-expired:
+		return Errorf(CodeTimeout, "rpc server: request handle timeout: expect within %s", timeout)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func jsonRPCServerError(err error) *JSONRPCError {
+	return &JSONRPCError{Code: JSONRPCServerError, Message: err.Error(), Data: ErrorDetailsOf(err), cause: err}
+}
+
+// admitHTTP 对 JSON-RPC 和网关的 HTTP 请求执行和 ServerConn 相同的准入检查：IPFilter、OnConnect 和 AuthFunc，
+// 令牌从 AuthHeader 中读取。通过之后返回带有 ConnInfo 的 ctx 和请求结束时调用的 done，
+// 拒绝时返回应答的 HTTP 状态码和错误，AuthFunc 的 conn 参数为 nil
+func (server *Server) admitHTTP(r *http.Request) (context.Context, func(), int, error) {
+	info := httpConnInfo(r)
+	if server.IPFilter != nil && !server.IPFilter.allowAddr(r.RemoteAddr) {
+		server.logger.Errorf("%v: %s", ErrIPRejected, r.RemoteAddr)
+		return nil, nil, http.StatusForbidden, ErrIPRejected
+	}
+	if server.OnConnect != nil {
+		if err := server.OnConnect(info); err != nil {
+			server.logger.Errorf("rpc server: reject connection from %s: %v", info.RemoteAddr, err)
+			return nil, nil, http.StatusServiceUnavailable, err
+		}
+	}
+	done := func() {}
+	if server.OnDisconnect != nil {
+		done = func() { server.OnDisconnect(info) }
+	}
+	token := strings.TrimPrefix(r.Header.Get(AuthHeader), "Bearer ")
+	if server.AuthFunc != nil {
+		if err := server.AuthFunc(token, nil); err != nil {
+			done()
+			err = fmt.Errorf("%w: %s", ErrUnauthorized, err)
+			server.logger.Errorf("rpc server: auth error from %s: %v", info.RemoteAddr, err)
+			return nil, nil, http.StatusUnauthorized, err
+		}
+	}
+	if server.IdentityFunc != nil {
+		info.Identity = server.IdentityFunc(token)
+	}
+	return contextWithConnInfo(r.Context(), info), done, 0, nil
+}
+
+// readHTTPBody 读取请求的 body，超过 Server.MaxHTTPBody 时返回 413
+func (server *Server) readHTTPBody(w http.ResponseWriter, r *http.Request) ([]byte, int, error) {
+	limit := server.MaxHTTPBody
+	if limit <= 0 {
+		limit = defaultMaxHTTPBody
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, http.StatusRequestEntityTooLarge, err
+		}
+		return nil, http.StatusBadRequest, err
+	}
+	return body, 0, nil
+}
+
+// decodeParams 把 params 解码到 argv 中，params 可以直接是参数本身，
+// 也可以是只有一个元素的数组（按位置传参，和 net/rpc/jsonrpc 的格式一致）
+func decodeParams(params json.RawMessage, argv reflect.Value) error {
+	params = bytes.TrimSpace(params)
+	if len(params) == 0 || bytes.Equal(params, []byte("null")) {
+		return nil
+	}
+	argvi := argv.Interface()
+	if argv.Type().Kind() != reflect.Ptr {
+		argvi = argv.Addr().Interface()
+	}
+	if kind := reflect.Indirect(argv).Kind(); params[0] == '[' && kind != reflect.Slice && kind != reflect.Array {
+		var positional []json.RawMessage
+		if err := json.Unmarshal(params, &positional); err != nil {
+			return err
+		}
+		if len(positional) != 1 {
+			return fmt.Errorf("rpc server: expect 1 positional param, got %d", len(positional))
+		}
+		params = positional[0]
+	}
+	return json.Unmarshal(params, argvi)
+}
+
+type jsonRPCHandler struct {
+	*Server
+}
+
+// JSONRPCHandler 返回处理 JSON-RPC 2.0 请求的 http.Handler，支持批量请求和通知，
+// 请求和普通连接一样需要通过 IPFilter、OnConnect 和 AuthFunc，令牌放在 AuthHeader 中。
+// HandleHTTP 不会注册它，需要时自己注册，比如 http.Handle("/_geerpc_/jsonrpc", server.JSONRPCHandler())
+func (server *Server) JSONRPCHandler() http.Handler {
+	return jsonRPCHandler{server}
+}
+
+func (h jsonRPCHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "405 must POST", http.StatusMethodNotAllowed)
+		return
+	}
+	ctx, done, status, err := h.admitHTTP(r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	defer done()
+	body, status, err := h.readHTTPBody(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+	var resp interface{}
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			resp = jsonRPCFailure(nil, JSONRPCParseError, err.Error())
+		} else if len(batch) == 0 {
+			resp = jsonRPCFailure(nil, JSONRPCInvalidRequest, "empty batch")
+		} else {
+			// 批量请求中的通知不需要回复，全部都是通知时不返回任何内容
+			replies := make([]*jsonRPCResponse, 0, len(batch))
+			for _, raw := range batch {
+				if reply := h.handle(ctx, raw); reply != nil {
+					replies = append(replies, reply)
+				}
+			}
+			if len(replies) > 0 {
+				resp = replies
+			}
+		}
+	} else if reply := h.handle(ctx, body); reply != nil {
+		resp = reply
+	}
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		h.logger.Errorf("rpc server: jsonrpc write response error: %v", err)
+	}
+}
+
+// handle 处理一个请求，请求是通知时返回 nil
+func (h jsonRPCHandler) handle(ctx context.Context, raw json.RawMessage) *jsonRPCResponse {
+	var req jsonRPCRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		if _, ok := err.(*json.SyntaxError); ok {
+			return jsonRPCFailure(nil, JSONRPCParseError, err.Error())
+		}
+		return jsonRPCFailure(nil, JSONRPCInvalidRequest, err.Error())
+	}
+	if req.Version != "2.0" || req.Method == "" {
+		return jsonRPCFailure(req.ID, JSONRPCInvalidRequest, "invalid JSON-RPC 2.0 request")
+	}
+	result, rpcErr := h.callJSON(ctx, req.Method, req.Params)
+	if req.ID == nil {
+		return nil
+	}
+	if rpcErr != nil {
+		return &jsonRPCResponse{Version: "2.0", Error: rpcErr, ID: req.ID}
+	}
+	return &jsonRPCResponse{Version: "2.0", Result: result, ID: req.ID}
+}
+
+func jsonRPCFailure(id *json.RawMessage, code int, message string) *jsonRPCResponse {
+	if id == nil {
+		null := json.RawMessage("null")
+		id = &null
+	}
+	return &jsonRPCResponse{Version: "2.0", Error: &JSONRPCError{Code: code, Message: message}, ID: id}
+}
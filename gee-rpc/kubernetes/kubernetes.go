@@ -0,0 +1,138 @@
+// Package kubernetes 通过 API Server 监听 Service 的 EndpointSlice，实时更新服务列表，
+// 相比 DNS 解析可以立即感知 Pod 的上线和下线
+//
+// Pod 滚动更新时，正在终止的 Pod 不会再被选中；如果所有端点都在终止（比如缩容到 0 的过程中），
+// 则继续使用其中仍然 Serving 的端点，和 kube-proxy 的处理方式一致，避免调用在 Pod 真正退出之前就全部失败
+package kubernetes
+
+import (
+	"context"
+	"errors"
+	"geerpc/xclient"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// syncTimeout 是创建时等待第一次同步 EndpointSlice 的最长时间
+const syncTimeout = 10 * time.Second
+
+// KubernetesDiscovery 监听一个 Service 的所有 EndpointSlice
+type KubernetesDiscovery struct {
+	*xclient.MultiServersDiscovery
+	protocol string
+	portName string
+	informer cache.SharedIndexInformer
+	stop     chan struct{}
+	mu       sync.Mutex // 保证按照事件的顺序更新服务列表
+}
+
+var _ xclient.Discovery = (*KubernetesDiscovery)(nil)
+
+// NewKubernetesDiscovery 监听 namespace 中 service 的端点，protocol 是 XDial 使用的协议，比如 tcp，
+// portName 是 Service 中端口的名字，Service 只有一个端口时可以为空，使用完之后需要调用 Close
+func NewKubernetesDiscovery(client kubernetes.Interface, namespace, service, protocol, portName string) (*KubernetesDiscovery, error) {
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(opts *metav1.ListOptions) {
+			opts.LabelSelector = discoveryv1.LabelServiceName + "=" + service
+		}))
+	d := &KubernetesDiscovery{
+		MultiServersDiscovery: xclient.NewMultiServersDiscovery(nil),
+		protocol:              protocol,
+		portName:              portName,
+		informer:              factory.Discovery().V1().EndpointSlices().Informer(),
+		stop:                  make(chan struct{}),
+	}
+	update := func(interface{}) { d.sync() }
+	_, err := d.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    update,
+		UpdateFunc: func(_, _ interface{}) { d.sync() },
+		DeleteFunc: update,
+	})
+	if err != nil {
+		return nil, err
+	}
+	go d.informer.Run(d.stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), syncTimeout)
+	defer cancel()
+	if !cache.WaitForCacheSync(ctx.Done(), d.informer.HasSynced) {
+		close(d.stop)
+		return nil, errors.New("rpc kubernetes: timeout waiting for endpoint slices of " + namespace + "/" + service)
+	}
+	d.sync()
+	return d, nil
+}
+
+// Refresh 根据本地缓存重新计算服务列表，缓存由 informer 实时更新，通常不需要调用
+func (d *KubernetesDiscovery) Refresh() error {
+	d.sync()
+	return nil
+}
+
+// Close 停止监听
+func (d *KubernetesDiscovery) Close() error {
+	close(d.stop)
+	return nil
+}
+
+// sync 合并所有 EndpointSlice 中的端点，同一个地址可能在迁移过程中同时出现在多个 slice 中，需要去重
+func (d *KubernetesDiscovery) sync() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ready, serving := make(map[string]bool), make(map[string]bool)
+	for _, obj := range d.informer.GetStore().List() {
+		slice, ok := obj.(*discoveryv1.EndpointSlice)
+		if !ok || slice.AddressType == discoveryv1.AddressTypeFQDN {
+			continue
+		}
+		port, ok := d.port(slice)
+		if !ok {
+			continue
+		}
+		for _, ep := range slice.Endpoints {
+			c := ep.Conditions
+			terminating := c.Terminating != nil && *c.Terminating
+			for _, ip := range ep.Addresses {
+				addr := d.protocol + "@" + net.JoinHostPort(ip, strconv.Itoa(int(port)))
+				// Ready 为空表示未知，按照规范应当视为 ready
+				if (c.Ready == nil || *c.Ready) && !terminating {
+					ready[addr] = true
+				} else if c.Serving != nil && *c.Serving {
+					serving[addr] = true
+				}
+			}
+		}
+	}
+	if len(ready) == 0 {
+		ready = serving
+	}
+	servers := make([]string, 0, len(ready))
+	for addr := range ready {
+		servers = append(servers, addr)
+	}
+	sort.Strings(servers) // 保持轮询的顺序稳定
+	_ = d.MultiServersDiscovery.Update(servers)
+}
+
+// port 返回 slice 中名字为 portName 的端口，portName 为空时要求只有一个端口
+func (d *KubernetesDiscovery) port(slice *discoveryv1.EndpointSlice) (int32, bool) {
+	for _, p := range slice.Ports {
+		if p.Port == nil {
+			continue
+		}
+		if (p.Name != nil && *p.Name == d.portName) || (d.portName == "" && len(slice.Ports) == 1) {
+			return *p.Port, true
+		}
+	}
+	return 0, false
+}
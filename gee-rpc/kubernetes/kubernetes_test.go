@@ -0,0 +1,78 @@
+package kubernetes
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func endpointSlice(name string, endpoints ...discoveryv1.Endpoint) *discoveryv1.EndpointSlice {
+	portName, port := "rpc", int32(9999)
+	return &discoveryv1.EndpointSlice{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{discoveryv1.LabelServiceName: "geerpc"},
+		},
+		AddressType: discoveryv1.AddressTypeIPv4,
+		Endpoints:   endpoints,
+		Ports:       []discoveryv1.EndpointPort{{Name: &portName, Port: &port}},
+	}
+}
+
+func endpoint(ip string, ready, serving, terminating bool) discoveryv1.Endpoint {
+	return discoveryv1.Endpoint{
+		Addresses:  []string{ip},
+		Conditions: discoveryv1.EndpointConditions{Ready: &ready, Serving: &serving, Terminating: &terminating},
+	}
+}
+
+func TestKubernetesDiscovery(t *testing.T) {
+	client := fake.NewSimpleClientset(endpointSlice("geerpc-a",
+		endpoint("10.0.0.1", true, true, false),
+		endpoint("10.0.0.2", false, false, false),
+	))
+	slices := client.DiscoveryV1().EndpointSlices("default")
+	ctx := context.Background()
+
+	d, err := NewKubernetesDiscovery(client, "default", "geerpc", "tcp", "rpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = d.Close() }()
+
+	waitFor := func(expect string) {
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			all, _ := d.GetAll()
+			if got := strings.Join(all, ","); got == expect {
+				return
+			} else if time.Now().After(deadline) {
+				t.Fatalf("expect %q, got %q", expect, got)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor("tcp@10.0.0.1:9999")
+
+	// 新的 Pod 就绪，旧的 Pod 开始终止，同一个地址出现在两个 slice 中
+	_, _ = slices.Create(ctx, endpointSlice("geerpc-b",
+		endpoint("10.0.0.3", true, true, false),
+		endpoint("10.0.0.1", true, true, false),
+	), metav1.CreateOptions{})
+	waitFor("tcp@10.0.0.1:9999,tcp@10.0.0.3:9999")
+	_, _ = slices.Update(ctx, endpointSlice("geerpc-a", endpoint("10.0.0.1", false, true, true)), metav1.UpdateOptions{})
+	_, _ = slices.Update(ctx, endpointSlice("geerpc-b", endpoint("10.0.0.3", true, true, false)), metav1.UpdateOptions{})
+	waitFor("tcp@10.0.0.3:9999")
+
+	// 所有端点都在终止时继续使用仍然 Serving 的端点
+	_ = slices.Delete(ctx, "geerpc-b", metav1.DeleteOptions{})
+	waitFor("tcp@10.0.0.1:9999")
+	_ = slices.Delete(ctx, "geerpc-a", metav1.DeleteOptions{})
+	waitFor("")
+}
@@ -0,0 +1,130 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// 进程内的传输层：PipeListener 的 Accept 返回 net.Pipe 的一端，客户端通过 pipe@name 拿到另一端，
+// 数据不经过内核，但是握手、编解码、心跳和流控等逻辑和真实的连接完全相同，
+// 单元测试不需要占用端口，也不需要等待服务端启动
+
+func init() {
+	RegisterDialer("pipe", dialPipe)
+}
+
+var (
+	pipesMu sync.RWMutex
+	pipes   = make(map[string]*PipeListener)
+	pipeSeq uint64 // NewLocalClientServer 生成 name 使用
+)
+
+// ErrPipeClosed PipeListener 已经关闭
+var ErrPipeClosed = errors.New("rpc: pipe listener closed")
+
+// PipeListener 是进程内的 net.Listener，使用 Dial("pipe", name) 或者 XDial("pipe@name") 连接
+type PipeListener struct {
+	name      string
+	conns     chan net.Conn
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+var _ net.Listener = (*PipeListener)(nil)
+
+// ListenPipe 创建名为 name 的 PipeListener，同一个 name 同时只能有一个 PipeListener，Close 之后可以重新使用
+func ListenPipe(name string) (*PipeListener, error) {
+	pipesMu.Lock()
+	defer pipesMu.Unlock()
+	if _, ok := pipes[name]; ok {
+		return nil, errors.New("rpc: pipe " + name + " is already in use")
+	}
+	lis := &PipeListener{
+		name:  name,
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+	pipes[name] = lis
+	return lis, nil
+}
+
+// Accept 等待客户端连接，返回连接的服务端一端
+func (lis *PipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-lis.conns:
+		return conn, nil
+	case <-lis.done:
+		return nil, ErrPipeClosed
+	}
+}
+
+// Close 停止接受新的连接，已经建立的连接不受影响
+func (lis *PipeListener) Close() error {
+	lis.closeOnce.Do(func() {
+		close(lis.done)
+		pipesMu.Lock()
+		delete(pipes, lis.name)
+		pipesMu.Unlock()
+	})
+	return nil
+}
+
+func (lis *PipeListener) Addr() net.Addr {
+	return pipeAddr(lis.name)
+}
+
+// Dial 建立一个到 lis 的连接，返回客户端一端，ctx 结束之前没有被 Accept 时返回 ctx 的错误
+func (lis *PipeListener) Dial(ctx context.Context) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case lis.conns <- server:
+		return client, nil
+	case <-lis.done:
+		return nil, ErrPipeClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+type pipeAddr string
+
+func (a pipeAddr) Network() string { return "pipe" }
+func (a pipeAddr) String() string  { return string(a) }
+
+// dialPipe 连接到名为 address 的 PipeListener
+func dialPipe(ctx context.Context, address string, opt *Option) (net.Conn, error) {
+	if opt.TLSConfig != nil {
+		return nil, errors.New("rpc client: pipe doesn't support TLSConfig")
+	}
+	pipesMu.RLock()
+	lis, ok := pipes[address]
+	pipesMu.RUnlock()
+	if !ok {
+		return nil, errors.New("rpc client: no pipe listener named " + address)
+	}
+	return lis.Dial(ctx)
+}
+
+// NewLocalClientServer 创建一个 Server 和连接到它的 Client，两者通过进程内的 PipeListener 通信，
+// 适合单元测试：在返回的 Server 上注册服务之后就可以通过 Client 调用。
+// 调用 Server.Shutdown 关闭 listener，再关闭 Client 就释放了所有资源。
+// 需要多个 Client 或者自定义 Server 时，使用 ListenPipe 创建 listener 之后自己调用 Accept 和 Dial
+func NewLocalClientServer(opts ...DialOption) (*Client, *Server, error) {
+	name := "local-" + strconv.FormatUint(atomic.AddUint64(&pipeSeq, 1), 10)
+	lis, err := ListenPipe(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	server := NewServer()
+	go server.Accept(lis)
+	client, err := Dial("pipe", name, opts...)
+	if err != nil {
+		_ = server.Shutdown(context.Background())
+		return nil, nil, err
+	}
+	return client, server, nil
+}
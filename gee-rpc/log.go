@@ -0,0 +1,46 @@
+package geerpc
+
+import "log"
+
+// LogLevel 日志级别，低于设置级别的日志不会输出
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelError
+	LevelOff // 关闭所有日志
+)
+
+// Logger 是 geerpc 输出日志使用的接口，可以替换成自己的日志库
+type Logger interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+// stdLogger 使用标准库的 log 输出日志
+type stdLogger struct {
+	level LogLevel
+}
+
+// NewLogger 返回一个基于标准库 log 的 Logger，只输出不低于 level 的日志
+func NewLogger(level LogLevel) Logger {
+	return &stdLogger{level: level}
+}
+
+// DefaultLogger 输出所有级别的日志，和之前直接使用 log 包的行为保持一致
+var DefaultLogger = NewLogger(LevelDebug)
+
+func (l *stdLogger) logf(level LogLevel, format string, v ...interface{}) {
+	if level < l.level {
+		return
+	}
+	log.Printf(format, v...)
+}
+
+func (l *stdLogger) Debugf(format string, v ...interface{}) { l.logf(LevelDebug, format, v...) }
+
+func (l *stdLogger) Infof(format string, v ...interface{}) { l.logf(LevelInfo, format, v...) }
+
+func (l *stdLogger) Errorf(format string, v ...interface{}) { l.logf(LevelError, format, v...) }
@@ -0,0 +1,54 @@
+package geerpc
+
+import "context"
+
+type outgoingMetadataKey struct{}
+
+type incomingMetadataKey struct{}
+
+// ContextWithMetadata 返回带有 md 的 ctx，使用这个 ctx 发起的 Call 会把 md 放到请求头中发送给服务端，
+// 适合传递鉴权 token、租户 ID 这类和参数无关的信息
+func ContextWithMetadata(ctx context.Context, md map[string]string) context.Context {
+	return context.WithValue(ctx, outgoingMetadataKey{}, copyMetadata(MetadataFromContext(ctx), md))
+}
+
+// MetadataFromContext 返回 ctx 中将要发送的 Metadata
+func MetadataFromContext(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(outgoingMetadataKey{}).(map[string]string)
+	return md
+}
+
+// IncomingMetadata 在服务端返回客户端随请求发送过来的 Metadata，
+// 和发送用的 Metadata 分开存放，避免服务端继续调用其他服务时把收到的信息原样转发出去
+func IncomingMetadata(ctx context.Context) map[string]string {
+	md, _ := ctx.Value(incomingMetadataKey{}).(map[string]string)
+	return md
+}
+
+func contextWithIncomingMetadata(ctx context.Context, md map[string]string) context.Context {
+	return context.WithValue(ctx, incomingMetadataKey{}, md)
+}
+
+// copyMetadata 把多个 Metadata 合并成一个新的 map，后面的覆盖前面的
+func copyMetadata(mds ...map[string]string) map[string]string {
+	var result map[string]string
+	for _, md := range mds {
+		for k, v := range md {
+			if result == nil {
+				result = make(map[string]string)
+			}
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// CallOption 用于设置单次调用的参数
+type CallOption func(call *Call)
+
+// WithMetadata 为这次调用添加 Metadata，会覆盖 ctx 中相同 key 的值
+func WithMetadata(md map[string]string) CallOption {
+	return func(call *Call) {
+		call.Metadata = copyMetadata(call.Metadata, md)
+	}
+}
@@ -0,0 +1,195 @@
+package geerpc
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets 耗时直方图的桶上界，单位为秒，和 Prometheus 客户端的默认值一致
+var latencyBuckets = [...]float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram 是一个并发安全的耗时直方图，counts 中每个桶只记录落在该桶内的次数，输出时再累加
+type histogram struct {
+	counts [len(latencyBuckets) + 1]uint64 // 最后一个桶对应 +Inf
+	sum    uint64                          // 总耗时，单位为纳秒
+	count  uint64
+	min    uint64 // 最短耗时加 1，0 表示还没有记录
+	max    uint64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	seconds := d.Seconds()
+	i := sort.SearchFloat64s(latencyBuckets[:], seconds)
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.sum, uint64(d))
+	atomic.AddUint64(&h.count, 1)
+	for v := uint64(d) + 1; ; {
+		old := atomic.LoadUint64(&h.min)
+		if (old != 0 && old <= v) || atomic.CompareAndSwapUint64(&h.min, old, v) {
+			break
+		}
+	}
+	for v := uint64(d); ; {
+		old := atomic.LoadUint64(&h.max)
+		if old >= v || atomic.CompareAndSwapUint64(&h.max, old, v) {
+			break
+		}
+	}
+}
+
+// LatencyStats 是一个方法调用耗时的统计，P50 和 P99 根据直方图估算，取所在桶的上界，不会超过 Max
+type LatencyStats struct {
+	Count uint64
+	Min   time.Duration
+	Avg   time.Duration
+	Max   time.Duration
+	P50   time.Duration
+	P99   time.Duration
+}
+
+func (h *histogram) stats() LatencyStats {
+	s := LatencyStats{Count: atomic.LoadUint64(&h.count)}
+	if s.Count == 0 {
+		return s
+	}
+	s.Min = time.Duration(atomic.LoadUint64(&h.min) - 1)
+	s.Max = time.Duration(atomic.LoadUint64(&h.max))
+	s.Avg = time.Duration(atomic.LoadUint64(&h.sum) / s.Count)
+	s.P50, s.P99 = h.quantile(0.5, s.Max), h.quantile(0.99, s.Max)
+	return s
+}
+
+// quantile 返回第 q 分位所在桶的上界，落在 +Inf 桶或者上界超过 max 时返回 max
+func (h *histogram) quantile(q float64, max time.Duration) time.Duration {
+	var counts [len(latencyBuckets) + 1]uint64
+	var total uint64
+	for i := range counts {
+		counts[i] = atomic.LoadUint64(&h.counts[i])
+		total += counts[i]
+	}
+	rank := uint64(q*float64(total-1)) + 1
+	var cumulative uint64
+	for i, bound := range latencyBuckets {
+		cumulative += counts[i]
+		if cumulative >= rank {
+			if d := time.Duration(bound * float64(time.Second)); d < max {
+				return d
+			}
+			break
+		}
+	}
+	return max
+}
+
+// countingReader 和 countingWriter 用于统计一条连接上收发的字节数
+type countingReader struct {
+	io.Reader
+	n *uint64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	atomic.AddUint64(r.n, uint64(n))
+	return n, err
+}
+
+type countingWriter struct {
+	io.WriteCloser
+	n *uint64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	atomic.AddUint64(w.n, uint64(n))
+	return n, err
+}
+
+// MetricsHandler 返回一个 http.Handler，以 Prometheus 文本格式输出每个方法的调用次数、错误次数、
+// 正在处理的请求数和耗时直方图，以及服务端收发的字节数，比如：
+//
+//	http.Handle("/metrics", server.MetricsHandler())
+func (server *Server) MetricsHandler() http.Handler {
+	return http.HandlerFunc(server.serveMetrics)
+}
+
+// labelEscaper 按照 Prometheus 文本格式转义标签的值，RegisterName 的服务名可以包含引号和反斜杠
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func (server *Server) serveMetrics(w http.ResponseWriter, _ *http.Request) {
+	type methodMetric struct {
+		labels string
+		mtype  *methodType
+	}
+	var methods []methodMetric
+	server.serviceMap.Range(func(namei, svci interface{}) bool {
+		svc := svci.(*service)
+		for _, name := range svc.methodNames() {
+			methods = append(methods, methodMetric{
+				labels: fmt.Sprintf(`service="%s",method="%s"`, labelEscaper.Replace(namei.(string)), labelEscaper.Replace(name)),
+				mtype:  svc.method[name],
+			})
+		}
+		return true
+	})
+	sort.Slice(methods, func(i, j int) bool { return methods[i].labels < methods[j].labels })
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	writeHeader := func(name, typ, help string) {
+		_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	}
+
+	writeHeader("geerpc_server_calls_total", "counter", "Total number of RPC calls handled.")
+	for _, m := range methods {
+		_, _ = fmt.Fprintf(w, "geerpc_server_calls_total{%s} %d\n", m.labels, m.mtype.NumCalls())
+	}
+	writeHeader("geerpc_server_errors_total", "counter", "Total number of RPC calls that returned an error.")
+	for _, m := range methods {
+		_, _ = fmt.Fprintf(w, "geerpc_server_errors_total{%s} %d\n", m.labels, m.mtype.NumErrors())
+	}
+	writeHeader("geerpc_server_slow_calls_total", "counter", "Total number of RPC calls slower than the slow call threshold.")
+	for _, m := range methods {
+		_, _ = fmt.Fprintf(w, "geerpc_server_slow_calls_total{%s} %d\n", m.labels, m.mtype.NumSlowCalls())
+	}
+	writeHeader("geerpc_server_request_bytes_total", "counter", "Total encoded size of requests, including headers.")
+	for _, m := range methods {
+		total, _ := m.mtype.RequestBytes()
+		_, _ = fmt.Fprintf(w, "geerpc_server_request_bytes_total{%s} %d\n", m.labels, total)
+	}
+	writeHeader("geerpc_server_response_bytes_total", "counter", "Total encoded size of responses, including headers.")
+	for _, m := range methods {
+		total, _ := m.mtype.ResponseBytes()
+		_, _ = fmt.Fprintf(w, "geerpc_server_response_bytes_total{%s} %d\n", m.labels, total)
+	}
+	writeHeader("geerpc_server_max_response_bytes", "gauge", "Largest encoded response seen, including headers.")
+	for _, m := range methods {
+		_, max := m.mtype.ResponseBytes()
+		_, _ = fmt.Fprintf(w, "geerpc_server_max_response_bytes{%s} %d\n", m.labels, max)
+	}
+	writeHeader("geerpc_server_in_flight", "gauge", "Number of RPC calls currently being handled.")
+	for _, m := range methods {
+		_, _ = fmt.Fprintf(w, "geerpc_server_in_flight{%s} %d\n", m.labels, m.mtype.InFlight())
+	}
+	writeHeader("geerpc_server_handling_seconds", "histogram", "Latency of RPC calls handled by the server.")
+	for _, m := range methods {
+		h := &m.mtype.latency
+		var cumulative uint64
+		for i, bound := range latencyBuckets {
+			cumulative += atomic.LoadUint64(&h.counts[i])
+			_, _ = fmt.Fprintf(w, "geerpc_server_handling_seconds_bucket{%s,le=\"%g\"} %d\n", m.labels, bound, cumulative)
+		}
+		cumulative += atomic.LoadUint64(&h.counts[len(latencyBuckets)])
+		_, _ = fmt.Fprintf(w, "geerpc_server_handling_seconds_bucket{%s,le=\"+Inf\"} %d\n", m.labels, cumulative)
+		sum := time.Duration(atomic.LoadUint64(&h.sum)).Seconds()
+		_, _ = fmt.Fprintf(w, "geerpc_server_handling_seconds_sum{%s} %g\n", m.labels, sum)
+		_, _ = fmt.Fprintf(w, "geerpc_server_handling_seconds_count{%s} %d\n", m.labels, atomic.LoadUint64(&h.count))
+	}
+	writeHeader("geerpc_server_received_bytes_total", "counter", "Total number of bytes received from clients.")
+	_, _ = fmt.Fprintf(w, "geerpc_server_received_bytes_total %d\n", atomic.LoadUint64(&server.bytesIn))
+	writeHeader("geerpc_server_sent_bytes_total", "counter", "Total number of bytes sent to clients.")
+	_, _ = fmt.Fprintf(w, "geerpc_server_sent_bytes_total %d\n", atomic.LoadUint64(&server.bytesOut))
+}
@@ -0,0 +1,62 @@
+package geerpc
+
+import (
+	"context"
+	"geerpc/codec"
+)
+
+// RequestContext 保存了一次请求在中间件之间传递的信息
+type RequestContext struct {
+	Context context.Context // 请求的上下文，中间件可以往里面添加信息，比如 trace 信息
+	Header  *codec.Header   // 请求头，ServiceMethod 和 Seq 等信息都在这里
+	Args    interface{}     // 已经解码好的参数
+	Reply   interface{}     // 用于存放返回值的指针，真正调用方法之前是零值
+}
+
+// Handler 处理一次请求，返回的错误会写到响应头的 Error 中
+type Handler func(ctx *RequestContext) error
+
+// Middleware 包装每一次请求的处理过程，调用 next 继续执行后面的中间件和服务方法，
+// 不调用 next 直接返回错误就可以中断这次请求，比如鉴权失败
+type Middleware func(ctx *RequestContext, next Handler) error
+
+// Use 添加中间件，先添加的中间件在外层，需要在 Accept 之前调用
+func (server *Server) Use(middlewares ...Middleware) {
+	server.middlewares = append(server.middlewares, middlewares...)
+}
+
+// chain 把中间件和最终的处理函数组合成一个 Handler
+func (server *Server) chain(h Handler) Handler {
+	for i := len(server.middlewares) - 1; i >= 0; i-- {
+		m, next := server.middlewares[i], h
+		h = func(ctx *RequestContext) error {
+			return m(ctx, next)
+		}
+	}
+	return h
+}
+
+func Use(middlewares ...Middleware) { DefaultServer.Use(middlewares...) }
+
+// CallHandler 发送一次请求并等待结果
+type CallHandler func(ctx context.Context, call *Call) error
+
+// CallInterceptor 包装客户端的每一次 Call，调用 next 真正发送请求，
+// 可以在前后修改请求、记录耗时，或者多次调用 next 实现重试
+type CallInterceptor func(ctx context.Context, call *Call, next CallHandler) error
+
+// Use 添加客户端拦截器，先添加的拦截器在外层，需要在发起调用之前设置
+// 注意拦截器只作用于 Call，异步的 Go 不经过拦截器
+func (client *Client) Use(interceptors ...CallInterceptor) {
+	client.interceptors = append(client.interceptors, interceptors...)
+}
+
+func (client *Client) chain(h CallHandler) CallHandler {
+	for i := len(client.interceptors) - 1; i >= 0; i-- {
+		interceptor, next := client.interceptors[i], h
+		h = func(ctx context.Context, call *Call) error {
+			return interceptor(ctx, call, next)
+		}
+	}
+	return h
+}
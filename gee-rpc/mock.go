@@ -0,0 +1,173 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// MockResponse 是 MockClient 对一次调用的回复
+type MockResponse struct {
+	Reply   interface{}   // 复制到调用方的 reply 中，可以是 reply 指向的类型的值或者指针
+	Err     error         // 不为空时调用返回这个错误，比如 NewError(CodeUnavailable, "...")
+	Latency time.Duration // 返回之前等待的时间，ctx 先结束时返回 ctx 的错误
+	// Func 不为空时代替 Reply 和 Err，可以根据参数计算回复
+	Func func(args, reply interface{}) error
+}
+
+// MockClient 实现了 ClientInterface，按照预先设置的回复返回结果，并记录所有的调用，
+// 用于在没有服务端的情况下测试调用方的错误处理，比如超时、服务不可用之后的降级逻辑
+type MockClient struct {
+	mu        sync.Mutex
+	responses map[string][]MockResponse
+	calls     []*Call
+	closed    bool
+}
+
+var _ ClientInterface = (*MockClient)(nil)
+
+func NewMockClient() *MockClient {
+	return &MockClient{responses: make(map[string][]MockResponse)}
+}
+
+// On 设置 serviceMethod 的回复，每次调用按照顺序使用一个回复，只剩最后一个时一直使用它，
+// 比如 On("Foo.Sum", MockResponse{Err: ErrUnavailable}, MockResponse{Reply: 3}) 第一次失败之后都成功。
+// 没有设置回复的方法返回 CodeNotFound 错误
+func (m *MockClient) On(serviceMethod string, responses ...MockResponse) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.responses[serviceMethod] = append(m.responses[serviceMethod], responses...)
+}
+
+// Calls 返回对 serviceMethod 的所有调用，serviceMethod 为空时返回所有调用，
+// Call 中记录了参数、Metadata 和优先级，Error 为这次调用的结果
+func (m *MockClient) Calls(serviceMethod string) []*Call {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var calls []*Call
+	for _, call := range m.calls {
+		if serviceMethod == "" || call.ServiceMethod == serviceMethod {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (m *MockClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error {
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Metadata:      copyMetadata(MetadataFromContext(ctx)),
+	}
+	for _, opt := range opts {
+		opt(call)
+	}
+	m.do(ctx, call)
+	return call.Error
+}
+
+func (m *MockClient) Go(serviceMethod string, args, reply interface{}, done chan *Call, opts ...CallOption) *Call {
+	if done == nil {
+		done = make(chan *Call, 10)
+	} else if cap(done) == 0 {
+		log.Panic("rpc client: done channel is unbuffered")
+	}
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Reply:         reply,
+		Done:          done,
+		finished:      make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(call)
+	}
+	go func() {
+		m.do(context.Background(), call)
+		call.done()
+	}()
+	return call
+}
+
+// Close 之后的调用返回 ErrShutdown
+func (m *MockClient) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return ErrShutdown
+	}
+	m.closed = true
+	return nil
+}
+
+// do 记录 call 并按照设置的回复完成它，结果写在 call.Error 中
+func (m *MockClient) do(ctx context.Context, call *Call) {
+	if call.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, call.deadline)
+		defer cancel()
+	}
+	resp, err := m.next(call)
+	if err != nil {
+		call.Error = err
+		return
+	}
+	if resp.Latency > 0 {
+		timer := time.NewTimer(resp.Latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			call.Error = errors.New("rpc client:" + ctx.Err().Error())
+			return
+		}
+	}
+	switch {
+	case resp.Func != nil:
+		call.Error = resp.Func(call.Args, call.Reply)
+	case resp.Err != nil:
+		call.Error = resp.Err
+	case resp.Reply != nil && call.Reply != nil:
+		call.Error = setMockReply(call.Reply, resp.Reply)
+	}
+}
+
+// next 记录 call 并取出它的回复
+func (m *MockClient) next(call *Call) (MockResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.calls = append(m.calls, call)
+	if m.closed {
+		return MockResponse{}, ErrShutdown
+	}
+	responses := m.responses[call.ServiceMethod]
+	if len(responses) == 0 {
+		return MockResponse{}, Errorf(CodeNotFound, "rpc client: mock has no response for %s", call.ServiceMethod)
+	}
+	if len(responses) > 1 {
+		m.responses[call.ServiceMethod] = responses[1:]
+	}
+	return responses[0], nil
+}
+
+// setMockReply 把 value 复制到 reply 指向的变量中
+func setMockReply(reply, value interface{}) error {
+	rv := reflect.ValueOf(reply)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("rpc client: mock reply must be a non-nil pointer, got %T", reply)
+	}
+	v := reflect.ValueOf(value)
+	if v.Type() != rv.Elem().Type() && v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if !v.Type().AssignableTo(rv.Elem().Type()) {
+		return fmt.Errorf("rpc client: mock reply %T can't be assigned to %T", value, reply)
+	}
+	rv.Elem().Set(v)
+	return nil
+}
@@ -0,0 +1,32 @@
+package geerpc
+
+import "log"
+
+// Mount 把 sub 挂载在 server 的 namespace 下，客户端在 Option.Namespace 中指定 namespace 时，
+// 连接在交换完 Option 之后交给 sub 处理，使用 sub 注册的服务、中间件、鉴权和超时等设置，
+// 这样一个进程可以在同一个端口上同时提供管理接口和公开接口，两者的中间件和访问控制互不影响。
+// 连接数限制、IP 过滤和 OnConnect 等建立连接时的设置仍然使用 server 的。
+// 通过 HTTP 提供服务时，也可以在同一个 mux 上使用 HandleHTTPOn 为每个 Server 注册不同的路径。
+// sub 为 nil 时取消挂载，已经建立的连接不受影响
+func (server *Server) Mount(namespace string, sub *Server) {
+	if namespace == "" {
+		log.Panic("rpc server: mount with empty namespace")
+	}
+	if sub == nil {
+		server.namespaces.Delete(namespace)
+		return
+	}
+	server.namespaces.Store(namespace, sub)
+}
+
+// namespace 返回处理 namespace 的 Server，namespace 为空时就是 server 本身，
+// 找不到时返回 server 和错误，由 server 把错误告诉客户端
+func (server *Server) namespace(namespace string) (*Server, error) {
+	if namespace == "" {
+		return server, nil
+	}
+	if sub, ok := server.namespaces.Load(namespace); ok {
+		return sub.(*Server), nil
+	}
+	return server, Errorf(CodeNotFound, "rpc server: unknown namespace %q", namespace)
+}
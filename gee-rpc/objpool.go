@@ -0,0 +1,118 @@
+package geerpc
+
+import (
+	"geerpc/codec"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// countingPool 在 sync.Pool 的基础上统计获取次数和命中次数，用于确认对象复用是否生效
+type countingPool struct {
+	pool   sync.Pool
+	gets   uint64
+	misses uint64
+}
+
+// get 返回 nil 表示池中没有可复用的对象，由调用方创建新的对象
+func (p *countingPool) get() interface{} {
+	atomic.AddUint64(&p.gets, 1)
+	x := p.pool.Get()
+	if x == nil {
+		atomic.AddUint64(&p.misses, 1)
+	}
+	return x
+}
+
+func (p *countingPool) put(x interface{}) {
+	p.pool.Put(x)
+}
+
+func (p *countingPool) stats() PoolCounter {
+	gets := atomic.LoadUint64(&p.gets)
+	return PoolCounter{Gets: gets, Hits: gets - atomic.LoadUint64(&p.misses)}
+}
+
+// PoolCounter 记录一个对象池的获取次数和其中复用了已有对象的次数
+type PoolCounter struct {
+	Gets uint64
+	Hits uint64
+}
+
+// PoolStats 服务端各个对象池的统计信息
+type PoolStats struct {
+	Headers  PoolCounter
+	Requests PoolCounter
+	Argv     PoolCounter // 所有方法的参数对象池的总和，只有开启 ReuseArgv 时才会使用
+}
+
+// PoolStats 返回服务端对象池的统计信息
+func (server *Server) PoolStats() PoolStats {
+	stats := PoolStats{
+		Headers:  server.headerPool.stats(),
+		Requests: server.requestPool.stats(),
+	}
+	server.serviceMap.Range(func(_, svci interface{}) bool {
+		for _, m := range svci.(*service).method {
+			c := m.argvPool.stats()
+			stats.Argv.Gets += c.Gets
+			stats.Argv.Hits += c.Hits
+		}
+		return true
+	})
+	return stats
+}
+
+func (server *Server) getHeader() *codec.Header {
+	if h := server.headerPool.get(); h != nil {
+		return h.(*codec.Header)
+	}
+	return new(codec.Header)
+}
+
+func (server *Server) putHeader(h *codec.Header) {
+	*h = codec.Header{}
+	server.headerPool.put(h)
+}
+
+func (server *Server) getRequest() *request {
+	if req := server.requestPool.get(); req != nil {
+		return req.(*request)
+	}
+	return new(request)
+}
+
+// freeRequest 回收请求使用的对象，调用之后不能再访问 req
+func (server *Server) freeRequest(req *request) {
+	req.release()
+	if req.h != nil {
+		server.putHeader(req.h)
+	}
+	if server.ReuseArgv && req.mtype != nil && req.argv.IsValid() {
+		req.mtype.argvPool.put(req.argv)
+	}
+	if req.stream != nil {
+		// 之后客户端发来的帧都会被丢弃
+		req.stream.cs.removeStream(req.stream)
+	}
+	*req = request{}
+	server.requestPool.put(req)
+}
+
+// getArgv 优先复用之前的参数对象，复用之前需要清零，因为 gob 不会写入值为零的字段
+func (m *methodType) getArgv(reuse bool) reflect.Value {
+	if !reuse {
+		return m.newArgv()
+	}
+	v := m.argvPool.get()
+	if v == nil {
+		return m.newArgv()
+	}
+	argv := v.(reflect.Value)
+	if argv.Kind() == reflect.Ptr {
+		argv.Elem().Set(reflect.Zero(argv.Elem().Type()))
+	} else {
+		argv.Set(reflect.Zero(argv.Type()))
+	}
+	return argv
+}
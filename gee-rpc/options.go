@@ -0,0 +1,248 @@
+package geerpc
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"geerpc/codec"
+	"net/http"
+	"net/url"
+	"reflect"
+	"time"
+)
+
+// DialOption 设置 Dial 系列函数使用的 Option，*Option 和 With 开头返回 OptionFunc 的函数都是 DialOption，
+// 两者可以混用，*Option 只能有一个并且需要放在最前面，比如：
+//
+//	Dial("tcp", addr, &Option{AuthToken: token}, WithCodec(codec.JsonType), WithConnectTimeout(time.Second))
+type DialOption interface {
+	applyOption(s *optionSetter) error
+}
+
+// OptionFunc 设置 Option 中的一项，同一项设置了两个不同的值时 Dial 返回错误，
+// 也可以传给 NewServer，此时只使用和服务端有关的设置，参考 NewServer
+type OptionFunc func(s *optionSetter) error
+
+func (f OptionFunc) applyOption(s *optionSetter) error {
+	return f(s)
+}
+
+// optionSetter 在 DefaultOption 的副本上依次应用 DialOption，记录显式设置过的字段，用于发现冲突的设置
+type optionSetter struct {
+	opt    Option
+	fields map[string]interface{}
+	funcs  bool // 已经应用过 OptionFunc
+}
+
+func newOptionSetter() *optionSetter {
+	return &optionSetter{opt: *DefaultOption, fields: make(map[string]interface{})}
+}
+
+// applyOption 使用 opt 代替默认的 Option，和之前的版本一样，没有设置的字段保持零值，CodecType 除外
+func (opt *Option) applyOption(s *optionSetter) error {
+	if opt == nil {
+		return nil
+	}
+	if len(s.fields) > 0 || s.funcs {
+		return errors.New("rpc client: *Option must be the first option and can only be given once")
+	}
+	s.opt = *opt
+	v := reflect.ValueOf(opt).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if !v.Field(i).IsZero() {
+			s.fields[v.Type().Field(i).Name] = v.Field(i).Interface()
+		}
+	}
+	return nil
+}
+
+// set 设置 name 对应的字段，之前已经设置为不同的值时返回错误
+func (s *optionSetter) set(name string, value interface{}, apply func(opt *Option)) error {
+	s.funcs = true
+	if old, ok := s.fields[name]; ok && !reflect.DeepEqual(old, value) {
+		return fmt.Errorf("rpc client: conflicting options: %s is set to both %v and %v", name, old, value)
+	}
+	s.fields[name] = value
+	apply(&s.opt)
+	return nil
+}
+
+// WithCodec 设置编解码方式，codec 需要在 codec.NewCodecFuncMap 中注册过
+func WithCodec(t codec.Type) OptionFunc {
+	return func(s *optionSetter) error {
+		return s.set("CodecType", t, func(opt *Option) { opt.CodecType = t })
+	}
+}
+
+// WithConnectTimeout 设置建立连接和握手的超时时间，0 表示不限制
+func WithConnectTimeout(d time.Duration) OptionFunc {
+	return func(s *optionSetter) error {
+		return s.set("ConnectTimeout", d, func(opt *Option) { opt.ConnectTimeout = d })
+	}
+}
+
+// WithHandleTimeout 设置服务端处理每个请求的超时时间，0 表示不限制
+func WithHandleTimeout(d time.Duration) OptionFunc {
+	return func(s *optionSetter) error {
+		return s.set("HandleTimeout", d, func(opt *Option) { opt.HandleTimeout = d })
+	}
+}
+
+// WithTLS 使用 TLS 加密连接，和 Option.TLSConfig 相同
+func WithTLS(config *tls.Config) OptionFunc {
+	return func(s *optionSetter) error {
+		return s.set("TLSConfig", config, func(opt *Option) { opt.TLSConfig = config })
+	}
+}
+
+// WithLogger 设置输出日志使用的 Logger
+func WithLogger(logger Logger) OptionFunc {
+	return func(s *optionSetter) error {
+		return s.set("Logger", logger, func(opt *Option) { opt.Logger = logger })
+	}
+}
+
+// WithAuthToken 设置握手时发送给服务端的 token
+func WithAuthToken(token string) OptionFunc {
+	return func(s *optionSetter) error {
+		return s.set("AuthToken", token, func(opt *Option) { opt.AuthToken = token })
+	}
+}
+
+// WithHeartbeat 开启心跳，idleTimeout 为 0 时使用 3 倍的 interval
+func WithHeartbeat(interval, idleTimeout time.Duration) OptionFunc {
+	return func(s *optionSetter) error {
+		if err := s.set("HeartbeatInterval", interval, func(opt *Option) { opt.HeartbeatInterval = interval }); err != nil {
+			return err
+		}
+		return s.set("IdleTimeout", idleTimeout, func(opt *Option) { opt.IdleTimeout = idleTimeout })
+	}
+}
+
+// WithReconnect 开启自动重连
+func WithReconnect(policy *ReconnectPolicy) OptionFunc {
+	return func(s *optionSetter) error {
+		return s.set("Reconnect", policy, func(opt *Option) { opt.Reconnect = policy })
+	}
+}
+
+// WithRetry 设置 Call 遇到临时错误时的重试策略
+func WithRetry(policy *RetryPolicy) OptionFunc {
+	return func(s *optionSetter) error {
+		return s.set("Retry", policy, func(opt *Option) { opt.Retry = policy })
+	}
+}
+
+// WithBreaker 为 Client 开启熔断
+func WithBreaker(policy *BreakerPolicy) OptionFunc {
+	return func(s *optionSetter) error {
+		return s.set("Breaker", policy, func(opt *Option) { opt.Breaker = policy })
+	}
+}
+
+// WithMaxPendingCalls 限制同时等待回复的请求数，failFast 为 true 时达到上限直接返回 ErrTooManyPending
+func WithMaxPendingCalls(n int, failFast bool) OptionFunc {
+	return func(s *optionSetter) error {
+		if err := s.set("MaxPendingCalls", n, func(opt *Option) { opt.MaxPendingCalls = n }); err != nil {
+			return err
+		}
+		return s.set("FailFastOnMaxPending", failFast, func(opt *Option) { opt.FailFastOnMaxPending = failFast })
+	}
+}
+
+// WithProxy 通过 HTTP 代理连接服务端，header 为 CONNECT 请求中额外携带的头部
+func WithProxy(proxy *url.URL, header http.Header) OptionFunc {
+	return func(s *optionSetter) error {
+		if err := s.set("Proxy", proxy, func(opt *Option) { opt.Proxy = proxy }); err != nil {
+			return err
+		}
+		return s.set("ProxyHeader", header, func(opt *Option) { opt.ProxyHeader = header })
+	}
+}
+
+// WithRPCPath 设置 DialHTTP 和 DialH2C 请求的路径
+func WithRPCPath(path string) OptionFunc {
+	return func(s *optionSetter) error {
+		return s.set("RPCPath", path, func(opt *Option) { opt.RPCPath = path })
+	}
+}
+
+// WithNamespace 设置连接使用的命名空间，参考 Server.Mount
+func WithNamespace(namespace string) OptionFunc {
+	return func(s *optionSetter) error {
+		return s.set("Namespace", namespace, func(opt *Option) { opt.Namespace = namespace })
+	}
+}
+
+// WithSeqStrategy 设置生成请求序列号的方式
+func WithSeqStrategy(strategy SeqStrategy) OptionFunc {
+	return func(s *optionSetter) error {
+		return s.set("SeqStrategy", strategy, func(opt *Option) { opt.SeqStrategy = strategy })
+	}
+}
+
+// validate 检查 Option 中互相矛盾或者无效的设置
+func (opt *Option) validate() error {
+	switch {
+	case opt.MagicNumber != MagicNumber:
+		return fmt.Errorf("rpc client: invalid magic number %x", opt.MagicNumber)
+	case codec.NewCodecFuncMap[opt.CodecType] == nil:
+		return fmt.Errorf("rpc client: unsupported codec type %s", opt.CodecType)
+	case opt.ConnectTimeout < 0 || opt.HandleTimeout < 0 || opt.HeartbeatInterval < 0 || opt.IdleTimeout < 0:
+		return errors.New("rpc client: timeouts can't be negative")
+	case opt.IdleTimeout > 0 && opt.HeartbeatInterval <= 0:
+		return errors.New("rpc client: IdleTimeout requires HeartbeatInterval")
+	case opt.IdleTimeout > 0 && opt.IdleTimeout <= opt.HeartbeatInterval:
+		return errors.New("rpc client: IdleTimeout must be longer than HeartbeatInterval")
+	case opt.MaxPendingCalls < 0:
+		return errors.New("rpc client: MaxPendingCalls can't be negative")
+	case opt.FailFastOnMaxPending && opt.MaxPendingCalls == 0:
+		return errors.New("rpc client: FailFastOnMaxPending requires MaxPendingCalls")
+	case opt.SeqStrategy != SeqMonotonic && opt.SeqStrategy != SeqRandom:
+		return fmt.Errorf("rpc client: unknown seq strategy %d", opt.SeqStrategy)
+	}
+	return opt.TCP.validate()
+}
+
+// parseOptions 在 DefaultOption 的副本上应用 opts 并检查结果，不会修改调用方传入的 *Option
+func parseOptions(opts ...DialOption) (*Option, error) {
+	s := newOptionSetter()
+	for _, o := range opts {
+		if o == nil {
+			continue
+		}
+		if err := o.applyOption(s); err != nil {
+			return nil, err
+		}
+	}
+	opt := &s.opt
+	if opt.MagicNumber == 0 {
+		opt.MagicNumber = MagicNumber
+	}
+	if opt.CodecType == "" {
+		opt.CodecType = DefaultOption.CodecType
+	}
+	if err := opt.validate(); err != nil {
+		return nil, err
+	}
+	return opt, nil
+}
+
+// applyServer 把和服务端有关的设置应用到 server 上，只使用显式设置过的字段
+func (s *optionSetter) applyServer(server *Server) {
+	if _, ok := s.fields["Logger"]; ok && s.opt.Logger != nil {
+		server.logger = s.opt.Logger
+	}
+	if _, ok := s.fields["ConnectTimeout"]; ok {
+		server.HandshakeTimeout = s.opt.ConnectTimeout
+	}
+	if _, ok := s.fields["HandleTimeout"]; ok {
+		server.HandleTimeout = s.opt.HandleTimeout
+	}
+	if _, ok := s.fields["TCP"]; ok {
+		server.TCP = s.opt.TCP
+	}
+	if _, ok := s.fields["CodecDebug"]; ok {
+		server.CodecDebug = s.opt.CodecDebug
+	}
+}
@@ -0,0 +1,66 @@
+package geerpc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+var (
+	// ErrTooManyConnections 连接数超过 Server.MaxConnections，新的连接会被直接关闭
+	ErrTooManyConnections = errors.New("rpc server: too many connections")
+	// ErrTooManyPendingRequests 单个连接上正在处理的请求数达到 Server.MaxPendingRequestsPerConn，
+	// 服务端会暂停读取这个连接上的请求，直到有请求处理完成
+	ErrTooManyPendingRequests = errors.New("rpc server: too many pending requests")
+)
+
+// acquireConn 占用一个连接数，超过 MaxConnections 时返回 false
+func (server *Server) acquireConn(conn io.ReadWriteCloser) bool {
+	n := atomic.AddInt64(&server.activeConns, 1)
+	if server.MaxConnections > 0 && n > int64(server.MaxConnections) {
+		atomic.AddInt64(&server.activeConns, -1)
+		server.overload(fmt.Errorf("%w: reject %s", ErrTooManyConnections, remoteAddr(conn)))
+		return false
+	}
+	return true
+}
+
+func (server *Server) releaseConn() {
+	atomic.AddInt64(&server.activeConns, -1)
+}
+
+// serveConn 在 IP 规则和连接数限制之内处理 conn，否则直接关闭
+func (server *Server) serveConn(conn io.ReadWriteCloser) {
+	if server.IPFilter != nil && !server.IPFilter.allowConn(conn) {
+		server.logger.Errorf("%v: %s", ErrIPRejected, remoteAddr(conn))
+		_ = conn.Close()
+		return
+	}
+	if !server.acquireConn(conn) {
+		_ = conn.Close()
+		return
+	}
+	defer server.releaseConn()
+	if c, ok := conn.(net.Conn); ok {
+		if err := server.TCP.apply(c); err != nil {
+			server.logger.Errorf("rpc server: set TCP options of %s error: %v", remoteAddr(conn), err)
+		}
+	}
+	server.ServerConn(conn)
+}
+
+func (server *Server) overload(err error) {
+	server.logger.Errorf("%v", err)
+	if server.OnOverload != nil {
+		server.OnOverload(err)
+	}
+}
+
+func remoteAddr(conn io.ReadWriteCloser) string {
+	if c, ok := conn.(net.Conn); ok {
+		return c.RemoteAddr().String()
+	}
+	return "unknown"
+}
@@ -0,0 +1,152 @@
+package geerpc
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrServerBusy 工作池已满并且溢出策略为 OverflowReject 时返回给客户端的错误
+var ErrServerBusy = errors.New("rpc server: server is busy")
+
+// OverflowPolicy 工作池队列已满时的处理策略
+type OverflowPolicy int
+
+const (
+	OverflowBlock  OverflowPolicy = iota // 阻塞等待，连接暂停读取新的请求
+	OverflowReject                       // 直接拒绝，返回 ErrServerBusy
+	OverflowSpawn                        // 启动一个新的 goroutine 执行，相当于退化为没有工作池
+)
+
+// WorkerPool 固定数量的 goroutine 从队列中取出任务执行，用于限制高并发时 goroutine 的数量，
+// 每个优先级使用一个队列，worker 总是先执行高优先级队列中的任务
+type WorkerPool struct {
+	tasks  chan func() // 普通优先级
+	high   chan func()
+	low    chan func()
+	policy OverflowPolicy
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+// NewWorkerPool 创建一个有 size 个 worker 的工作池，每个优先级的队列长度都为 queueLen
+func NewWorkerPool(size, queueLen int, policy OverflowPolicy) *WorkerPool {
+	if size <= 0 {
+		size = 1
+	}
+	p := &WorkerPool{
+		tasks:  make(chan func(), queueLen),
+		high:   make(chan func(), queueLen),
+		low:    make(chan func(), queueLen),
+		policy: policy,
+	}
+	p.wg.Add(size)
+	for i := 0; i < size; i++ {
+		go p.work()
+	}
+	return p
+}
+
+func (p *WorkerPool) work() {
+	defer p.wg.Done()
+	queues := [3]chan func(){p.high, p.tasks, p.low} // 按优先级从高到低
+	for open := len(queues); open > 0; {
+		task, i := nextTask(&queues)
+		if task == nil {
+			queues[i] = nil // 队列已经关闭并且取完了
+			open--
+			continue
+		}
+		task()
+	}
+}
+
+// nextTask 从优先级最高的非空队列中取出一个任务，所有队列都为空时等待任意一个队列，
+// 队列已经关闭时返回 nil 和它的下标
+func nextTask(queues *[3]chan func()) (func(), int) {
+	for i, q := range queues {
+		select {
+		case task := <-q:
+			return task, i
+		default:
+		}
+	}
+	select {
+	case task := <-queues[0]:
+		return task, 0
+	case task := <-queues[1]:
+		return task, 1
+	case task := <-queues[2]:
+		return task, 2
+	}
+}
+
+// Submit 以普通优先级提交一个任务，只有在策略为 OverflowReject 并且队列已满时返回 false
+func (p *WorkerPool) Submit(task func()) bool {
+	return p.SubmitPriority(PriorityNormal, task)
+}
+
+// SubmitPriority 以 priority 提交一个任务。低优先级的任务在普通队列积压超过一半或者自己的队列已满时直接拒绝，
+// 不受 OverflowPolicy 的影响；其他优先级的队列已满时按照 OverflowPolicy 处理
+func (p *WorkerPool) SubmitPriority(priority Priority, task func()) bool {
+	queue := p.tasks
+	switch priority {
+	case PriorityHigh:
+		queue = p.high
+	case PriorityLow:
+		if n := len(p.tasks); n > 0 && n >= cap(p.tasks)/2 {
+			return false
+		}
+		select {
+		case p.low <- task:
+			return true
+		default:
+			return false
+		}
+	}
+	select {
+	case queue <- task:
+		return true
+	default:
+	}
+	switch p.policy {
+	case OverflowReject:
+		return false
+	case OverflowSpawn:
+		go task()
+	default:
+		queue <- task
+	}
+	return true
+}
+
+// Close 等待队列中的任务执行完之后退出所有的 worker，Close 之后不能再提交任务
+func (p *WorkerPool) Close() {
+	p.once.Do(func() {
+		close(p.high)
+		close(p.tasks)
+		close(p.low)
+	})
+	p.wg.Wait()
+}
+
+// SetWorkerPool 为 name 设置独立的工作池，name 为 "Foo" 表示 Foo 服务的所有方法，"Foo.Sum" 表示单个方法，
+// pool 为空表示取消。这样一个很慢的服务只会占满自己的工作池，不会耗尽其他服务的 goroutine，
+// 独立的工作池一般使用 OverflowReject，否则工作池满了之后会阻塞整个连接读取请求。
+// 没有设置的服务和方法使用 Server.WorkerPool
+func (server *Server) SetWorkerPool(name string, pool *WorkerPool) {
+	if pool == nil {
+		server.workerPools.Delete(name)
+		return
+	}
+	server.workerPools.Store(name, pool)
+}
+
+// workerPool 返回执行 req 使用的工作池，依次查找方法、服务的工作池和 Server.WorkerPool，都没有时返回 nil
+func (server *Server) workerPool(req *request) *WorkerPool {
+	for _, name := range []string{req.svc.name + "." + req.mtype.method.Name, req.svc.name} {
+		if pool, ok := server.workerPools.Load(name); ok {
+			return pool.(*WorkerPool)
+		}
+	}
+	return server.WorkerPool
+}
@@ -0,0 +1,42 @@
+package geerpc
+
+// Priority 是请求的优先级，随请求头发送给服务端。服务端的工作池先执行高优先级的请求，
+// 负载高的时候直接拒绝低优先级的请求，健康检查和控制面的调用就不会被批量的请求堵住
+type Priority uint8
+
+const (
+	PriorityNormal Priority = iota // 默认的优先级
+	PriorityHigh                   // 比普通请求先执行，适合健康检查和控制面的调用
+	PriorityLow                    // 工作池有积压时直接返回 ErrServerBusy，适合可以稍后重试的批量任务
+)
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityLow:
+		return "low"
+	default:
+		return "normal"
+	}
+}
+
+// WithPriority 设置这次调用的优先级
+func WithPriority(p Priority) CallOption {
+	return func(call *Call) {
+		call.Priority = p
+	}
+}
+
+// requestPriority 返回 req 的优先级，内置的健康检查服务总是高优先级，不认识的取值按普通优先级处理
+func requestPriority(req *request) Priority {
+	if req.svc != nil && req.svc.name == HealthServiceName {
+		return PriorityHigh
+	}
+	switch p := Priority(req.h.Priority); p {
+	case PriorityHigh, PriorityLow:
+		return p
+	default:
+		return PriorityNormal
+	}
+}
@@ -0,0 +1,55 @@
+package geerpc
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// dialAddr 返回实际需要建立 TCP 连接的地址，设置了代理时为代理的地址
+func (opt *Option) dialAddr(address string) string {
+	if opt.Proxy == nil {
+		return address
+	}
+	if opt.Proxy.Port() == "" {
+		return net.JoinHostPort(opt.Proxy.Hostname(), "80")
+	}
+	return opt.Proxy.Host
+}
+
+// proxyConnect 请求 HTTP 代理建立到 address 的隧道，之后 conn 上的数据由代理原样转发
+func proxyConnect(conn net.Conn, address string, opt *Option) error {
+	if opt.Proxy.Scheme != "http" {
+		return fmt.Errorf("rpc client: unsupported proxy scheme %q", opt.Proxy.Scheme)
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	for k, v := range opt.ProxyHeader {
+		req.Header[k] = v
+	}
+	if u := opt.Proxy.User; u != nil {
+		password, _ := u.Password()
+		auth := base64.StdEncoding.EncodeToString([]byte(u.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	if err := req.Write(conn); err != nil {
+		return fmt.Errorf("rpc client: proxy connect error: %s", err)
+	}
+	// 代理回复之后在收到我们的数据之前不会再发送任何内容，所以这里的 bufio.Reader 不会多读
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		return fmt.Errorf("rpc client: proxy connect error: %s", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("rpc client: proxy connect error: %s", resp.Status)
+	}
+	return nil
+}
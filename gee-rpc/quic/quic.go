@@ -0,0 +1,153 @@
+// Package quic 让 geerpc 运行在 QUIC 之上，每个 QUIC 连接使用一个双向流承载原有的 Option 握手和请求帧，
+// QUIC 支持连接迁移并且握手更快，适合移动端等网络不稳定的场景
+//
+// 服务端使用 Listen 创建 net.Listener 交给 Server.Accept，客户端引入本包之后即可使用 XDial("quic@addr")，
+// QUIC 强制使用 TLS 1.3，所以客户端需要在 Option.TLSConfig 中设置校验服务端证书的配置
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"geerpc"
+	"net"
+	"sync"
+	"time"
+
+	quicgo "github.com/quic-go/quic-go"
+)
+
+// NextProto 是 TLS 握手中 ALPN 使用的协议名，tls.Config 中没有设置 NextProtos 时自动填入
+const NextProto = "geerpc"
+
+// acceptStreamTimeout 是建立连接之后等待客户端打开流的最长时间
+const acceptStreamTimeout = 10 * time.Second
+
+func init() {
+	geerpc.RegisterDialer("quic", dial)
+}
+
+// conn 把 QUIC 连接和其中的一个流包装为 net.Conn，关闭的时候关闭整个连接
+type conn struct {
+	*quicgo.Stream
+	qc *quicgo.Conn
+}
+
+var _ net.Conn = (*conn)(nil)
+
+func (c *conn) LocalAddr() net.Addr  { return c.qc.LocalAddr() }
+func (c *conn) RemoteAddr() net.Addr { return c.qc.RemoteAddr() }
+
+func (c *conn) Close() error {
+	return c.qc.CloseWithError(0, "")
+}
+
+func dial(ctx context.Context, address string, opt *geerpc.Option) (net.Conn, error) {
+	if opt.TLSConfig == nil {
+		return nil, errors.New("rpc client: quic requires Option.TLSConfig")
+	}
+	config := opt.TLSConfig.Clone()
+	if config.ServerName == "" {
+		if host, _, err := net.SplitHostPort(address); err == nil {
+			config.ServerName = host
+		}
+	}
+	qc, err := quicgo.DialAddr(ctx, address, withNextProto(config), quicConfig(opt))
+	if err != nil {
+		return nil, err
+	}
+	stream, err := qc.OpenStreamSync(ctx)
+	if err != nil {
+		_ = qc.CloseWithError(0, "")
+		return nil, err
+	}
+	return &conn{Stream: stream, qc: qc}, nil
+}
+
+// quicConfig 开启心跳时让 QUIC 自身也保持连接活跃，避免 NAT 映射过期
+func quicConfig(opt *geerpc.Option) *quicgo.Config {
+	return &quicgo.Config{KeepAlivePeriod: opt.HeartbeatInterval}
+}
+
+func withNextProto(config *tls.Config) *tls.Config {
+	if len(config.NextProtos) == 0 {
+		config.NextProtos = []string{NextProto}
+	}
+	return config
+}
+
+// listener 在后台接受 QUIC 连接，等客户端打开流之后再通过 Accept 返回，
+// 这样一个迟迟不打开流的客户端不会阻塞其他连接
+type listener struct {
+	ln     *quicgo.Listener
+	conns  chan net.Conn
+	err    error         // serve 退出的原因
+	failed chan struct{} // serve 退出之后关闭
+	done   chan struct{}
+	closer sync.Once
+}
+
+// Listen 在 addr 上监听 UDP 并返回 net.Listener，config 中需要有服务端证书
+func Listen(addr string, config *tls.Config) (net.Listener, error) {
+	ln, err := quicgo.ListenAddr(addr, withNextProto(config.Clone()), nil)
+	if err != nil {
+		return nil, err
+	}
+	l := &listener{
+		ln:     ln,
+		conns:  make(chan net.Conn),
+		failed: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go l.serve()
+	return l, nil
+}
+
+func (l *listener) serve() {
+	for {
+		qc, err := l.ln.Accept(context.Background())
+		if err != nil {
+			l.err = err
+			close(l.failed)
+			return
+		}
+		go l.acceptStream(qc)
+	}
+}
+
+func (l *listener) acceptStream(qc *quicgo.Conn) {
+	ctx, cancel := context.WithTimeout(qc.Context(), acceptStreamTimeout)
+	defer cancel()
+	stream, err := qc.AcceptStream(ctx)
+	if err != nil {
+		_ = qc.CloseWithError(0, "")
+		return
+	}
+	select {
+	case l.conns <- &conn{Stream: stream, qc: qc}:
+	case <-l.done:
+		_ = qc.CloseWithError(0, "")
+	}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	case <-l.failed:
+		return nil, l.err
+	}
+}
+
+func (l *listener) Close() error {
+	var err error
+	l.closer.Do(func() {
+		close(l.done)
+		err = l.ln.Close()
+	})
+	return err
+}
+
+func (l *listener) Addr() net.Addr { return l.ln.Addr() }
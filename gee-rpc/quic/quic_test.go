@@ -0,0 +1,78 @@
+package quic
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"geerpc"
+	"math/big"
+	"testing"
+	"time"
+)
+
+type Foo int
+
+type Args struct{ Num1, Num2 int }
+
+func (f Foo) Sum(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+// selfSignedConfig 生成 localhost 的自签名证书，返回服务端和客户端的 TLS 配置
+func selfSignedConfig(t *testing.T) (*tls.Config, *tls.Config) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	server := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}
+	return server, &tls.Config{RootCAs: pool, ServerName: "localhost"}
+}
+
+func TestQUIC(t *testing.T) {
+	serverConfig, clientConfig := selfSignedConfig(t)
+	l, err := Listen("127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = l.Close() }()
+	server := geerpc.NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	go server.Accept(l)
+
+	t.Run("call", func(t *testing.T) {
+		client, err := geerpc.XDial("quic@"+l.Addr().String(), &geerpc.Option{TLSConfig: clientConfig})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { _ = client.Close() }()
+		for i := 0; i < 3; i++ {
+			var reply int
+			if err := client.Call(context.Background(), "Foo.Sum", Args{Num1: i, Num2: 2}, &reply); err != nil || reply != i+2 {
+				t.Fatalf("expect %d, got %d %v", i+2, reply, err)
+			}
+		}
+	})
+	t.Run("tls required", func(t *testing.T) {
+		if _, err := geerpc.XDial("quic@" + l.Addr().String()); err == nil {
+			t.Fatal("expect an error without TLSConfig")
+		}
+	})
+}
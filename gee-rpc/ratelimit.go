@@ -0,0 +1,97 @@
+package geerpc
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited 请求被限流时返回给客户端的错误
+var ErrRateLimited = errors.New("rpc server: rate limited")
+
+// RateLimiter 限流器，Allow 返回 false 表示这次请求需要被拒绝
+type RateLimiter interface {
+	Allow() bool
+}
+
+// RateLimitRefunder 是 RateLimiter 的可选接口，Refund 归还最近一次 Allow 成功时消耗的配额。
+// 一个请求需要经过多个限流器，后面的限流器拒绝时会调用前面已经放行的限流器的 Refund，
+// 被拒绝的请求不会消耗其他限流器的配额
+type RateLimitRefunder interface {
+	Refund()
+}
+
+// tokenBucket 令牌桶限流器，以 rate 的速度往桶里放令牌，桶里最多有 burst 个令牌，每个请求消耗一个令牌
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64 // 每秒生成的令牌数
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket 创建一个令牌桶限流器，每秒允许 rate 个请求，最多允许 burst 个请求的突发
+func NewTokenBucket(rate float64, burst int) RateLimiter {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+func (b *tokenBucket) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Refund 归还一个令牌，桶里的令牌不会超过 burst
+func (b *tokenBucket) Refund() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens++; b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// SetRateLimiter 为 name 设置限流器，name 为空表示整个 Server，"Foo" 表示 Foo 服务的所有方法，
+// "Foo.Sum" 表示单个方法，limiter 为空表示取消限流
+func (server *Server) SetRateLimiter(name string, limiter RateLimiter) {
+	if limiter == nil {
+		server.limiters.Delete(name)
+		return
+	}
+	server.limiters.Store(name, limiter)
+}
+
+// allow 依次检查连接、Server、服务和方法的限流器，任意一个拒绝都会拒绝这次请求，
+// 并且归还前面已经放行的限流器消耗的配额
+func (server *Server) allow(connLimiter RateLimiter, req *request) bool {
+	limiters := make([]RateLimiter, 0, 4)
+	if connLimiter != nil {
+		limiters = append(limiters, connLimiter)
+	}
+	for _, name := range []string{"", req.svc.name, req.svc.name + "." + req.mtype.method.Name} {
+		if limiter, ok := server.limiters.Load(name); ok {
+			limiters = append(limiters, limiter.(RateLimiter))
+		}
+	}
+	for i, limiter := range limiters {
+		if limiter.Allow() {
+			continue
+		}
+		for _, allowed := range limiters[:i] {
+			if r, ok := allowed.(RateLimitRefunder); ok {
+				r.Refund()
+			}
+		}
+		return false
+	}
+	return true
+}
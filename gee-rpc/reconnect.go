@@ -0,0 +1,141 @@
+package geerpc
+
+import (
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"geerpc/codec"
+)
+
+// ConnState 表示客户端连接的状态，开启自动重连之后通过 ReconnectPolicy.OnStateChange 通知
+type ConnState int
+
+const (
+	StateConnected    ConnState = iota // 重新建立了连接并完成了握手
+	StateDisconnected                  // 连接断开，未完成的 Call 都已经返回错误，之后开始重连
+)
+
+func (s ConnState) String() string {
+	if s == StateConnected {
+		return "connected"
+	}
+	return "disconnected"
+}
+
+// ReconnectPolicy 设置在 Option.Reconnect 中之后，Dial 创建的客户端在连接断开时会自动重连，
+// 重连期间发起的调用会直接返回 ErrShutdown，重连成功之后 Client 可以继续使用，调用 Close 之后停止重连
+type ReconnectPolicy struct {
+	MinBackoff time.Duration // 第一次重连之前等待的时间，默认 100ms
+	MaxBackoff time.Duration // 每次失败之后等待时间翻倍，最多等待这么久，默认 10s
+	// OnStateChange 在连接状态变化时调用，断开时 err 为断开的原因
+	OnStateChange func(state ConnState, err error)
+}
+
+// backoff 返回第 attempt 次重连之前等待的时间
+func (p *ReconnectPolicy) backoff(attempt int) time.Duration {
+	min, max := p.MinBackoff, p.MaxBackoff
+	if min <= 0 {
+		min = 100 * time.Millisecond
+	}
+	if max <= 0 {
+		max = 10 * time.Second
+	}
+	return jitterBackoff(min, max, attempt)
+}
+
+// jitterBackoff 在指数退避的基础上加上随机抖动，避免大量客户端在服务端重启之后同时重试
+func jitterBackoff(min, max time.Duration, attempt int) time.Duration {
+	d := min
+	for i := 0; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func (p *ReconnectPolicy) notify(state ConnState, err error) {
+	if p.OnStateChange != nil {
+		p.OnStateChange(state, err)
+	}
+}
+
+// reconnect 在 receive 结束之后不断重连，直到成功或者用户调用了 Close
+func (client *Client) reconnect(cause error) {
+	policy := client.opt.Reconnect
+	policy.notify(StateDisconnected, cause)
+	for attempt := 0; ; attempt++ {
+		select {
+		case <-client.closed:
+			return
+		case <-time.After(policy.backoff(attempt)):
+		}
+		cc, err := client.redial()
+		if err != nil {
+			client.opt.logger().Debugf("rpc client: reconnect attempt %d failed: %v", attempt+1, err)
+			continue
+		}
+		client.sending.Lock()
+		client.mu.Lock()
+		if client.closing {
+			client.mu.Unlock()
+			client.sending.Unlock()
+			_ = cc.Close()
+			return
+		}
+		client.cc = cc
+		client.shutdown = false
+		client.closeErr = nil
+		client.goingAway = false
+		atomic.StoreInt64(&client.lastRecv, time.Now().UnixNano())
+		client.quit = make(chan struct{})
+		client.mu.Unlock()
+		client.sending.Unlock()
+
+		client.opt.logger().Infof("rpc client: reconnected after %d attempts", attempt+1)
+		client.start()
+		client.connected()
+		policy.notify(StateConnected, nil)
+		return
+	}
+}
+
+// DialMulti 按顺序尝试 addrs 中的地址，返回连接到第一个可用地址的 Client，连接断开之后自动切换到下一个地址，
+// 所有地址都连接失败时按照 Option.Reconnect 的退避时间再依次尝试，没有设置 Reconnect 时使用默认的退避时间。
+// 和自动重连一样，切换期间发起的调用会直接返回 ErrShutdown。
+// 只有一个主节点和少量备用节点时不需要 XClient 和 Discovery
+func DialMulti(network string, addrs []string, opts ...DialOption) (*Client, error) {
+	if len(addrs) == 0 {
+		return nil, errors.New("rpc client: DialMulti needs at least one address")
+	}
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if opt.Reconnect == nil {
+		opt.Reconnect = &ReconnectPolicy{}
+	}
+	next := 0 // 下一次从这个地址开始尝试，只在建立连接和重连的 goroutine 中访问
+	redial := func() (codec.Codec, error) {
+		var err error
+		for i := range addrs {
+			addr := addrs[(next+i)%len(addrs)]
+			var cc codec.Codec
+			if cc, err = dialCodec(handshake, network, addr, opt); err == nil {
+				next = (next + i + 1) % len(addrs)
+				opt.logger().Debugf("rpc client: connected to %s", addr)
+				return cc, nil
+			}
+			opt.logger().Debugf("rpc client: dial %s failed: %v", addr, err)
+		}
+		return nil, err
+	}
+	cc, err := redial()
+	if err != nil {
+		return nil, err
+	}
+	return newClientCodec(cc, opt, redial), nil
+}
@@ -0,0 +1,131 @@
+package geerpc
+
+import (
+	"context"
+	"geerpc/registry"
+	"net"
+	"sync"
+	"time"
+)
+
+// defaultRegistrationTTL 和注册中心默认的过期时间一致
+const defaultRegistrationTTL = 5 * time.Minute
+
+// registration 记录服务端自动注册的配置，第一次 Accept 时开始发送心跳
+type registration struct {
+	registry string
+	addr     string
+	interval time.Duration
+	once     sync.Once
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// EnableRegistration 让服务端在开始 Accept 之后自动注册到 registryAddr，并按照 ttl 的三分之一定期发送心跳，
+// 心跳失败之后在下一个周期继续重试，Shutdown 时停止心跳并注销。advertiseAddr 是客户端连接使用的地址，
+// 比如 tcp@10.0.0.1:9999，ttl 需要和注册中心的过期时间一致，为 0 时使用注册中心默认的 5 分钟，
+// 需要在 Accept 之前调用
+func (server *Server) EnableRegistration(registryAddr, advertiseAddr string, ttl time.Duration) {
+	if ttl <= 0 {
+		ttl = defaultRegistrationTTL
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	server.registration = &registration{
+		registry: registryAddr,
+		addr:     advertiseAddr,
+		interval: ttl / 3,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// startRegistration 开启了自动注册时启动心跳，多次 Accept 只会启动一次
+func (server *Server) startRegistration() {
+	server.mu.Lock()
+	r := server.registration
+	server.mu.Unlock()
+	if r != nil {
+		r.once.Do(func() { go server.heartbeat(r) })
+	}
+}
+
+func (server *Server) heartbeat(r *registration) {
+	defer close(r.done)
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+	for {
+		if err := registry.SendHeartbeat(r.registry, r.addr); err != nil {
+			server.logger.Errorf("rpc server: heartbeat to %s error: %v", r.registry, err)
+		}
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (server *Server) trackListener(lis net.Listener) bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.shutdown {
+		return false
+	}
+	if server.listeners == nil {
+		server.listeners = make(map[net.Listener]struct{})
+	}
+	server.listeners[lis] = struct{}{}
+	return true
+}
+
+func (server *Server) untrackListener(lis net.Listener) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	delete(server.listeners, lis)
+}
+
+func (server *Server) isShutdown() bool {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	return server.shutdown
+}
+
+// Shutdown 停止自动注册的心跳并从注册中心注销，然后关闭 Accept 使用的所有 listener，
+// 之后的 Accept 会直接返回。先注销再关闭 listener，客户端刷新服务列表之前仍然可以建立连接。
+// 已经建立的连接会收到 FlagGoAway，客户端不再在上面发起新的调用，已经发出的调用正常完成。ctx 用于限制注销的时间
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.mu.Lock()
+	if server.shutdown {
+		server.mu.Unlock()
+		return nil
+	}
+	server.shutdown = true
+	r := server.registration
+	server.mu.Unlock()
+
+	var err error
+	if r != nil {
+		r.once.Do(func() { close(r.done) }) // 还没有开始心跳时不会再启动，也不需要等待
+		close(r.stop)
+		<-r.done
+		errc := make(chan error, 1)
+		go func() { errc <- registry.Unregister(r.registry, r.addr) }()
+		select {
+		case err = <-errc:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	server.mu.Lock()
+	for lis := range server.listeners {
+		_ = lis.Close()
+	}
+	// 写入可能因为客户端不读取而阻塞，不等待发送完成
+	for cs := range server.conns {
+		go server.goAway(cs)
+	}
+	server.mu.Unlock()
+	return err
+}
@@ -1,14 +1,57 @@
 package registry
 
 import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// Logger 是注册中心输出日志使用的接口，geerpc.Logger 满足这个接口
+type Logger interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+type stdLogger struct{}
+
+func (stdLogger) Debugf(format string, v ...interface{}) { log.Printf(format, v...) }
+func (stdLogger) Infof(format string, v ...interface{})  { log.Printf(format, v...) }
+func (stdLogger) Errorf(format string, v ...interface{}) { log.Printf(format, v...) }
+
+type loggerHolder struct{ Logger }
+
+var logger atomic.Value // loggerHolder
+
+func init() {
+	logger.Store(loggerHolder{stdLogger{}})
+}
+
+// SetLogger 设置注册中心和心跳输出日志使用的 Logger，比如 registry.SetLogger(geerpc.DefaultLogger)，
+// 默认直接使用标准库的 log 输出，logger 为空时恢复默认
+func SetLogger(l Logger) {
+	if l == nil {
+		l = stdLogger{}
+	}
+	logger.Store(loggerHolder{l})
+}
+
+func currentLogger() Logger {
+	return logger.Load().(loggerHolder).Logger
+}
+
+// GeeRegistry 是一个简单的注册中心，服务端定期发送心跳，超过 timeout 没有收到心跳的服务会被删除，
+// 通过 HTTP（ServeHTTP）和 TCP（Serve）两种方式提供服务
 type GeeRegistry struct {
 	timeout time.Duration
 	mu      sync.Mutex
@@ -23,8 +66,16 @@ type ServerItem struct {
 const (
 	defaultPath    = "/_geerpc_/registry"
 	defaultTimeout = time.Minute * 5
+	listSuffix     = "/list"
+	tcpIdleTimeout = time.Minute
+	tcpPrefix      = "tcp@" // 以 tcp@ 开头的注册中心地址使用 TCP 协议，比如 tcp@localhost:9998
+	// requestTimeout 是客户端访问注册中心的超时时间，注册中心没有响应时不会一直阻塞
+	requestTimeout = 10 * time.Second
 )
 
+// httpClient 是访问 HTTP 注册中心使用的客户端
+var httpClient = &http.Client{Timeout: requestTimeout}
+
 func New(timeout time.Duration) *GeeRegistry {
 	return &GeeRegistry{
 		servers: make(map[string]*ServerItem),
@@ -45,6 +96,12 @@ func (r *GeeRegistry) putServer(addr string) {
 	}
 }
 
+func (r *GeeRegistry) removeServer(addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.servers, addr)
+}
+
 func (r *GeeRegistry) aliveServers() []string {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -72,43 +129,221 @@ func (r *GeeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		}
 		r.putServer(addr)
 		w.WriteHeader(http.StatusOK)
+	case "DELETE":
+		// 服务端正常退出时主动注销，不用等到超时
+		addr := req.Header.Get("X-Geerpc-Server")
+		if addr == "" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		r.removeServer(addr)
+		w.WriteHeader(http.StatusOK)
 	default:
 		w.WriteHeader(http.StatusMethodNotAllowed)
 	}
 }
 
+// AliveServer 是 ListHandler 返回的一个服务
+type AliveServer struct {
+	Addr      string    `json:"addr"`
+	Heartbeat time.Time `json:"heartbeat"` // 最近一次心跳的时间
+	ExpireAt  time.Time `json:"expireAt"`  // 超过这个时间没有心跳就会被删除，timeout 为 0 时为零值
+}
+
+// ListHandler 返回一个 http.Handler，以 JSON 的形式列出存活的服务以及它们的过期时间，方便查看注册中心的状态
+func (r *GeeRegistry) ListHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		alive := r.aliveServers()
+		list := make([]AliveServer, 0, len(alive))
+		r.mu.Lock()
+		for _, addr := range alive {
+			s, ok := r.servers[addr]
+			if !ok {
+				continue
+			}
+			item := AliveServer{Addr: addr, Heartbeat: s.start}
+			if r.timeout != 0 {
+				item.ExpireAt = s.start.Add(r.timeout)
+			}
+			list = append(list, item)
+		}
+		r.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(list)
+	})
+}
+
 func (r *GeeRegistry) HandleHTTP(registryPath string) {
 	http.Handle(registryPath, r)
-	log.Println("rpc registry path:", registryPath)
+	http.Handle(registryPath+listSuffix, r.ListHandler())
+	currentLogger().Infof("rpc registry path: %s", registryPath)
+}
+
+// TCP 协议每行一个命令，每个命令回复一行：
+//
+//	POST <addr>    发送心跳，回复 OK
+//	DELETE <addr>  注销服务，回复 OK
+//	GET            回复逗号分隔的存活服务
+//
+// 出错时回复 ERR <message>，一个连接上可以发送多个命令
+
+// Serve 在 lis 上接受 TCP 连接并处理注册中心的命令，lis 关闭时返回
+func (r *GeeRegistry) Serve(lis net.Listener) error {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go r.ServeConn(conn)
+	}
+}
+
+// ServeConn 处理一个 TCP 连接，连接空闲超过 tcpIdleTimeout 之后关闭
+func (r *GeeRegistry) ServeConn(conn net.Conn) {
+	defer func() { _ = conn.Close() }()
+	reader := bufio.NewReader(conn)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(tcpIdleTimeout))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err != io.EOF {
+				currentLogger().Errorf("rpc registry: read command error: %v", err)
+			}
+			return
+		}
+		if _, err := io.WriteString(conn, r.handleCommand(strings.TrimSpace(line))+"\n"); err != nil {
+			currentLogger().Errorf("rpc registry: write reply error: %v", err)
+			return
+		}
+	}
+}
+
+func (r *GeeRegistry) handleCommand(line string) string {
+	cmd, addr, _ := strings.Cut(line, " ")
+	addr = strings.TrimSpace(addr)
+	switch cmd {
+	case "GET":
+		return strings.Join(r.aliveServers(), ",")
+	case "POST", "DELETE":
+		if addr == "" {
+			return "ERR missing server address"
+		}
+		if cmd == "POST" {
+			r.putServer(addr)
+		} else {
+			r.removeServer(addr)
+		}
+		return "OK"
+	default:
+		return "ERR unknown command " + cmd
+	}
 }
 
 func HandleHTTP() {
 	DefaultGeeRegister.HandleHTTP(defaultPath)
 }
 
+// Heartbeat 立即向 registry 发送一次心跳，之后每隔 duration 发送一次，直到发送失败，
+// registry 可以是 HTTP 地址，也可以是 tcp@host:port
 func Heartbeat(registry, addr string, duration time.Duration) {
 	if duration == 0 {
 		duration = defaultTimeout - time.Duration(1)*time.Minute
 	}
 	var err error
-	err = sendHeartbeat(registry, addr)
+	err = SendHeartbeat(registry, addr)
 	go func() {
 		t := time.NewTicker(duration)
 		for err == nil {
 			<-t.C
-			err = sendHeartbeat(registry, addr)
+			err = SendHeartbeat(registry, addr)
 		}
 	}()
 }
 
-func sendHeartbeat(registry, addr string) error {
-	log.Println(addr, "send heart beat to registry", registry)
-	httpClient := &http.Client{}
-	req, _ := http.NewRequest("POST", registry, nil)
+// SendHeartbeat 向 registry 发送一次心跳，注册或者续期 addr
+func SendHeartbeat(registry, addr string) error {
+	currentLogger().Debugf("%s send heart beat to registry %s", addr, registry)
+	if err := send(registry, "POST", addr); err != nil {
+		currentLogger().Errorf("rpc server: heart beat err: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Unregister 从 registry 中删除 addr，服务端退出之前调用
+func Unregister(registry, addr string) error {
+	return send(registry, "DELETE", addr)
+}
+
+// Lookup 从 registry 获取存活的服务列表
+func Lookup(registry string) ([]string, error) {
+	var list string
+	if strings.HasPrefix(registry, tcpPrefix) {
+		reply, err := tcpCommand(strings.TrimPrefix(registry, tcpPrefix), "GET")
+		if err != nil {
+			return nil, err
+		}
+		list = reply
+	} else {
+		resp, err := httpClient.Get(registry)
+		if err != nil {
+			return nil, err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("rpc registry: unexpected status %s", resp.Status)
+		}
+		list = resp.Header.Get("X-Geerpc-Servers")
+	}
+	servers := make([]string, 0)
+	for _, server := range strings.Split(list, ",") {
+		if server = strings.TrimSpace(server); server != "" {
+			servers = append(servers, server)
+		}
+	}
+	return servers, nil
+}
+
+// send 发送 POST 或者 DELETE 请求
+func send(registry, method, addr string) error {
+	if strings.HasPrefix(registry, tcpPrefix) {
+		_, err := tcpCommand(strings.TrimPrefix(registry, tcpPrefix), method+" "+addr)
+		return err
+	}
+	req, _ := http.NewRequest(method, registry, nil)
 	req.Header.Set("X-Geerpc-Server", addr)
-	if _, err := httpClient.Do(req); err != nil {
-		log.Println("rpc server: heart beat err:", err)
+	resp, err := httpClient.Do(req)
+	if err != nil {
 		return err
 	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("rpc registry: unexpected status %s", resp.Status)
+	}
 	return nil
 }
+
+// tcpCommand 建立一个 TCP 连接发送一个命令并读取回复
+func tcpCommand(address, cmd string) (string, error) {
+	conn, err := net.DialTimeout("tcp", address, requestTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = conn.Close() }()
+	_ = conn.SetDeadline(time.Now().Add(requestTimeout))
+	if _, err := io.WriteString(conn, cmd+"\n"); err != nil {
+		return "", err
+	}
+	reply, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	reply = strings.TrimSpace(reply)
+	if strings.HasPrefix(reply, "ERR ") {
+		return "", errors.New("rpc registry: " + strings.TrimPrefix(reply, "ERR "))
+	}
+	return reply, nil
+}
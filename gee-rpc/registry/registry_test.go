@@ -0,0 +1,110 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGeeRegistry_HTTP(t *testing.T) {
+	r := New(100 * time.Millisecond)
+	mux := http.NewServeMux()
+	mux.Handle(defaultPath, r)
+	mux.Handle(defaultPath+listSuffix, r.ListHandler())
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	registry := ts.URL + defaultPath
+
+	for _, addr := range []string{"tcp@b:1", "tcp@a:1"} {
+		if err := SendHeartbeat(registry, addr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if servers, err := Lookup(registry); err != nil || !reflect.DeepEqual(servers, []string{"tcp@a:1", "tcp@b:1"}) {
+		t.Fatalf("expect both servers, got %v %v", servers, err)
+	}
+
+	resp, err := http.Get(ts.URL + defaultPath + listSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list []AliveServer
+	_ = json.NewDecoder(resp.Body).Decode(&list)
+	_ = resp.Body.Close()
+	if len(list) != 2 || list[0].Addr != "tcp@a:1" || !list[0].ExpireAt.Equal(list[0].Heartbeat.Add(100*time.Millisecond)) {
+		t.Fatalf("unexpected list %+v", list)
+	}
+
+	if err := Unregister(registry, "tcp@b:1"); err != nil {
+		t.Fatal(err)
+	}
+	if servers, _ := Lookup(registry); !reflect.DeepEqual(servers, []string{"tcp@a:1"}) {
+		t.Fatalf("expect tcp@a:1 after unregister, got %v", servers)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if servers, _ := Lookup(registry); len(servers) != 0 {
+		t.Fatalf("servers without heartbeat should expire, got %v", servers)
+	}
+}
+
+func TestGeeRegistry_TCP(t *testing.T) {
+	r := New(0)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go func() { _ = r.Serve(l) }()
+	registry := tcpPrefix + l.Addr().String()
+
+	if err := SendHeartbeat(registry, "tcp@a:1"); err != nil {
+		t.Fatal(err)
+	}
+	if servers, err := Lookup(registry); err != nil || !reflect.DeepEqual(servers, []string{"tcp@a:1"}) {
+		t.Fatalf("expect tcp@a:1, got %v %v", servers, err)
+	}
+	if err := Unregister(registry, "tcp@a:1"); err != nil {
+		t.Fatal(err)
+	}
+	if servers, _ := Lookup(registry); len(servers) != 0 {
+		t.Fatalf("expect no servers after unregister, got %v", servers)
+	}
+	if _, err := tcpCommand(l.Addr().String(), "POST"); err == nil {
+		t.Fatal("expect an error when the address is missing")
+	}
+}
+
+type recordLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *recordLogger) logf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func (l *recordLogger) Debugf(format string, v ...interface{}) { l.logf(format, v...) }
+func (l *recordLogger) Infof(format string, v ...interface{})  { l.logf(format, v...) }
+func (l *recordLogger) Errorf(format string, v ...interface{}) { l.logf(format, v...) }
+
+func TestSetLogger(t *testing.T) {
+	logger := &recordLogger{}
+	SetLogger(logger)
+	defer SetLogger(nil)
+
+	if err := SendHeartbeat("tcp@127.0.0.1:1", "tcp@a:1"); err == nil {
+		t.Fatal("expect an error for an unreachable registry")
+	}
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	if len(logger.lines) != 2 || !strings.Contains(logger.lines[1], "heart beat err") {
+		t.Fatalf("logs should go through the Logger, got %v", logger.lines)
+	}
+}
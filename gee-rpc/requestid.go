@@ -0,0 +1,86 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"geerpc/codec"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// RequestIDKey 是 Metadata 中请求 ID 使用的 key，客户端为每个 Call 生成一个，也可以通过 WithMetadata 指定，
+// 服务端处理这个请求时输出的日志都带有请求 ID，并且在回复的 Header 中原样返回，方便关联两边的日志
+const RequestIDKey = "x-request-id"
+
+var (
+	requestIDPrefix = newRequestIDPrefix()
+	requestIDSeq    uint64
+)
+
+func newRequestIDPrefix() string {
+	b := make([]byte, 6)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b) + "-"
+}
+
+// newRequestID 返回进程内唯一的请求 ID，格式为随机前缀加上自增序号，生成时不需要每次读取随机数
+func newRequestID() string {
+	return requestIDPrefix + strconv.FormatUint(atomic.AddUint64(&requestIDSeq, 1), 10)
+}
+
+// ensureRequestID 请求中没有请求 ID 时生成一个，老版本的客户端不会发送
+func ensureRequestID(h *codec.Header) {
+	if h.Metadata[RequestIDKey] != "" {
+		return
+	}
+	if h.Metadata == nil {
+		h.Metadata = make(map[string]string, 1)
+	}
+	h.Metadata[RequestIDKey] = newRequestID()
+}
+
+// RequestIDFromContext 在服务方法和中间件中返回正在处理的请求的 ID
+func RequestIDFromContext(ctx context.Context) string {
+	return IncomingMetadata(ctx)[RequestIDKey]
+}
+
+type loggerKey struct{}
+
+// LoggerFromContext 在服务方法和中间件中返回服务端的 Logger，输出的每行日志前面都带有请求 ID
+func LoggerFromContext(ctx context.Context) Logger {
+	logger, ok := ctx.Value(loggerKey{}).(Logger)
+	if !ok {
+		logger = DefaultLogger
+	}
+	return withRequestID(logger, RequestIDFromContext(ctx))
+}
+
+// requestLogger 在日志前面加上请求 ID
+type requestLogger struct {
+	Logger
+	prefix string
+}
+
+func withRequestID(logger Logger, id string) Logger {
+	if id == "" {
+		return logger
+	}
+	return requestLogger{Logger: logger, prefix: "[" + strings.ReplaceAll(id, "%", "%%") + "] "}
+}
+
+func (l requestLogger) Debugf(format string, v ...interface{}) {
+	l.Logger.Debugf(l.prefix+format, v...)
+}
+
+func (l requestLogger) Infof(format string, v ...interface{}) { l.Logger.Infof(l.prefix+format, v...) }
+
+func (l requestLogger) Errorf(format string, v ...interface{}) {
+	l.Logger.Errorf(l.prefix+format, v...)
+}
+
+// requestLogger 返回处理 h 对应的请求时使用的 Logger
+func (server *Server) requestLogger(h *codec.Header) Logger {
+	return withRequestID(server.logger, h.Metadata[RequestIDKey])
+}
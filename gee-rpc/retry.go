@@ -0,0 +1,93 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// RetryPolicy 设置在 Option.Retry 中之后，Client.Call 和 XClient.Call 在遇到临时错误时自动重试，
+// 重试可能导致服务端执行多次，所以只有在 Idempotent 中标记为幂等的方法才会重试
+type RetryPolicy struct {
+	MaxAttempts int           // 包括第一次在内最多调用多少次，默认 3 次
+	MinBackoff  time.Duration // 第一次重试之前等待的时间，默认 10ms
+	MaxBackoff  time.Duration // 每次重试之后等待时间翻倍，最多等待这么久，默认 1s
+	// Idempotent 幂等的方法，格式为 "Service.Method"，也可以是 "Service" 表示这个服务的所有方法
+	Idempotent []string
+	// Retryable 判断错误是否可以重试，为空时使用 IsTransient
+	Retryable func(err error) bool
+}
+
+func (p *RetryPolicy) idempotent(serviceMethod string) bool {
+	for _, name := range p.Idempotent {
+		if name == serviceMethod || strings.HasPrefix(serviceMethod, name+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *RetryPolicy) retryable(err error) bool {
+	if p.Retryable != nil {
+		return p.Retryable(err)
+	}
+	return IsTransient(err)
+}
+
+// Do 按照重试策略执行 f，p 为空或者方法没有标记为幂等时只执行一次，ctx 结束之后不再重试
+func (p *RetryPolicy) Do(ctx context.Context, serviceMethod string, f func() error) error {
+	if p == nil || !p.idempotent(serviceMethod) {
+		return f()
+	}
+	maxAttempts, min, max := p.MaxAttempts, p.MinBackoff, p.MaxBackoff
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+	if min <= 0 {
+		min = 10 * time.Millisecond
+	}
+	if max <= 0 {
+		max = time.Second
+	}
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= maxAttempts || !p.retryable(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(jitterBackoff(min, max, attempt-1)):
+		}
+	}
+}
+
+// transientErrors 服务端过载时返回的错误，老版本的服务端不发送错误码，客户端只能比较错误信息
+var transientErrors = []error{ErrServerBusy, ErrRateLimited, ErrTooManyPendingRequests, ErrTooManyConnections}
+
+// IsTransient 判断是否是连接断开、服务端过载这类重试之后可能成功的错误，
+// 熔断器打开也算在内，XClient 重试时会换一个服务实例
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrShutdown) || errors.Is(err, ErrBreakerOpen) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	if CodeOf(err) == CodeUnavailable {
+		return true
+	}
+	for _, e := range transientErrors {
+		if err.Error() == e.Error() {
+			return true
+		}
+	}
+	return false
+}
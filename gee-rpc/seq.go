@@ -0,0 +1,83 @@
+package geerpc
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+)
+
+// SeqStrategy 决定客户端生成请求序列号的方式，在握手时发送给服务端，服务端据此检查重复的序列号
+type SeqStrategy int
+
+const (
+	// SeqMonotonic 序列号从 1 开始递增，到达最大值之后回到 1，服务端要求序列号递增，回退的请求被当作重放拒绝
+	SeqMonotonic SeqStrategy = iota
+	// SeqRandom 使用随机的 64 位序列号，服务端不再要求递增，只拒绝和还没有回复的请求重复的序列号，
+	// 适合不希望序列号泄露请求数量的场景
+	SeqRandom
+)
+
+// SeqGenerator 为一个 Client 生成请求的序列号，只会在持有 Client 的锁时调用，不需要考虑并发，
+// 0 保留给心跳，返回 0 时会重新生成。生成的序列号需要符合 Option.SeqStrategy 的要求
+type SeqGenerator interface {
+	Next() uint64
+}
+
+// ErrSeqCollision 连续多次生成的序列号都和还没有回复的请求重复
+var ErrSeqCollision = errors.New("rpc client: seq collides with pending calls")
+
+// maxSeqAttempts 生成的序列号和未完成的请求重复时最多重新生成的次数
+const maxSeqAttempts = 8
+
+// monotonicSeq 从 1 开始递增，跳过 0
+type monotonicSeq struct {
+	next uint64
+}
+
+func (s *monotonicSeq) Next() uint64 {
+	s.next++
+	if s.next == 0 {
+		s.next = 1 // 回绕之后跳过 0
+	}
+	return s.next
+}
+
+// randomSeq 生成随机的序列号
+type randomSeq struct{}
+
+func (randomSeq) Next() uint64 {
+	return rand.Uint64()
+}
+
+// newSeqGenerator 返回 opt 对应的序列号生成器
+func (opt *Option) newSeqGenerator() SeqGenerator {
+	if opt.NewSeqGenerator != nil {
+		return opt.NewSeqGenerator()
+	}
+	if opt.SeqStrategy == SeqRandom {
+		return randomSeq{}
+	}
+	return &monotonicSeq{}
+}
+
+// nextSeq 生成一个和未完成的请求不重复的序列号，调用方需要持有 client.mu
+func (client *Client) nextSeq() (uint64, error) {
+	for i := 0; i < maxSeqAttempts; i++ {
+		seq := client.seqGen.Next()
+		if seq == 0 {
+			continue
+		}
+		if _, ok := client.pending[seq]; ok {
+			client.opt.logger().Errorf("rpc client: seq %d collides with a pending call", seq)
+			continue
+		}
+		return seq, nil
+	}
+	return 0, ErrSeqCollision
+}
+
+// seqWrapped 判断 seq 是不是递增的序列号到达最大值之后回到了开头，
+// 上一个序列号在最高的四分之一，新的序列号在最低的四分之一时认为发生了回绕
+func seqWrapped(last, seq uint64) bool {
+	return last > math.MaxUint64/4*3 && seq < math.MaxUint64/4
+}
@@ -0,0 +1,109 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// ServeOption 设置 ListenAndServe 和 ListenAndServeHTTP 的行为
+type ServeOption func(*serveOptions)
+
+type serveOptions struct {
+	server    *Server
+	ctx       context.Context
+	tlsConfig *tls.Config
+}
+
+// WithServer 指定提供服务的 Server，默认为 DefaultServer
+func WithServer(server *Server) ServeOption {
+	return func(o *serveOptions) { o.server = server }
+}
+
+// WithContext ctx 结束之后停止监听，ListenAndServe 和 ListenAndServeHTTP 返回 nil
+func WithContext(ctx context.Context) ServeOption {
+	return func(o *serveOptions) { o.ctx = ctx }
+}
+
+// WithTLSConfig 在监听的端口上使用 TLS，需要校验客户端证书时在 config 中设置 ClientAuth 和 ClientCAs
+func WithTLSConfig(config *tls.Config) ServeOption {
+	return func(o *serveOptions) { o.tlsConfig = config }
+}
+
+func newServeOptions(opts []ServeOption) *serveOptions {
+	o := &serveOptions{server: DefaultServer, ctx: context.Background()}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// listen 监听 addr，格式和 XDial 相同为 protocol@addr，比如 tcp@:9999、unix@/tmp/geerpc.sock，
+// 省略 protocol 时使用 tcp，热重启的新进程会使用继承的 listener
+func (o *serveOptions) listen(addr string) (net.Listener, error) {
+	network := "tcp"
+	if parts := strings.SplitN(addr, "@", 2); len(parts) == 2 {
+		network, addr = parts[0], parts[1]
+	}
+	if network == "tls" {
+		// 和 XDial 的 tls@addr 对应，需要同时通过 WithTLSConfig 设置证书
+		if o.tlsConfig == nil {
+			return nil, errors.New("rpc server: tls@" + addr + " requires WithTLSConfig")
+		}
+		network = "tcp"
+	}
+	lis, err := Listen(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	if o.tlsConfig != nil {
+		lis = tls.NewListener(lis, o.tlsConfig)
+	}
+	return lis, nil
+}
+
+// ListenAndServe 监听 addr 并提供 RPC 服务，直到 WithContext 设置的 ctx 结束或者 Server.Shutdown，
+// 客户端使用 XDial("tcp@addr") 连接
+func ListenAndServe(addr string, opts ...ServeOption) error {
+	o := newServeOptions(opts)
+	lis, err := o.listen(addr)
+	if err != nil {
+		return err
+	}
+	return o.server.Serve(o.ctx, lis)
+}
+
+// ListenAndServeHTTP 监听 addr 并通过 HTTP 提供 RPC 服务，同时支持 HTTP CONNECT 和 h2c，
+// 路径为默认的 RPC 和调试页面路径，客户端使用 XDial("http@addr") 或者 XDial("h2c@addr") 连接。
+// 和 ListenAndServe 一样，Server.Shutdown 也会关闭这里的 listener
+func ListenAndServeHTTP(addr string, opts ...ServeOption) error {
+	o := newServeOptions(opts)
+	lis, err := o.listen(addr)
+	if err != nil {
+		return err
+	}
+	server := o.server
+	if !server.trackListener(lis) {
+		_ = lis.Close()
+		return nil
+	}
+	defer server.untrackListener(lis)
+	server.startRegistration()
+
+	mux := http.NewServeMux()
+	server.HandleHTTPOn(mux, defaultRPCPath, defaultDebugPath)
+	hs := &http.Server{Handler: h2c.NewHandler(mux, &http2.Server{})}
+	stop := context.AfterFunc(o.ctx, func() { _ = hs.Close() })
+	defer stop()
+	err = hs.Serve(lis)
+	if o.ctx.Err() != nil || server.isShutdown() || errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	return err
+}
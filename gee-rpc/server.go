@@ -1,17 +1,22 @@
 package geerpc
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"geerpc/codec"
 	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"reflect"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -27,6 +32,78 @@ type Option struct {
 	CodecType      codec.Type
 	ConnectTimeout time.Duration
 	HandleTimeout  time.Duration
+	// TLSConfig 不为空时，客户端在 Dial 的时候使用 TLS 加密连接，只在本地生效，不参与 Option 的交换
+	TLSConfig *tls.Config `json:"-"`
+	// Logger 客户端输出日志使用的 Logger，为空时使用 DefaultLogger
+	Logger Logger `json:"-"`
+	// AuthToken 在握手时发送给服务端，由 Server.AuthFunc 校验，设置之后 Dial 会等待服务端的鉴权结果
+	AuthToken string
+	// SeqStrategy 客户端生成序列号的方式，服务端据此检查重复的序列号，默认从 1 开始递增
+	SeqStrategy SeqStrategy `json:",omitempty"`
+	// NewSeqGenerator 不为空时每个 Client 使用它创建的生成器生成序列号，生成的序列号需要符合 SeqStrategy，只在本地生效
+	NewSeqGenerator func() SeqGenerator `json:"-"`
+	// Namespace 不为空时连接由服务端通过 Server.Mount 挂载在这个名字下的 Server 处理，
+	// 设置之后 Dial 会等待服务端的握手结果，命名空间不存在时 Dial 返回错误
+	Namespace string `json:",omitempty"`
+	// ChunkedReplies 表示客户端可以接收分块发送的回复，参考 Server.ChunkSize，Dial 时自动设置
+	ChunkedReplies bool `json:",omitempty"`
+	// HeartbeatInterval 大于 0 时客户端每隔这么久发送一次心跳，服务端回复之后客户端就知道连接还是通的
+	HeartbeatInterval time.Duration
+	// IdleTimeout 开启心跳之后，双方超过这么久没有收到任何数据就关闭连接，默认为 3 倍的 HeartbeatInterval
+	IdleTimeout time.Duration
+	// Reconnect 不为空时 Dial 创建的客户端在连接断开之后自动重连，只在本地生效
+	Reconnect *ReconnectPolicy `json:"-"`
+	// OnConnected 在 Client 建立连接并完成握手之后调用，自动重连成功之后也会调用，只在本地生效
+	OnConnected func(client *Client) `json:"-"`
+	// OnError 在连接因为错误断开时调用，err 为断开的原因，调用时未完成的 Call 都已经返回了错误，
+	// 开启了自动重连时之后会开始重连，只在本地生效
+	OnError func(client *Client, err error) `json:"-"`
+	// OnClosed 在用户调用 Client.Close 之后调用一次，只在本地生效
+	OnClosed func(client *Client) `json:"-"`
+	// Retry 不为空时 Call 遇到临时错误会按照策略重试，只在本地生效
+	Retry *RetryPolicy `json:"-"`
+	// MaxPendingCalls 大于 0 时限制一个 Client 同时等待回复的请求数，达到之后新的调用阻塞到有请求完成或者 ctx 结束，
+	// 避免服务端变慢时请求在客户端无限堆积，只在本地生效
+	MaxPendingCalls int `json:"-"`
+	// FailFastOnMaxPending 为 true 时达到 MaxPendingCalls 之后新的调用直接返回 ErrTooManyPending，不再等待
+	FailFastOnMaxPending bool `json:"-"`
+	// Breaker 不为空时每个 Client 使用一个熔断器，后端频繁出错时快速失败，只在本地生效
+	Breaker *BreakerPolicy `json:"-"`
+	// RPCPath 是 DialHTTP 和 DialH2C 请求的路径，需要和服务端 HandleHTTPOn 的 rpcPath 一致，为空时使用默认路径
+	RPCPath string `json:"-"`
+	// Proxy 不为空时先通过 HTTP 代理的 CONNECT 隧道连接到服务端，用户信息会作为 Proxy-Authorization 发送，
+	// ProxyHeader 是 CONNECT 请求中额外携带的头部，都只在本地生效
+	Proxy       *url.URL    `json:"-"`
+	ProxyHeader http.Header `json:"-"`
+	// TCP 不为空时调整 TCP 连接的参数，只在本地生效，参考 TCPOptions
+	TCP *TCPOptions `json:"-"`
+	// CodecDebug 不为空时统计编解码的耗时，并可以输出每一帧的内容，只在本地生效，参考 CodecDebug
+	CodecDebug *CodecDebug `json:"-"`
+}
+
+// idleTimeout 返回开启心跳时的空闲超时时间，没有开启心跳时返回 0
+func (opt *Option) idleTimeout() time.Duration {
+	if opt.HeartbeatInterval <= 0 {
+		return 0
+	}
+	if opt.IdleTimeout > 0 {
+		return opt.IdleTimeout
+	}
+	return 3 * opt.HeartbeatInterval
+}
+
+func (opt *Option) rpcPath() string {
+	if opt.RPCPath == "" {
+		return defaultRPCPath
+	}
+	return opt.RPCPath
+}
+
+func (opt *Option) logger() Logger {
+	if opt.Logger == nil {
+		return DefaultLogger
+	}
+	return opt.Logger
 }
 
 var DefaultOption = &Option{
@@ -36,20 +113,204 @@ var DefaultOption = &Option{
 }
 
 type Server struct {
-	serviceMap sync.Map
+	serviceMap  sync.Map
+	registerMu  sync.Mutex // RegisterFunc 需要先读取再替换服务，和其他注册、Unregister、ReplaceService 互斥
+	middlewares []Middleware
+	logger      Logger
+	bytesIn     uint64 // 从客户端读取的字节数
+	bytesOut    uint64 // 发送给客户端的字节数
+
+	// AuthFunc 不为空时，每个连接在交换完 Option 之后都需要先通过鉴权才能调用服务
+	AuthFunc AuthFunc
+	// IdentityFunc 不为空时在连接通过鉴权之后调用，把客户端的 token 转换成调用方的身份，比如 API key 对应的用户名，
+	// 结果保存在 ConnInfo.Identity 中。为空时身份为空字符串，token 是密钥，不会作为身份保存或者传给 Authorize
+	IdentityFunc func(token string) string
+	// Authorize 不为空时在找到服务方法之后、执行中间件和服务方法之前调用，identity 为 ConnInfo.Identity，
+	// 也可以通过 IncomingMetadata(ctx) 读取请求的 Metadata，返回错误时拒绝这次调用，
+	// 错误不是 *Error 时客户端收到的错误码为 CodePermissionDenied
+	Authorize func(ctx context.Context, identity, serviceMethod string) error
+	// OnConnect 不为空时在每个新连接开始握手之前调用，返回错误时直接关闭连接，可以用来限制单个 IP 的连接数
+	OnConnect func(info *ConnInfo) error
+	// OnDisconnect 在 OnConnect 接受的连接结束、所有请求都处理完成之后调用
+	OnDisconnect func(info *ConnInfo)
+	// ConnRateLimiter 不为空时，为每个连接创建一个独立的限流器
+	ConnRateLimiter func() RateLimiter
+	limiters        sync.Map // 服务和方法的限流器，参考 SetRateLimiter
+	caches          sync.Map // 方法的响应缓存，参考 SetCache
+	// IdempotencyTTL 带有 IdempotencyKey 的请求的结果在服务端保存的时间，默认 5 分钟
+	IdempotencyTTL time.Duration
+	idempotency    idempotencyStore
+
+	// IPFilter 不为空时只接受规则允许的地址发起的连接
+	IPFilter *IPFilter
+	// MaxConnections 最大连接数，超过之后新的连接会被直接关闭，0 表示不限制
+	MaxConnections int
+	// MaxPendingRequestsPerConn 单个连接上同时处理的最大请求数，达到之后暂停读取新的请求，0 表示不限制，
+	// 流式调用需要这个连接继续读取后续的帧，不计入这个限制
+	MaxPendingRequestsPerConn int
+	// OnOverload 在拒绝连接或者暂停读取请求的时候调用，用于观察服务端的过载情况
+	OnOverload  func(err error)
+	activeConns int64
+	// WorkerPool 不为空时，请求提交到工作池中执行，而不是每个请求启动一个 goroutine，流式调用除外
+	WorkerPool  *WorkerPool
+	workerPools sync.Map // 服务和方法独立的工作池，参考 SetWorkerPool
+	// SlowCallThreshold 大于 0 时，服务方法执行超过这么久的调用会输出一行日志，记录方法、耗时、对端地址和 Seq，
+	// 并计入 MethodStats.SlowCalls，不需要完整的链路追踪也能发现变慢的方法
+	SlowCallThreshold time.Duration
+	// HandleTimeout 客户端没有在 Option 中设置 HandleTimeout 时使用的处理超时时间，0 表示不限制
+	HandleTimeout time.Duration
+	timeouts      sync.Map // 服务和方法的处理超时时间，参考 SetTimeout
+	sizeLimits    sync.Map // 服务和方法的请求和回复大小限制，参考 SetSizeLimit
+	namespaces    sync.Map // 挂载的 Server，参考 Mount
+	// HandshakeTimeout 建立连接之后完成 TLS 握手、Option 交换和鉴权的最长时间，默认 10s
+	HandshakeTimeout time.Duration
+	// ReadTimeout 读取一帧数据的最长时间，从开始等待下一帧算起，超时之后关闭连接，
+	// 所以空闲的连接也会在 ReadTimeout 之后被关闭，客户端可以开启心跳保持连接，0 表示不限制
+	ReadTimeout time.Duration
+	// IdleTimeout 连接上没有正在处理的请求并且超过这么久没有收到任何数据时关闭连接，心跳也算作数据，
+	// 和 ReadTimeout 不同，执行时间很长的请求不会让连接被关闭，0 表示不限制
+	IdleTimeout time.Duration
+	// WriteTimeout 向连接写入一帧数据的最长时间，客户端不读取数据导致写入阻塞时关闭连接，0 表示不限制
+	WriteTimeout time.Duration
+	// MaxHTTPBody 是 JSON-RPC 和网关请求 body 的最大字节数，超过之后返回 413，默认 4MB
+	MaxHTTPBody int64
+	// TCP 不为空时在接受连接之后调整 TCP 连接的参数，参考 TCPOptions
+	TCP *TCPOptions
+	// Coalesce 不为空时合并多个回复的写入，参考 CoalescePolicy
+	Coalesce *CoalescePolicy
+	// ChunkSize 大于 0 时，编码之后超过 ChunkSize 字节的回复被拆成多个 ChunkSize 大小的帧发送，
+	// 每一帧单独占用发送锁，其他回复可以插在中间发送，一个很大的回复不会让同一个连接上的其他调用一直等待。
+	// 开启之后每个回复都需要先编码一次来判断大小，只对实现了 codec.BodyMarshaler 的编解码器
+	// 和声明了 Option.ChunkedReplies 的客户端生效，流式调用的帧不会被拆分
+	ChunkSize int
+	// CodecDebug 不为空时统计每个连接编解码的耗时，并可以输出每一帧的内容，参考 CodecDebug
+	CodecDebug *CodecDebug
+	// ReuseArgv 为 true 时复用请求参数的内存，开启之后服务方法不能在返回之后继续持有参数
+	ReuseArgv   bool
+	headerPool  countingPool
+	requestPool countingPool
+
+	mu           sync.Mutex
+	listeners    map[net.Listener]struct{} // Accept 正在使用的 listener，Shutdown 时关闭
+	conns        map[*connState]struct{}   // 正在处理请求的连接，Drain 时关闭
+	shutdown     bool
+	registration *registration
 }
 
-func NewServer() *Server {
-	return &Server{}
+// NewServer 创建 Server，opts 中对服务端有效的只有 WithLogger、WithConnectTimeout、WithHandleTimeout、WithTCPOptions
+// 和 WithCodecDebug，分别设置 Logger、HandshakeTimeout、HandleTimeout、TCP 和 CodecDebug，其他的设置会被忽略，设置冲突时 panic
+func NewServer(opts ...OptionFunc) *Server {
+	server := &Server{logger: DefaultLogger}
+	s := newOptionSetter()
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			log.Panic(err)
+		}
+	}
+	s.applyServer(server)
+	server.serviceMap.Store(HealthServiceName, newHealthService(server))
+	return server
+}
+
+// SetLogger 替换服务端使用的 Logger，需要在 Accept 之前调用
+func (server *Server) SetLogger(logger Logger) {
+	server.logger = logger
 }
 
 var DefaultServer = NewServer()
 
 func (server *Server) Register(rcvr interface{}) error {
-	s := newService(rcvr)
+	s, err := newService(rcvr)
+	if err != nil {
+		return err
+	}
+	return server.register(s)
+}
+
+// RegisterName 与 Register 相同，但是使用 name 作为服务名，而不是结构体的名称，
+// 同一个类型的多个实例，或者同一个实例的多个别名，可以分别注册在不同的名字下
+func (server *Server) RegisterName(name string, rcvr interface{}) error {
+	s, err := newNamedService(rcvr, name)
+	if err != nil {
+		return err
+	}
+	return server.register(s)
+}
+
+// RegisterWithMethods 与 Register 相同，但是只公开 methods 中列出的方法，
+// 一个类型有很多符合签名的导出方法，而其中只有一部分可以安全地对外提供时使用
+func (server *Server) RegisterWithMethods(rcvr interface{}, methods ...string) error {
+	s, err := newService(rcvr)
+	if err != nil {
+		return err
+	}
+	if err := s.only(methods); err != nil {
+		return err
+	}
+	return server.register(s)
+}
+
+// RegisterFunc 把函数注册为 serviceMethod 对应的方法，格式为 "Service.Method"，
+// 函数的签名和方法一样，比如 func(args Args, reply *int) error，可以带有 context.Context，
+// 同一个服务名下可以注册多个函数，但是不能和 Register 注册的服务同名
+func (server *Server) RegisterFunc(serviceMethod string, fn interface{}) error {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot <= 0 || dot == len(serviceMethod)-1 {
+		return errors.New("rpc server: service/method ill-formed: " + serviceMethod)
+	}
+	name, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
+	server.registerMu.Lock()
+	defer server.registerMu.Unlock()
+	var base *service
+	if svci, ok := server.serviceMap.Load(name); ok {
+		base = svci.(*service)
+	}
+	s, err := newFuncService(base, name, methodName, fn)
+	if err != nil {
+		return err
+	}
+	server.serviceMap.Store(name, s)
+	server.logger.Infof("rpc server: register %s", serviceMethod)
+	return nil
+}
+
+func (server *Server) register(s *service) error {
+	server.registerMu.Lock()
+	defer server.registerMu.Unlock()
 	if _, dup := server.serviceMap.LoadOrStore(s.name, s); dup {
 		return errors.New("rpc: service already defined" + s.name)
 	}
+	for _, name := range s.methodNames() {
+		server.logger.Infof("rpc server: reigster %s.%s", s.name, name)
+	}
+	return nil
+}
+
+// Unregister 删除一个服务，之后的请求会返回找不到服务的错误，
+// 已经开始处理的请求持有原来的 service，会正常完成并回复
+func (server *Server) Unregister(name string) error {
+	server.registerMu.Lock()
+	defer server.registerMu.Unlock()
+	if _, ok := server.serviceMap.LoadAndDelete(name); !ok {
+		return errors.New("rpc server: can't find service " + name)
+	}
+	server.logger.Infof("rpc server: unregister %s", name)
+	return nil
+}
+
+// ReplaceService 用 rcvr 替换同名的服务，服务不存在时直接注册，
+// 替换之后的请求由新的服务处理，已经开始处理的请求仍然由原来的服务完成
+func (server *Server) ReplaceService(rcvr interface{}) error {
+	s, err := newService(rcvr)
+	if err != nil {
+		return err
+	}
+	server.registerMu.Lock()
+	defer server.registerMu.Unlock()
+	server.serviceMap.Store(s.name, s)
+	for _, name := range s.methodNames() {
+		server.logger.Infof("rpc server: replace %s.%s", s.name, name)
+	}
 	return nil
 }
 
@@ -57,19 +318,19 @@ func (server *Server) Register(rcvr interface{}) error {
 func (server *Server) findService(serviceMethod string) (svc *service, mtype *methodType, err error) {
 	dot := strings.LastIndex(serviceMethod, ".")
 	if dot < 0 {
-		err = errors.New("rpc server: service/method request ill-formed:" + serviceMethod)
+		err = NewError(CodeNotFound, "rpc server: service/method request ill-formed:"+serviceMethod)
 		return
 	}
 	serviceName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
 	svci, ok := server.serviceMap.Load(serviceName)
 	if !ok {
-		err = errors.New("rpc server: can't find service " + serviceName)
+		err = NewError(CodeNotFound, "rpc server: can't find service "+serviceName)
 		return
 	}
 	svc = svci.(*service)
 	mtype = svc.method[methodName]
 	if mtype == nil {
-		err = errors.New("rpc server: can't find method " + methodName)
+		err = NewError(CodeNotFound, "rpc server: can't find method "+methodName)
 	}
 	return
 }
@@ -81,49 +342,264 @@ func (server *Server) findService(serviceMethod string) (svc *service, mtype *me
 // | Option | Header1 | Body1 | Header2 | Body2 | ...
 func (server *Server) ServerConn(conn io.ReadWriteCloser) {
 	defer func() { _ = conn.Close() }()
-	// 在连接开始的时候协商通信协议信息
+	info := newConnInfo(conn)
+	if server.OnConnect != nil {
+		if err := server.OnConnect(info); err != nil {
+			server.logger.Errorf("rpc server: reject connection from %s: %v", info.RemoteAddr, err)
+			return
+		}
+	}
+	if server.OnDisconnect != nil {
+		defer server.OnDisconnect(info)
+	}
+	// 只有 net.Conn 才能设置读写的截止时间，握手阶段使用单独的超时时间，
+	// 避免端口扫描这类建立连接之后什么都不发送的对端一直占用连接
+	nc, _ := conn.(net.Conn)
+	if nc != nil {
+		_ = nc.SetDeadline(time.Now().Add(server.handshakeTimeout()))
+	}
+	// TLS 连接需要先完成握手，这样客户端证书校验失败时能够尽早断开并打印原因
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			server.logger.Errorf("rpc server: tls handshake error from %s: %v", remoteAddr(conn), err)
+			return
+		}
+		state := tlsConn.ConnectionState()
+		info.TLS = &state
+	}
+	// 在连接开始的时候协商通信协议信息，Option 由 json.Encoder 编码，第一个字节一定是 '{'，
+	// 不是的话说明对端不是 geerpc 的客户端，直接关闭连接，不再继续读取
+	first := make([]byte, 1)
+	if _, err := io.ReadFull(conn, first); err != nil {
+		if err != io.EOF {
+			server.logger.Errorf("rpc server: options error from %s: %v", remoteAddr(conn), err)
+		}
+		return
+	}
+	if first[0] != '{' {
+		server.rejectHandshake(conn, first[0])
+		return
+	}
 	var opt Option
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
-		log.Println("rpc server: options error:", err)
+	dec := json.NewDecoder(io.MultiReader(bytes.NewReader(first), io.LimitReader(conn, maxOptionSize)))
+	if err := dec.Decode(&opt); err != nil {
+		server.logger.Errorf("rpc server: options error from %s: %v", remoteAddr(conn), err)
 		return
 	}
 	if opt.MagicNumber != MagicNumber {
-		log.Printf("rpc server: invalid magic number %x\n", opt.MagicNumber)
+		server.logger.Errorf("rpc server: invalid magic number %x from %s", opt.MagicNumber, remoteAddr(conn))
 		return
 	}
 	f := codec.NewCodecFuncMap[opt.CodecType]
 	if f == nil {
-		log.Printf("rpc server: not supporting codec type %s\n", opt.CodecType)
+		server.logger.Errorf("rpc server: not supporting codec type %s from %s", opt.CodecType, remoteAddr(conn))
+		return
+	}
+	// 之后的握手和请求都由命名空间对应的 Server 处理，命名空间不存在时由当前 Server 回复错误
+	target, nsErr := server.namespace(opt.Namespace)
+	// json.Decoder 自带缓冲，可能已经把 Option 之后的 Header 读了进去，需要把这部分数据还给编解码器
+	// 另外 json.Encoder 会在 Option 之后写入一个换行符，需要跳过
+	br := bufio.NewReader(io.MultiReader(dec.Buffered(), conn))
+	if b, err := br.Peek(1); err == nil && b[0] == '\n' {
+		_, _ = br.Discard(1)
+	}
+	var w io.WriteCloser = conn
+	if nc != nil && target.WriteTimeout > 0 {
+		w = &deadlineWriter{Conn: nc, timeout: target.WriteTimeout}
+	}
+	cc := f(&handshakeConn{
+		Reader:      &countingReader{Reader: br, n: &target.bytesIn},
+		WriteCloser: &countingWriter{WriteCloser: w, n: &target.bytesOut},
+	})
+	if nsErr != nil {
+		server.logger.Errorf("%v from %s", nsErr, remoteAddr(conn))
+		h := &codec.Header{}
+		setError(h, nsErr)
+		_ = cc.Write(h, invalidRequest)
 		return
 	}
-	server.serveCodec(f(conn), &opt)
+	if opt.AuthToken != "" || opt.Namespace != "" || target.AuthFunc != nil {
+		if err := target.authenticate(cc, &opt, conn); err != nil {
+			target.logger.Errorf("rpc server: auth error: %v", err)
+			return
+		}
+	}
+	info.Namespace = opt.Namespace
+	if target.IdentityFunc != nil {
+		info.Identity = target.IdentityFunc(opt.AuthToken)
+	}
+	if nc != nil {
+		_ = nc.SetDeadline(time.Time{}) // 之后由 ReadTimeout 和 WriteTimeout 控制
+	}
+	target.serveCodec(cc, &opt, nc, info)
+}
+
+const (
+	// maxOptionSize Option 编码之后的最大长度，超过之后解码失败，避免对端不停发送数据
+	maxOptionSize = 64 << 10
+	// defaultHandshakeTimeout 没有设置 HandshakeTimeout 时使用的握手超时时间
+	defaultHandshakeTimeout = 10 * time.Second
+)
+
+// handshakeTimeout 返回握手的超时时间，设置了更短的 ReadTimeout 时使用 ReadTimeout
+func (server *Server) handshakeTimeout() time.Duration {
+	timeout := server.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = defaultHandshakeTimeout
+	}
+	if server.ReadTimeout > 0 && server.ReadTimeout < timeout {
+		timeout = server.ReadTimeout
+	}
+	return timeout
+}
+
+// rejectHandshake 对端发送的不是 Option，如果是 HTTP 请求就回复 400，告诉对方应该使用 CONNECT 方法或者 RPC 客户端
+func (server *Server) rejectHandshake(conn io.ReadWriteCloser, first byte) {
+	if first >= 'A' && first <= 'Z' {
+		server.logger.Errorf("rpc server: http request on rpc port from %s", remoteAddr(conn))
+		_, _ = io.WriteString(conn, "HTTP/1.0 400 Bad Request\r\nContent-Type: text/plain\r\nConnection: close\r\n\r\n"+
+			"geerpc: this port serves the rpc protocol, use an rpc client or HTTP CONNECT on the rpc path\n")
+		return
+	}
+	server.logger.Errorf("rpc server: invalid handshake from %s: first byte %q", remoteAddr(conn), first)
+}
+
+// handshakeConn 读取时先返回 Option 解码时多读的数据，再从原始连接读取
+type handshakeConn struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// deadlineWriter 每次写入之前设置写入的截止时间，对端迟迟不读取数据时写入失败，编解码器随之关闭连接
+type deadlineWriter struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (w *deadlineWriter) Write(p []byte) (int, error) {
+	_ = w.Conn.SetWriteDeadline(time.Now().Add(w.timeout))
+	return w.Conn.Write(p)
+}
+
+// setReadDeadline 设置读取下一帧数据的截止时间，没有设置 ReadTimeout 或者不是 net.Conn 时什么都不做
+func (server *Server) setReadDeadline(nc net.Conn) {
+	if nc != nil && server.ReadTimeout > 0 {
+		_ = nc.SetReadDeadline(time.Now().Add(server.ReadTimeout))
+	}
 }
 
 var invalidRequest = struct {
 }{}
 
-func (server *Server) serveCodec(cc codec.Codec, opt *Option) {
+// ErrDeadlineExceeded 请求开始处理之前就已经超过了客户端的截止时间
+var ErrDeadlineExceeded = errors.New("rpc server: request deadline exceeded")
+
+func (server *Server) serveCodec(cc codec.Codec, opt *Option, nc net.Conn, info *ConnInfo) {
 	sending := new(sync.Mutex) // 针对的是一条连接
+	cc = server.CodecDebug.wrap(server.coalesce(cc), info.RemoteAddr, server.logger)
+	if c, ok := cc.(*debugCodec); ok {
+		info.mu.Lock()
+		info.codec = c
+		info.mu.Unlock()
+	}
+	if opt.HandleTimeout == 0 {
+		opt.HandleTimeout = server.HandleTimeout
+	}
 	wg := new(sync.WaitGroup)
+	ctx := contextWithConnInfo(context.WithValue(context.Background(), loggerKey{}, server.logger), info)
+	cs := newConnState(ctx, cc, sending)
+	cs.randomSeq = opt.SeqStrategy == SeqRandom
+	cs.chunked = opt.ChunkedReplies
+	server.trackConn(cs)
+	defer server.untrackConn(cs)
+	defer server.reapIdle(cs)()
+	var idle *time.Timer
+	if timeout := opt.idleTimeout(); timeout > 0 {
+		// 客户端开启了心跳，超时没有收到任何数据说明连接已经断开了，关闭连接让 readRequest 返回
+		idle = time.AfterFunc(timeout, func() {
+			server.logger.Infof("rpc server: connection idle for %s, closing", timeout)
+			_ = cc.Close()
+		})
+		defer idle.Stop()
+	}
+	var connLimiter RateLimiter
+	if server.ConnRateLimiter != nil {
+		connLimiter = server.ConnRateLimiter()
+	}
+	var pending chan struct{}
+	if server.MaxPendingRequestsPerConn > 0 {
+		pending = make(chan struct{}, server.MaxPendingRequestsPerConn)
+	}
 	// 处理多个请求
 	for {
-		req, err := server.readRequest(cc)
+		server.setReadDeadline(nc)
+		req, err := server.readRequest(cc, cs)
+		cs.touch()
+		if idle != nil {
+			idle.Reset(opt.idleTimeout())
+		}
 		if err != nil {
 			if req == nil {
 				break
 			}
-			req.h.Error = err.Error()
+			setError(req.h, err)
 			server.sendResponse(cc, req.h, invalidRequest, sending) // 处理错误场景
+			server.freeRequest(req)
+			continue
+		}
+		if req == nil {
+			continue // 心跳或者流式调用的后续帧，已经处理完了
+		}
+		// 在启动 goroutine 之前限流，超出限制的请求直接返回错误，避免堆积大量的 goroutine
+		if !server.allow(connLimiter, req) {
+			setError(req.h, ErrRateLimited)
+			server.sendResponse(cc, req.h, invalidRequest, sending)
+			server.freeRequest(req)
+			continue
+		}
+		// 流式方法在 Recv 中等待的帧也由这个循环读取，如果它占用 pending 或者在工作池中排队，
+		// 这个循环阻塞之后流再也收不到数据，整个连接就死锁了。所以流式方法不计入 pending，总是单独启动 goroutine
+		limited := pending != nil && !req.mtype.stream
+		if limited {
+			select {
+			case pending <- struct{}{}:
+			default:
+				// 不再读取新的请求，让 TCP 的流量控制把压力传递给客户端
+				server.overload(ErrTooManyPendingRequests)
+				pending <- struct{}{}
+			}
 		}
 		wg.Add(1)
-		go server.handleRequest(cc, req, sending, wg, opt.HandleTimeout) // 并行处理多个请求
+		task := func() {
+			server.handleRequest(cc, req, sending, wg, server.handleTimeout(req, opt.HandleTimeout)) // 并行处理多个请求
+			if limited {
+				<-pending
+			}
+		}
+		if pool := server.workerPool(req); pool == nil || req.mtype.stream {
+			go task()
+		} else if !pool.SubmitPriority(requestPriority(req), task) {
+			// 工作池已满并且策略为拒绝
+			wg.Done()
+			if limited {
+				<-pending
+			}
+			setError(req.h, ErrServerBusy)
+			server.sendResponse(cc, req.h, invalidRequest, sending)
+			server.freeRequest(req)
+		}
 	}
+	cs.abortStreams()
 	wg.Wait()
 	_ = cc.Close()
 }
 
 func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	fmt.Println(req.Method)
+	server.logger.Debugf("rpc server: http %s %s", req.Method, req.RemoteAddr)
+	if req.ProtoMajor == 2 && req.Method == http.MethodPost {
+		server.serveHTTP2(w, req)
+		return
+	}
 	if req.Method != "CONNECT" {
 		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
 		w.WriteHeader(http.StatusMethodNotAllowed)
@@ -132,47 +608,167 @@ func (server *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	}
 	conn, _, err := w.(http.Hijacker).Hijack()
 	if err != nil {
-		log.Print("rpc hijacking ", req.RemoteAddr, ": ", err.Error())
+		server.logger.Errorf("rpc hijacking %s: %v", req.RemoteAddr, err)
 		return
 	}
 	_, _ = io.WriteString(conn, "HTTP/1.0 "+connected+"\n\n")
-	server.ServerConn(conn)
+	server.serveConn(conn)
 }
 
+// HandleHTTP 在 http.DefaultServeMux 上使用默认路径注册
 func (server *Server) HandleHTTP() {
-	http.Handle(defaultRPCPath, server)
-	http.Handle(defaultDebugPath, debugHTTP{server})
-	log.Println("rpc server debug path:", defaultDebugPath)
+	server.HandleHTTPOn(http.DefaultServeMux, defaultRPCPath, defaultDebugPath)
+}
+
+// HandleHTTPOn 在 mux 上注册 RPC 和调试页面的路径，
+// 同一个进程中的多个 Server 使用不同的路径或者不同的 mux 就不会冲突，
+// 客户端通过 Option.RPCPath 指定 rpcPath。JSON-RPC 需要单独注册 JSONRPCHandler
+func (server *Server) HandleHTTPOn(mux *http.ServeMux, rpcPath, debugPath string) {
+	mux.Handle(rpcPath, server)
+	mux.Handle(debugPath, debugHTTP{server})
+	server.logger.Infof("rpc server debug path: %s", debugPath)
 }
 
 func HandleHTTP() {
 	DefaultServer.HandleHTTP()
 }
 
+func HandleHTTPOn(mux *http.ServeMux, rpcPath, debugPath string) {
+	DefaultServer.HandleHTTPOn(mux, rpcPath, debugPath)
+}
+
 type request struct {
 	h            *codec.Header
 	argv, replyv reflect.Value // 数据可以支持多种类型，所以这里使用反射
 	mtype        *methodType
 	svc          *service
+	deadline     time.Time // 根据客户端的剩余超时时间算出的截止时间，零值表示没有限制
+	stream       *ServerStream
+	cs           *connState
+	ctx          context.Context // 客户端发送取消帧时被取消
+	cancel       context.CancelFunc
+	canceled     int32       // 客户端已经放弃了这个请求，不需要再回复
+	details      interface{} // 服务方法返回的错误的详细信息，参考 ErrorDetailer
+}
+
+// connState 一条连接上的状态，用于把客户端发来的取消帧和流式调用的后续帧交给对应的请求
+type connState struct {
+	ctx     context.Context // 带有 ConnInfo 和 Logger，请求的 ctx 都从它派生
+	cc      codec.Codec
+	sending *sync.Mutex
+	mu      sync.Mutex
+	streams map[uint64]*ServerStream
+	calls   map[uint64]*request // 还没有回复的请求
+	lastSeq uint64              // 最近一个请求的 Seq，只在读取请求的 goroutine 中访问
+	seqSeen bool
+	// randomSeq 客户端使用随机的序列号，参考 SeqRandom
+	randomSeq bool
+	// chunked 客户端可以接收分块发送的回复
+	chunked bool
+	// lastActive 最近一次活动的时间，UnixNano，参考 Server.IdleTimeout
+	lastActive int64
+}
+
+func newConnState(ctx context.Context, cc codec.Codec, sending *sync.Mutex) *connState {
+	return &connState{
+		ctx:     ctx,
+		cc:      cc,
+		sending: sending,
+		streams: make(map[uint64]*ServerStream),
+		calls:   make(map[uint64]*request),
+	}
+}
+
+// track 记录一个已经读取完成的请求，之后客户端可以通过取消帧取消它
+func (cs *connState) track(req *request) {
+	req.cs = cs
+	req.ctx, req.cancel = context.WithCancel(cs.ctx)
+	cs.mu.Lock()
+	cs.calls[req.h.Seq] = req
+	cs.mu.Unlock()
+}
+
+// nextSeq 检查请求的 Seq，同一个连接上请求的 Seq 必须递增，重复或者回退的 Seq 被认为是重放的请求，
+// 到达最大值之后回到开头的 Seq 除外。客户端使用随机的 Seq 时只拒绝和还没有回复的请求重复的 Seq
+func (cs *connState) nextSeq(seq uint64) bool {
+	if cs.randomSeq {
+		cs.mu.Lock()
+		defer cs.mu.Unlock()
+		_, pending := cs.calls[seq]
+		_, streaming := cs.streams[seq]
+		return !pending && !streaming
+	}
+	if cs.seqSeen && seq <= cs.lastSeq && !seqWrapped(cs.lastSeq, seq) {
+		return false
+	}
+	cs.lastSeq, cs.seqSeen = seq, true
+	return true
+}
+
+// cancel 处理客户端的取消帧，请求还在排队时直接跳过，正在执行时取消 ctx，并且都不再回复
+func (cs *connState) cancel(seq uint64) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if req, ok := cs.calls[seq]; ok {
+		atomic.StoreInt32(&req.canceled, 1)
+		req.cancel()
+	}
+}
+
+// release 请求已经回复或者被丢弃，取消它的 ctx 并且不再接收客户端的取消帧
+func (req *request) release() {
+	if req.cs == nil {
+		return
+	}
+	req.cancel()
+	req.cs.mu.Lock()
+	if req.cs.calls[req.h.Seq] == req {
+		delete(req.cs.calls, req.h.Seq)
+	}
+	req.cs.mu.Unlock()
+	req.cs.touch()
+}
+
+func (req *request) isCanceled() bool {
+	return atomic.LoadInt32(&req.canceled) == 1
 }
 
 func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
-	var h codec.Header
-	if err := cc.ReadHeader(&h); err != nil {
+	h := server.getHeader()
+	if err := cc.ReadHeader(h); err != nil {
 		// 如果不是文件末尾，表示读取错误
 		if err != io.EOF && err != io.ErrUnexpectedEOF {
-			log.Println("rpc server: read header error", err)
+			server.logger.Errorf("rpc server: read header error %v", err)
 		}
+		server.putHeader(h)
 		return nil, err
 	}
-	return &h, nil
+	return h, nil
 }
 
-func (server *Server) readRequest(cc codec.Codec) (*request, error) {
+// readRequest 读取一个请求，心跳、取消帧和流式调用的后续帧直接处理，此时返回的 request 为 nil
+func (server *Server) readRequest(cc codec.Codec, cs *connState) (*request, error) {
+	start := bytesRead(cc)
 	h, err := server.readRequestHeader(cc)
 	if err != nil {
 		return nil, err
 	}
+	if h.Flags&codec.FlagPing != 0 {
+		server.putHeader(h)
+		if err := cc.ReadBody(nil); err != nil {
+			return nil, err
+		}
+		server.sendResponse(cc, &codec.Header{Flags: codec.FlagPong}, invalidRequest, cs.sending)
+		return nil, nil
+	}
+	if h.Flags&codec.FlagCancel != 0 {
+		cs.cancel(h.Seq)
+		server.putHeader(h)
+		return nil, cc.ReadBody(nil)
+	}
+	if h.Flags&codec.FlagStream != 0 {
+		return nil, server.receiveStream(cc, h, cs)
+	}
 	/*
 		// day 1 和 day2 的代码
 		req := &request{h: h}
@@ -183,13 +779,30 @@ func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 		return req, nil
 	*/
 	// day 3
-	req := &request{h: h}
+	req := server.getRequest()
+	req.h = h
+	ensureRequestID(h)
+	if !cs.nextSeq(h.Seq) {
+		server.requestLogger(h).Errorf("rpc server: duplicate seq %d", h.Seq)
+		_ = cc.ReadBody(nil)
+		return req, ErrDuplicateSeq
+	}
+	if h.Timeout > 0 {
+		req.deadline = time.Now().Add(h.Timeout)
+	}
+	// 普通响应会原样带回请求的 Header，Flags 只能由服务端按需设置，否则客户端会把错误当作流数据
+	halfClosed := h.Flags&codec.FlagEnd != 0
+	h.Flags = 0
 	req.svc, req.mtype, err = server.findService(h.ServiceMethod)
 	if err != nil {
+		// 找不到服务时也需要把 body 读掉，否则会被当作下一个请求的 Header
+		_ = cc.ReadBody(nil)
 		return req, err
 	}
-	req.argv = req.mtype.newArgv()
-	req.replyv = req.mtype.newReplyv()
+	req.argv = req.mtype.getArgv(server.ReuseArgv)
+	if !req.mtype.stream {
+		req.replyv = req.mtype.newReplyv()
+	}
 
 	// 因为ReadBody需要是指针类型的参数，所以需要保证argvi是指针类型
 	argvi := req.argv.Interface()
@@ -197,18 +810,51 @@ func (server *Server) readRequest(cc codec.Codec) (*request, error) {
 		argvi = req.argv.Addr().Interface()
 	}
 	if err = cc.ReadBody(argvi); err != nil {
-		log.Println("rpc server: read body err:", err)
+		err = withTypeHint(err)
+		server.requestLogger(h).Errorf("rpc server: read body err: %v", err)
+		return req, NewError(CodeInvalidArgument, err.Error())
+	}
+	if err = server.checkRequestSize(cc, req, bytesRead(cc)-start); err != nil {
 		return req, err
 	}
+	if req.mtype.stream {
+		req.stream = cs.openStream(h, req.mtype, halfClosed)
+		req.replyv = reflect.ValueOf(req.stream)
+	}
+	cs.track(req)
 	return req, nil
 }
 
-func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) {
+// sendResponse 发送一个回复，返回 Header 和 Body 编码之后的字节数，codec 不支持统计时返回 0
+func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) int {
 	sending.Lock()
 	defer sending.Unlock()
+	start := bytesWritten(cc)
 	if err := cc.Write(h, body); err != nil {
-		log.Println("rpc server: write response error:", err)
+		server.requestLogger(h).Errorf("rpc server: write response error: %v", withTypeHint(err))
+	}
+	return int(bytesWritten(cc) - start)
+}
+
+// SetTimeout 为 name 设置处理超时时间，代替客户端 Option.HandleTimeout 和 Server.HandleTimeout，
+// name 为 "Foo" 表示 Foo 服务的所有方法，"Foo.Sum" 表示单个方法，方法的设置优先于服务。
+// 比如批量导出需要几分钟，而 Foo.Sum 应该在几毫秒之内完成。d 为 0 表示这些方法不限制处理时间，小于 0 表示取消设置
+func (server *Server) SetTimeout(name string, d time.Duration) {
+	if d < 0 {
+		server.timeouts.Delete(name)
+		return
+	}
+	server.timeouts.Store(name, d)
+}
+
+// handleTimeout 返回 req 的处理超时时间，没有通过 SetTimeout 设置时使用连接的 HandleTimeout
+func (server *Server) handleTimeout(req *request, timeout time.Duration) time.Duration {
+	for _, name := range []string{req.svc.name + "." + req.mtype.method.Name, req.svc.name} {
+		if d, ok := server.timeouts.Load(name); ok {
+			return d.(time.Duration)
+		}
 	}
+	return timeout
 }
 
 func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup, timeout time.Duration) {
@@ -230,46 +876,194 @@ func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.
 		server.sendResponse(cc, req.h, req.replyv.Interface(), sending)
 	*/
 	defer wg.Done()
-	called := make(chan struct{})
-	sent := make(chan struct{})
-	go func() {
-		err := req.svc.call(req.mtype, req.argv, req.replyv)
+	if req.isCanceled() {
+		// 请求在队列中等待的时候客户端就已经放弃了
+		server.freeRequest(req)
+		return
+	}
+	if !req.deadline.IsZero() {
+		remaining := time.Until(req.deadline)
+		if remaining <= 0 {
+			// 请求在队列中等待的时候客户端就已经超时了，不需要再处理
+			setError(req.h, ErrDeadlineExceeded)
+			server.sendResponse(cc, req.h, invalidRequest, sending)
+			server.freeRequest(req)
+			return
+		}
+		if timeout == 0 || remaining < timeout {
+			timeout = remaining
+		}
+	}
+	if req.mtype.stream {
+		server.handleStream(cc, req, sending)
+		return
+	}
+	// 使用带缓冲的 channel，超时返回之后处理请求的 goroutine 也能正常退出
+	called := make(chan struct{}, 1)
+	sent := make(chan struct{}, 1)
+	process := func() {
+		err := server.invoke(req)
 		called <- struct{}{}
 		if err != nil {
-			req.h.Error = err.Error()
-			server.sendResponse(cc, req.h, req.replyv.Interface(), sending)
-			sent <- struct{}{}
-			return
+			setError(req.h, err)
+			req.details = ErrorDetailsOf(err)
+		}
+		if !req.isCanceled() {
+			server.sendReply(cc, req, sending)
 		}
-		server.sendResponse(cc, req.h, req.replyv.Interface(), sending)
 		sent <- struct{}{}
-	}()
+	}
 
 	if timeout == 0 {
-		<-called
-		<-sent
+		// 没有超时限制时直接在当前 goroutine 中处理，不需要再启动一个 goroutine
+		process()
+		server.freeRequest(req)
 		return
 	}
+	go process()
 	select {
 	case <-time.After(timeout):
-		req.h.Error = fmt.Sprintf("rpc server: request handle timeout: expect within %s", timeout)
-		server.sendResponse(cc, req.h, invalidRequest, sending)
+		atomic.StoreInt32(&req.canceled, 1) // 已经回复了超时错误，方法执行完之后不再回复
+		// 方法还在执行，返回之后会写 req.h，这里使用新的 Header 回复
+		h := &codec.Header{ServiceMethod: req.h.ServiceMethod, Seq: req.h.Seq}
+		setError(h, Errorf(CodeTimeout, "rpc server: request handle timeout: expect within %s", timeout))
+		server.sendResponse(cc, h, invalidRequest, sending)
+		req.release() // 超时之后通知还在执行的方法放弃
 	case <-called: // 注意这里只是控制了调用的超时，没有控制发送回复的超时
 		<-sent
+		// 超时的请求可能还在被处理，只有正常处理完成的请求才能回收
+		server.freeRequest(req)
 	}
 }
 
+// invoke 经过中间件调用请求对应的服务方法
+func (server *Server) invoke(req *request) error {
+	c := context.Background()
+	if req.ctx != nil {
+		c = req.ctx
+	}
+	c = contextWithIncomingMetadata(c, req.h.Metadata)
+	if !req.deadline.IsZero() {
+		var cancel context.CancelFunc
+		c, cancel = context.WithDeadline(c, req.deadline)
+		defer cancel()
+	}
+	if req.stream != nil {
+		req.stream.ctx = c // 流式方法通过 stream.Context() 获取同一个 ctx
+	}
+	if err := server.authorize(c, req.h.ServiceMethod); err != nil {
+		return err
+	}
+	if err := validate(req.argv, req.h.ServiceMethod); err != nil {
+		return err
+	}
+	ctx := &RequestContext{
+		Context: c,
+		Header:  req.h,
+		Args:    req.argv.Interface(),
+		Reply:   req.replyv.Interface(),
+	}
+	return server.idempotentInvoke(c, req, func() error {
+		return server.cachedInvoke(req, func() error {
+			return server.chain(func(ctx *RequestContext) error {
+				start := time.Now()
+				err := req.svc.callContext(ctx.Context, req.mtype, req.argv, req.replyv)
+				server.checkSlow(ctx.Context, req, time.Since(start))
+				return err
+			})(ctx)
+		})
+	})
+}
+
+// checkSlow 记录耗时超过 SlowCallThreshold 的调用
+func (server *Server) checkSlow(ctx context.Context, req *request, d time.Duration) {
+	if server.SlowCallThreshold <= 0 || d < server.SlowCallThreshold {
+		return
+	}
+	atomic.AddUint64(&req.mtype.numSlow, 1)
+	peer := "unknown"
+	if info, ok := ConnInfoFromContext(ctx); ok {
+		peer = info.RemoteAddr
+	}
+	server.requestLogger(req.h).Infof("rpc server: slow call %s took %s, peer %s, seq %d",
+		req.h.ServiceMethod, d.Truncate(time.Microsecond), peer, req.h.Seq)
+}
+
+// authorize 使用 Server.Authorize 检查调用方是否可以调用 serviceMethod
+func (server *Server) authorize(ctx context.Context, serviceMethod string) error {
+	if server.Authorize == nil {
+		return nil
+	}
+	var identity string
+	if info, ok := ConnInfoFromContext(ctx); ok {
+		identity = info.Identity
+	}
+	err := server.Authorize(ctx, identity, serviceMethod)
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	return Errorf(CodePermissionDenied, "rpc server: permission denied for %s: %v", serviceMethod, err)
+}
+
 func (server *Server) Accept(lis net.Listener) {
+	if err := server.serve(lis); err != nil {
+		server.logger.Errorf("rpc server: accept error: %v", err)
+	}
+}
+
+// Serve 和 Accept 相同，ctx 结束之后关闭 lis 并停止接受新的连接，已经建立的连接不受影响，
+// ctx 结束或者 Shutdown 之后返回 nil，其他情况返回 lis.Accept 的错误
+func (server *Server) Serve(ctx context.Context, lis net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { _ = lis.Close() })
+	defer stop()
+	err := server.serve(lis)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+func (server *Server) serve(lis net.Listener) error {
+	if !server.trackListener(lis) {
+		_ = lis.Close()
+		return nil
+	}
+	defer server.untrackListener(lis)
+	server.startRegistration()
 	for {
 		conn, err := lis.Accept()
 		if err != nil {
-			log.Println("rpc server: accept error:", err)
-			return
+			if server.isShutdown() {
+				return nil
+			}
+			return err
 		}
-		go server.ServerConn(conn)
+		go server.serveConn(conn)
 	}
 }
 
 func Accept(lis net.Listener) { DefaultServer.Accept(lis) }
 
+// AcceptTLS 在 lis 上接受 TLS 连接，如果需要校验客户端证书（双向 TLS），
+// 在 config 中设置 ClientAuth 和 ClientCAs 即可
+func (server *Server) AcceptTLS(lis net.Listener, config *tls.Config) {
+	server.Accept(tls.NewListener(lis, config))
+}
+
+func AcceptTLS(lis net.Listener, config *tls.Config) { DefaultServer.AcceptTLS(lis, config) }
+
 func Register(rcvr interface{}) error { return DefaultServer.Register(rcvr) }
+
+func RegisterName(name string, rcvr interface{}) error { return DefaultServer.RegisterName(name, rcvr) }
+
+func RegisterFunc(serviceMethod string, fn interface{}) error {
+	return DefaultServer.RegisterFunc(serviceMethod, fn)
+}
+
+func Unregister(name string) error { return DefaultServer.Unregister(name) }
+
+func ReplaceService(rcvr interface{}) error { return DefaultServer.ReplaceService(rcvr) }
@@ -0,0 +1,2154 @@
+package geerpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"geerpc/codec"
+	"geerpc/registry"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startTestServer 在随机端口上启动 server，返回监听的地址
+func startTestServer(server *Server) string {
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	return l.Addr().String()
+}
+
+func TestServer_Use(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	var trace []string
+	server.Use(func(ctx *RequestContext, next Handler) error {
+		trace = append(trace, "outer")
+		if ctx.Args.(Args).Num1 < 0 {
+			return errors.New("negative number")
+		}
+		return next(ctx)
+	}, func(ctx *RequestContext, next Handler) error {
+		trace = append(trace, "inner "+ctx.Header.ServiceMethod)
+		err := next(ctx)
+		trace = append(trace, "reply "+strconv.Itoa(*ctx.Reply.(*int)))
+		return err
+	})
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	t.Run("chain", func(t *testing.T) {
+		var reply int
+		err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "failed to call Foo.Sum")
+		_assert(strings.Join(trace, ",") == "outer,inner Foo.Sum,reply 3", "wrong middleware order: %v", trace)
+	})
+	t.Run("short circuit", func(t *testing.T) {
+		trace = nil
+		var reply int
+		err := client.Call(context.Background(), "Foo.Sum", Args{Num1: -1, Num2: 2}, &reply)
+		_assert(err != nil && err.Error() == "negative number", "expect middleware error, got %v", err)
+		_assert(len(trace) == 1, "inner middleware shouldn't be called")
+	})
+	t.Run("unknown service", func(t *testing.T) {
+		var reply int
+		err := client.Call(context.Background(), "Baz.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err != nil && strings.Contains(err.Error(), "can't find service"), "expect can't find service, got %v", err)
+	})
+}
+
+type testLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) logf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func (l *testLogger) Debugf(format string, v ...interface{}) {}
+func (l *testLogger) Infof(format string, v ...interface{})  { l.logf(format, v...) }
+func (l *testLogger) Errorf(format string, v ...interface{}) { l.logf(format, v...) }
+
+type unexported int
+
+func (u unexported) Sum(args Args, reply *int) error { return nil }
+
+func TestServer_SetLogger(t *testing.T) {
+	server := NewServer()
+	logger := &testLogger{}
+	server.SetLogger(logger)
+	var foo Foo
+	_ = server.Register(&foo)
+	_assert(len(logger.lines) == 1 && logger.lines[0] == "rpc server: reigster Foo.Sum", "unexpected logs: %v", logger.lines)
+
+	var u unexported
+	err := server.Register(&u)
+	_assert(err != nil && strings.Contains(err.Error(), "not valid service name"), "expect an invalid service name error")
+}
+
+func TestServer_MetricsHandler(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+	var reply int
+	_ = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_ = client.Call(context.Background(), "Foo.Sum", Args{Num1: 3, Num2: 4}, &reply)
+
+	w := httptest.NewRecorder()
+	server.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		`geerpc_server_calls_total{service="Foo",method="Sum"} 2`,
+		`geerpc_server_errors_total{service="Foo",method="Sum"} 0`,
+		`geerpc_server_in_flight{service="Foo",method="Sum"} 0`,
+		`geerpc_server_handling_seconds_bucket{service="Foo",method="Sum",le="+Inf"} 2`,
+		`geerpc_server_handling_seconds_count{service="Foo",method="Sum"} 2`,
+	} {
+		_assert(strings.Contains(body, line+"\n"), "metrics should contain %s, got:\n%s", line, body)
+	}
+	_assert(!strings.Contains(body, "geerpc_server_received_bytes_total 0\n"), "received bytes should be counted")
+
+	_ = server.RegisterName(`Foo"\v1`, &foo)
+	w = httptest.NewRecorder()
+	server.MetricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	line := `geerpc_server_calls_total{service="Foo\"\\v1",method="Sum"} 0`
+	_assert(strings.Contains(w.Body.String(), line+"\n"), "label values should be escaped, got:\n%s", w.Body.String())
+	_assert(!strings.Contains(body, "geerpc_server_sent_bytes_total 0\n"), "sent bytes should be counted")
+}
+
+func TestServer_Stats(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	_ = server.RegisterFunc("Bad.Call", func(_ int, _ *int) error { return errors.New("bad") })
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+	var reply int
+	_ = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_ = client.Call(context.Background(), "Foo.Sum", Args{Num1: 3, Num2: 4}, &reply)
+	_ = client.Call(context.Background(), "Bad.Call", 0, &reply)
+
+	stats := server.Stats()
+	_assert(stats.ActiveConns == 1 && stats.Calls == 3 && stats.Errors == 1 && stats.InFlight == 0,
+		"unexpected stats %+v", stats)
+	_assert(stats.BytesIn > 0 && stats.BytesOut > 0, "bytes should be counted")
+	methods := make(map[string]MethodStats)
+	for _, m := range stats.Methods {
+		methods[m.Name] = m
+	}
+	_assert(methods["Foo.Sum"].Calls == 2 && methods["Bad.Call"].Errors == 1, "unexpected method stats %+v", stats.Methods)
+	l := methods["Foo.Sum"].Latency
+	_assert(l.Count == 2 && l.Min > 0 && l.Min <= l.Avg && l.Avg <= l.Max && l.P50 <= l.P99 && l.P99 <= l.Max,
+		"unexpected latency stats %+v", l)
+
+	var h histogram
+	for i := 1; i <= 100; i++ {
+		h.observe(time.Duration(i) * time.Millisecond)
+	}
+	l = h.stats()
+	_assert(l.Min == time.Millisecond && l.Max == 100*time.Millisecond && l.Avg == 50500*time.Microsecond,
+		"unexpected min/avg/max %+v", l)
+	_assert(l.P50 == 50*time.Millisecond && l.P99 == 100*time.Millisecond, "unexpected quantiles %+v", l)
+
+	server.PublishExpvar("geerpc_test_stats")
+	v := expvar.Get("geerpc_test_stats").String()
+	_assert(strings.Contains(v, `"Name":"Foo.Sum","Calls":2`), "expvar should publish the stats, got %s", v)
+}
+
+func TestDebugHTTP(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	var budget Budget
+	_ = server.Register(&foo)
+	_ = server.Register(&budget)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+	var reply int
+	_ = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+
+	w := httptest.NewRecorder()
+	debugHTTP{server}.ServeHTTP(w, httptest.NewRequest("GET", defaultDebugPath, nil))
+	body := w.Body.String()
+	_assert(strings.Index(body, "Service Budget") < strings.Index(body, "Service Foo"), "services should be sorted")
+	_assert(strings.Contains(body, "Remaining(context.Context, int, *int64) error"), "signature should include the context:\n%s", body)
+	_assert(strings.Contains(body, "Sum(geerpc.Args, *int) error</td>\n\t\t\t<td align=center>1</td>"), "calls should be counted:\n%s", body)
+}
+
+func TestTracePropagation(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	spans := make(chan SpanContext, 1)
+	server.Use(TraceMiddleware(nil), func(ctx *RequestContext, next Handler) error {
+		sc, _ := SpanContextFromContext(ctx.Context)
+		spans <- sc
+		return next(ctx)
+	})
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+	client.Use(TraceInterceptor(nil))
+
+	parent := SpanContext{TraceID: [16]byte{1, 2, 3}, SpanID: [8]byte{4, 5, 6}, Sampled: true}
+	ctx := ContextWithSpanContext(context.Background(), parent)
+	var reply int
+	err := client.Call(ctx, "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil, "failed to call Foo.Sum: %v", err)
+	sc := <-spans
+	_assert(sc.IsValid() && sc.TraceID == parent.TraceID, "trace id should be propagated")
+	_assert(sc.SpanID != parent.SpanID && sc.Sampled, "a new sampled span should be created")
+}
+
+type Tenant int
+
+func (t Tenant) Echo(ctx context.Context, key string, reply *string) error {
+	*reply = IncomingMetadata(ctx)[key]
+	return nil
+}
+
+func TestCallMetadata(t *testing.T) {
+	server := NewServer()
+	var tenant Tenant
+	_ = server.Register(&tenant)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	ctx := ContextWithMetadata(context.Background(), map[string]string{"tenant": "a", "locale": "zh"})
+	t.Run("context", func(t *testing.T) {
+		var reply string
+		err := client.Call(ctx, "Tenant.Echo", "tenant", &reply)
+		_assert(err == nil && reply == "a", "expect tenant a, got %q %v", reply, err)
+	})
+	t.Run("call option", func(t *testing.T) {
+		var reply string
+		err := client.Call(ctx, "Tenant.Echo", "tenant", &reply, WithMetadata(map[string]string{"tenant": "b"}))
+		_assert(err == nil && reply == "b", "call option should override context, got %q", reply)
+		err = client.Call(ctx, "Tenant.Echo", "locale", &reply, WithMetadata(map[string]string{"tenant": "b"}))
+		_assert(err == nil && reply == "zh", "context metadata should be kept, got %q", reply)
+	})
+	t.Run("go", func(t *testing.T) {
+		var reply string
+		call := <-client.Go("Tenant.Echo", "tenant", &reply, nil, WithMetadata(map[string]string{"tenant": "c"})).Done
+		_assert(call.Error == nil && reply == "c", "expect tenant c, got %q", reply)
+	})
+}
+
+func TestServer_AuthFunc(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	server.AuthFunc = func(token string, conn io.ReadWriteCloser) error {
+		if token != "secret" {
+			return errors.New("invalid token")
+		}
+		return nil
+	}
+	addr := startTestServer(server)
+
+	t.Run("authorized", func(t *testing.T) {
+		client, err := Dial("tcp", addr, &Option{AuthToken: "secret"})
+		_assert(err == nil, "failed to dial: %v", err)
+		defer func() { _ = client.Close() }()
+		var reply int
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+	})
+	t.Run("wrong token", func(t *testing.T) {
+		_, err := Dial("tcp", addr, &Option{AuthToken: "guess"})
+		_assert(errors.Is(err, ErrUnauthorized) && strings.Contains(err.Error(), "invalid token"), "expect unauthorized, got %v", err)
+	})
+	t.Run("no token", func(t *testing.T) {
+		client, err := Dial("tcp", addr)
+		_assert(err == nil, "dial without token doesn't wait for the handshake")
+		var reply int
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err != nil, "expect an error without token")
+	})
+}
+
+func TestServer_Authorize(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	var budget Budget
+	_ = server.Register(&foo)
+	_ = server.Register(&budget)
+	users := map[string]string{"key-alice": "alice", "key-bob": "bob"}
+	server.IdentityFunc = func(token string) string { return users[token] }
+	// 只有 alice 可以调用 Budget 服务
+	server.Authorize = func(ctx context.Context, identity, serviceMethod string) error {
+		if strings.HasPrefix(serviceMethod, "Budget.") && identity != "alice" {
+			return errors.New(identity + " is not allowed")
+		}
+		return nil
+	}
+	addr := startTestServer(server)
+	call := func(token, serviceMethod string) error {
+		client, err := Dial("tcp", addr, &Option{AuthToken: token})
+		_assert(err == nil, "failed to dial: %v", err)
+		defer func() { _ = client.Close() }()
+		var reply int64
+		if serviceMethod == "Foo.Sum" {
+			var sum int
+			return client.Call(context.Background(), serviceMethod, Args{Num1: 1, Num2: 2}, &sum)
+		}
+		return client.Call(context.Background(), serviceMethod, 0, &reply)
+	}
+
+	_assert(call("key-alice", "Budget.Remaining") == nil, "alice should be allowed")
+	_assert(call("key-bob", "Foo.Sum") == nil, "bob should be allowed to call Foo")
+	err := call("key-bob", "Budget.Remaining")
+	_assert(errors.Is(err, ErrPermissionDenied) && strings.Contains(err.Error(), "bob is not allowed"),
+		"expect permission denied, got %v", err)
+
+	// 没有设置 IdentityFunc 时令牌不能作为身份
+	server = NewServer()
+	_ = server.Register(&foo)
+	identities := make(chan string, 1)
+	server.Authorize = func(ctx context.Context, identity, serviceMethod string) error {
+		info, _ := ConnInfoFromContext(ctx)
+		identities <- identity + "|" + info.Identity
+		return nil
+	}
+	addr = startTestServer(server)
+	_assert(call("key-alice", "Foo.Sum") == nil, "call should be allowed")
+	got := <-identities
+	_assert(got == "|", "the token should not be used as the identity, got %q", got)
+}
+
+func TestServer_SetRateLimiter(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	server.SetRateLimiter("Foo.Sum", NewTokenBucket(0.001, 2))
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	for i := 0; i < 2; i++ {
+		err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil, "call %d should be allowed: %v", i, err)
+	}
+	err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err != nil && err.Error() == ErrRateLimited.Error(), "expect rate limited, got %v", err)
+
+	server.SetRateLimiter("Foo.Sum", nil)
+	server.ConnRateLimiter = func() RateLimiter { return NewTokenBucket(0.001, 1) }
+	other, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = other.Close() }()
+	err = other.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil, "first call on a new connection should be allowed: %v", err)
+	err = other.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err != nil && err.Error() == ErrRateLimited.Error(), "expect connection rate limited, got %v", err)
+
+	// 被方法的限流器拒绝的请求不能消耗 Server 的令牌
+	server = NewServer()
+	_ = server.Register(&foo)
+	server.SetRateLimiter("", NewTokenBucket(0.001, 3))
+	server.SetRateLimiter("Foo.Sum", NewTokenBucket(0.001, 1))
+	third, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = third.Close() }()
+	for i := 0; i < 3; i++ {
+		err = third.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert((i == 0) == (err == nil), "only the first call should pass the method limiter, call %d got %v", i, err)
+	}
+	server.SetRateLimiter("Foo.Sum", nil)
+	for i := 0; i < 2; i++ {
+		err = third.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil, "rejected calls should not consume server tokens, call %d got %v", i, err)
+	}
+}
+
+type Slow int
+
+func (s Slow) Sleep(ms int, reply *int) error {
+	time.Sleep(time.Duration(ms) * time.Millisecond)
+	*reply = ms
+	return nil
+}
+
+func TestServer_Overload(t *testing.T) {
+	server := NewServer()
+	var slow Slow
+	_ = server.Register(&slow)
+	server.MaxConnections = 1
+	server.MaxPendingRequestsPerConn = 1
+	overloads := make(chan error, 10)
+	server.OnOverload = func(err error) { overloads <- err }
+	addr := startTestServer(server)
+
+	client, _ := Dial("tcp", addr)
+	defer func() { _ = client.Close() }()
+	t.Run("pending requests", func(t *testing.T) {
+		var r1, r2 int
+		call1 := client.Go("Slow.Sleep", 100, &r1, nil)
+		call2 := client.Go("Slow.Sleep", 10, &r2, nil)
+		<-call1.Done
+		<-call2.Done
+		_assert(call1.Error == nil && call2.Error == nil, "throttled requests should still succeed")
+		_assert(errors.Is(<-overloads, ErrTooManyPendingRequests), "expect too many pending requests")
+	})
+	t.Run("connections", func(t *testing.T) {
+		other, _ := Dial("tcp", addr)
+		var reply int
+		err := other.Call(context.Background(), "Slow.Sleep", 1, &reply)
+		_assert(err != nil, "the second connection should be rejected")
+		_assert(errors.Is(<-overloads, ErrTooManyConnections), "expect too many connections")
+	})
+}
+
+func TestServer_WorkerPool(t *testing.T) {
+	server := NewServer()
+	var slow Slow
+	_ = server.Register(&slow)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	t.Run("block", func(t *testing.T) {
+		server.WorkerPool = NewWorkerPool(2, 0, OverflowBlock)
+		defer server.WorkerPool.Close()
+		var calls []*Call
+		for i := 0; i < 5; i++ {
+			var reply int
+			calls = append(calls, client.Go("Slow.Sleep", 10, &reply, nil))
+		}
+		for _, call := range calls {
+			<-call.Done
+			_assert(call.Error == nil, "every call should succeed: %v", call.Error)
+		}
+	})
+	t.Run("reject", func(t *testing.T) {
+		server.WorkerPool = NewWorkerPool(1, 0, OverflowReject)
+		defer server.WorkerPool.Close()
+		var r1, r2 int
+		call1 := client.Go("Slow.Sleep", 100, &r1, nil)
+		time.Sleep(10 * time.Millisecond)
+		call2 := client.Go("Slow.Sleep", 10, &r2, nil)
+		<-call1.Done
+		<-call2.Done
+		_assert(call1.Error == nil, "the first call should succeed: %v", call1.Error)
+		_assert(call2.Error != nil && call2.Error.Error() == ErrServerBusy.Error(), "expect server busy, got %v", call2.Error)
+	})
+	t.Run("per service", func(t *testing.T) {
+		server.WorkerPool = nil
+		var foo Foo
+		_ = server.Register(&foo)
+		pool := NewWorkerPool(1, 0, OverflowReject)
+		defer pool.Close()
+		server.SetWorkerPool("Slow", pool)
+		defer server.SetWorkerPool("Slow", nil)
+		var r1, r2, sum int
+		call1 := client.Go("Slow.Sleep", 100, &r1, nil)
+		time.Sleep(10 * time.Millisecond)
+		call2 := client.Go("Slow.Sleep", 10, &r2, nil)
+		// 其他服务不受 Slow 工作池的影响
+		err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &sum)
+		_assert(err == nil && sum == 3, "Foo.Sum should not wait for Slow: %v", err)
+		<-call1.Done
+		<-call2.Done
+		_assert(call1.Error == nil, "the first call should succeed: %v", call1.Error)
+		_assert(call2.Error != nil && call2.Error.Error() == ErrServerBusy.Error(), "expect server busy, got %v", call2.Error)
+	})
+	t.Run("priority", func(t *testing.T) {
+		server.WorkerPool = NewWorkerPool(1, 4, OverflowBlock)
+		defer server.WorkerPool.Close()
+		done := make(chan *Call, 5)
+		var replies [5]int
+		first := client.Go("Slow.Sleep", 50, &replies[0], done)
+		time.Sleep(10 * time.Millisecond)
+		normal1 := client.Go("Slow.Sleep", 1, &replies[1], done)
+		normal2 := client.Go("Slow.Sleep", 1, &replies[2], done)
+		// 普通队列积压了一半，低优先级的请求直接被拒绝
+		low := client.Go("Slow.Sleep", 1, &replies[3], done, WithPriority(PriorityLow))
+		high := client.Go("Slow.Sleep", 1, &replies[4], done, WithPriority(PriorityHigh))
+		var order []*Call
+		for i := 0; i < 5; i++ {
+			order = append(order, <-done)
+		}
+		_assert(low.Error != nil && low.Error.Error() == ErrServerBusy.Error(), "expect low priority call to be shed, got %v", low.Error)
+		var served []*Call
+		for _, call := range order {
+			if call != low {
+				_assert(call.Error == nil, "call should succeed: %v", call.Error)
+				served = append(served, call)
+			}
+		}
+		_assert(served[0] == first && served[1] == high && served[2] == normal1 && served[3] == normal2,
+			"expect high priority call to run before queued normal calls")
+	})
+}
+
+func TestServer_PoolStats(t *testing.T) {
+	server := NewServer()
+	server.ReuseArgv = true
+	var foo Foo
+	_ = server.Register(&foo)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	for i := 0; i < 10; i++ {
+		err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "failed to call Foo.Sum: %v", err)
+	}
+	// gob 不会传输值为零的字段，复用的参数必须清零，否则 Num1 会残留上一次的值
+	err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 0, Num2: 5}, &reply)
+	_assert(err == nil && reply == 5, "reused argv should be reset, got %d", reply)
+
+	stats := server.PoolStats()
+	_assert(stats.Requests.Gets == 11 && stats.Requests.Hits > 0, "requests should be reused: %+v", stats.Requests)
+	_assert(stats.Headers.Hits > 0, "headers should be reused: %+v", stats.Headers)
+	_assert(stats.Argv.Gets == 11 && stats.Argv.Hits > 0, "argv should be reused: %+v", stats.Argv)
+}
+
+type Budget int
+
+func (b *Budget) Remaining(ctx context.Context, _ int, reply *int64) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		*reply = int64(time.Until(deadline) / time.Millisecond)
+	}
+	return nil
+}
+
+func TestServer_DeadlinePropagation(t *testing.T) {
+	server := NewServer()
+	var slow Slow
+	var budget Budget
+	_ = server.Register(&slow)
+	_ = server.Register(&budget)
+	server.WorkerPool = NewWorkerPool(1, 10, OverflowBlock)
+	defer server.WorkerPool.Close()
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	t.Run("deadline", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		var reply int64
+		err := client.Call(ctx, "Budget.Remaining", 0, &reply)
+		_assert(err == nil && reply > 0 && reply <= 1000, "handler should see the client deadline, got %d", reply)
+	})
+	t.Run("expired in queue", func(t *testing.T) {
+		var r1 int
+		call := client.Go("Slow.Sleep", 200, &r1, nil)
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		var reply int64
+		err := client.Call(ctx, "Budget.Remaining", 0, &reply)
+		_assert(err != nil, "expect a timeout error")
+		<-call.Done
+		time.Sleep(50 * time.Millisecond)
+		_, mtype, _ := server.findService("Budget.Remaining")
+		_assert(mtype.NumCalls() == 1, "expired request shouldn't be handled")
+	})
+}
+
+type Counter int
+
+func (c Counter) Count(n int, stream *ServerStream) error {
+	for i := 0; i < n; i++ {
+		if err := stream.Send(i); err != nil {
+			return err
+		}
+	}
+	if n < 0 {
+		return errors.New("negative count")
+	}
+	return nil
+}
+
+func (c Counter) Sum(first int, stream *ServerStream) error {
+	total := first
+	for {
+		var n int
+		err := stream.Recv(&n)
+		if err == io.EOF {
+			return stream.Send(total)
+		}
+		if err != nil {
+			return err
+		}
+		total += n
+	}
+}
+
+func (c Counter) Echo(first int, stream *ServerStream) error {
+	for n := first; ; {
+		if err := stream.Send(n); err != nil {
+			return err
+		}
+		if err := stream.Recv(&n); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func TestServer_Stream(t *testing.T) {
+	server := NewServer()
+	var counter Counter
+	_ = server.Register(&counter)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	t.Run("count", func(t *testing.T) {
+		stream, err := client.NewStream(context.Background(), "Counter.Count", 5, new(int))
+		_assert(err == nil, "failed to open stream: %v", err)
+		var got []int
+		for {
+			var v int
+			if err = stream.Recv(&v); err != nil {
+				break
+			}
+			got = append(got, v)
+		}
+		_assert(err == io.EOF && len(got) == 5 && got[4] == 4, "unexpected stream result: %v %v", got, err)
+	})
+	t.Run("error", func(t *testing.T) {
+		stream, _ := client.NewStream(context.Background(), "Counter.Count", -1, new(int))
+		var v int
+		err := stream.Recv(&v)
+		_assert(err != nil && strings.Contains(err.Error(), "negative count"), "expect handler error, got %v", err)
+	})
+	t.Run("unknown method", func(t *testing.T) {
+		stream, _ := client.NewStream(context.Background(), "Counter.Unknown", 1, new(int))
+		var v int
+		err := stream.Recv(&v)
+		_assert(err != nil && strings.Contains(err.Error(), "can't find method"), "expect error, got %v", err)
+	})
+	t.Run("beyond window", func(t *testing.T) {
+		stream, _ := client.NewStream(context.Background(), "Counter.Count", 10*streamWindow, new(int))
+		n := 0
+		for v := 0; stream.Recv(&v) == nil; n++ {
+		}
+		_assert(n == 10*streamWindow, "expect %d values, got %d", 10*streamWindow, n)
+	})
+	t.Run("client stream", func(t *testing.T) {
+		stream, err := client.NewBidiStream(context.Background(), "Counter.Sum", 0, new(int))
+		_assert(err == nil, "failed to open stream: %v", err)
+		for i := 1; i <= 100; i++ {
+			_assert(stream.Send(i) == nil, "failed to send %d", i)
+		}
+		_ = stream.CloseSend()
+		var total int
+		err = stream.Recv(&total)
+		_assert(err == nil && total == 5050, "expect 5050, got %d %v", total, err)
+		_assert(stream.Recv(&total) == io.EOF, "expect io.EOF after the single reply")
+	})
+	t.Run("bidi", func(t *testing.T) {
+		stream, _ := client.NewBidiStream(context.Background(), "Counter.Echo", 0, new(int))
+		go func() {
+			for i := 1; i < 10*streamWindow; i++ {
+				if stream.Send(i) != nil {
+					return
+				}
+			}
+			_ = stream.CloseSend()
+		}()
+		var v, n int
+		for ; stream.Recv(&v) == nil; n++ {
+			_assert(v == n, "expect %d, got %d", n, v)
+		}
+		_assert(n == 10*streamWindow, "expect %d echoes, got %d", 10*streamWindow, n)
+	})
+	t.Run("type mismatch", func(t *testing.T) {
+		stream, _ := client.NewStream(context.Background(), "Counter.Count", 1, new(int))
+		defer func() { _ = stream.Close() }()
+		var s string
+		_assert(stream.Recv(&s) != nil, "expect type mismatch error")
+	})
+}
+
+func TestServer_StreamPendingLimit(t *testing.T) {
+	server := NewServer()
+	var counter Counter
+	var foo Foo
+	_ = server.Register(&counter)
+	_ = server.Register(&foo)
+	server.MaxPendingRequestsPerConn = 1
+	server.WorkerPool = NewWorkerPool(1, 0, OverflowBlock)
+	defer server.WorkerPool.Close()
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	// 两个流都在等待客户端的数据，流式调用不能占满 pending 和工作池，否则读取会被阻塞
+	first, err := client.NewBidiStream(context.Background(), "Counter.Sum", 1, new(int))
+	_assert(err == nil, "failed to open the first stream: %v", err)
+	second, err := client.NewBidiStream(context.Background(), "Counter.Sum", 2, new(int))
+	_assert(err == nil, "failed to open the second stream: %v", err)
+	_assert(first.Send(10) == nil && second.Send(20) == nil, "failed to send")
+	_ = second.CloseSend()
+	_ = first.CloseSend()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var a, b int
+		err1, err2 := first.Recv(&a), second.Recv(&b)
+		_assert(err1 == nil && a == 11 && err2 == nil && b == 22, "unexpected sums %d %v, %d %v", a, err1, b, err2)
+		var reply int
+		err := client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_assert(err == nil && reply == 3, "expect unary calls to keep working, got %v", err)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("concurrent client streams should not deadlock the connection")
+	}
+}
+
+// Stall 的方法在流被重置之前不读取客户端发送的数据
+type Stall struct{}
+
+func (Stall) Hold(n int, stream *ServerStream) error {
+	<-stream.aborted
+	var v int
+	for {
+		if err := stream.Recv(&v); err != nil {
+			return err
+		}
+	}
+}
+
+func TestServer_StreamWindowExceeded(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	_ = server.Register(Stall{})
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	stream, err := client.NewBidiStream(context.Background(), "Stall.Hold", 0, new(int))
+	_assert(err == nil, "failed to open stream: %v", err)
+	// 绕过发送窗口，发送比窗口更多的数据
+	for i := 0; i <= streamWindow; i++ {
+		err := client.write(&codec.Header{ServiceMethod: stream.call.ServiceMethod, Seq: stream.call.Seq, Flags: codec.FlagStream}, i)
+		_assert(err == nil, "failed to write frame %d: %v", i, err)
+	}
+	var v int
+	err = stream.Recv(&v)
+	_assert(err != nil && strings.Contains(err.Error(), ErrStreamWindowExceeded.Error()), "expect the stream to be reset, got %v", err)
+
+	// 连接上的其他调用不受影响
+	var reply int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "expect the connection to keep working, got %v", err)
+}
+
+type Waiter chan error
+
+func (w Waiter) Wait(ctx context.Context, ms int, reply *int) error {
+	select {
+	case <-ctx.Done():
+		w <- ctx.Err()
+		return ctx.Err()
+	case <-time.After(time.Duration(ms) * time.Millisecond):
+		w <- nil
+		return nil
+	}
+}
+
+func TestServer_Cancel(t *testing.T) {
+	server := NewServer()
+	waiter := make(Waiter, 1)
+	_ = server.Register(waiter)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	// 不设置截止时间，服务端只能通过取消帧知道客户端放弃了
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	var reply int
+	err := client.Call(ctx, "Waiter.Wait", 2000, &reply)
+	_assert(err != nil, "expect a cancellation error")
+	select {
+	case err := <-waiter:
+		_assert(errors.Is(err, context.Canceled), "handler should see the cancellation, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("handler should be canceled by the client")
+	}
+	err = client.Call(context.Background(), "Waiter.Wait", 1, &reply)
+	_assert(err == nil && <-waiter == nil, "connection should still work after cancellation: %v", err)
+}
+
+type Version string
+
+func (v *Version) Get(_ int, reply *string) error {
+	*reply = string(*v)
+	return nil
+}
+
+func TestServer_ReplaceService(t *testing.T) {
+	server := NewServer()
+	var slow Slow
+	v1, v2 := Version("v1"), Version("v2")
+	_ = server.Register(&slow)
+	_ = server.Register(&v1)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	_ = client.Call(context.Background(), "Version.Get", 0, &reply)
+	_assert(reply == "v1", "expect v1, got %s", reply)
+	_assert(server.ReplaceService(&v2) == nil, "failed to replace service")
+	_ = client.Call(context.Background(), "Version.Get", 0, &reply)
+	_assert(reply == "v2", "expect v2 after replacing, got %s", reply)
+
+	// 已经开始处理的请求在删除服务之后仍然正常完成
+	var r int
+	call := client.Go("Slow.Sleep", 100, &r, nil)
+	time.Sleep(20 * time.Millisecond)
+	_assert(server.Unregister("Slow") == nil, "failed to unregister")
+	<-call.Done
+	_assert(call.Error == nil, "in-flight request should complete: %v", call.Error)
+	err := client.Call(context.Background(), "Slow.Sleep", 1, &r)
+	_assert(err != nil && strings.Contains(err.Error(), "can't find service"), "expect unknown service, got %v", err)
+	_assert(server.Unregister("Slow") != nil, "unregister twice should fail")
+}
+
+func TestServer_RegisterName(t *testing.T) {
+	server := NewServer()
+	v1, v2 := Version("v1"), Version("v2")
+	_ = server.Register(&v1)
+	_assert(server.RegisterName("Version.v2", &v2) == nil, "failed to register by name")
+	_assert(server.RegisterName("Alias", &v1) == nil, "failed to register alias")
+	_assert(server.RegisterName("Alias", &v2) != nil, "duplicate name should be rejected")
+	_assert(server.RegisterName("bad name", &v2) != nil, "invalid name should be rejected")
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	for method, expect := range map[string]string{"Version.Get": "v1", "Version.v2.Get": "v2", "Alias.Get": "v1"} {
+		var reply string
+		err := client.Call(context.Background(), method, 0, &reply)
+		_assert(err == nil && reply == expect, "%s: expect %s, got %s %v", method, expect, reply, err)
+	}
+}
+
+func TestServer_RegisterFunc(t *testing.T) {
+	server := NewServer()
+	prefix := "hello, "
+	_assert(server.RegisterFunc("Greeter.Hello", func(name string, reply *string) error {
+		*reply = prefix + name
+		return nil
+	}) == nil, "failed to register func")
+	_assert(server.RegisterFunc("Greeter", func(string, *string) error { return nil }) != nil, "ill-formed name should be rejected")
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err := client.Call(context.Background(), "Greeter.Hello", "geerpc", &reply)
+	_assert(err == nil && reply == "hello, geerpc", "failed to call func: %s %v", reply, err)
+}
+
+func TestServer_JSONRPC(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	var counter Counter
+	_ = server.Register(&foo)
+	_ = server.Register(&counter)
+	_ = server.RegisterFunc("Math.Fail", func(n int, reply *int) error { return errors.New("always fail") })
+	post := func(body string) (int, string) {
+		w := httptest.NewRecorder()
+		server.JSONRPCHandler().ServeHTTP(w, httptest.NewRequest("POST", defaultJSONRPCPath, strings.NewReader(body)))
+		return w.Code, strings.TrimSpace(w.Body.String())
+	}
+
+	cases := []struct{ body, expect string }{
+		{`{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1,"Num2":2},"id":1}`, `{"jsonrpc":"2.0","result":3,"id":1}`},
+		{`{"jsonrpc":"2.0","method":"Foo.Sum","params":[{"Num1":3,"Num2":4}],"id":"a"}`, `{"jsonrpc":"2.0","result":7,"id":"a"}`},
+		{`{"jsonrpc":"2.0","method":"Foo.Nope","id":2}`, `"code":-32601`},
+		{`{"jsonrpc":"2.0","method":"Counter.Count","params":1,"id":2}`, `"code":-32601`},
+		{`{"jsonrpc":"2.0","method":"Foo.Sum","params":"x","id":3}`, `"code":-32602`},
+		{`{"jsonrpc":"2.0","method":"Math.Fail","params":1,"id":4}`, `{"jsonrpc":"2.0","error":{"code":-32000,"message":"always fail"},"id":4}`},
+		{`{"method":"Foo.Sum","id":5}`, `"code":-32600`},
+		{`{"jsonrpc":"2.0",`, `"code":-32700`},
+		{`[{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1},"id":1},{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1}}]`, `[{"jsonrpc":"2.0","result":1,"id":1}]`},
+	}
+	for _, c := range cases {
+		code, body := post(c.body)
+		_assert(code == http.StatusOK && strings.Contains(body, c.expect), "%s: expect %s, got %d %s", c.body, c.expect, code, body)
+	}
+
+	code, _ := post(`{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1}}`)
+	_assert(code == http.StatusNoContent, "notification shouldn't be answered, got %d", code)
+	w := httptest.NewRecorder()
+	server.JSONRPCHandler().ServeHTTP(w, httptest.NewRequest("GET", defaultJSONRPCPath, nil))
+	_assert(w.Code == http.StatusMethodNotAllowed, "expect 405, got %d", w.Code)
+}
+
+func TestServer_JSONRPCAdmission(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	server.AuthFunc = func(token string, conn io.ReadWriteCloser) error {
+		if token != "secret" {
+			return errors.New("invalid token")
+		}
+		return nil
+	}
+	server.IdentityFunc = func(token string) string { return "user-" + token }
+	_ = server.RegisterFunc("Who.Am", func(ctx context.Context, n int, reply *string) error {
+		info, _ := ConnInfoFromContext(ctx)
+		*reply = info.Identity
+		return nil
+	})
+	_ = server.RegisterFunc("Slow.Sleep", func(ctx context.Context, n int, reply *int) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	server.SetTimeout("Slow", 50*time.Millisecond)
+	server.SetRateLimiter("Foo.Sum", NewTokenBucket(0, 1))
+	server.MaxHTTPBody = 256
+	post := func(body, token string) (int, string) {
+		req := httptest.NewRequest("POST", defaultJSONRPCPath, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set(AuthHeader, "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		server.JSONRPCHandler().ServeHTTP(w, req)
+		return w.Code, strings.TrimSpace(w.Body.String())
+	}
+
+	code, body := post(`{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1,"Num2":2},"id":1}`, "")
+	_assert(code == http.StatusUnauthorized && !strings.Contains(body, "result"), "expect 401 without a token, got %d %s", code, body)
+	code, _ = post(`{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1,"Num2":2},"id":1}`, "guess")
+	_assert(code == http.StatusUnauthorized, "expect 401 with a wrong token, got %d", code)
+	code, body = post(`{"jsonrpc":"2.0","method":"Who.Am","params":1,"id":1}`, "secret")
+	_assert(code == http.StatusOK && strings.Contains(body, `"result":"user-secret"`), "expect the identity, got %d %s", code, body)
+
+	// 限流、HandleTimeout 和 body 大小限制和普通连接一样生效
+	_, body = post(`{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1,"Num2":2},"id":1}`, "secret")
+	_assert(strings.Contains(body, `"result":3`), "expect the first call to pass the limiter, got %s", body)
+	_, body = post(`{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1,"Num2":2},"id":2}`, "secret")
+	_assert(strings.Contains(body, ErrRateLimited.Error()), "expect rate limited, got %s", body)
+	_, body = post(`{"jsonrpc":"2.0","method":"Slow.Sleep","params":1,"id":3}`, "secret")
+	_assert(strings.Contains(body, "handle timeout"), "expect handle timeout, got %s", body)
+	code, _ = post(`{"jsonrpc":"2.0","method":"Who.Am","params":1,"id":4,"pad":"`+strings.Repeat("x", 512)+`"}`, "secret")
+	_assert(code == http.StatusRequestEntityTooLarge, "expect 413, got %d", code)
+
+	filter, _ := NewIPFilter(nil, []string{"192.0.2.0/24"})
+	server.IPFilter = filter
+	code, _ = post(`{"jsonrpc":"2.0","method":"Who.Am","params":1,"id":5}`, "secret")
+	_assert(code == http.StatusForbidden, "expect the ip filter to reject httptest's 192.0.2.1, got %d", code)
+}
+
+func TestGateway(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	_ = server.RegisterFunc("Math.Fail", func(n int, reply *int) error { return errors.New("always fail") })
+	_ = server.RegisterFunc("Math.FailWith", func(code int, reply *int) error { return Errorf(Code(code), "fail with %d", code) })
+	_ = server.RegisterFunc("Math.Busy", func(n int, reply *int) error { return ErrServerBusy })
+	_ = server.RegisterFunc("Limited.Call", func(n int, reply *int) error { return nil })
+	_ = server.RegisterFunc("Slow.Sleep", func(ctx context.Context, n int, reply *int) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	_ = server.RegisterFunc("Secret.Get", func(n int, reply *int) error { return nil })
+	server.SetRateLimiter("Limited", NewTokenBucket(0, 0))
+	server.SetTimeout("Slow", 20*time.Millisecond)
+	server.Authorize = func(ctx context.Context, identity, serviceMethod string) error {
+		if strings.HasPrefix(serviceMethod, "Secret.") {
+			return errors.New("secret")
+		}
+		return nil
+	}
+	gateway := NewGateway(server, "/rpc/")
+	do := func(method, path, body string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		gateway.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/rpc/Foo/Sum", `{"Num1":1,"Num2":2}`, map[string]string{"Content-Type": "application/json; charset=utf-8"})
+	_assert(w.Code == http.StatusOK && strings.TrimSpace(w.Body.String()) == "3", "expect 3, got %d %s", w.Code, w.Body)
+	_assert(w.Header().Get("Content-Type") == "application/json", "expect json, got %s", w.Header().Get("Content-Type"))
+
+	w = do("POST", "/rpc/Foo/Sum", `{"Num1":1,"Num2":2}`, map[string]string{"Accept": "text/html, application/x-gob"})
+	var reply int
+	err := gob.NewDecoder(w.Body).Decode(&reply)
+	_assert(err == nil && reply == 3, "expect gob 3, got %d %v", reply, err)
+
+	cases := []struct {
+		method, path, body string
+		header             map[string]string
+		status             int
+	}{
+		{"POST", "/rpc/Foo/Nope", `{}`, nil, http.StatusNotFound},
+		{"POST", "/rpc/Foo", `{}`, nil, http.StatusNotFound},
+		{"POST", "/other/Foo/Sum", `{}`, nil, http.StatusNotFound},
+		{"POST", "/rpc/Foo/Sum", `"x"`, nil, http.StatusBadRequest},
+		{"POST", "/rpc/Math/Fail", `1`, nil, http.StatusInternalServerError},
+		{"POST", "/rpc/Math/Busy", `1`, nil, http.StatusServiceUnavailable},
+		{"POST", "/rpc/Limited/Call", `1`, nil, http.StatusTooManyRequests},
+		{"POST", "/rpc/Slow/Sleep", `1`, nil, http.StatusGatewayTimeout},
+		{"POST", "/rpc/Secret/Get", `1`, nil, http.StatusForbidden},
+		{"POST", "/rpc/Math/FailWith", strconv.Itoa(int(CodeUnauthenticated)), nil, http.StatusUnauthorized},
+		{"POST", "/rpc/Math/FailWith", strconv.Itoa(int(CodeInvalidArgument)), nil, http.StatusBadRequest},
+		{"POST", "/rpc/Math/FailWith", strconv.Itoa(int(CodeNotFound)), nil, http.StatusNotFound},
+		{"POST", "/rpc/Math/FailWith", strconv.Itoa(int(CodeInternal)), nil, http.StatusInternalServerError},
+		{"POST", "/rpc/Foo/Sum", `{}`, map[string]string{"Content-Type": "text/xml"}, http.StatusUnsupportedMediaType},
+		{"POST", "/rpc/Foo/Sum", `{}`, map[string]string{"Accept": "text/html"}, http.StatusNotAcceptable},
+		{"GET", "/rpc/Foo/Sum", ``, nil, http.StatusMethodNotAllowed},
+	}
+	for _, c := range cases {
+		w := do(c.method, c.path, c.body, c.header)
+		_assert(w.Code == c.status, "%s %s: expect %d, got %d %s", c.method, c.path, c.status, w.Code, w.Body)
+		_assert(strings.Contains(w.Body.String(), `"error":{"code"`), "error should be json: %s", w.Body)
+	}
+
+	// 网关和普通连接一样需要鉴权，body 的大小也有限制
+	server.AuthFunc = func(token string, conn io.ReadWriteCloser) error {
+		if token != "secret" {
+			return errors.New("invalid token")
+		}
+		return nil
+	}
+	server.MaxHTTPBody = 64
+	w = do("POST", "/rpc/Foo/Sum", `{"Num1":1,"Num2":2}`, nil)
+	_assert(w.Code == http.StatusUnauthorized && strings.TrimSpace(w.Body.String()) != "3", "expect 401 without a token, got %d %s", w.Code, w.Body)
+	w = do("POST", "/rpc/Foo/Sum", `{"Num1":1,"Num2":2}`, map[string]string{AuthHeader: "Bearer secret"})
+	_assert(w.Code == http.StatusOK && strings.TrimSpace(w.Body.String()) == "3", "expect 3 with a token, got %d %s", w.Code, w.Body)
+	w = do("POST", "/rpc/Foo/Sum", `{"Num1":1,"Num2":2,"Pad":"`+strings.Repeat("x", 128)+`"}`, map[string]string{AuthHeader: "Bearer secret"})
+	_assert(w.Code == http.StatusRequestEntityTooLarge, "expect 413, got %d", w.Code)
+}
+
+func TestServer_HandleHTTPOn(t *testing.T) {
+	mux := http.NewServeMux()
+	for _, name := range []string{"a", "b"} {
+		server := NewServer()
+		_ = server.RegisterFunc("Who.Am", func(_ int, reply *string) error {
+			*reply = name
+			return nil
+		})
+		server.HandleHTTPOn(mux, "/rpc/"+name, "/debug/"+name)
+	}
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go func() { _ = http.Serve(l, mux) }()
+
+	for _, name := range []string{"a", "b"} {
+		client, err := DialHTTP("tcp", l.Addr().String(), &Option{RPCPath: "/rpc/" + name})
+		_assert(err == nil, "failed to dial %s: %v", name, err)
+		var reply string
+		err = client.Call(context.Background(), "Who.Am", 0, &reply)
+		_assert(err == nil && reply == name, "expect %s, got %s %v", name, reply, err)
+		_ = client.Close()
+
+		resp, err := http.Get("http://" + l.Addr().String() + "/debug/" + name)
+		_assert(err == nil && resp.StatusCode == http.StatusOK, "debug page of %s should be served: %v", name, err)
+		_ = resp.Body.Close()
+
+		// JSON-RPC 需要单独注册，不会被自动暴露
+		resp, err = http.Post("http://"+l.Addr().String()+"/rpc/"+name+"/jsonrpc", "application/json",
+			strings.NewReader(`{"jsonrpc":"2.0","method":"Who.Am","params":0,"id":1}`))
+		_assert(err == nil && resp.StatusCode == http.StatusNotFound, "jsonrpc of %s shouldn't be mounted: %v", name, err)
+		_ = resp.Body.Close()
+	}
+	_, err := DialHTTP("tcp", l.Addr().String(), &Option{RPCPath: "/rpc/c"})
+	_assert(err != nil, "unknown path should be rejected")
+}
+
+func TestServer_EnableRegistration(t *testing.T) {
+	ts := httptest.NewServer(registry.New(0))
+	defer ts.Close()
+	server := NewServer()
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := "tcp@" + l.Addr().String()
+	server.EnableRegistration(ts.URL, addr, 30*time.Millisecond)
+	accepted := make(chan struct{})
+	go func() {
+		server.Accept(l)
+		close(accepted)
+	}()
+
+	waitFor := func(expect string) {
+		deadline := time.Now().Add(time.Second)
+		for {
+			servers, _ := registry.Lookup(ts.URL)
+			if got := strings.Join(servers, ","); got == expect {
+				return
+			} else if time.Now().After(deadline) {
+				t.Fatalf("expect %q, got %q", expect, got)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor(addr)
+
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("")
+	select {
+	case <-accepted:
+	case <-time.After(time.Second):
+		t.Fatal("Accept should return after Shutdown")
+	}
+	// Shutdown 之后心跳已经停止，不会重新注册
+	time.Sleep(50 * time.Millisecond)
+	waitFor("")
+	if err := server.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServer_ReadWriteTimeout(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	serve := func(conn net.Conn) chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			server.ServerConn(conn)
+			close(done)
+		}()
+		return done
+	}
+	waitClosed := func(done chan struct{}, msg string) {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal(msg)
+		}
+	}
+
+	// 对端一直不发送数据
+	server.ReadTimeout = 50 * time.Millisecond
+	conn, peer := net.Pipe()
+	defer func() { _ = peer.Close() }()
+	waitClosed(serve(conn), "connection should be closed when the peer sends nothing")
+
+	// 对端发送了请求但是一直不读取响应
+	server.ReadTimeout, server.WriteTimeout = 0, 50*time.Millisecond
+	conn, peer = net.Pipe()
+	defer func() { _ = peer.Close() }()
+	done := serve(conn)
+	_ = json.NewEncoder(peer).Encode(DefaultOption)
+	cc := codec.NewGobCodec(peer)
+	_ = cc.Write(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 1}, Args{Num1: 1, Num2: 2})
+	waitClosed(done, "connection should be closed when the peer does not read responses")
+}
+
+func TestServer_Serve(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	call := func(rpcAddr string) error {
+		client, err := XDial(rpcAddr)
+		if err != nil {
+			return err
+		}
+		defer func() { _ = client.Close() }()
+		var reply int
+		return client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	}
+	// freeAddr 返回一个当前没有被占用的地址
+	freeAddr := func() string {
+		l, _ := net.Listen("tcp", "127.0.0.1:0")
+		defer func() { _ = l.Close() }()
+		return l.Addr().String()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	errc := make(chan error, 1)
+	go func() { errc <- server.Serve(ctx, l) }()
+	if err := call("tcp@" + l.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if err := <-errc; err != nil {
+		t.Fatalf("expect nil after ctx is done, got %v", err)
+	}
+	if err := call("tcp@" + l.Addr().String()); err == nil {
+		t.Fatal("listener should be closed after ctx is done")
+	}
+
+	for _, c := range []struct {
+		serve     func(addr string, opts ...ServeOption) error
+		protocols []string
+	}{
+		{ListenAndServe, []string{"tcp"}},
+		{ListenAndServeHTTP, []string{"http", "h2c"}},
+	} {
+		ctx, cancel := context.WithCancel(context.Background())
+		addr := freeAddr()
+		go func() { errc <- c.serve(addr, WithServer(server), WithContext(ctx)) }()
+		for _, protocol := range c.protocols {
+			var err error
+			for i := 0; i < 100; i++ {
+				if err = call(protocol + "@" + addr); err == nil {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", protocol, err)
+			}
+		}
+		cancel()
+		if err := <-errc; err != nil {
+			t.Fatalf("expect nil after ctx is done, got %v", err)
+		}
+	}
+}
+
+func TestServer_InvalidHandshake(t *testing.T) {
+	server := NewServer()
+	server.SetLogger(NewLogger(LevelOff))
+	server.HandshakeTimeout = 50 * time.Millisecond
+	for _, c := range []struct {
+		name, input string
+		reply       string
+	}{
+		{"http", "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", "HTTP/1.0 400 Bad Request"},
+		{"garbage", "\x00\x01\x02\x03", ""},
+		{"partial json", `{"MagicNumber":`, ""},
+		{"silent", "", ""},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			conn, peer := net.Pipe()
+			done := make(chan struct{})
+			go func() {
+				server.ServerConn(conn)
+				close(done)
+			}()
+			go func() { _, _ = io.WriteString(peer, c.input) }()
+			reply, _ := io.ReadAll(peer)
+			if !strings.HasPrefix(string(reply), c.reply) {
+				t.Fatalf("expect reply %q, got %q", c.reply, reply)
+			}
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("connection should be closed")
+			}
+		})
+	}
+}
+
+// FuzzServerConn 任意的输入都不能让服务端 panic，对端关闭连接之后 ServerConn 需要及时返回
+func FuzzServerConn(f *testing.F) {
+	var valid bytes.Buffer
+	_ = json.NewEncoder(&valid).Encode(DefaultOption)
+	cc := codec.NewGobCodec(nopCloser{&valid})
+	_ = cc.Write(&codec.Header{ServiceMethod: "Foo.Sum", Seq: 1}, Args{Num1: 1, Num2: 2})
+	f.Add(valid.Bytes())
+	f.Add(valid.Bytes()[:valid.Len()/2])
+	f.Add([]byte("GET / HTTP/1.1\r\n\r\n"))
+	f.Add([]byte(`{"MagicNumber":3927900,"CodecType":"application/gob"}` + "\n\x00\x01\x02"))
+	f.Add([]byte{0xff, 0xfe, 0xfd})
+
+	server := NewServer()
+	server.SetLogger(NewLogger(LevelOff))
+	server.HandshakeTimeout = 100 * time.Millisecond
+	server.ReadTimeout = 100 * time.Millisecond
+	var foo Foo
+	_ = server.Register(&foo)
+	f.Fuzz(func(t *testing.T, input []byte) {
+		conn, peer := net.Pipe()
+		done := make(chan struct{})
+		go func() {
+			server.ServerConn(conn)
+			close(done)
+		}()
+		go func() { _, _ = io.Copy(io.Discard, peer) }()
+		_, _ = peer.Write(input)
+		_ = peer.Close()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("connection is not closed for input %q", input)
+		}
+	})
+}
+
+type nopCloser struct {
+	io.ReadWriter
+}
+
+func (nopCloser) Close() error { return nil }
+
+func TestServer_ConnHooks(t *testing.T) {
+	type userKey struct{}
+	server := NewServer()
+	var mu sync.Mutex
+	conns := make(map[string]int) // 每个 IP 的连接数
+	disconnected := make(chan *ConnInfo, 2)
+	server.OnConnect = func(info *ConnInfo) error {
+		host, _, _ := net.SplitHostPort(info.RemoteAddr)
+		mu.Lock()
+		defer mu.Unlock()
+		if conns[host] >= 1 {
+			return errors.New("too many connections from " + host)
+		}
+		conns[host]++
+		info.Set(userKey{}, "user-"+strconv.Itoa(len(conns)))
+		return nil
+	}
+	server.OnDisconnect = func(info *ConnInfo) {
+		host, _, _ := net.SplitHostPort(info.RemoteAddr)
+		mu.Lock()
+		conns[host]--
+		mu.Unlock()
+		disconnected <- info
+	}
+	_ = server.RegisterFunc("Conn.User", func(ctx context.Context, _ int, reply *string) error {
+		info, ok := ConnInfoFromContext(ctx)
+		if !ok {
+			return errors.New("no conn info")
+		}
+		*reply, _ = info.Value(userKey{}).(string)
+		return nil
+	})
+	addr := startTestServer(server)
+
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "failed to dial: %v", err)
+	var user string
+	err = client.Call(context.Background(), "Conn.User", 0, &user)
+	_assert(err == nil && user == "user-1", "expect user-1 from the conn info, got %q %v", user, err)
+
+	// 同一个 IP 的第二个连接被拒绝
+	if c, err := Dial("tcp", addr, &Option{ConnectTimeout: time.Second}); err == nil {
+		err = c.Call(context.Background(), "Conn.User", 0, &user)
+		_ = c.Close()
+		_assert(err != nil, "second connection from the same ip should be rejected")
+	}
+
+	_ = client.Close()
+	select {
+	case info := <-disconnected:
+		_assert(info.Value(userKey{}) == "user-1", "OnDisconnect should receive the same conn info")
+	case <-time.After(time.Second):
+		t.Fatal("OnDisconnect should be called after the client closes")
+	}
+	client, err = Dial("tcp", addr)
+	_assert(err == nil, "failed to dial after the first connection is closed: %v", err)
+	_ = client.Close()
+}
+
+func TestServer_RequestID(t *testing.T) {
+	server := NewServer()
+	logger := &testLogger{}
+	server.SetLogger(logger)
+	ids := make(chan string, 2)
+	_ = server.RegisterFunc("Log.Echo", func(ctx context.Context, msg string, reply *string) error {
+		ids <- RequestIDFromContext(ctx)
+		LoggerFromContext(ctx).Infof("echo %s", msg)
+		*reply = msg
+		return nil
+	})
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err := client.Call(context.Background(), "Log.Echo", "hello", &reply, WithMetadata(map[string]string{RequestIDKey: "req-42"}))
+	_assert(err == nil && <-ids == "req-42", "request id in metadata should be used, err %v", err)
+	err = client.Call(context.Background(), "Log.Echo", "world", &reply)
+	id := <-ids
+	_assert(err == nil && id != "" && id != "req-42", "client should generate a request id, got %q", id)
+	logger.mu.Lock()
+	lines := strings.Join(logger.lines, "\n")
+	logger.mu.Unlock()
+	_assert(strings.Contains(lines, "[req-42] echo hello") && strings.Contains(lines, "["+id+"] echo world"),
+		"logs should contain request ids, got:\n%s", lines)
+
+	// 没有请求 ID 的请求由服务端生成，并在回复中返回
+	conn, peer := net.Pipe()
+	go server.ServerConn(conn)
+	_ = json.NewEncoder(peer).Encode(DefaultOption)
+	cc := codec.NewGobCodec(peer)
+	go func() { _ = cc.Write(&codec.Header{ServiceMethod: "Log.Echo", Seq: 1}, "raw") }()
+	var h codec.Header
+	_assert(cc.ReadHeader(&h) == nil && cc.ReadBody(&reply) == nil, "failed to read the response")
+	_assert(h.Metadata[RequestIDKey] != "" && h.Metadata[RequestIDKey] == <-ids, "response should echo the generated request id, got %v", h.Metadata)
+	_ = cc.Close()
+}
+
+func TestServer_IPFilter(t *testing.T) {
+	_, err := NewIPFilter([]string{"10.0.0.0/33"}, nil)
+	_assert(err != nil, "invalid rule should be rejected")
+	f, err := NewIPFilter([]string{"10.0.0.0/8", "192.168.1.1", "::1"}, []string{"10.1.0.0/16"})
+	_assert(err == nil, "failed to parse rules: %v", err)
+	for addr, allowed := range map[string]bool{
+		"10.2.3.4":         true,
+		"10.1.2.3":         false, // Deny 优先
+		"192.168.1.1":      true,
+		"192.168.1.2":      false,
+		"::ffff:10.2.3.4":  true,
+		"::1":              true,
+		"2001:db8::1":      false,
+		"::ffff:10.1.0.10": false,
+	} {
+		_assert(f.Allowed(netip.MustParseAddr(addr)) == allowed, "%s: expect allowed %v", addr, allowed)
+	}
+
+	server := NewServer()
+	logger := &testLogger{}
+	server.SetLogger(logger)
+	var foo Foo
+	_ = server.Register(&foo)
+	server.IPFilter, _ = NewIPFilter(nil, []string{"127.0.0.0/8"})
+	addr := startTestServer(server)
+	var reply int
+	client, err := Dial("tcp", addr, &Option{ConnectTimeout: time.Second})
+	if err == nil {
+		err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+		_ = client.Close()
+	}
+	_assert(err != nil, "connection from a denied address should be closed")
+	logger.mu.Lock()
+	lines := strings.Join(logger.lines, "\n")
+	logger.mu.Unlock()
+	_assert(strings.Contains(lines, ErrIPRejected.Error()+": 127.0.0.1:"), "rejected peer should be logged, got:\n%s", lines)
+
+	server = NewServer()
+	_ = server.Register(&foo)
+	server.IPFilter, _ = NewIPFilter([]string{"127.0.0.1"}, nil)
+	client, err = Dial("tcp", startTestServer(server))
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "allowed address should be served: %v", err)
+}
+
+type PositiveArgs struct {
+	Num int
+}
+
+func (a *PositiveArgs) Validate() error {
+	if a.Num <= 0 {
+		return errors.New("num must be positive")
+	}
+	return nil
+}
+
+func TestServer_Validator(t *testing.T) {
+	server := NewServer()
+	var called int32
+	_ = server.RegisterFunc("Valid.Double", func(args PositiveArgs, reply *int) error {
+		atomic.AddInt32(&called, 1)
+		*reply = args.Num * 2
+		return nil
+	})
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err := client.Call(context.Background(), "Valid.Double", PositiveArgs{Num: 2}, &reply)
+	_assert(err == nil && reply == 4, "valid argument should be handled: %v", err)
+	err = client.Call(context.Background(), "Valid.Double", PositiveArgs{Num: -1}, &reply)
+	_assert(CodeOf(err) == CodeInvalidArgument && strings.Contains(err.Error(), "num must be positive"),
+		"expect invalid argument, got %v", err)
+	_assert(atomic.LoadInt32(&called) == 1, "handler should not be called for an invalid argument")
+}
+
+func TestServer_SetCache(t *testing.T) {
+	server := NewServer()
+	var calls int32
+	_ = server.RegisterFunc("Cache.Square", func(n int, reply *int) error {
+		atomic.AddInt32(&calls, 1)
+		*reply = n * n
+		return nil
+	})
+	server.SetCache("Cache.Square", &CachePolicy{TTL: 50 * time.Millisecond, Size: 2})
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+	square := func(n int) {
+		var reply int
+		err := client.Call(context.Background(), "Cache.Square", n, &reply)
+		_assert(err == nil && reply == n*n, "expect %d, got %d %v", n*n, reply, err)
+	}
+	expectCalls := func(n int32, msg string) {
+		_assert(atomic.LoadInt32(&calls) == n, "%s: expect %d calls, got %d", msg, n, atomic.LoadInt32(&calls))
+	}
+
+	square(2)
+	square(2)
+	expectCalls(1, "second call should hit the cache")
+	square(3)
+	square(4) // 淘汰最久没有使用的 2
+	square(3)
+	expectCalls(3, "3 should still be cached")
+	square(2)
+	expectCalls(4, "2 should be evicted")
+
+	time.Sleep(60 * time.Millisecond)
+	square(2)
+	expectCalls(5, "expired result should not be used")
+
+	server.SetCache("Cache.Square", nil)
+	square(2)
+	expectCalls(6, "cache should be disabled")
+}
+
+func TestServer_DuplicateSeq(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_ = server.Register(&foo)
+	conn, err := net.Dial("tcp", startTestServer(server))
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = conn.Close() }()
+	_ = json.NewEncoder(conn).Encode(DefaultOption)
+	cc := codec.NewGobCodec(conn)
+	call := func(seq uint64) (*codec.Header, int) {
+		_ = cc.Write(&codec.Header{ServiceMethod: "Foo.Sum", Seq: seq}, Args{Num1: 1, Num2: 2})
+		var h codec.Header
+		var reply int
+		_ = cc.ReadHeader(&h)
+		_ = cc.ReadBody(&reply)
+		return &h, reply
+	}
+	h, reply := call(1)
+	_assert(h.Error == "" && reply == 3, "expect 3, got %d %s", reply, h.Error)
+	h, _ = call(1)
+	_assert(h.Seq == 1 && h.Error == ErrDuplicateSeq.Error(), "expect duplicate seq error, got %q", h.Error)
+	h, reply = call(2)
+	_assert(h.Error == "" && reply == 3, "expect 3, got %d %s", reply, h.Error)
+	// 到达最大值之后回到开头的 Seq 不算回退
+	h, reply = call(math.MaxUint64 - 1)
+	_assert(h.Error == "" && reply == 3, "expect 3, got %d %s", reply, h.Error)
+	h, reply = call(1)
+	_assert(h.Error == "" && reply == 3, "expect wrapped seq to be accepted, got %d %s", reply, h.Error)
+}
+
+func TestServer_IdempotencyKey(t *testing.T) {
+	server := NewServer()
+	var calls int32
+	_ = server.RegisterFunc("Order.Create", func(n int, reply *int) error {
+		*reply = int(atomic.AddInt32(&calls, 1))
+		return nil
+	})
+	addr := startTestServer(server)
+	create := func(key string) int {
+		// 每次调用使用新的连接，模拟客户端重连之后重试
+		client, _ := Dial("tcp", addr)
+		defer func() { _ = client.Close() }()
+		var reply int
+		err := client.Call(context.Background(), "Order.Create", 1, &reply, WithIdempotencyKey(key))
+		_assert(err == nil, "call error: %v", err)
+		return reply
+	}
+	_assert(create("a") == 1, "first call should be executed")
+	_assert(create("a") == 1, "retried call should return the original result")
+	_assert(create("b") == 2, "call with another key should be executed")
+	_assert(create("") == 3, "call without key should be executed")
+	_assert(atomic.LoadInt32(&calls) == 3, "expect 3 calls, got %d", atomic.LoadInt32(&calls))
+
+	server.IdempotencyTTL = 20 * time.Millisecond
+	_assert(create("c") == 4, "first call should be executed")
+	time.Sleep(30 * time.Millisecond)
+	_assert(create("c") == 5, "expired result should not be used")
+
+	// 不同身份的调用方使用相同的幂等键互不影响
+	server.IdempotencyTTL = 0
+	server.IdentityFunc = func(token string) string { return token }
+	createAs := func(token, key string) int {
+		client, _ := Dial("tcp", addr, &Option{AuthToken: token})
+		defer func() { _ = client.Close() }()
+		var reply int
+		err := client.Call(context.Background(), "Order.Create", 1, &reply, WithIdempotencyKey(key))
+		_assert(err == nil, "call error: %v", err)
+		return reply
+	}
+	_assert(createAs("alice", "order-1") == 6, "alice's call should be executed")
+	_assert(createAs("bob", "order-1") == 7, "bob shouldn't get alice's result")
+	_assert(createAs("alice", "order-1") == 6, "alice's retry should return her result")
+}
+
+func TestServer_IdleTimeout(t *testing.T) {
+	server := NewServer()
+	server.IdleTimeout = 50 * time.Millisecond
+	_ = server.RegisterFunc("Slow.Sleep", func(d time.Duration, reply *int) error {
+		time.Sleep(d)
+		return nil
+	})
+	disconnected := make(chan struct{})
+	server.OnDisconnect = func(*ConnInfo) { close(disconnected) }
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	// 正在处理的请求不算空闲
+	var reply int
+	err := client.Call(context.Background(), "Slow.Sleep", 150*time.Millisecond, &reply)
+	_assert(err == nil, "slow call should succeed, got %v", err)
+	_assert(client.IsAvailable(), "connection with in-flight calls should not be reaped")
+
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatal("idle connection should be closed")
+	}
+	time.Sleep(10 * time.Millisecond)
+	_assert(!client.IsAvailable(), "client should see the connection closed")
+}
+
+func TestServer_Drain(t *testing.T) {
+	server := NewServer()
+	started, release := make(chan struct{}), make(chan struct{})
+	_ = server.RegisterFunc("Slow.Wait", func(_ int, reply *int) error {
+		close(started)
+		<-release
+		*reply = 1
+		return nil
+	})
+	addr := startTestServer(server)
+	busy, _ := Dial("tcp", addr)
+	defer func() { _ = busy.Close() }()
+	idle, _ := Dial("tcp", addr)
+	defer func() { _ = idle.Close() }()
+
+	call := busy.Go("Slow.Wait", 0, new(int), nil)
+	<-started
+	drained := make(chan error, 1)
+	go func() { drained <- server.Drain(context.Background()) }()
+	time.Sleep(50 * time.Millisecond)
+	_assert(!idle.IsAvailable(), "idle connection should be closed")
+	select {
+	case err := <-drained:
+		t.Fatalf("drain should wait for in-flight calls, got %v", err)
+	default:
+	}
+	close(release)
+	_assert((<-call.Done).Error == nil, "in-flight call should complete")
+	select {
+	case err := <-drained:
+		_assert(err == nil, "drain error: %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("drain should return after in-flight calls complete")
+	}
+	_, err := Dial("tcp", addr)
+	_assert(err != nil, "drained server should not accept new connections")
+
+	// ctx 结束时强制关闭
+	server = NewServer()
+	stop := make(chan struct{})
+	defer close(stop)
+	_ = server.RegisterFunc("Slow.Block", func(_ int, _ *int) error {
+		<-stop
+		return nil
+	})
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+	call = client.Go("Slow.Block", 0, new(int), nil)
+	time.Sleep(20 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = server.Drain(ctx)
+	_assert(errors.Is(err, context.DeadlineExceeded), "expect deadline exceeded, got %v", err)
+	_assert((<-call.Done).Error != nil, "blocked call should fail after forced close")
+}
+
+func TestServer_Restart(t *testing.T) {
+	newServer := func() *Server {
+		server := NewServer()
+		_ = server.RegisterFunc("Proc.Pid", func(_ int, pid *int) error {
+			*pid = os.Getpid()
+			return nil
+		})
+		return server
+	}
+	if os.Getenv(inheritedFDsEnv) != "" {
+		// 由 Restart 启动的新进程，继续在继承的 listener 上提供服务，直到被父进程结束
+		lis, err := Listen("tcp", os.Getenv("GEERPC_TEST_ADDR"))
+		if err != nil || len(inherited) != 0 {
+			os.Exit(2)
+		}
+		_ = newServer().Serve(context.Background(), lis)
+		os.Exit(0)
+	}
+
+	server := newServer()
+	lis, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := lis.Addr().String()
+	go func() { _ = server.Serve(context.Background(), lis) }()
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial error: %v", err)
+	defer func() { _ = client.Close() }()
+	var pid int
+	_ = client.Call(context.Background(), "Proc.Pid", 0, &pid)
+	_assert(pid == os.Getpid(), "expect pid %d, got %d", os.Getpid(), pid)
+
+	// 新进程只运行这个测试
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestServer_Restart$"}
+	t.Setenv("GEERPC_TEST_ADDR", addr)
+	p, err := server.Restart(context.Background())
+	os.Args = args
+	_assert(err == nil, "restart error: %v", err)
+	defer func() {
+		_ = p.Kill()
+		_, _ = p.Wait()
+	}()
+	_assert(!client.IsAvailable(), "old connections should be closed after drain")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		client, err := Dial("tcp", addr)
+		if err == nil {
+			err = client.Call(context.Background(), "Proc.Pid", 0, &pid)
+			_ = client.Close()
+		}
+		if err == nil {
+			_assert(pid == p.Pid, "expect pid %d from the new process, got %d", p.Pid, pid)
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("new process didn't serve on the inherited listener: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestServer_SetTimeout(t *testing.T) {
+	server := NewServer()
+	var slow Slow
+	_ = server.Register(&slow)
+	_ = server.RegisterFunc("Bulk.Export", func(ms int, reply *int) error {
+		time.Sleep(time.Duration(ms) * time.Millisecond)
+		return nil
+	})
+	server.SetTimeout("Slow", 20*time.Millisecond)
+	server.SetTimeout("Bulk.Export", 0)
+	client, _ := Dial("tcp", startTestServer(server), &Option{HandleTimeout: 50 * time.Millisecond})
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err := client.Call(context.Background(), "Slow.Sleep", 40, &reply)
+	_assert(errors.Is(err, ErrTimeout), "service timeout should be shorter than the global one, got %v", err)
+	err = client.Call(context.Background(), "Bulk.Export", 80, &reply)
+	_assert(err == nil, "method without timeout should not be bounded by the global one, got %v", err)
+
+	server.SetTimeout("Slow.Sleep", 100*time.Millisecond)
+	err = client.Call(context.Background(), "Slow.Sleep", 40, &reply)
+	_assert(err == nil, "method timeout should take precedence over the service one, got %v", err)
+	server.SetTimeout("Slow.Sleep", -1)
+	server.SetTimeout("Slow", -1)
+	err = client.Call(context.Background(), "Slow.Sleep", 80, &reply)
+	_assert(errors.Is(err, ErrTimeout), "global timeout should apply after removing overrides, got %v", err)
+}
+
+func TestServer_SlowCallThreshold(t *testing.T) {
+	server := NewServer()
+	logger := &testLogger{}
+	server.SetLogger(logger)
+	server.SlowCallThreshold = 20 * time.Millisecond
+	var slow Slow
+	_ = server.Register(&slow)
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	_ = client.Call(context.Background(), "Slow.Sleep", 1, &reply)
+	_ = client.Call(context.Background(), "Slow.Sleep", 30, &reply)
+	stats := server.Stats()
+	_assert(stats.SlowCalls == 1 && stats.Methods[len(stats.Methods)-1].SlowCalls == 1, "expect 1 slow call, got %+v", stats)
+
+	logger.mu.Lock()
+	defer logger.mu.Unlock()
+	var found bool
+	for _, line := range logger.lines {
+		if strings.Contains(line, "slow call Slow.Sleep") && strings.Contains(line, "peer 127.0.0.1") && strings.Contains(line, "seq 2") {
+			found = true
+		}
+	}
+	_assert(found, "expect a slow call log, got %v", logger.lines)
+}
+
+func TestServer_SetSizeLimit(t *testing.T) {
+	server := NewServer()
+	_ = server.RegisterFunc("Blob.Make", func(n int, reply *[]byte) error {
+		*reply = make([]byte, n)
+		return nil
+	})
+	_ = server.RegisterFunc("Blob.Echo", func(b []byte, reply *int) error {
+		*reply = len(b)
+		return nil
+	})
+	server.SetSizeLimit("Blob.Make", &SizeLimit{MaxResponse: 1024})
+	server.SetSizeLimit("Blob", &SizeLimit{MaxRequest: 1024})
+	client, _ := Dial("tcp", startTestServer(server))
+	defer func() { _ = client.Close() }()
+
+	var blob []byte
+	_assert(client.Call(context.Background(), "Blob.Make", 100, &blob) == nil && len(blob) == 100, "expect small reply to succeed")
+	err := client.Call(context.Background(), "Blob.Make", 4096, &blob)
+	_assert(CodeOf(err) == CodeInternal && strings.Contains(err.Error(), "exceeds limit 1024"), "expect reply too large, got %v", err)
+
+	var n int
+	_assert(client.Call(context.Background(), "Blob.Echo", make([]byte, 100), &n) == nil && n == 100, "expect small request to succeed")
+	err = client.Call(context.Background(), "Blob.Echo", make([]byte, 4096), &n)
+	_assert(CodeOf(err) == CodeInvalidArgument && strings.Contains(err.Error(), "exceeds limit 1024"), "expect request too large, got %v", err)
+
+	// 超过限制的请求被拒绝之后连接仍然可用
+	_assert(client.Call(context.Background(), "Blob.Echo", make([]byte, 10), &n) == nil && n == 10, "expect connection to stay usable")
+
+	stats := server.Stats()
+	var echo, blobs MethodStats
+	for _, ms := range stats.Methods {
+		switch ms.Name {
+		case "Blob.Echo":
+			echo = ms
+		case "Blob.Make":
+			blobs = ms
+		}
+	}
+	_assert(echo.MaxRequestBytes > 4096 && echo.RequestBytes > 4096+100+10, "unexpected request bytes %+v", echo)
+	_assert(blobs.MaxResponseBytes > 100 && blobs.MaxResponseBytes < 1024, "unexpected response bytes %+v", blobs)
+
+	server.SetSizeLimit("Blob.Make", nil)
+	_assert(client.Call(context.Background(), "Blob.Make", 4096, &blob) == nil && len(blob) == 4096, "expect limit to be removed")
+}
+
+type Shape interface {
+	Area() float64
+}
+
+type Square struct {
+	Side float64
+}
+
+func (s Square) Area() float64 { return s.Side * s.Side }
+
+type ShapeArgs struct {
+	Shape Shape
+}
+
+func TestRegisterType(t *testing.T) {
+	server := NewServer()
+	_ = server.RegisterFunc("Shape.Area", func(args ShapeArgs, reply *float64) error {
+		*reply = args.Shape.Area()
+		return nil
+	})
+	addr := startTestServer(server)
+
+	client, _ := Dial("tcp", addr)
+	var area float64
+	err := client.Call(context.Background(), "Shape.Area", ShapeArgs{Shape: Square{Side: 2}}, &area)
+	_assert(err != nil && strings.Contains(err.Error(), "geerpc.RegisterType"), "expect a hint to register the type, got %v", err)
+	_ = client.Close()
+
+	RegisterType(Square{})
+	client, _ = Dial("tcp", addr)
+	defer func() { _ = client.Close() }()
+	err = client.Call(context.Background(), "Shape.Area", ShapeArgs{Shape: Square{Side: 2}}, &area)
+	_assert(err == nil && area == 4, "failed to call with registered type: %v", err)
+}
+
+func TestServer_Mount(t *testing.T) {
+	public := NewServer()
+	var foo Foo
+	_ = public.Register(&foo)
+	admin := NewServer()
+	admin.AuthFunc = func(token string, _ io.ReadWriteCloser) error {
+		if token != "secret" {
+			return errors.New("bad token")
+		}
+		return nil
+	}
+	_ = admin.RegisterFunc("Admin.Ping", func(ctx context.Context, _ int, reply *string) error {
+		if info, ok := ConnInfoFromContext(ctx); !ok || info.Namespace != "admin" {
+			return errors.New("expect admin namespace in conn info")
+		}
+		*reply = "pong"
+		return nil
+	})
+	public.Mount("admin", admin)
+	addr := startTestServer(public)
+
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+	var sum int
+	err = client.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &sum)
+	_assert(err == nil && sum == 3, "failed to call public service: %v", err)
+	var pong string
+	err = client.Call(context.Background(), "Admin.Ping", 0, &pong)
+	_assert(CodeOf(err) == CodeNotFound, "expect admin service to be hidden, got %v", err)
+
+	adminClient, err := Dial("tcp", addr, WithNamespace("admin"), WithAuthToken("secret"))
+	_assert(err == nil, "failed to dial admin namespace: %v", err)
+	defer func() { _ = adminClient.Close() }()
+	err = adminClient.Call(context.Background(), "Admin.Ping", 0, &pong)
+	_assert(err == nil && pong == "pong", "failed to call admin service: %v", err)
+	err = adminClient.Call(context.Background(), "Foo.Sum", Args{Num1: 1, Num2: 2}, &sum)
+	_assert(CodeOf(err) == CodeNotFound, "expect public service to be hidden, got %v", err)
+
+	// 挂载的 Server 使用自己的鉴权设置
+	_, err = Dial("tcp", addr, WithNamespace("admin"))
+	_assert(errors.Is(err, ErrUnauthorized), "expect unauthorized, got %v", err)
+	_, err = Dial("tcp", addr, WithNamespace("missing"))
+	_assert(err != nil && strings.Contains(err.Error(), "unknown namespace"), "expect unknown namespace, got %v", err)
+}
+
+func TestServer_GoAway(t *testing.T) {
+	server := NewServer()
+	var slow Slow
+	_ = server.Register(&slow)
+	client, err := Dial("tcp", startTestServer(server))
+	_assert(err == nil, "failed to dial: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var r1 int
+	inflight := client.Go("Slow.Sleep", 100, &r1, nil)
+	time.Sleep(10 * time.Millisecond)
+	_ = server.Shutdown(context.Background())
+	deadline := time.Now().Add(time.Second)
+	for client.IsAvailable() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	_assert(!client.IsAvailable(), "client should stop using the connection after go away")
+
+	var r2 int
+	err = client.Call(context.Background(), "Slow.Sleep", 1, &r2)
+	_assert(errors.Is(err, ErrGoingAway) && errors.Is(err, ErrUnavailable), "expect going away, got %v", err)
+	<-inflight.Done
+	_assert(inflight.Error == nil && r1 == 100, "in-flight call should complete: %v", inflight.Error)
+}
+
+// writeCountingListener 统计接受的连接上 Write 的调用次数，也就是 write 系统调用的次数
+type writeCountingListener struct {
+	net.Listener
+	writes int64
+}
+
+func (l *writeCountingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &writeCountingConn{Conn: conn, writes: &l.writes}, nil
+}
+
+type writeCountingConn struct {
+	net.Conn
+	writes *int64
+}
+
+func (c *writeCountingConn) Write(p []byte) (int, error) {
+	atomic.AddInt64(c.writes, 1)
+	return c.Conn.Write(p)
+}
+
+func TestServer_Coalesce(t *testing.T) {
+	const n = 16
+	for _, c := range []struct {
+		name      string
+		policy    *CoalescePolicy
+		maxWrites int64
+	}{
+		{"off", nil, n},
+		{"on", &CoalescePolicy{Delay: 20 * time.Millisecond, MaxBatch: n}, 2},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			server := NewServer()
+			server.Coalesce = c.policy
+			var barrier sync.WaitGroup
+			barrier.Add(n)
+			_ = server.RegisterFunc("Barrier.Wait", func(i int, reply *int) error {
+				// 所有请求都到达之后同时回复
+				barrier.Done()
+				barrier.Wait()
+				*reply = i
+				return nil
+			})
+			_ = server.RegisterFunc("Echo.Int", func(i int, reply *int) error {
+				*reply = i
+				return nil
+			})
+			l, _ := net.Listen("tcp", "127.0.0.1:0")
+			lis := &writeCountingListener{Listener: l}
+			go server.Accept(lis)
+			defer func() { _ = l.Close() }()
+			client, _ := Dial("tcp", l.Addr().String())
+			defer func() { _ = client.Close() }()
+
+			calls := make([]*Call, n)
+			replies := make([]int, n)
+			for i := range calls {
+				calls[i] = client.Go("Barrier.Wait", i, &replies[i], nil)
+			}
+			for i, call := range calls {
+				<-call.Done
+				_assert(call.Error == nil && replies[i] == i, "expect %d, got %d %v", i, replies[i], call.Error)
+			}
+			writes := atomic.LoadInt64(&lis.writes)
+			_assert(writes <= c.maxWrites, "expect at most %d writes, got %d", c.maxWrites, writes)
+			if c.policy == nil {
+				return
+			}
+			// 没有达到 MaxBatch 的回复在 Delay 之后发送
+			var reply int
+			err := client.Call(context.Background(), "Echo.Int", 42, &reply)
+			_assert(err == nil && reply == 42, "expect 42, got %d %v", reply, err)
+			_assert(atomic.LoadInt64(&lis.writes) == writes+1, "expect one more write after the delay")
+		})
+	}
+}
+
+func TestServer_ChunkSize(t *testing.T) {
+	server := NewServer()
+	server.ChunkSize = 1024
+	blob := make([]byte, 64*1024)
+	for i := range blob {
+		blob[i] = byte(i)
+	}
+	_ = server.RegisterFunc("Blob.Get", func(n int, reply *[]byte) error {
+		*reply = blob[:n]
+		return nil
+	})
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	lis := &writeCountingListener{Listener: l}
+	go server.Accept(lis)
+	defer func() { _ = l.Close() }()
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+
+	var reply []byte
+	err := client.Call(context.Background(), "Blob.Get", len(blob), &reply)
+	_assert(err == nil && bytes.Equal(reply, blob), "expect the whole blob, got %d bytes %v", len(reply), err)
+	writes := atomic.LoadInt64(&lis.writes)
+	_assert(writes > int64(len(blob)/server.ChunkSize), "expect the reply to be sent in chunks, got %d writes", writes)
+	// 小的回复不需要拆分
+	err = client.Call(context.Background(), "Blob.Get", 16, &reply)
+	_assert(err == nil && bytes.Equal(reply, blob[:16]), "expect 16 bytes, got %v", err)
+	_assert(atomic.LoadInt64(&lis.writes) == writes+1, "expect a small reply in one write")
+
+	var raw RawMessage
+	err = client.Call(context.Background(), "Blob.Get", len(blob), &raw)
+	_assert(err == nil, "call with raw message error: %v", err)
+	_ = gob.NewDecoder(bytes.NewReader(raw)).Decode(&reply)
+	_assert(bytes.Equal(reply, blob), "expect the raw message to decode to the blob")
+
+	// 大的回复和小的回复并发时都能正确地返回
+	calls := make([]*Call, 20)
+	replies := make([][]byte, len(calls))
+	for i := range calls {
+		n := len(blob)
+		if i%2 == 1 {
+			n = i
+		}
+		calls[i] = client.Go("Blob.Get", n, &replies[i], nil)
+	}
+	for i, call := range calls {
+		<-call.Done
+		_assert(call.Error == nil && bytes.Equal(replies[i], blob[:len(replies[i])]) && len(replies[i]) > 0,
+			"unexpected reply of call %d: %d bytes %v", i, len(replies[i]), call.Error)
+	}
+
+	// 没有声明 ChunkedReplies 的客户端收到完整的回复
+	req := &request{h: &codec.Header{}, cs: &connState{}}
+	_assert(server.chunkedBody(client.cc, req, blob) == nil, "expect no chunks for clients without chunk support")
+}
+
+type QuotaFailure struct {
+	Limit     int
+	Remaining int
+}
+
+type quotaError struct {
+	failure *QuotaFailure
+}
+
+func (e *quotaError) Error() string { return "quota exceeded" }
+
+func (e *quotaError) ErrorDetails() interface{} { return e.failure }
+
+type unregisteredDetails struct{ Reason string }
+
+func TestServer_ErrorDetails(t *testing.T) {
+	RegisterType(&QuotaFailure{})
+	server := NewServer()
+	_ = server.RegisterFunc("Quota.Take", func(n int, reply *int) error {
+		return fmt.Errorf("take %d: %w", n, &quotaError{&QuotaFailure{Limit: 10, Remaining: 3}})
+	})
+	_ = server.RegisterFunc("Quota.Unknown", func(n int, reply *int) error {
+		return &Error{Code: CodeUnavailable, Message: "no quota", Details: &unregisteredDetails{"busy"}}
+	})
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	go server.Accept(l)
+	defer func() { _ = l.Close() }()
+	client, _ := Dial("tcp", l.Addr().String())
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err := client.Call(context.Background(), "Quota.Take", 5, &reply)
+	var e *Error
+	_assert(errors.As(err, &e) && e.Message == "take 5: quota exceeded", "expect the error message, got %v", err)
+	quota, ok := ErrorDetailsOf(err).(*QuotaFailure)
+	_assert(ok && quota.Limit == 10 && quota.Remaining == 3, "expect quota failure details, got %#v", ErrorDetailsOf(err))
+
+	// 没有注册的类型不会发送详细信息，错误码和错误信息不受影响，连接也可以继续使用
+	err = client.Call(context.Background(), "Quota.Unknown", 1, &reply)
+	_assert(errors.Is(err, ErrUnavailable) && ErrorDetailsOf(err) == nil, "expect the code without details, got %v", err)
+	err = client.Call(context.Background(), "Quota.Take", 1, &reply)
+	_assert(ErrorDetailsOf(err) != nil, "expect the connection to keep working, got %v", err)
+}
+
+func TestServer_CodecDebug(t *testing.T) {
+	var out bytes.Buffer
+	server := NewServer(WithCodecDebug(&CodecDebug{
+		Dump:         true,
+		Output:       &out,
+		RedactHeader: func(h *codec.Header) { delete(h.Metadata, "token") },
+	}))
+	stats := make(chan CodecStats, 1)
+	_ = server.RegisterFunc("Debug.Echo", func(ctx context.Context, s string, reply *string) error {
+		info, _ := ConnInfoFromContext(ctx)
+		st, ok := info.CodecStats()
+		_assert(ok, "expect codec stats on the server")
+		stats <- st
+		*reply = s
+		return nil
+	})
+	addr := startTestServer(server)
+	client, _ := Dial("tcp", addr, WithCodecDebug(&CodecDebug{}))
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	ctx := ContextWithMetadata(context.Background(), map[string]string{"token": "secret-token"})
+	err := client.Call(ctx, "Debug.Echo", "hello", &reply)
+	_assert(err == nil && reply == "hello", "call with codec debug error: %v", err)
+	st := <-stats
+	_assert(st.FramesRead == 1 && st.BytesRead > 0, "expect the request to be counted, got %+v", st)
+	cst, ok := client.CodecStats()
+	_assert(ok && cst.FramesWritten == 1 && cst.FramesRead == 1 && cst.BytesWritten > 0, "expect client codec stats, got %+v", cst)
+
+	dumpMu.Lock()
+	dump := out.String()
+	dumpMu.Unlock()
+	_assert(strings.Contains(dump, "rpc codec: < ") && strings.Contains(dump, "rpc codec: > "), "expect frames in both directions:\n%s", dump)
+	_assert(strings.Contains(dump, "00000000  "), "expect hex dumps of the bodies:\n%s", dump)
+	_assert(strings.Contains(dump, "ServiceMethod:Debug.Echo") && !strings.Contains(dump, "secret-token"), "expect headers with the token redacted:\n%s", dump)
+
+	client2, _ := Dial("tcp", addr)
+	defer func() { _ = client2.Close() }()
+	_, ok = client2.CodecStats()
+	_assert(!ok, "expect no codec stats without CodecDebug")
+}
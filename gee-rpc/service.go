@@ -1,10 +1,15 @@
 package geerpc
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"go/ast"
-	"log"
 	"reflect"
+	"sort"
+	"strings"
 	"sync/atomic"
+	"time"
 )
 
 // RPC框架的基础能力是：能像调用本地程序一样调用远程服务
@@ -21,15 +26,80 @@ import (
 
 type methodType struct {
 	method    reflect.Method
-	ArgType   reflect.Type // 第一个参数的类型
-	ReplyType reflect.Type // 第二个参数的类型
+	fn        reflect.Value // 通过 RegisterFunc 注册的函数，不为空时不需要接收者
+	ArgType   reflect.Type  // 第一个参数的类型
+	ReplyType reflect.Type  // 第二个参数的类型
+	withCtx   bool          // 方法的第一个参数是否为 context.Context
+	stream    bool          // 最后一个参数为 *ServerStream，表示服务端流式调用
 	numCalls  uint64
+	numErrors uint64    // 返回错误的调用次数
+	numSlow   uint64    // 超过 Server.SlowCallThreshold 的调用次数
+	inFlight  int64     // 正在执行的调用数
+	latency   histogram // 调用耗时的分布
+	// requestSize 和 responseSize 是请求和回复编码之后的字节数，codec 实现了 codec.Sizer 时才会统计
+	requestSize  sizeStats
+	responseSize sizeStats
+	argvPool     countingPool
 }
 
 func (m *methodType) NumCalls() uint64 {
 	return atomic.LoadUint64(&m.numCalls)
 }
 
+func (m *methodType) NumErrors() uint64 {
+	return atomic.LoadUint64(&m.numErrors)
+}
+
+func (m *methodType) InFlight() int64 {
+	return atomic.LoadInt64(&m.inFlight)
+}
+
+// NumSlowCalls 返回耗时超过 Server.SlowCallThreshold 的调用次数
+func (m *methodType) NumSlowCalls() uint64 {
+	return atomic.LoadUint64(&m.numSlow)
+}
+
+// RequestBytes 返回请求的总字节数和最大的一个请求的字节数
+func (m *methodType) RequestBytes() (total, max uint64) {
+	return m.requestSize.load()
+}
+
+// ResponseBytes 返回回复的总字节数和最大的一个回复的字节数
+func (m *methodType) ResponseBytes() (total, max uint64) {
+	return m.responseSize.load()
+}
+
+// Latency 返回调用耗时的最小值、平均值、最大值以及估算的分位数
+func (m *methodType) Latency() LatencyStats {
+	return m.latency.stats()
+}
+
+// Validator 由请求参数实现，服务端解码参数之后、调用服务方法之前调用 Validate，
+// 返回错误时直接回复 CodeInvalidArgument，不会执行中间件和服务方法，参数的校验就不需要写在每个方法里了
+type Validator interface {
+	Validate() error
+}
+
+// validate 参数或者参数的指针实现了 Validator 时校验参数
+func validate(argv reflect.Value, serviceMethod string) error {
+	v, ok := argv.Interface().(Validator)
+	if !ok && argv.Kind() != reflect.Ptr && argv.CanAddr() {
+		v, ok = argv.Addr().Interface().(Validator)
+	}
+	if !ok {
+		return nil
+	}
+	err := v.Validate()
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	return Errorf(CodeInvalidArgument, "rpc server: invalid argument for %s: %v", serviceMethod, err)
+}
+
 // 根据参数类型创建 Value，其中指针和普通变量的创建不同
 func (m *methodType) newArgv() reflect.Value {
 	var argv reflect.Value
@@ -66,17 +136,29 @@ type service struct {
 	method map[string]*methodType // 存储映射结构体的所有符合上诉条件的方法
 }
 
-func newService(rcvr interface{}) *service {
+func newService(rcvr interface{}) (*service, error) {
+	return newNamedService(rcvr, "")
+}
+
+// newNamedService 使用 name 作为服务名，name 为空时使用结构体的名称
+func newNamedService(rcvr interface{}, name string) (*service, error) {
 	s := new(service)
 	s.rcvr = reflect.ValueOf(rcvr)
-	// Indirect 的目的就是做一个 Ptr 的转换，如果是 Ptr，则需要使用Elem()，如果不是 Ptr，那直接返回它本身就可以
-	s.name = reflect.Indirect(s.rcvr).Type().Name()
 	s.typ = reflect.TypeOf(rcvr)
-	if !ast.IsExported(s.name) { // 利于语法树的函数判断结构体是否可导出
-		log.Fatalf("rpc server: %s is not valid service name", s.name)
+	if name != "" {
+		if strings.HasSuffix(name, ".") || strings.ContainsAny(name, " \t\n") {
+			return nil, fmt.Errorf("rpc server: %q is not valid service name", name)
+		}
+		s.name = name
+	} else {
+		// Indirect 的目的就是做一个 Ptr 的转换，如果是 Ptr，则需要使用Elem()，如果不是 Ptr，那直接返回它本身就可以
+		s.name = reflect.Indirect(s.rcvr).Type().Name()
+		if !ast.IsExported(s.name) { // 利于语法树的函数判断结构体是否可导出
+			return nil, fmt.Errorf("rpc server: %s is not valid service name", s.name)
+		}
 	}
 	s.registerMethods()
-	return s
+	return s, nil
 }
 
 func (s *service) registerMethods() {
@@ -84,35 +166,121 @@ func (s *service) registerMethods() {
 	// 遍历结构体的所有方法，注册method
 	for i := 0; i < s.typ.NumMethod(); i++ {
 		method := s.typ.Method(i)
-		mType := method.Type
 		// 这里注册的方法，限定的输入参数为3个，返回参数为1个
 		// 输入参数三个，第一个是自身，第二个是输入参数，第三个是输出参数
-		// 返回参数为 error
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
-			continue
+		if m := newMethodType(method.Type, 1); m != nil {
+			m.method = method
+			s.method[method.Name] = m
 		}
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
-			continue
+	}
+}
+
+// only 只保留 methods 中的方法，methods 中有不存在或者签名不符合要求的方法时返回错误
+func (s *service) only(methods []string) error {
+	kept := make(map[string]*methodType, len(methods))
+	for _, name := range methods {
+		m, ok := s.method[name]
+		if !ok {
+			return fmt.Errorf("rpc server: %s has no method %s or its signature is invalid", s.name, name)
 		}
-		// 输入参数和输出参数都必须是可导出的
-		argType, replyType := mType.In(1), mType.In(2)
-		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
-			continue
+		kept[name] = m
+	}
+	s.method = kept
+	return nil
+}
+
+// newMethodType 检查函数的签名，不符合要求时返回 nil，skip 为参数列表开头的接收者个数，
+// 除去接收者之后是输入参数和输出参数，返回参数为 error，
+// 另外也支持在输入参数之前多加一个 context.Context，用于获取 Metadata 等请求相关的信息
+func newMethodType(fType reflect.Type, skip int) *methodType {
+	withCtx := fType.NumIn() == skip+3 && fType.In(skip) == typeOfContext
+	if (fType.NumIn() != skip+2 && !withCtx) || fType.NumOut() != 1 {
+		return nil
+	}
+	if fType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
+		return nil
+	}
+	// 输入参数和输出参数都必须是可导出的
+	argType, replyType := fType.In(fType.NumIn()-2), fType.In(fType.NumIn()-1)
+	if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
+		return nil
+	}
+	// 最后一个参数为 *ServerStream 的是流式方法，通过 stream.Send 多次返回数据
+	return &methodType{
+		ArgType:   argType,
+		ReplyType: replyType,
+		withCtx:   withCtx,
+		stream:    replyType == typeOfServerStream,
+	}
+}
+
+// newFuncService 创建一个只包含函数的服务，在 base 已有方法的基础上加入 fn，
+// 已经注册的 service 可能正在被并发读取，所以复制一份而不是直接修改
+func newFuncService(base *service, name, methodName string, fn interface{}) (*service, error) {
+	fv := reflect.ValueOf(fn)
+	if fv.Kind() != reflect.Func {
+		return nil, fmt.Errorf("rpc server: %s.%s is not a function", name, methodName)
+	}
+	m := newMethodType(fv.Type(), 0)
+	if m == nil {
+		return nil, fmt.Errorf("rpc server: %s.%s has wrong signature %s", name, methodName, fv.Type())
+	}
+	m.fn = fv
+	m.method.Name = methodName // 按方法名查找限流器、工作池和超时设置时使用
+	s := &service{name: name, method: map[string]*methodType{methodName: m}}
+	if base != nil {
+		if base.typ != nil {
+			return nil, fmt.Errorf("rpc server: service %s is already registered with a receiver", name)
+		}
+		if _, dup := base.method[methodName]; dup {
+			return nil, fmt.Errorf("rpc server: method already defined: %s.%s", name, methodName)
 		}
-		s.method[method.Name] = &methodType{
-			method:    method,
-			ArgType:   argType,
-			ReplyType: replyType,
+		for k, v := range base.method {
+			s.method[k] = v
 		}
-		log.Printf("rpc server: reigster %s.%s\n", s.name, method.Name)
 	}
+	return s, nil
+}
+
+// methodNames 返回排好序的方法名，用于输出日志
+func (s *service) methodNames() []string {
+	names := make([]string, 0, len(s.method))
+	for name := range s.method {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
 }
 
+var typeOfContext = reflect.TypeOf((*context.Context)(nil)).Elem()
+
 func (s *service) call(m *methodType, argv, replyv reflect.Value) error {
+	return s.callContext(context.Background(), m, argv, replyv)
+}
+
+// callContext 调用注册的方法，如果方法需要 context.Context，则把 ctx 作为第一个参数传入
+func (s *service) callContext(ctx context.Context, m *methodType, argv, replyv reflect.Value) error {
 	atomic.AddUint64(&m.numCalls, 1)
+	atomic.AddInt64(&m.inFlight, 1)
+	start := time.Now()
+	defer func() {
+		atomic.AddInt64(&m.inFlight, -1)
+		m.latency.observe(time.Since(start))
+	}()
 	f := m.method.Func
-	returnValues := f.Call([]reflect.Value{s.rcvr, argv, replyv}) // 调用执行注册的函数
+	in := make([]reflect.Value, 0, 4)
+	if m.fn.IsValid() {
+		f = m.fn
+	} else {
+		in = append(in, s.rcvr)
+	}
+	if m.withCtx {
+		in = append(in, reflect.ValueOf(ctx))
+	}
+	in = append(in, argv, replyv)
+	returnValues := f.Call(in) // 调用执行注册的函数
 	if errInter := returnValues[0].Interface(); errInter != nil {
+		atomic.AddUint64(&m.numErrors, 1)
 		return errInter.(error)
 	}
 	return nil
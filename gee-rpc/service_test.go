@@ -36,7 +36,7 @@ func _assert(condition bool, msg string, v ...interface{}) {
 
 func TestNewService(t *testing.T) {
 	var foo Foo
-	s := newService(&foo)
+	s, _ := newService(&foo)
 	_assert(len(s.method) == 1, "wrong service Method, expect 1, but got %d", len(s.method))
 	mType := s.method["Sum"]
 	_assert(mType != nil, "wrong Method, Sum shouldn't nil")
@@ -44,7 +44,7 @@ func TestNewService(t *testing.T) {
 
 func TestMethodType_Call(t *testing.T) {
 	var foo Foo
-	s := newService(&foo)
+	s, _ := newService(&foo)
 	mType := s.method["Sum"]
 
 	argv := mType.newArgv()
@@ -101,7 +101,8 @@ func TestClient_Call(t *testing.T) {
 	time.Sleep(time.Second)
 	t.Run("client timeout", func(t *testing.T) {
 		client, _ := Dial("tcp", addr)
-		ctx, _ := context.WithTimeout(context.Background(), time.Second)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
 		var reply int
 		err := client.Call(ctx, "Bar.Timeout", 1, &reply)
 		_assert(err != nil && strings.Contains(err.Error(), ctx.Err().Error()), "expect a timeout error")
@@ -113,3 +114,57 @@ func TestClient_Call(t *testing.T) {
 		_assert(err != nil && strings.Contains(err.Error(), "handle timeout"), "expect a timeout error")
 	})
 }
+
+func TestNewFuncService(t *testing.T) {
+	sum := func(args Args, reply *int) error {
+		*reply = args.Num1 + args.Num2
+		return nil
+	}
+	s, err := newFuncService(nil, "Math", "Sum", sum)
+	_assert(err == nil, "failed to create func service: %v", err)
+	s, err = newFuncService(s, "Math", "Mul", func(ctx context.Context, args Args, reply *int) error {
+		*reply = args.Num1 * args.Num2
+		return nil
+	})
+	_assert(err == nil && len(s.method) == 2, "expect 2 methods, got %d %v", len(s.method), err)
+
+	for name, expect := range map[string]int{"Sum": 7, "Mul": 12} {
+		mType := s.method[name]
+		argv, replyv := mType.newArgv(), mType.newReplyv()
+		argv.Set(reflect.ValueOf(Args{Num1: 3, Num2: 4}))
+		err := s.call(mType, argv, replyv)
+		_assert(err == nil && *replyv.Interface().(*int) == expect, "%s: expect %d, got %d", name, expect, *replyv.Interface().(*int))
+	}
+
+	_, err = newFuncService(s, "Math", "Sum", sum)
+	_assert(err != nil, "duplicate method should be rejected")
+	_, err = newFuncService(nil, "Math", "Bad", func(args Args) error { return nil })
+	_assert(err != nil, "wrong signature should be rejected")
+	var foo Foo
+	base, _ := newService(&foo)
+	_, err = newFuncService(base, "Foo", "Mul", sum)
+	_assert(err != nil, "can't add functions to a service with a receiver")
+}
+
+type Calc int
+
+func (c Calc) Add(args Args, reply *int) error {
+	*reply = args.Num1 + args.Num2
+	return nil
+}
+
+func (c Calc) Reset(_ int, _ *int) error { return nil }
+
+func TestServer_RegisterWithMethods(t *testing.T) {
+	server := NewServer()
+	var calc Calc
+	err := server.RegisterWithMethods(&calc, "Add")
+	_assert(err == nil, "failed to register: %v", err)
+	_, mtype, err := server.findService("Calc.Add")
+	_assert(err == nil && mtype != nil, "Calc.Add should be registered: %v", err)
+	_, _, err = server.findService("Calc.Reset")
+	_assert(err != nil, "Calc.Reset should not be exposed")
+
+	err = NewServer().RegisterWithMethods(&calc, "Add", "Mul")
+	_assert(err != nil, "unknown method should be rejected")
+}
@@ -0,0 +1,108 @@
+package geerpc
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"geerpc/codec"
+)
+
+// SizeLimit 限制一个方法请求和回复编码之后的大小，包括 Header，0 表示不限制
+type SizeLimit struct {
+	MaxRequest  int
+	MaxResponse int
+}
+
+// SetSizeLimit 为 name 设置请求和回复的大小限制，name 为 "Foo" 表示 Foo 服务的所有方法，"Foo.Sum" 表示单个方法，
+// 方法的设置优先于服务。超过限制的请求不会执行，客户端收到 CodeInvalidArgument；
+// 超过限制的回复不会发送，客户端收到 CodeInternal。limit 为 nil 表示取消设置。
+// 只有 codec 实现了 codec.Sizer 时才会生效，检查回复大小需要额外编码一次，只在设置了 MaxResponse 时才会进行
+func (server *Server) SetSizeLimit(name string, limit *SizeLimit) {
+	if limit == nil {
+		server.sizeLimits.Delete(name)
+		return
+	}
+	l := *limit
+	server.sizeLimits.Store(name, &l)
+}
+
+// sizeLimit 返回 req 的大小限制，没有设置时返回 nil
+func (server *Server) sizeLimit(req *request) *SizeLimit {
+	for _, name := range []string{req.svc.name + "." + req.mtype.method.Name, req.svc.name} {
+		if l, ok := server.sizeLimits.Load(name); ok {
+			return l.(*SizeLimit)
+		}
+	}
+	return nil
+}
+
+// checkRequestSize 记录请求的大小，超过限制时返回错误
+func (server *Server) checkRequestSize(cc codec.Codec, req *request, size uint64) error {
+	if _, ok := cc.(codec.Sizer); !ok {
+		return nil
+	}
+	req.mtype.requestSize.observe(size)
+	if l := server.sizeLimit(req); l != nil && l.MaxRequest > 0 && size > uint64(l.MaxRequest) {
+		return Errorf(CodeInvalidArgument, "rpc server: request of %s is %d bytes, exceeds limit %d",
+			req.h.ServiceMethod, size, l.MaxRequest)
+	}
+	return nil
+}
+
+// sendReply 发送服务方法的回复并记录它的大小，回复超过限制时改为发送错误，很大的回复分块发送
+func (server *Server) sendReply(cc codec.Codec, req *request, sending *sync.Mutex) {
+	body := req.replyv.Interface()
+	if req.details != nil {
+		body = server.errorDetailsBody(cc, req, body)
+	}
+	sizer, ok := cc.(codec.Sizer)
+	if !ok {
+		server.sendBody(cc, req, body, sending)
+		return
+	}
+	if l := server.sizeLimit(req); l != nil && l.MaxResponse > 0 && req.h.Error == "" {
+		if n, err := sizer.BodySize(body); err == nil && n > l.MaxResponse {
+			server.requestLogger(req.h).Errorf("rpc server: response of %s is %d bytes, exceeds limit %d",
+				req.h.ServiceMethod, n, l.MaxResponse)
+			setError(req.h, Errorf(CodeInternal, "rpc server: response of %s is %d bytes, exceeds limit %d",
+				req.h.ServiceMethod, n, l.MaxResponse))
+			body = invalidRequest
+		}
+	}
+	n := server.sendBody(cc, req, body, sending)
+	req.mtype.responseSize.observe(uint64(n))
+}
+
+// sizeStats 累计一个方法请求或者回复的字节数，并记录其中最大的一个
+type sizeStats struct {
+	total uint64
+	max   uint64
+}
+
+func (s *sizeStats) observe(n uint64) {
+	atomic.AddUint64(&s.total, n)
+	for {
+		max := atomic.LoadUint64(&s.max)
+		if n <= max || atomic.CompareAndSwapUint64(&s.max, max, n) {
+			return
+		}
+	}
+}
+
+func (s *sizeStats) load() (total, max uint64) {
+	return atomic.LoadUint64(&s.total), atomic.LoadUint64(&s.max)
+}
+
+func bytesRead(cc codec.Codec) uint64 {
+	if s, ok := cc.(codec.Sizer); ok {
+		return s.BytesRead()
+	}
+	return 0
+}
+
+func bytesWritten(cc codec.Codec) uint64 {
+	if s, ok := cc.(codec.Sizer); ok {
+		return s.BytesWritten()
+	}
+	return 0
+}
@@ -0,0 +1,74 @@
+package geerpc
+
+import (
+	"expvar"
+	"sort"
+	"sync/atomic"
+)
+
+// ServerStats 是服务端运行状态的快照，可以直接编码成 JSON 输出到监控面板
+type ServerStats struct {
+	ActiveConns int64  // 当前的连接数
+	InFlight    int64  // 所有方法正在处理的请求数
+	Calls       uint64 // 所有方法的调用次数
+	Errors      uint64 // 所有方法返回错误的次数
+	SlowCalls   uint64 // 所有方法超过 Server.SlowCallThreshold 的调用次数
+	BytesIn     uint64 // 从客户端读取的字节数
+	BytesOut    uint64 // 发送给客户端的字节数
+	Methods     []MethodStats
+}
+
+// MethodStats 是一个方法的统计信息
+type MethodStats struct {
+	Name      string // 格式为 "Service.Method"
+	Calls     uint64
+	Errors    uint64
+	SlowCalls uint64
+	InFlight  int64
+	Latency   LatencyStats
+	// RequestBytes 和 ResponseBytes 是请求和回复的总字节数，包括 Header，
+	// MaxRequestBytes 和 MaxResponseBytes 是其中最大的一个，用来发现返回大量数据的方法，参考 Server.SetSizeLimit
+	RequestBytes     uint64
+	ResponseBytes    uint64
+	MaxRequestBytes  uint64
+	MaxResponseBytes uint64
+}
+
+// Stats 返回服务端的统计信息，Methods 按照名字排序
+func (server *Server) Stats() ServerStats {
+	stats := ServerStats{
+		ActiveConns: atomic.LoadInt64(&server.activeConns),
+		BytesIn:     atomic.LoadUint64(&server.bytesIn),
+		BytesOut:    atomic.LoadUint64(&server.bytesOut),
+	}
+	server.serviceMap.Range(func(namei, svci interface{}) bool {
+		svc := svci.(*service)
+		for _, name := range svc.methodNames() {
+			m := svc.method[name]
+			ms := MethodStats{
+				Name:      namei.(string) + "." + name,
+				Calls:     m.NumCalls(),
+				Errors:    m.NumErrors(),
+				SlowCalls: m.NumSlowCalls(),
+				InFlight:  m.InFlight(),
+				Latency:   m.Latency(),
+			}
+			ms.RequestBytes, ms.MaxRequestBytes = m.RequestBytes()
+			ms.ResponseBytes, ms.MaxResponseBytes = m.ResponseBytes()
+			stats.Calls += ms.Calls
+			stats.Errors += ms.Errors
+			stats.SlowCalls += ms.SlowCalls
+			stats.InFlight += ms.InFlight
+			stats.Methods = append(stats.Methods, ms)
+		}
+		return true
+	})
+	sort.Slice(stats.Methods, func(i, j int) bool { return stats.Methods[i].Name < stats.Methods[j].Name })
+	return stats
+}
+
+// PublishExpvar 把 Stats 以 name 发布到 expvar，访问 /debug/vars 时输出最新的统计信息，
+// 和 expvar.Publish 一样，同一个 name 只能发布一次，否则会 panic
+func (server *Server) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} { return server.Stats() }))
+}
@@ -0,0 +1,458 @@
+package geerpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"geerpc/codec"
+	"io"
+	"reflect"
+	"sync"
+	"time"
+)
+
+// ErrStreamClosed 在流已经关闭之后继续读写时返回
+var ErrStreamClosed = errors.New("rpc: stream is closed")
+
+// ErrStreamWindowExceeded 对方没有遵守流量控制，发送的数据超过了窗口，这个流会被重置
+var ErrStreamWindowExceeded = errors.New("rpc: stream flow control window exceeded")
+
+var typeOfServerStream = reflect.TypeOf((*ServerStream)(nil))
+
+// streamWindow 流式调用每个方向的窗口大小，发送方最多可以有这么多帧没有被对方读取，
+// 接收方每读取半个窗口的数据就通过 FlagWindow 帧归还，这样双方缓存的数据都是有限的
+const streamWindow = 32
+
+// streamQueue 缓存对方发来但是还没有被读取的数据
+type streamQueue struct {
+	mu       sync.Mutex
+	queue    []reflect.Value
+	err      error         // 队列结束的原因，正常结束为 io.EOF
+	consumed int           // 已经读取但是还没有归还给对方的窗口
+	notify   chan struct{} // 有新数据或者队列结束时通知读取方
+	closed   chan struct{} // 队列结束时关闭
+}
+
+func (q *streamQueue) init() {
+	q.notify = make(chan struct{}, 1)
+	q.closed = make(chan struct{})
+}
+
+// push 缓存一帧数据，已经收到但是还没有归还窗口的数据达到 streamWindow 时返回 false，说明对方没有遵守流量控制，
+// 队列结束之后收到的数据直接丢弃
+func (q *streamQueue) push(v reflect.Value) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return true
+	}
+	if len(q.queue)+q.consumed >= streamWindow {
+		return false
+	}
+	q.queue = append(q.queue, v)
+	q.wakeup()
+	return true
+}
+
+// finish 结束这个队列，只有第一次调用生效，已经收到的数据仍然可以读取
+func (q *streamQueue) finish(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.err != nil {
+		return
+	}
+	q.err = err
+	close(q.closed)
+	q.wakeup()
+}
+
+func (q *streamQueue) wakeup() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// pop 把下一帧数据写入 reply，队列为空时阻塞，返回的 ack 为需要归还给对方的窗口
+func (q *streamQueue) pop(ctx context.Context, typ reflect.Type, reply interface{}) (ack int, err error) {
+	rv := reflect.ValueOf(reply)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Type() != typ {
+		return 0, fmt.Errorf("rpc: stream message type mismatch: expect *%s", typ)
+	}
+	for {
+		q.mu.Lock()
+		if len(q.queue) > 0 {
+			rv.Elem().Set(q.queue[0])
+			q.queue[0] = reflect.Value{}
+			q.queue = q.queue[1:]
+			if q.consumed++; q.consumed >= streamWindow/2 {
+				ack, q.consumed = q.consumed, 0
+			}
+			q.mu.Unlock()
+			return ack, nil
+		}
+		err = q.err
+		q.mu.Unlock()
+		if err != nil {
+			return 0, err
+		}
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+}
+
+// sendWindow 中的每个元素表示可以再发送一帧数据
+type sendWindow chan struct{}
+
+func newSendWindow() sendWindow {
+	w := make(sendWindow, streamWindow)
+	w.add(streamWindow)
+	return w
+}
+
+func (w sendWindow) add(n int) {
+	for i := 0; i < n; i++ {
+		select {
+		case w <- struct{}{}:
+		default:
+			return // 对方归还的窗口超过了初始大小，忽略多出来的部分
+		}
+	}
+}
+
+// ServerStream 流式方法的最后一个参数，比如：
+//
+//	func (c Counter) Count(n int, stream *geerpc.ServerStream) error
+//
+// 第一个参数是客户端发起调用时发送的数据，之后客户端发送的数据通过 Recv 读取，类型和第一个参数相同，
+// 方法中可以多次调用 Send 发送数据，方法返回之后流结束，返回的错误会传递给客户端
+type ServerStream struct {
+	ctx     context.Context
+	cc      codec.Codec
+	sending *sync.Mutex
+	header  codec.Header
+	closed  bool // 由 sending 保护
+
+	cs      *connState
+	argType reflect.Type // 客户端发送的数据类型
+	recv    streamQueue
+	window  sendWindow
+	aborted chan struct{} // 连接断开时关闭
+}
+
+// Context 返回这次调用的上下文，包含客户端的 Metadata 和截止时间
+func (s *ServerStream) Context() context.Context {
+	if s.ctx == nil {
+		return context.Background()
+	}
+	return s.ctx
+}
+
+// Send 向客户端发送一帧数据，可以在多个 goroutine 中并发调用，
+// 客户端没有及时读取时会阻塞，直到窗口可用、ctx 结束或者连接断开
+func (s *ServerStream) Send(v interface{}) error {
+	ctx := s.Context()
+	select {
+	case <-s.window:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.aborted:
+		return ErrStreamClosed
+	}
+	s.sending.Lock()
+	defer s.sending.Unlock()
+	if s.closed {
+		return ErrStreamClosed
+	}
+	h := s.header
+	return s.cc.Write(&h, v)
+}
+
+// Recv 读取客户端发送的下一帧数据，reply 需要是方法第一个参数类型（去掉指针）的指针，
+// 客户端调用 CloseSend 之后返回 io.EOF
+func (s *ServerStream) Recv(reply interface{}) error {
+	ack, err := s.recv.pop(s.Context(), s.argType, reply)
+	if ack > 0 {
+		s.sending.Lock()
+		h := s.header
+		h.Flags |= codec.FlagWindow
+		_ = s.cc.Write(&h, ack)
+		s.sending.Unlock()
+	}
+	return err
+}
+
+// openStream 在读取请求的 goroutine 中创建 ServerStream，保证之后的帧到达时已经可以找到
+func (cs *connState) openStream(h *codec.Header, mtype *methodType, halfClosed bool) *ServerStream {
+	argType := mtype.ArgType
+	if argType.Kind() == reflect.Ptr {
+		argType = argType.Elem()
+	}
+	s := &ServerStream{
+		cc:      cs.cc,
+		sending: cs.sending,
+		header:  codec.Header{ServiceMethod: h.ServiceMethod, Seq: h.Seq, Flags: codec.FlagStream},
+		cs:      cs,
+		argType: argType,
+		window:  newSendWindow(),
+		aborted: make(chan struct{}),
+	}
+	s.recv.init()
+	if halfClosed {
+		s.recv.finish(io.EOF)
+	}
+	cs.mu.Lock()
+	cs.streams[h.Seq] = s
+	cs.mu.Unlock()
+	return s
+}
+
+func (cs *connState) getStream(seq uint64) *ServerStream {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	return cs.streams[seq]
+}
+
+func (cs *connState) removeStream(s *ServerStream) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.streams[s.header.Seq] == s {
+		delete(cs.streams, s.header.Seq)
+	}
+}
+
+// reset 在客户端违反流量控制时结束这个流，之后的帧都会被丢弃，方法的 Recv 和 Send 返回错误
+func (s *ServerStream) reset(err error) {
+	s.cs.removeStream(s)
+	close(s.aborted)
+	s.recv.finish(err)
+}
+
+// abortStreams 在连接断开时结束所有的流，唤醒阻塞在 Send 和 Recv 上的方法
+func (cs *connState) abortStreams() {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	for seq, s := range cs.streams {
+		close(s.aborted)
+		s.recv.finish(io.ErrUnexpectedEOF)
+		delete(cs.streams, seq)
+	}
+}
+
+// receiveStream 处理客户端发来的流式调用的后续帧，流已经结束时直接丢弃
+func (server *Server) receiveStream(cc codec.Codec, h *codec.Header, cs *connState) error {
+	s := cs.getStream(h.Seq)
+	flags := h.Flags
+	server.putHeader(h)
+	switch {
+	case s == nil:
+		return cc.ReadBody(nil)
+	case flags&codec.FlagWindow != 0:
+		var n int
+		if err := cc.ReadBody(&n); err != nil {
+			return err
+		}
+		s.window.add(n)
+	case flags&codec.FlagEnd != 0:
+		s.recv.finish(io.EOF)
+		return cc.ReadBody(nil)
+	default:
+		v := reflect.New(s.argType)
+		if err := cc.ReadBody(v.Interface()); err != nil {
+			return err
+		}
+		if !s.recv.push(v.Elem()) {
+			server.logger.Errorf("rpc server: %v, reset stream %d", ErrStreamWindowExceeded, s.header.Seq)
+			s.reset(ErrStreamWindowExceeded)
+		}
+	}
+	return nil
+}
+
+// handleStream 执行流式方法，方法返回之后发送一个带有 FlagEnd 的帧表示流结束
+func (server *Server) handleStream(cc codec.Codec, req *request, sending *sync.Mutex) {
+	stream := req.stream
+	err := server.invoke(req)
+
+	sending.Lock()
+	stream.closed = true
+	sending.Unlock()
+	if !req.isCanceled() {
+		h := stream.header
+		h.Flags |= codec.FlagEnd
+		if err != nil {
+			setError(&h, err)
+		}
+		server.sendResponse(cc, &h, invalidRequest, sending)
+	}
+	server.freeRequest(req)
+}
+
+// ClientStream 客户端一侧的流，NewStream 创建的流只能接收数据，
+// NewBidiStream 创建的流还可以通过 Send 向服务端发送数据
+type ClientStream struct {
+	client    *Client
+	call      *Call
+	replyType reflect.Type
+	recv      streamQueue
+	window    sendWindow
+
+	sendMu     sync.Mutex
+	sendClosed bool
+}
+
+// NewStream 发起一个服务端流式调用，replyType 用于指定服务端每次发送的数据类型，比如 new(int)，
+// 因为数据需要在接收的 goroutine 中解码，所以必须在调用之前确定类型
+func (client *Client) NewStream(ctx context.Context, serviceMethod string, args, replyType interface{}, opts ...CallOption) (*ClientStream, error) {
+	return client.newStream(ctx, serviceMethod, args, replyType, true, opts)
+}
+
+// NewBidiStream 发起一个双向流式调用，args 是发送的第一帧数据，之后通过 Send 继续发送相同类型的数据，
+// 发送完成之后调用 CloseSend，服务端可以只返回一帧数据，也可以返回多帧
+func (client *Client) NewBidiStream(ctx context.Context, serviceMethod string, args, replyType interface{}, opts ...CallOption) (*ClientStream, error) {
+	return client.newStream(ctx, serviceMethod, args, replyType, false, opts)
+}
+
+func (client *Client) newStream(ctx context.Context, serviceMethod string, args, replyType interface{}, halfClosed bool, opts []CallOption) (*ClientStream, error) {
+	typ := reflect.TypeOf(replyType)
+	if typ == nil || typ.Kind() != reflect.Ptr {
+		return nil, errors.New("rpc client: stream reply type must be a pointer")
+	}
+	stream := &ClientStream{
+		client:     client,
+		replyType:  typ.Elem(),
+		window:     newSendWindow(),
+		sendClosed: halfClosed,
+	}
+	stream.recv.init()
+	call := &Call{
+		ServiceMethod: serviceMethod,
+		Args:          args,
+		Done:          make(chan *Call, 1),
+		Metadata:      copyMetadata(MetadataFromContext(ctx)),
+		stream:        stream,
+	}
+	for _, opt := range opts {
+		opt(call)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		call.timeout = time.Until(deadline)
+	}
+	stream.call = call
+	client.send(ctx, call)
+	select {
+	case call := <-call.Done:
+		if call.Error != nil {
+			return nil, call.Error
+		}
+	default:
+	}
+	go stream.watch(ctx)
+	return stream, nil
+}
+
+// watch 在 ctx 结束时停止接收数据
+func (s *ClientStream) watch(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		s.client.cancel(s.call)
+		s.recv.finish(errors.New("rpc client:" + ctx.Err().Error()))
+	case <-s.recv.closed:
+	}
+}
+
+// Recv 读取服务端发送的下一帧数据，reply 的类型需要和创建流时的 replyType 一致，
+// 流正常结束时返回 io.EOF，服务端方法返回错误时返回该错误
+func (s *ClientStream) Recv(reply interface{}) error {
+	ack, err := s.recv.pop(context.Background(), s.replyType, reply)
+	if ack > 0 {
+		_ = s.client.write(&codec.Header{ServiceMethod: s.call.ServiceMethod, Seq: s.call.Seq, Flags: codec.FlagStream | codec.FlagWindow}, ack)
+	}
+	return err
+}
+
+// Send 向服务端发送一帧数据，服务端没有及时读取时会阻塞，
+// 流已经结束时返回 io.EOF，这时可以通过 Recv 获取结束的原因
+func (s *ClientStream) Send(v interface{}) error {
+	select {
+	case <-s.window:
+	case <-s.recv.closed:
+		return io.EOF
+	}
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	if s.sendClosed {
+		return ErrStreamClosed
+	}
+	return s.client.write(&codec.Header{ServiceMethod: s.call.ServiceMethod, Seq: s.call.Seq, Flags: codec.FlagStream}, v)
+}
+
+// CloseSend 通知服务端不会再发送数据，服务端的 Recv 会返回 io.EOF
+func (s *ClientStream) CloseSend() error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	if s.sendClosed {
+		return nil
+	}
+	s.sendClosed = true
+	return s.client.write(&codec.Header{ServiceMethod: s.call.ServiceMethod, Seq: s.call.Seq, Flags: codec.FlagStream | codec.FlagEnd}, invalidRequest)
+}
+
+// Close 停止发送和接收数据，服务端的方法会看到 ctx 被取消，之后服务端发送的数据都会被丢弃
+func (s *ClientStream) Close() error {
+	s.sendMu.Lock()
+	s.sendClosed = true
+	s.sendMu.Unlock()
+	s.client.cancel(s.call)
+	s.recv.finish(ErrStreamClosed)
+	return nil
+}
+
+// write 发送流式调用的后续帧
+func (client *Client) write(h *codec.Header, body interface{}) error {
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	return client.cc.Write(h, body)
+}
+
+// receiveStream 处理流式调用的帧，流没有结束之前 Call 一直保留在 pending 中
+func (client *Client) receiveStream(h *codec.Header) error {
+	client.mu.Lock()
+	call := client.pending[h.Seq]
+	client.mu.Unlock()
+	if call == nil || call.stream == nil {
+		return client.cc.ReadBody(nil)
+	}
+	switch {
+	case h.Flags&codec.FlagWindow != 0:
+		var n int
+		if err := client.cc.ReadBody(&n); err != nil {
+			return err
+		}
+		call.stream.window.add(n)
+	case h.Flags&codec.FlagEnd != 0:
+		client.removeCall(h.Seq)
+		err := client.cc.ReadBody(nil)
+		if h.Error != "" {
+			call.Error = headerError(h)
+			call.stream.recv.finish(call.Error)
+		} else {
+			call.stream.recv.finish(io.EOF)
+		}
+		call.done()
+		return err
+	default:
+		v := reflect.New(call.stream.replyType)
+		if err := client.cc.ReadBody(v.Interface()); err != nil {
+			return err
+		}
+		if !call.stream.recv.push(v.Elem()) {
+			client.cancel(call)
+			call.stream.recv.finish(ErrStreamWindowExceeded)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package geerpc
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// TCPOptions 调整 TCP 连接的参数，零值表示使用 Go 的默认值：开启 TCP_NODELAY，15s 的 keepalive，系统默认的缓冲区大小。
+// 客户端通过 WithTCPOptions 设置，服务端设置 Server.TCP 或者在 NewServer 中传入 WithTCPOptions，
+// 连接不是 TCP 连接时（比如 unix 域套接字、QUIC 和进程内的 pipe）被忽略
+type TCPOptions struct {
+	// DisableNoDelay 为 true 时关闭 TCP_NODELAY，使用 Nagle 算法合并小的数据包，
+	// 适合吞吐量优先的批量调用，代价是小请求的延迟变高
+	DisableNoDelay bool
+	// KeepAlive 是 TCP keepalive 探测的间隔，0 使用默认值，小于 0 时关闭 keepalive
+	KeepAlive time.Duration
+	// ReadBuffer 和 WriteBuffer 是 SO_RCVBUF 和 SO_SNDBUF，0 使用系统默认值，
+	// 高带宽、高延迟的链路上调大可以提高单个连接的吞吐量
+	ReadBuffer  int
+	WriteBuffer int
+}
+
+func (o *TCPOptions) validate() error {
+	if o != nil && (o.ReadBuffer < 0 || o.WriteBuffer < 0) {
+		return errors.New("rpc client: TCP buffer sizes can't be negative")
+	}
+	return nil
+}
+
+// apply 把设置应用到 conn 上，TLS 连接使用底层的连接，不是 TCP 连接时什么也不做
+func (o *TCPOptions) apply(conn net.Conn) error {
+	if o == nil {
+		return nil
+	}
+	if c, ok := conn.(interface{ NetConn() net.Conn }); ok {
+		conn = c.NetConn()
+	}
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if o.DisableNoDelay {
+		if err := tc.SetNoDelay(false); err != nil {
+			return err
+		}
+	}
+	if o.KeepAlive < 0 {
+		if err := tc.SetKeepAlive(false); err != nil {
+			return err
+		}
+	} else if o.KeepAlive > 0 {
+		if err := tc.SetKeepAlive(true); err != nil {
+			return err
+		}
+		if err := tc.SetKeepAlivePeriod(o.KeepAlive); err != nil {
+			return err
+		}
+	}
+	if o.ReadBuffer > 0 {
+		if err := tc.SetReadBuffer(o.ReadBuffer); err != nil {
+			return err
+		}
+	}
+	if o.WriteBuffer > 0 {
+		if err := tc.SetWriteBuffer(o.WriteBuffer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WithTCPOptions 设置 TCP 连接的参数，也可以传给 NewServer 设置服务端接受的连接
+func WithTCPOptions(tcp *TCPOptions) OptionFunc {
+	return func(s *optionSetter) error {
+		return s.set("TCP", tcp, func(opt *Option) { opt.TCP = tcp })
+	}
+}
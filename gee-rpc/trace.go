@@ -0,0 +1,130 @@
+package geerpc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// traceparentKey 是 W3C Trace Context 规范中传递 trace 信息使用的 key
+const traceparentKey = "traceparent"
+
+// MetadataCarrier 把请求头中的 Metadata 包装成 OpenTelemetry 的 propagation.TextMapCarrier，
+// 这样就可以直接使用 otel 的 Propagator 注入和提取 trace 信息，而 geerpc 本身不需要依赖 otel
+type MetadataCarrier map[string]string
+
+func (c MetadataCarrier) Get(key string) string { return c[key] }
+
+func (c MetadataCarrier) Set(key, value string) { c[key] = value }
+
+func (c MetadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// SpanContext 是在 geerpc 调用之间传递的 trace 信息
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// traceparent 格式为 version-traceid-spanid-flags，比如
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func (sc SpanContext) traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%s-%s-%s", hex.EncodeToString(sc.TraceID[:]), hex.EncodeToString(sc.SpanID[:]), flags)
+}
+
+func parseTraceparent(s string) (sc SpanContext, ok bool) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, false
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return sc, false
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpanContext 把 sc 放到 ctx 中，作为后续调用的父 span
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+// SpanContextFromContext 取出 ctx 中的 trace 信息
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok
+}
+
+// injectTraceparent 为这次调用生成一个新的 span，如果 ctx 中没有父 span 则开启一个新的 trace
+func injectTraceparent(ctx context.Context, md MetadataCarrier) {
+	sc, ok := SpanContextFromContext(ctx)
+	if !ok {
+		_, _ = rand.Read(sc.TraceID[:])
+		sc.Sampled = true
+	}
+	_, _ = rand.Read(sc.SpanID[:])
+	md.Set(traceparentKey, sc.traceparent())
+}
+
+func extractTraceparent(ctx context.Context, md MetadataCarrier) context.Context {
+	if sc, ok := parseTraceparent(md.Get(traceparentKey)); ok {
+		return ContextWithSpanContext(ctx, sc)
+	}
+	return ctx
+}
+
+// TraceInterceptor 返回一个客户端拦截器，在发送请求之前调用 inject 把 ctx 中的 trace 信息写入 Metadata。
+// inject 为空时使用 W3C traceparent 格式，使用 OpenTelemetry 时可以这样写：
+//
+//	client.Use(geerpc.TraceInterceptor(func(ctx context.Context, md geerpc.MetadataCarrier) {
+//		otel.GetTextMapPropagator().Inject(ctx, md)
+//	}))
+func TraceInterceptor(inject func(ctx context.Context, md MetadataCarrier)) CallInterceptor {
+	if inject == nil {
+		inject = injectTraceparent
+	}
+	return func(ctx context.Context, call *Call, next CallHandler) error {
+		if call.Metadata == nil {
+			call.Metadata = make(map[string]string)
+		}
+		inject(ctx, call.Metadata)
+		return next(ctx, call)
+	}
+}
+
+// TraceMiddleware 返回一个服务端中间件，调用 extract 从 Metadata 中取出 trace 信息并放到 RequestContext.Context 中，
+// extract 为空时使用 W3C traceparent 格式，和 TraceInterceptor 对应
+func TraceMiddleware(extract func(ctx context.Context, md MetadataCarrier) context.Context) Middleware {
+	if extract == nil {
+		extract = extractTraceparent
+	}
+	return func(ctx *RequestContext, next Handler) error {
+		ctx.Context = extract(ctx.Context, ctx.Header.Metadata)
+		return next(ctx)
+	}
+}
@@ -0,0 +1,25 @@
+package geerpc
+
+import (
+	"fmt"
+	"strings"
+
+	"geerpc/codec"
+)
+
+// RegisterType 登记 Args 或者 Reply 的接口字段中可能出现的具体类型，客户端和服务端都需要在使用之前登记，
+// 通常放在定义这些类型的包的 init 中。gob 编码接口字段时需要知道具体的类型，
+// 没有登记时调用会失败，错误信息为 type not registered for interface
+func RegisterType(value interface{}) {
+	for _, register := range codec.RegisterTypeFuncMap {
+		register(value)
+	}
+}
+
+// withTypeHint 在接口字段的类型没有登记导致的编解码错误中提示使用 RegisterType
+func withTypeHint(err error) error {
+	if err == nil || !strings.Contains(err.Error(), "type not registered for interface") {
+		return err
+	}
+	return fmt.Errorf("%w (register the concrete type with geerpc.RegisterType on both client and server)", err)
+}
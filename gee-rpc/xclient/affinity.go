@@ -0,0 +1,112 @@
+package xclient
+
+import (
+	"context"
+	"geerpc"
+	"time"
+)
+
+type sessionKey struct{}
+
+// WithSession 返回带有会话标识 key 的 ctx，开启会话保持之后，
+// 使用相同 key 的调用会一直发送到同一个实例，适合服务端在内存中保存了客户端状态的场景
+func WithSession(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, sessionKey{}, key)
+}
+
+func sessionFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(sessionKey{}).(string)
+	return key, ok
+}
+
+// session 是一个会话绑定的实例
+type session struct {
+	addr     string
+	lastUsed time.Time
+}
+
+// SetAffinity 开启会话保持，idle 是会话的空闲时间，超过之后绑定关系被删除，为 0 时关闭。
+// 绑定的实例从 Discovery 中移除、被健康检查摘除、熔断器打开或者调用返回连接类错误之后，
+// 会话会重新选择实例并绑定到新的实例上，需要在发起调用之前设置
+func (xc *XClient) SetAffinity(idle time.Duration) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.affinity = idle
+	xc.sessions = make(map[string]*session)
+}
+
+// selectFor 开启会话保持并且 ctx 带有会话标识时优先使用绑定的实例，否则按照负载均衡策略选择，
+// 指定了哈希 key 或者 tag 时按照单次调用的参数选择，不使用会话保持
+func (xc *XClient) selectFor(ctx context.Context, o *callOptions) (string, error) {
+	if o.selective() {
+		return xc.selectWith(o, "")
+	}
+	key, ok := sessionFromContext(ctx)
+	if !ok || xc.affinity <= 0 {
+		return xc.selectServer()
+	}
+	if rpcAddr := xc.boundServer(key); rpcAddr != "" {
+		return rpcAddr, nil
+	}
+	rpcAddr, err := xc.selectServer()
+	if err != nil {
+		return "", err
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	now := time.Now()
+	xc.sessions[key] = &session{addr: rpcAddr, lastUsed: now}
+	// 顺便清理空闲的会话，每个空闲周期最多清理一次
+	if now.Sub(xc.lastSweep) > xc.affinity {
+		xc.lastSweep = now
+		for k, s := range xc.sessions {
+			if now.Sub(s.lastUsed) > xc.affinity {
+				delete(xc.sessions, k)
+			}
+		}
+	}
+	return rpcAddr, nil
+}
+
+// boundServer 返回会话绑定的实例，实例已经不可用时返回空字符串
+func (xc *XClient) boundServer(key string) string {
+	xc.mu.Lock()
+	s, ok := xc.sessions[key]
+	if ok && time.Since(s.lastUsed) > xc.affinity {
+		delete(xc.sessions, key)
+		ok = false
+	}
+	xc.mu.Unlock()
+	if !ok {
+		return ""
+	}
+	if b := xc.getBreaker(s.addr); b != nil && b.State() == geerpc.BreakerOpen {
+		return ""
+	}
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return ""
+	}
+	for _, rpcAddr := range servers {
+		if rpcAddr == s.addr {
+			xc.mu.Lock()
+			s.lastUsed = time.Now()
+			xc.mu.Unlock()
+			return s.addr
+		}
+	}
+	return ""
+}
+
+// unbind 调用 rpcAddr 出现连接类错误之后解除 ctx 中的会话和它的绑定，下次调用重新选择实例
+func (xc *XClient) unbind(ctx context.Context, rpcAddr string) {
+	key, ok := sessionFromContext(ctx)
+	if !ok {
+		return
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	if s := xc.sessions[key]; s != nil && s.addr == rpcAddr {
+		delete(xc.sessions, key)
+	}
+}
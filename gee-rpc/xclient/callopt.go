@@ -0,0 +1,118 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"geerpc"
+	"hash/fnv"
+	"time"
+)
+
+// CallOption 设置 XClient 单次调用的参数，覆盖 XClient 上的全局设置
+type CallOption func(*callOptions)
+
+// BroadcastOption 设置 Broadcast 的行为，Broadcast 使用 WithTimeout、WithTag 和 WithCallOptions，
+// 其他只影响选择实例的参数不起作用
+type BroadcastOption = CallOption
+
+type callOptions struct {
+	hashKey  string
+	failMode *FailMode
+	timeout  time.Duration
+	tag      string
+	fastest  bool // Broadcast 使用
+	callOpts []geerpc.CallOption
+}
+
+func newCallOptions(opts []CallOption) *callOptions {
+	o := &callOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithHashKey 使用一致性哈希选择实例，相同 key 的调用发送到同一个实例，实例增减时只有少部分 key 会换到其他实例，
+// 适合分布式缓存这类按 key 分片的场景。使用的是 Router 过滤之后的实例，优先于会话保持
+func WithHashKey(key string) CallOption {
+	return func(o *callOptions) { o.hashKey = key }
+}
+
+// WithFailMode 覆盖 SetFailMode 设置的失败处理方式
+func WithFailMode(mode FailMode) CallOption {
+	return func(o *callOptions) { o.failMode = &mode }
+}
+
+// WithTimeout 设置整个调用的超时时间，包括重试和备份请求
+func WithTimeout(d time.Duration) CallOption {
+	return func(o *callOptions) { o.timeout = d }
+}
+
+// WithTag 只选择带有 tag 的实例，在 Router 过滤之后执行，没有实例带有 tag 时调用失败
+func WithTag(tag string) CallOption {
+	return func(o *callOptions) { o.tag = tag }
+}
+
+// WithCallOptions 把 geerpc.CallOption 传给实际发起调用的 Client，比如 geerpc.WithMetadata
+func WithCallOptions(opts ...geerpc.CallOption) CallOption {
+	return func(o *callOptions) { o.callOpts = append(o.callOpts, opts...) }
+}
+
+// mode 返回这次调用使用的失败处理方式
+func (o *callOptions) mode(xc *XClient) FailMode {
+	if o.failMode != nil {
+		return *o.failMode
+	}
+	return xc.failMode
+}
+
+// context 设置了 WithTimeout 时返回带有超时的 ctx
+func (o *callOptions) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if o.timeout > 0 {
+		return context.WithTimeout(ctx, o.timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// selective 判断选择实例时是否需要使用单次调用的参数
+func (o *callOptions) selective() bool {
+	return o.hashKey != "" || o.tag != ""
+}
+
+// selectWith 按照哈希 key 和 tag 在路由之后的实例中选择，exclude 不为空时排除这个实例
+func (xc *XClient) selectWith(o *callOptions, exclude string) (string, error) {
+	servers, err := xc.route()
+	if err != nil {
+		return "", err
+	}
+	if o.tag != "" {
+		servers = filter(servers, MatchTag(o.tag), true)
+	}
+	if exclude != "" {
+		servers = filter(servers, func(s ServerInfo) bool { return s.Addr == exclude }, false)
+	}
+	if len(servers) == 0 {
+		return "", errors.New("rpc xclient: no server matches the call options")
+	}
+	if o.hashKey != "" {
+		return hashSelect(servers, o.hashKey), nil
+	}
+	return xc.pick(servers), nil
+}
+
+// hashSelect 使用 rendezvous hashing 选择实例：key 和每个实例的地址一起计算哈希，选择哈希值最大的实例，
+// 实例被移除时只有原来选中它的 key 会换到其他实例，不需要维护哈希环
+func hashSelect(servers []ServerInfo, key string) string {
+	var best string
+	var max uint64
+	for _, s := range servers {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(key))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(s.Addr))
+		if sum := h.Sum64(); best == "" || sum > max {
+			best, max = s.Addr, sum
+		}
+	}
+	return best
+}
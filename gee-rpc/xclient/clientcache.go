@@ -0,0 +1,78 @@
+package xclient
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// defaultCleanupInterval 是默认检查缓存的 Client 的间隔
+const defaultCleanupInterval = 30 * time.Second
+
+// SetMaxIdle 设置 Client 的最大空闲时间，超过这个时间没有被使用并且没有未完成调用的 Client 会被关闭，
+// 下次调用时重新建立连接，为 0 时不关闭空闲的 Client
+func (xc *XClient) SetMaxIdle(d time.Duration) {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	xc.maxIdle = d
+}
+
+// SetCleanupInterval 设置后台检查缓存的 Client 的间隔，默认 30s，
+// 每次检查都会关闭已经不可用（连接断开、心跳超时等）或者空闲超时的 Client
+func (xc *XClient) SetCleanupInterval(d time.Duration) {
+	if d <= 0 {
+		d = defaultCleanupInterval
+	}
+	xc.mu.Lock()
+	xc.cleanupInterval = d
+	xc.mu.Unlock()
+	// 通知后台按照新的间隔重新计时
+	select {
+	case xc.cleanupReset <- struct{}{}:
+	default:
+	}
+}
+
+// janitor 定期清理缓存的 Client，Close 之后退出
+func (xc *XClient) janitor() {
+	defer close(xc.janitorDone)
+	for {
+		xc.mu.Lock()
+		interval := xc.cleanupInterval
+		xc.mu.Unlock()
+		timer := time.NewTimer(interval)
+		select {
+		case <-xc.closing:
+			timer.Stop()
+			return
+		case <-xc.cleanupReset:
+			timer.Stop()
+			continue
+		case <-timer.C:
+		}
+		xc.cleanup()
+	}
+}
+
+// cleanup 关闭不可用和空闲超时的 Client
+func (xc *XClient) cleanup() {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	now := time.Now()
+	for rpcAddr, client := range xc.clients {
+		reason := ""
+		if !client.IsAvailable() {
+			reason = "unavailable"
+		} else if xc.maxIdle > 0 && now.Sub(xc.lastUsed[rpcAddr]) > xc.maxIdle {
+			if s := xc.stats[rpcAddr]; s != nil && atomic.LoadInt64(&s.inflight) > 0 {
+				continue
+			}
+			reason = "idle"
+		}
+		if reason != "" {
+			xc.logger().Debugf("rpc xclient: close %s client of %s", reason, rpcAddr)
+			_ = client.Close()
+			delete(xc.clients, rpcAddr)
+			delete(xc.lastUsed, rpcAddr)
+		}
+	}
+}
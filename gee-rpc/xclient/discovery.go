@@ -13,6 +13,10 @@ type SelectMode int
 const (
 	RandomSelect SelectMode = iota
 	RoundRobinSelect
+	WeightedRoundRobinSelect // 平滑加权轮询，权重写在地址后面，比如 tcp@10.0.0.1:9999?weight=3
+	// P2CSelect 随机选出两个实例，使用 XClient 统计的延迟和未完成调用数较低的那个，
+	// Discovery 本身没有这些统计信息，直接调用 Discovery.Get 时和 RandomSelect 相同
+	P2CSelect
 )
 
 type Discovery interface {
@@ -20,6 +24,9 @@ type Discovery interface {
 	Update(servers []string) error       // 手动更新服务列表
 	Get(mode SelectMode) (string, error) // 根据负载均衡策略，选择一个服务实例
 	GetAll() ([]string, error)           // 返回所有的服务实例
+	// Watch 订阅服务列表的变化，先发送一次当前的列表，之后每次变化都发送新的列表，调用 cancel 取消订阅
+	Watch() (ch <-chan []string, cancel func())
+	GetAllInfo() ([]ServerInfo, error) // 返回所有的服务实例以及它们的元数据
 }
 
 var _ Discovery = (*MultiServersDiscovery)(nil)
@@ -31,6 +38,13 @@ type MultiServersDiscovery struct {
 	mu      sync.RWMutex
 	servers []string
 	index   int // 记录 Round Robin 算法已经轮询到的位置，为了避免每次从0开始，初始化的时候会随机设定一个值
+	// weighted 加权轮询的状态，服务列表更新之后重新创建
+	weighted []*weightedServer
+	// down 健康检查认为不可用的实例，Get 和 GetAll 不会返回这些实例
+	down   map[string]bool
+	health *healthChecker
+	// watchers 通过 Watch 订阅服务列表变化的 channel
+	watchers map[chan []string]struct{}
 }
 
 func (d *MultiServersDiscovery) Refresh() error {
@@ -41,6 +55,8 @@ func (d *MultiServersDiscovery) Update(servers []string) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	d.servers = servers
+	d.weighted = nil
+	d.notify()
 	return nil
 }
 
@@ -56,17 +72,20 @@ func (d *MultiServersDiscovery) Update(servers []string) error {
 func (d *MultiServersDiscovery) Get(mode SelectMode) (string, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	n := len(d.servers)
+	servers := d.available()
+	n := len(servers)
 	if n == 0 {
 		return "", errors.New("rpc discovery: no available servers")
 	}
 	switch mode {
-	case RandomSelect:
-		return d.servers[d.r.Intn(n)], nil
+	case RandomSelect, P2CSelect:
+		return stripWeight(servers[d.r.Intn(n)]), nil
 	case RoundRobinSelect:
-		s := d.servers[d.index%n]
+		s := servers[d.index%n]
 		d.index = (d.index + 1) % n
-		return s, nil
+		return stripWeight(s), nil
+	case WeightedRoundRobinSelect:
+		return d.nextWeighted(), nil
 	default:
 		return "", errors.New("rpc discovery: not supported select mode")
 	}
@@ -75,9 +94,7 @@ func (d *MultiServersDiscovery) Get(mode SelectMode) (string, error) {
 func (d *MultiServersDiscovery) GetAll() ([]string, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	servers := make([]string, len(d.servers), len(d.servers))
-	copy(servers, d.servers)
-	return servers, nil
+	return d.snapshot(), nil
 }
 
 func NewMultiServersDiscovery(servers []string) *MultiServersDiscovery {
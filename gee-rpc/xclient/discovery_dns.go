@@ -0,0 +1,168 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"geerpc"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DNSDiscovery 定期解析一个域名得到服务列表，适合 Kubernetes 的 headless service 等场景：
+// 地址带端口时（比如 tcp@geerpc.default.svc:9999）解析 A/AAAA 记录，所有 IP 使用同一个端口；
+// 不带端口时（比如 tcp@_geerpc._tcp.geerpc.default.svc）解析 SRV 记录，使用记录中的端口和权重，
+// 只使用优先级最高（Priority 最小）的一组记录。解析失败时继续使用上一次的结果
+type DNSDiscovery struct {
+	*MultiServersDiscovery
+	protocol   string
+	host       string
+	port       string // 为空时解析 SRV 记录
+	interval   time.Duration
+	lastUpdate time.Time
+	fetched    bool
+	logger     geerpc.Logger
+
+	lookupHost func(ctx context.Context, host string) ([]string, error)
+	lookupSRV  func(ctx context.Context, name string) ([]*net.SRV, error)
+}
+
+const (
+	defaultDNSInterval = 30 * time.Second
+	dnsTimeout         = 5 * time.Second
+)
+
+// NewDNSDiscovery 创建解析 name 的 Discovery，name 的格式为 protocol@host[:port]，
+// interval 是重新解析的间隔，为 0 时使用 30s
+func NewDNSDiscovery(name string, interval time.Duration) (*DNSDiscovery, error) {
+	protocol, target, ok := strings.Cut(name, "@")
+	if !ok || target == "" {
+		return nil, errors.New("rpc discovery: wrong format '" + name + "', expect protocol@host[:port]")
+	}
+	if interval <= 0 {
+		interval = defaultDNSInterval
+	}
+	d := &DNSDiscovery{
+		MultiServersDiscovery: NewMultiServersDiscovery(nil),
+		protocol:              protocol,
+		host:                  target,
+		interval:              interval,
+		lookupHost:            net.DefaultResolver.LookupHost,
+		lookupSRV: func(ctx context.Context, name string) ([]*net.SRV, error) {
+			_, addrs, err := net.DefaultResolver.LookupSRV(ctx, "", "", name)
+			return addrs, err
+		},
+	}
+	if host, port, err := net.SplitHostPort(target); err == nil {
+		d.host, d.port = host, port
+	}
+	return d, nil
+}
+
+// SetLogger 设置输出解析错误使用的 Logger，默认使用 geerpc.DefaultLogger
+func (d *DNSDiscovery) SetLogger(logger geerpc.Logger) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.logger = logger
+}
+
+func (d *DNSDiscovery) Update(servers []string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.servers = servers
+	d.weighted = nil
+	d.notify()
+	d.fetched = true
+	d.lastUpdate = time.Now()
+	return nil
+}
+
+// Refresh 距离上一次解析超过 interval 时重新解析
+func (d *DNSDiscovery) Refresh() error {
+	d.mu.RLock()
+	fresh := d.lastUpdate.Add(d.interval).After(time.Now())
+	logger := d.logger
+	d.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), dnsTimeout)
+	defer cancel()
+	servers, err := d.resolve(ctx)
+	if err != nil {
+		if logger == nil {
+			logger = geerpc.DefaultLogger
+		}
+		logger.Errorf("rpc discovery: resolve %s err: %v", d.host, err)
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if !d.fetched {
+			return err
+		}
+		d.lastUpdate = time.Now()
+		return nil
+	}
+	return d.Update(servers)
+}
+
+func (d *DNSDiscovery) resolve(ctx context.Context) ([]string, error) {
+	var servers []string
+	if d.port != "" {
+		hosts, err := d.lookupHost(ctx, d.host)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			servers = append(servers, d.protocol+"@"+net.JoinHostPort(host, d.port))
+		}
+	} else {
+		records, err := d.lookupSRV(ctx, d.host)
+		if err != nil {
+			return nil, err
+		}
+		if len(records) == 0 {
+			return nil, errors.New("rpc discovery: no SRV records for " + d.host)
+		}
+		priority := records[0].Priority
+		for _, r := range records {
+			if r.Priority < priority {
+				priority = r.Priority
+			}
+		}
+		for _, r := range records {
+			if r.Priority != priority {
+				continue
+			}
+			addr := d.protocol + "@" + net.JoinHostPort(strings.TrimSuffix(r.Target, "."), strconv.Itoa(int(r.Port)))
+			if r.Weight > 1 {
+				addr += "?weight=" + strconv.Itoa(int(r.Weight))
+			}
+			servers = append(servers, addr)
+		}
+	}
+	sort.Strings(servers) // 保持轮询的顺序稳定
+	return servers, nil
+}
+
+func (d *DNSDiscovery) Get(mode SelectMode) (string, error) {
+	if err := d.Refresh(); err != nil {
+		return "", err
+	}
+	return d.MultiServersDiscovery.Get(mode)
+}
+
+func (d *DNSDiscovery) GetAll() ([]string, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetAll()
+}
+
+func (d *DNSDiscovery) GetAllInfo() ([]ServerInfo, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetAllInfo()
+}
@@ -1,21 +1,29 @@
 package xclient
 
 import (
-	"log"
-	"net/http"
-	"strings"
+	"geerpc"
+	"geerpc/registry"
 	"time"
 )
 
+// GeeRegistryDiscovery 从 GeeRegistry 获取服务列表，列表超过 timeout 之后在 Get 和 GetAll 时重新获取，
+// 注册中心不可用时继续使用最后一次获取到的列表
 type GeeRegistryDiscovery struct {
 	*MultiServersDiscovery
 	registry   string
 	timeout    time.Duration
 	lastUpdate time.Time
+	logger     geerpc.Logger
+	fetched    bool          // 是否成功获取过服务列表
+	refreshing bool          // 是否有 goroutine 正在从注册中心获取
+	stop       chan struct{} // 关闭时停止后台刷新
+	done       chan struct{}
 }
 
 const defaultUpdateTimeout = time.Second * 10
 
+// NewGeeRegistryDiscovery 创建从 registerAddr 获取服务列表的 Discovery，registerAddr 可以是 HTTP 地址，
+// 也可以是 tcp@host:port，timeout 为 0 时使用 defaultUpdateTimeout
 func NewGeeRegistryDiscovery(registerAddr string, timeout time.Duration) *GeeRegistryDiscovery {
 	if timeout == 0 {
 		timeout = defaultUpdateTimeout
@@ -32,33 +40,102 @@ func (d *GeeRegistryDiscovery) Update(servers []string) error {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 	d.servers = servers
+	d.weighted = nil
+	d.notify()
+	d.fetched = true
 	d.lastUpdate = time.Now()
 	return nil
 }
 
-func (d *GeeRegistryDiscovery) Refresh() error {
+// SetLogger 设置输出刷新日志使用的 Logger，默认使用 geerpc.DefaultLogger
+func (d *GeeRegistryDiscovery) SetLogger(logger geerpc.Logger) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	if d.lastUpdate.Add(d.timeout).After(time.Now()) {
+	d.logger = logger
+}
+
+// Refresh 在服务列表过期时从注册中心重新获取
+func (d *GeeRegistryDiscovery) Refresh() error {
+	return d.refresh(false)
+}
+
+// refresh 从注册中心获取服务列表，force 为 false 时列表没有过期就直接返回，
+// 获取失败时如果之前成功获取过，保留原来的列表并等到下一个 timeout 再重试，不返回错误。
+// 访问注册中心时不持有 d.mu，已经有列表时如果其他 goroutine 正在获取，直接使用原来的列表
+func (d *GeeRegistryDiscovery) refresh(force bool) error {
+	d.mu.Lock()
+	// 开启后台刷新之后列表由后台刷新更新，Get 和 GetAll 不再等待网络请求
+	if d.fetched && (d.refreshing || !force && (d.stop != nil || d.lastUpdate.Add(d.timeout).After(time.Now()))) {
+		d.mu.Unlock()
 		return nil
 	}
-	log.Println("rpc registry: refresh servers from registry")
-	resp, err := http.Get(d.registry)
-	if err != nil {
-		log.Println("rpc registry refresh err:", err)
-		return err
+	d.refreshing = true
+	logger := d.logger
+	d.mu.Unlock()
+	if logger == nil {
+		logger = geerpc.DefaultLogger
 	}
-	servers := strings.Split(resp.Header.Get("X-Geerpc-Servers"), ",")
-	d.servers = make([]string, 0, len(servers))
-	for _, server := range servers {
-		if strings.TrimSpace(server) != "" {
-			d.servers = append(d.servers, strings.TrimSpace(server))
+
+	logger.Debugf("rpc registry: refresh servers from registry")
+	servers, err := registry.Lookup(d.registry)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.refreshing = false
+	if err != nil {
+		logger.Errorf("rpc registry refresh err: %v", err)
+		if !d.fetched {
+			return err
 		}
+		d.lastUpdate = time.Now()
+		return nil
 	}
+	d.servers = servers
+	d.weighted = nil
+	d.notify()
+	d.fetched = true
 	d.lastUpdate = time.Now()
 	return nil
 }
 
+// StartAutoRefresh 在后台每隔 interval 从注册中心获取一次服务列表，获取到列表之后 Get 和 GetAll 不再需要等待网络请求，
+// interval 为 0 时使用创建时的 timeout，已经开启时先停止之前的刷新
+func (d *GeeRegistryDiscovery) StartAutoRefresh(interval time.Duration) {
+	d.StopAutoRefresh()
+	if interval <= 0 {
+		interval = d.timeout
+	}
+	stop, done := make(chan struct{}), make(chan struct{})
+	d.mu.Lock()
+	d.stop, d.done = stop, done
+	d.mu.Unlock()
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			_ = d.refresh(true)
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// StopAutoRefresh 停止后台刷新，之后回到按需刷新
+func (d *GeeRegistryDiscovery) StopAutoRefresh() {
+	d.mu.Lock()
+	stop, done := d.stop, d.done
+	d.stop, d.done = nil, nil
+	d.mu.Unlock()
+	if stop != nil {
+		close(stop)
+		<-done
+	}
+}
+
 func (d *GeeRegistryDiscovery) Get(mode SelectMode) (string, error) {
 	if err := d.Refresh(); err != nil {
 		return "", err
@@ -72,3 +149,10 @@ func (d *GeeRegistryDiscovery) GetAll() ([]string, error) {
 	}
 	return d.MultiServersDiscovery.GetAll()
 }
+
+func (d *GeeRegistryDiscovery) GetAllInfo() ([]ServerInfo, error) {
+	if err := d.Refresh(); err != nil {
+		return nil, err
+	}
+	return d.MultiServersDiscovery.GetAllInfo()
+}
@@ -0,0 +1,316 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"geerpc/registry"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMultiServersDiscovery_Weighted(t *testing.T) {
+	d := NewMultiServersDiscovery([]string{"tcp@a:1?weight=5", "tcp@b:1", "tcp@c:1?weight=x"})
+	var picks []string
+	for i := 0; i < 7; i++ {
+		s, err := d.Get(WeightedRoundRobinSelect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		picks = append(picks, s[4:5])
+	}
+	// 平滑加权轮询不会连续选中权重高的实例
+	if got := strings.Join(picks, ""); got != "aabacaa" {
+		t.Fatalf("expect aabacaa, got %s", got)
+	}
+
+	all, _ := d.GetAll()
+	if strings.Join(all, ",") != "tcp@a:1,tcp@b:1,tcp@c:1" {
+		t.Fatalf("weights should be stripped from addresses, got %v", all)
+	}
+	if s, _ := d.Get(RoundRobinSelect); strings.Contains(s, "?") {
+		t.Fatalf("weights should be stripped from addresses, got %s", s)
+	}
+
+	_ = d.Update([]string{"tcp@b:1?weight=2", "tcp@c:1"})
+	picks = picks[:0]
+	for i := 0; i < 3; i++ {
+		s, _ := d.Get(WeightedRoundRobinSelect)
+		picks = append(picks, s[4:5])
+	}
+	if got := strings.Join(picks, ""); got != "bcb" {
+		t.Fatalf("expect bcb after update, got %s", got)
+	}
+}
+
+func TestMultiServersDiscovery_HealthCheck(t *testing.T) {
+	var mu sync.Mutex
+	healthy := map[string]bool{"tcp@a:1": true, "tcp@b:1": true}
+	setHealthy := func(addr string, ok bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		healthy[addr] = ok
+	}
+	d := NewMultiServersDiscovery([]string{"tcp@a:1", "tcp@b:1?weight=2"})
+	d.StartHealthCheck(HealthCheckOption{
+		Interval:          5 * time.Millisecond,
+		RecoveryThreshold: 3,
+		Probe: func(ctx context.Context, rpcAddr string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if !healthy[rpcAddr] {
+				return errors.New("unhealthy")
+			}
+			return nil
+		},
+	})
+	defer d.StopHealthCheck()
+
+	waitFor := func(expect string) {
+		deadline := time.Now().Add(time.Second)
+		for {
+			all, _ := d.GetAll()
+			if got := strings.Join(all, ","); got == expect {
+				return
+			} else if time.Now().After(deadline) {
+				t.Fatalf("expect %q, got %q", expect, got)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	setHealthy("tcp@b:1", false)
+	waitFor("tcp@a:1")
+	for _, mode := range []SelectMode{RandomSelect, RoundRobinSelect, WeightedRoundRobinSelect} {
+		if s, _ := d.Get(mode); s != "tcp@a:1" {
+			t.Fatalf("unhealthy server should not be selected, got %s", s)
+		}
+	}
+
+	setHealthy("tcp@a:1", false)
+	waitFor("")
+	if _, err := d.Get(RandomSelect); err == nil {
+		t.Fatal("expect an error when no server is healthy")
+	}
+
+	setHealthy("tcp@a:1", true)
+	setHealthy("tcp@b:1", true)
+	waitFor("tcp@a:1,tcp@b:1")
+
+	setHealthy("tcp@a:1", false)
+	waitFor("tcp@b:1")
+	d.StopHealthCheck()
+	if all, _ := d.GetAll(); len(all) != 2 {
+		t.Fatalf("all servers should be available after stopping, got %v", all)
+	}
+}
+
+func TestGeeRegistryDiscovery(t *testing.T) {
+	reg := registry.New(0)
+	ts := httptest.NewServer(reg)
+	registry.Heartbeat(ts.URL, "tcp@a:1", time.Hour)
+
+	unreachable := NewGeeRegistryDiscovery("http://127.0.0.1:1/_geerpc_/registry", time.Millisecond)
+	if _, err := unreachable.GetAll(); err == nil {
+		t.Fatal("expect an error before any list is fetched")
+	}
+
+	d := NewGeeRegistryDiscovery(ts.URL, time.Hour)
+	if all, err := d.GetAll(); err != nil || strings.Join(all, ",") != "tcp@a:1" {
+		t.Fatalf("expect tcp@a:1, got %v %v", all, err)
+	}
+
+	// 列表没有过期时不会重新获取，后台刷新会立即获取新的列表
+	registry.Heartbeat(ts.URL, "tcp@b:1", time.Hour)
+	if all, _ := d.GetAll(); len(all) != 1 {
+		t.Fatalf("list should not be refreshed before timeout, got %v", all)
+	}
+	d.StartAutoRefresh(10 * time.Millisecond)
+	defer d.StopAutoRefresh()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if all, _ := d.GetAll(); len(all) == 2 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("expect the list to be refreshed in background, got %v", all)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// 注册中心不可用时使用最后一次获取到的列表
+	ts.Close()
+	time.Sleep(30 * time.Millisecond)
+	if all, err := d.GetAll(); err != nil || strings.Join(all, ",") != "tcp@a:1,tcp@b:1" {
+		t.Fatalf("expect the last known list, got %v %v", all, err)
+	}
+}
+
+func TestGeeRegistryDiscovery_SlowRegistry(t *testing.T) {
+	var blocked atomic.Bool
+	release := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if blocked.Load() {
+			<-release
+		}
+		w.Header().Set("X-Geerpc-Servers", "tcp@a:1")
+	}))
+	defer ts.Close()
+	defer close(release)
+
+	d := NewGeeRegistryDiscovery(ts.URL, time.Millisecond)
+	logger := &bufferLogger{}
+	d.SetLogger(logger)
+	if all, err := d.GetAll(); err != nil || strings.Join(all, ",") != "tcp@a:1" {
+		t.Fatalf("expect tcp@a:1, got %v %v", all, err)
+	}
+	if !strings.Contains(logger.String(), "refresh servers from registry") {
+		t.Fatalf("refresh should be logged through the Logger, got %q", logger.String())
+	}
+
+	// 注册中心没有响应时，正在进行的刷新不能阻塞 GetAll
+	blocked.Store(true)
+	time.Sleep(2 * time.Millisecond)
+	go func() { _ = d.Refresh() }()
+	for {
+		d.mu.RLock()
+		refreshing := d.refreshing
+		d.mu.RUnlock()
+		if refreshing {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	got := make(chan []string, 1)
+	go func() {
+		all, _ := d.GetAll()
+		got <- all
+	}()
+	select {
+	case all := <-got:
+		if strings.Join(all, ",") != "tcp@a:1" {
+			t.Fatalf("expect the last known list, got %v", all)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("GetAll should not wait for a refresh in progress")
+	}
+}
+
+// bufferLogger 记录所有级别的日志
+type bufferLogger struct {
+	mu sync.Mutex
+	sb strings.Builder
+}
+
+func (l *bufferLogger) logf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintf(&l.sb, format+"\n", v...)
+}
+
+func (l *bufferLogger) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sb.String()
+}
+
+func (l *bufferLogger) Debugf(format string, v ...interface{}) { l.logf(format, v...) }
+func (l *bufferLogger) Infof(format string, v ...interface{})  { l.logf(format, v...) }
+func (l *bufferLogger) Errorf(format string, v ...interface{}) { l.logf(format, v...) }
+
+func TestDNSDiscovery(t *testing.T) {
+	if _, err := NewDNSDiscovery("localhost:9999", 0); err == nil {
+		t.Fatal("expect an error for name without protocol")
+	}
+
+	d, _ := NewDNSDiscovery("tcp@geerpc.local:9999", time.Hour)
+	d.lookupHost = func(ctx context.Context, host string) ([]string, error) {
+		return []string{"10.0.0.2", "10.0.0.1", "fd00::1"}, nil
+	}
+	if all, err := d.GetAll(); err != nil || strings.Join(all, ",") != "tcp@10.0.0.1:9999,tcp@10.0.0.2:9999,tcp@[fd00::1]:9999" {
+		t.Fatalf("unexpected servers %v %v", all, err)
+	}
+
+	d, _ = NewDNSDiscovery("tcp@_geerpc._tcp.geerpc.local", time.Millisecond)
+	d.lookupSRV = func(ctx context.Context, name string) ([]*net.SRV, error) {
+		return nil, errors.New("no such host")
+	}
+	logger := &bufferLogger{}
+	d.SetLogger(logger)
+	if _, err := d.GetAll(); err == nil {
+		t.Fatal("expect an error before any record is resolved")
+	}
+	if !strings.Contains(logger.String(), "no such host") {
+		t.Fatalf("resolve error should be logged through the Logger, got %q", logger.String())
+	}
+	d.lookupSRV = func(ctx context.Context, name string) ([]*net.SRV, error) {
+		return []*net.SRV{
+			{Target: "b.geerpc.local.", Port: 2, Priority: 10, Weight: 3},
+			{Target: "a.geerpc.local.", Port: 1, Priority: 10, Weight: 1},
+			{Target: "backup.geerpc.local.", Port: 3, Priority: 20, Weight: 1},
+		}, nil
+	}
+	if all, err := d.GetAll(); err != nil || strings.Join(all, ",") != "tcp@a.geerpc.local:1,tcp@b.geerpc.local:2" {
+		t.Fatalf("only records with the lowest priority should be used, got %v %v", all, err)
+	}
+	var picks []string
+	for i := 0; i < 4; i++ {
+		s, _ := d.Get(WeightedRoundRobinSelect)
+		picks = append(picks, s[4:5])
+	}
+	if got := strings.Join(picks, ""); got != "babb" {
+		t.Fatalf("weights from SRV records should be used, got %s", got)
+	}
+
+	// 解析失败时使用上一次的结果
+	d.lookupSRV = func(ctx context.Context, name string) ([]*net.SRV, error) {
+		return nil, errors.New("no such host")
+	}
+	time.Sleep(2 * time.Millisecond)
+	if all, err := d.GetAll(); err != nil || len(all) != 2 {
+		t.Fatalf("expect the last resolved servers, got %v %v", all, err)
+	}
+}
+
+func TestMultiServersDiscovery_Watch(t *testing.T) {
+	d := NewMultiServersDiscovery([]string{"tcp@a:1?weight=2"})
+	ch, cancel := d.Watch()
+	if servers := <-ch; strings.Join(servers, ",") != "tcp@a:1" {
+		t.Fatalf("expect the current servers first, got %v", servers)
+	}
+	// 没有及时接收时只保留最新的列表
+	_ = d.Update([]string{"tcp@b:1"})
+	_ = d.Update([]string{"tcp@b:1", "tcp@c:1"})
+	if servers := <-ch; strings.Join(servers, ",") != "tcp@b:1,tcp@c:1" {
+		t.Fatalf("expect the latest servers, got %v", servers)
+	}
+	cancel()
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Fatal("channel should be closed after cancel")
+	}
+	_ = d.Update(nil)
+}
+
+func TestParseServerInfo(t *testing.T) {
+	info := ParseServerInfo("tcp@10.0.0.1:1?weight=3&version=v2&zone=bj-a&tags=gpu,ssd&owner=foo")
+	expect := ServerInfo{Addr: "tcp@10.0.0.1:1", Weight: 3, Version: "v2", Zone: "bj-a", Tags: []string{"gpu", "ssd"}, Meta: map[string]string{"owner": "foo"}}
+	if !reflect.DeepEqual(info, expect) {
+		t.Fatalf("expect %+v, got %+v", expect, info)
+	}
+	if again := ParseServerInfo(info.String()); !reflect.DeepEqual(again, expect) {
+		t.Fatalf("String should be the inverse of ParseServerInfo, got %+v", again)
+	}
+	if strings.Contains(info.String(), ",") {
+		t.Fatalf("commas should be escaped, got %s", info.String())
+	}
+	if s := ParseServerInfo("tcp@10.0.0.1:1").String(); s != "tcp@10.0.0.1:1" {
+		t.Fatalf("expect address without params, got %s", s)
+	}
+}
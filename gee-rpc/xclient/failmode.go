@@ -0,0 +1,127 @@
+package xclient
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"time"
+)
+
+// FailMode 决定 XClient.Call 调用失败之后怎么处理
+type FailMode int
+
+const (
+	// Failover 重新选择一个实例重试，默认的处理方式
+	Failover FailMode = iota
+	// Failtry 在同一个实例上重试，适合实例上有缓存等状态的场景
+	Failtry
+	// Failfast 失败之后立即返回，不重试
+	Failfast
+	// Failbackup 如果一段时间之内没有返回，向另一个实例发送相同的请求，使用先返回的结果，
+	// 方法会被执行两次，只适合幂等的方法
+	Failbackup
+)
+
+func (m FailMode) String() string {
+	switch m {
+	case Failover:
+		return "failover"
+	case Failtry:
+		return "failtry"
+	case Failfast:
+		return "failfast"
+	default:
+		return "failbackup"
+	}
+}
+
+const defaultBackupDelay = 10 * time.Millisecond
+
+// SetFailMode 设置调用失败之后的处理方式，Failover 和 Failtry 的重试次数和条件由 Option.Retry 决定，
+// 没有设置 Option.Retry 时和 Failfast 一样不重试，需要在发起调用之前设置
+func (xc *XClient) SetFailMode(mode FailMode) {
+	xc.failMode = mode
+}
+
+// SetBackupDelay 设置 Failbackup 模式下发送备份请求之前等待的时间，默认 10ms
+func (xc *XClient) SetBackupDelay(d time.Duration) {
+	xc.backupDelay = d
+}
+
+// backupCall 先调用一个实例，backupDelay 之后还没有返回就再调用另一个实例，
+// 任意一个成功就取消另一个，两个都失败时返回第一个错误
+func (xc *XClient) backupCall(ctx context.Context, serviceMethod string, args, reply interface{}, o *callOptions) error {
+	first, err := xc.selectFor(ctx, o)
+	if err != nil {
+		return err
+	}
+	atomic.AddUint64(&xc.hedge.requests, 1)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	type result struct {
+		reply  interface{}
+		err    error
+		backup bool
+	}
+	results := make(chan result, 2)
+	call := func(rpcAddr string, backup bool) {
+		var cloneReply interface{} // 两个调用同时进行，各自写自己的 reply
+		if reply != nil {
+			cloneReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
+		}
+		err := xc.call(rpcAddr, ctx, serviceMethod, args, cloneReply, o.callOpts)
+		results <- result{reply: cloneReply, err: err, backup: backup}
+	}
+	go call(first, false)
+
+	pending := 1
+	timer := time.NewTimer(xc.backupDelay)
+	defer timer.Stop()
+	var firstErr error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if backup := xc.backupServer(first, o); backup != "" {
+				pending++
+				atomic.AddUint64(&xc.hedge.hedged, 1)
+				go call(backup, true)
+			}
+		case r := <-results:
+			pending--
+			if r.err == nil {
+				if r.backup {
+					atomic.AddUint64(&xc.hedge.backupWins, 1)
+				}
+				if reply != nil {
+					reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(r.reply).Elem())
+				}
+				return nil
+			}
+			if firstErr == nil {
+				firstErr = r.err
+			}
+		}
+	}
+	return firstErr
+}
+
+// backupServer 选择一个和 first 不同的实例，只有一个实例时返回空字符串，
+// 指定了哈希 key 时返回排除 first 之后哈希选中的实例，同一个 key 的备份请求也总是发送到同一个实例
+func (xc *XClient) backupServer(first string, o *callOptions) string {
+	if o.selective() {
+		rpcAddr, _ := xc.selectWith(o, first)
+		return rpcAddr
+	}
+	for i := 0; i < 3; i++ {
+		if rpcAddr, err := xc.selectServer(); err == nil && rpcAddr != first {
+			return rpcAddr
+		}
+	}
+	servers, _ := xc.d.GetAll()
+	for _, rpcAddr := range servers {
+		if rpcAddr != first {
+			return rpcAddr
+		}
+	}
+	return ""
+}
@@ -0,0 +1,165 @@
+package xclient
+
+import (
+	"context"
+	"geerpc"
+	"sync"
+	"time"
+)
+
+// HealthCheckOption 设置主动健康检查，零值使用默认值
+type HealthCheckOption struct {
+	Interval time.Duration // 检查的间隔，默认 10s
+	Timeout  time.Duration // 每次检查的超时时间，默认 1s
+	// FailureThreshold 连续失败这么多次之后摘除实例，默认 1
+	FailureThreshold int
+	// RecoveryThreshold 摘除之后连续成功这么多次才恢复，默认 2，避免实例在可用和不可用之间反复切换
+	RecoveryThreshold int
+	// Probe 检查一个实例，为空时连接到实例并调用内置的 Health 服务
+	Probe func(ctx context.Context, rpcAddr string) error
+}
+
+type healthChecker struct {
+	opt  HealthCheckOption
+	stop chan struct{}
+	done chan struct{}
+	// 每个实例连续成功和失败的次数
+	successes map[string]int
+	failures  map[string]int
+}
+
+// StartHealthCheck 开始定期检查所有实例，不健康的实例暂时不会被 Get 和 GetAll 返回，
+// 已经开启时先停止之前的检查
+func (d *MultiServersDiscovery) StartHealthCheck(opt HealthCheckOption) {
+	d.StopHealthCheck()
+	if opt.Interval <= 0 {
+		opt.Interval = 10 * time.Second
+	}
+	if opt.Timeout <= 0 {
+		opt.Timeout = time.Second
+	}
+	if opt.FailureThreshold <= 0 {
+		opt.FailureThreshold = 1
+	}
+	if opt.RecoveryThreshold <= 0 {
+		opt.RecoveryThreshold = 2
+	}
+	if opt.Probe == nil {
+		opt.Probe = probeHealth
+	}
+	h := &healthChecker{
+		opt:       opt,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+		successes: make(map[string]int),
+		failures:  make(map[string]int),
+	}
+	d.mu.Lock()
+	d.health = h
+	d.down = make(map[string]bool)
+	d.mu.Unlock()
+	go d.runHealthCheck(h)
+}
+
+// StopHealthCheck 停止健康检查，所有实例重新变为可用
+func (d *MultiServersDiscovery) StopHealthCheck() {
+	d.mu.Lock()
+	h := d.health
+	d.health, d.down = nil, nil
+	d.notify()
+	d.mu.Unlock()
+	if h != nil {
+		close(h.stop)
+		<-h.done
+	}
+}
+
+func (d *MultiServersDiscovery) runHealthCheck(h *healthChecker) {
+	defer close(h.done)
+	ticker := time.NewTicker(h.opt.Interval)
+	defer ticker.Stop()
+	for {
+		d.probeAll(h)
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeAll 并发检查当前的所有实例，包括已经被摘除的实例
+func (d *MultiServersDiscovery) probeAll(h *healthChecker) {
+	d.mu.RLock()
+	servers := make([]string, 0, len(d.servers))
+	for _, s := range d.servers {
+		servers = append(servers, stripWeight(s))
+	}
+	d.mu.RUnlock()
+
+	results := make([]error, len(servers))
+	var wg sync.WaitGroup
+	for i, rpcAddr := range servers {
+		wg.Add(1)
+		go func(i int, rpcAddr string) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), h.opt.Timeout)
+			defer cancel()
+			results[i] = h.opt.Probe(ctx, rpcAddr)
+		}(i, rpcAddr)
+	}
+	wg.Wait()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.health != h {
+		return // 检查过程中被停止了
+	}
+	changed := false
+	for i, rpcAddr := range servers {
+		if results[i] != nil {
+			h.successes[rpcAddr] = 0
+			if h.failures[rpcAddr]++; h.failures[rpcAddr] >= h.opt.FailureThreshold && !d.down[rpcAddr] {
+				d.down[rpcAddr] = true
+				changed = true
+			}
+			continue
+		}
+		h.failures[rpcAddr] = 0
+		if h.successes[rpcAddr]++; d.down[rpcAddr] && h.successes[rpcAddr] >= h.opt.RecoveryThreshold {
+			delete(d.down, rpcAddr)
+			changed = true
+		}
+	}
+	if changed {
+		d.notify()
+	}
+}
+
+// probeHealth 建立一个新连接并调用内置的 Health 服务，检查完成之后关闭连接
+func probeHealth(ctx context.Context, rpcAddr string) error {
+	opt := &geerpc.Option{}
+	if deadline, ok := ctx.Deadline(); ok {
+		opt.ConnectTimeout = time.Until(deadline)
+	}
+	client, err := geerpc.XDial(rpcAddr, opt)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = client.Close() }()
+	return client.HealthCheck(ctx)
+}
+
+// available 返回没有被健康检查摘除的实例，调用方需要持有 d.mu
+func (d *MultiServersDiscovery) available() []string {
+	if len(d.down) == 0 {
+		return d.servers
+	}
+	servers := make([]string, 0, len(d.servers))
+	for _, s := range d.servers {
+		if !d.down[stripWeight(s)] {
+			servers = append(servers, s)
+		}
+	}
+	return servers
+}
@@ -0,0 +1,34 @@
+package xclient
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// HedgeStats 是 Failbackup（对冲请求）的统计，用于评估对冲延迟设置得是否合理：
+// Hedged/Requests 过高说明延迟太小，浪费了服务端资源，BackupWins 很少说明对冲没有起到作用
+type HedgeStats struct {
+	Requests   uint64 // 使用 Failbackup 发起的调用数
+	Hedged     uint64 // 等待超过延迟之后发送了备份请求的调用数
+	BackupWins uint64 // 备份请求先成功返回的调用数
+}
+
+type hedgeCounters struct {
+	requests, hedged, backupWins uint64
+}
+
+// SetHedging 开启对冲请求：调用在 delay 之内没有返回时向另一个实例发送相同的请求，使用先成功的结果并取消另一个，
+// 等价于 SetFailMode(Failbackup) 加上 SetBackupDelay(delay)，delay 通常设置为调用延迟的 P95 左右
+func (xc *XClient) SetHedging(delay time.Duration) {
+	xc.SetFailMode(Failbackup)
+	xc.SetBackupDelay(delay)
+}
+
+// HedgeStats 返回对冲请求的统计
+func (xc *XClient) HedgeStats() HedgeStats {
+	return HedgeStats{
+		Requests:   atomic.LoadUint64(&xc.hedge.requests),
+		Hedged:     atomic.LoadUint64(&xc.hedge.hedged),
+		BackupWins: atomic.LoadUint64(&xc.hedge.backupWins),
+	}
+}
@@ -0,0 +1,193 @@
+package xclient
+
+import (
+	"math/rand"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 和权重一样，服务实例的元数据写在地址后面的参数中，比如
+// tcp@10.0.0.1:9999?weight=3&version=v2&zone=bj-a&tags=gpu,ssd&owner=foo，
+// 服务端把带元数据的地址注册到注册中心，Discovery.GetAllInfo 返回解析之后的元数据，
+// XClient 的 Router 根据元数据过滤实例，实现按机房就近访问、按版本灰度等功能
+
+// ServerInfo 是一个服务实例以及它的元数据
+type ServerInfo struct {
+	Addr    string // 去掉参数之后的地址，可以直接用于 XDial
+	Weight  int    // 没有设置时为 1
+	Version string
+	Zone    string
+	Tags    []string
+	Meta    map[string]string // 其他参数
+}
+
+// ParseServerInfo 解析带参数的服务地址，参数不合法时忽略
+func ParseServerInfo(server string) ServerInfo {
+	addr, weight := parseWeight(server)
+	info := ServerInfo{Addr: addr, Weight: weight}
+	i := strings.LastIndex(server, "?")
+	if i < 0 {
+		return info
+	}
+	q, err := url.ParseQuery(server[i+1:])
+	if err != nil {
+		return info
+	}
+	for key := range q {
+		value := q.Get(key)
+		switch key {
+		case "weight":
+		case "version":
+			info.Version = value
+		case "zone":
+			info.Zone = value
+		case "tags":
+			for _, tag := range strings.Split(value, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					info.Tags = append(info.Tags, tag)
+				}
+			}
+		default:
+			if info.Meta == nil {
+				info.Meta = make(map[string]string)
+			}
+			info.Meta[key] = value
+		}
+	}
+	return info
+}
+
+// String 返回带参数的服务地址，和 ParseServerInfo 互逆，可以用于注册到注册中心
+func (s ServerInfo) String() string {
+	q := url.Values{}
+	if s.Weight > 1 {
+		q.Set("weight", strconv.Itoa(s.Weight))
+	}
+	if s.Version != "" {
+		q.Set("version", s.Version)
+	}
+	if s.Zone != "" {
+		q.Set("zone", s.Zone)
+	}
+	if len(s.Tags) > 0 {
+		q.Set("tags", strings.Join(s.Tags, ","))
+	}
+	for key, value := range s.Meta {
+		q.Set(key, value)
+	}
+	if len(q) == 0 {
+		return s.Addr
+	}
+	// 标签之间的逗号会被转义，GeeRegistry 使用逗号分隔服务列表，注册到 GeeRegistry 时需要使用转义之后的地址
+	return s.Addr + "?" + q.Encode()
+}
+
+// HasTag 判断实例是否带有 tag
+func (s ServerInfo) HasTag(tag string) bool {
+	for _, t := range s.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// GetAllInfo 返回所有可用实例以及它们的元数据
+func (d *MultiServersDiscovery) GetAllInfo() ([]ServerInfo, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	available := d.available()
+	infos := make([]ServerInfo, len(available))
+	for i, s := range available {
+		infos[i] = ParseServerInfo(s)
+	}
+	return infos, nil
+}
+
+// Matcher 判断实例是否满足条件
+type Matcher func(ServerInfo) bool
+
+// MatchVersion 匹配 version 等于 version 的实例
+func MatchVersion(version string) Matcher {
+	return func(s ServerInfo) bool { return s.Version == version }
+}
+
+// MatchZone 匹配 zone 等于 zone 的实例
+func MatchZone(zone string) Matcher {
+	return func(s ServerInfo) bool { return s.Zone == zone }
+}
+
+// MatchTag 匹配带有 tag 的实例
+func MatchTag(tag string) Matcher {
+	return func(s ServerInfo) bool { return s.HasTag(tag) }
+}
+
+// MatchMeta 匹配参数 key 等于 value 的实例
+func MatchMeta(key, value string) Matcher {
+	return func(s ServerInfo) bool { return s.Meta[key] == value }
+}
+
+// Router 在负载均衡之前过滤候选实例，多个 Router 按照顺序执行，最后没有剩下实例时调用失败
+type Router func(servers []ServerInfo) []ServerInfo
+
+// Only 只使用满足 m 的实例
+func Only(m Matcher) Router {
+	return func(servers []ServerInfo) []ServerInfo {
+		return filter(servers, m, true)
+	}
+}
+
+// PreferZone 同一个 zone 有可用实例时只使用这些实例，否则使用所有实例
+func PreferZone(zone string) Router {
+	m := MatchZone(zone)
+	return func(servers []ServerInfo) []ServerInfo {
+		if local := filter(servers, m, true); len(local) > 0 {
+			return local
+		}
+		return servers
+	}
+}
+
+// Canary 把 percent（0 到 100）比例的调用发送到满足 m 的实例，其余调用发送到不满足 m 的实例，
+// 某一边没有实例时使用所有实例，比如 Canary(MatchVersion("v2"), 5) 把 5% 的流量发送到 v2
+func Canary(m Matcher, percent float64) Router {
+	return func(servers []ServerInfo) []ServerInfo {
+		matched := rand.Float64()*100 < percent
+		if picked := filter(servers, m, matched); len(picked) > 0 {
+			return picked
+		}
+		return servers
+	}
+}
+
+// filter 返回 m 的结果等于 match 的实例
+func filter(servers []ServerInfo, m Matcher, match bool) []ServerInfo {
+	picked := make([]ServerInfo, 0, len(servers))
+	for _, s := range servers {
+		if m(s) == match {
+			picked = append(picked, s)
+		}
+	}
+	return picked
+}
+
+// SetRouters 设置路由规则，之后 Call 在 Router 过滤之后的实例中按照 SelectMode 选择，
+// 需要在发起调用之前设置，Broadcast 不受影响
+func (xc *XClient) SetRouters(routers ...Router) {
+	xc.routers = routers
+}
+
+// route 获取所有实例并执行路由规则，返回按地址排序的候选实例
+func (xc *XClient) route() ([]ServerInfo, error) {
+	servers, err := xc.d.GetAllInfo()
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range xc.routers {
+		servers = r(servers)
+	}
+	sort.Slice(servers, func(i, j int) bool { return servers[i].Addr < servers[j].Addr })
+	return servers, nil
+}
@@ -0,0 +1,133 @@
+package xclient
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ewmaAlpha 是新的延迟样本在 EWMA 中的权重，越大对延迟变化越敏感
+const ewmaAlpha = 0.3
+
+// endpointStats 记录一个实例的延迟 EWMA 和正在进行的调用数
+type endpointStats struct {
+	inflight int64
+	mu       sync.Mutex
+	ewma     float64 // 纳秒，没有样本时为 0，新实例会优先被选中
+}
+
+// begin 开始一次调用，返回的函数在调用结束时执行
+func (s *endpointStats) begin() func() {
+	atomic.AddInt64(&s.inflight, 1)
+	start := time.Now()
+	return func() {
+		atomic.AddInt64(&s.inflight, -1)
+		s.observe(time.Since(start))
+	}
+}
+
+func (s *endpointStats) observe(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ewma == 0 {
+		s.ewma = float64(d)
+		return
+	}
+	s.ewma = ewmaAlpha*float64(d) + (1-ewmaAlpha)*s.ewma
+}
+
+// load 综合延迟和未完成调用数的负载，越小越好
+func (s *endpointStats) load() float64 {
+	s.mu.Lock()
+	ewma := s.ewma
+	s.mu.Unlock()
+	return (ewma + 1) * float64(atomic.LoadInt64(&s.inflight)+1)
+}
+
+func (xc *XClient) getStats(rpcAddr string) *endpointStats {
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	s, ok := xc.stats[rpcAddr]
+	if !ok {
+		s = &endpointStats{}
+		xc.stats[rpcAddr] = s
+	}
+	return s
+}
+
+// selectServer 按照负载均衡策略选择实例，P2CSelect 需要 XClient 的统计信息，其他策略交给 Discovery，
+// 设置了 Router 时由 XClient 在过滤之后的实例中选择
+func (xc *XClient) selectServer() (string, error) {
+	if len(xc.routers) > 0 {
+		return xc.selectRouted()
+	}
+	if xc.mode != P2CSelect {
+		return xc.d.Get(xc.mode)
+	}
+	servers, err := xc.d.GetAll()
+	if err != nil {
+		return "", err
+	}
+	return xc.p2c(servers)
+}
+
+// selectRouted 在路由之后的实例中选择，加权轮询的状态保存在 Discovery 中，这里使用按权重随机代替
+func (xc *XClient) selectRouted() (string, error) {
+	servers, err := xc.route()
+	if err != nil {
+		return "", err
+	}
+	if len(servers) == 0 {
+		return "", errors.New("rpc xclient: no server matches the routers")
+	}
+	return xc.pick(servers), nil
+}
+
+// pick 按照 SelectMode 在 servers 中选择一个实例，servers 不能为空
+func (xc *XClient) pick(servers []ServerInfo) string {
+	switch xc.mode {
+	case RoundRobinSelect:
+		return servers[(atomic.AddUint64(&xc.next, 1)-1)%uint64(len(servers))].Addr
+	case WeightedRoundRobinSelect:
+		total := 0
+		for _, s := range servers {
+			total += s.Weight
+		}
+		n := rand.Intn(total)
+		for _, s := range servers {
+			if n -= s.Weight; n < 0 {
+				return s.Addr
+			}
+		}
+	case P2CSelect:
+		addrs := make([]string, len(servers))
+		for i, s := range servers {
+			addrs[i] = s.Addr
+		}
+		addr, _ := xc.p2c(addrs)
+		return addr
+	}
+	return servers[rand.Intn(len(servers))].Addr
+}
+
+// p2c 随机选出两个实例，返回负载较低的那个
+func (xc *XClient) p2c(servers []string) (string, error) {
+	switch len(servers) {
+	case 0:
+		return "", errors.New("rpc discovery: no available servers")
+	case 1:
+		return servers[0], nil
+	}
+	i := rand.Intn(len(servers))
+	j := rand.Intn(len(servers) - 1)
+	if j >= i {
+		j++ // 保证选出的两个实例不同
+	}
+	a, b := servers[i], servers[j]
+	if xc.getStats(b).load() < xc.getStats(a).load() {
+		return b, nil
+	}
+	return a, nil
+}
@@ -0,0 +1,50 @@
+package xclient
+
+// Watch 返回一个 channel，立即发送一次当前的服务列表，之后每次服务列表变化（包括健康检查摘除或恢复实例）
+// 都会发送新的列表，列表的格式和 GetAll 相同。接收不及时的时候只保留最新的列表，
+// 调用返回的 cancel 之后 channel 会被关闭
+func (d *MultiServersDiscovery) Watch() (<-chan []string, func()) {
+	ch := make(chan []string, 1)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.watchers == nil {
+		d.watchers = make(map[chan []string]struct{})
+	}
+	d.watchers[ch] = struct{}{}
+	ch <- d.snapshot()
+	cancel := func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		if _, ok := d.watchers[ch]; ok {
+			delete(d.watchers, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+// notify 把当前的服务列表发送给所有的 watcher，调用方需要持有 d.mu
+func (d *MultiServersDiscovery) notify() {
+	if len(d.watchers) == 0 {
+		return
+	}
+	servers := d.snapshot()
+	for ch := range d.watchers {
+		// 丢弃还没有被接收的旧列表，channel 的缓冲为 1，并且只在持有 d.mu 时发送，所以不会阻塞
+		select {
+		case <-ch:
+		default:
+		}
+		ch <- servers
+	}
+}
+
+// snapshot 返回可用实例去掉权重之后的地址，调用方需要持有 d.mu
+func (d *MultiServersDiscovery) snapshot() []string {
+	available := d.available()
+	servers := make([]string, len(available))
+	for i, s := range available {
+		servers[i] = stripWeight(s)
+	}
+	return servers
+}
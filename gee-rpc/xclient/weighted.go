@@ -0,0 +1,64 @@
+package xclient
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// 服务实例的权重写在地址后面，比如 tcp@10.0.0.1:9999?weight=3，没有写时权重为 1，
+// 注册到注册中心的时候带上权重，或者通过 Update 手动设置，Discovery 返回的地址会去掉权重部分
+
+type weightedServer struct {
+	addr    string
+	weight  int
+	current int
+}
+
+// parseWeight 把服务地址拆分为真正的地址和权重，权重不合法时使用 1
+func parseWeight(server string) (string, int) {
+	i := strings.LastIndex(server, "?")
+	if i < 0 {
+		return server, 1
+	}
+	q, err := url.ParseQuery(server[i+1:])
+	if err != nil {
+		return server[:i], 1
+	}
+	weight, err := strconv.Atoi(q.Get("weight"))
+	if err != nil || weight <= 0 {
+		return server[:i], 1
+	}
+	return server[:i], weight
+}
+
+func stripWeight(server string) string {
+	addr, _ := parseWeight(server)
+	return addr
+}
+
+// nextWeighted 使用平滑加权轮询（和 nginx 相同）选择实例：每次选择时所有实例的 current 加上自己的权重，
+// 选出 current 最大的实例，再把它的 current 减去总权重，这样权重高的实例不会被连续选中，调用方需要持有 d.mu
+func (d *MultiServersDiscovery) nextWeighted() string {
+	if d.weighted == nil {
+		d.weighted = make([]*weightedServer, 0, len(d.servers))
+		for _, s := range d.servers {
+			addr, weight := parseWeight(s)
+			d.weighted = append(d.weighted, &weightedServer{addr: addr, weight: weight})
+		}
+	}
+	var best *weightedServer
+	total := 0
+	for _, s := range d.weighted {
+		if d.down[s.addr] {
+			continue
+		}
+		s.current += s.weight
+		total += s.weight
+		if best == nil || s.current > best.current {
+			best = s
+		}
+	}
+	best.current -= total
+	return best.addr
+}
@@ -2,10 +2,12 @@ package xclient
 
 import (
 	"context"
+	"errors"
 	"geerpc"
 	"io"
 	"reflect"
 	"sync"
+	"time"
 )
 
 type XClient struct {
@@ -14,20 +16,142 @@ type XClient struct {
 	opt     *geerpc.Option
 	mu      sync.Mutex
 	clients map[string]*geerpc.Client
+	retry   *geerpc.RetryPolicy
+	// breakers 每个服务实例一个熔断器，和 Client 分开保存，重新连接之后熔断状态不会丢失
+	breaker  *geerpc.BreakerPolicy
+	breakers map[string]*geerpc.Breaker
+
+	failMode    FailMode
+	backupDelay time.Duration
+	hedge       hedgeCounters
+
+	stats map[string]*endpointStats // 每个实例的延迟和未完成调用数，P2CSelect 使用
+
+	cancelWatch func() // 取消订阅 Discovery 的服务列表变化
+	watchDone   chan struct{}
+
+	routers []Router
+	next    uint64 // 设置了 Router 时 RoundRobinSelect 使用的计数
+
+	affinity  time.Duration       // 会话的空闲时间，为 0 时不开启会话保持
+	sessions  map[string]*session // 会话标识 -> 绑定的实例
+	lastSweep time.Time
+
+	lastUsed        map[string]time.Time // 每个 Client 最近一次被使用的时间
+	maxIdle         time.Duration
+	cleanupInterval time.Duration
+	cleanupReset    chan struct{}
+	closing         chan struct{} // Close 时关闭，通知后台的清理任务退出
+	janitorDone     chan struct{}
+	closeOnce       sync.Once
+	closed          bool
 }
 
 var _ io.Closer = (*XClient)(nil)
 
 func NewXClient(d Discovery, mode SelectMode, opt *geerpc.Option) *XClient {
-	return &XClient{d: d, mode: mode, opt: opt, clients: make(map[string]*geerpc.Client)}
+	xc := &XClient{
+		d:           d,
+		mode:        mode,
+		opt:         opt,
+		clients:     make(map[string]*geerpc.Client),
+		breakers:    make(map[string]*geerpc.Breaker),
+		backupDelay: defaultBackupDelay,
+		stats:       make(map[string]*endpointStats),
+
+		lastUsed:        make(map[string]time.Time),
+		cleanupInterval: defaultCleanupInterval,
+		cleanupReset:    make(chan struct{}, 1),
+		closing:         make(chan struct{}),
+		janitorDone:     make(chan struct{}),
+	}
+	if opt != nil && (opt.Retry != nil || opt.Breaker != nil) {
+		// 由 XClient 负责重试，每次重试都重新选择服务实例，Client 自己不再重试，熔断也由 XClient 按实例处理
+		o := *opt
+		xc.retry, o.Retry = o.Retry, nil
+		xc.breaker, o.Breaker = o.Breaker, nil
+		xc.opt = &o
+	}
+	ch, cancel := d.Watch()
+	xc.cancelWatch, xc.watchDone = cancel, make(chan struct{})
+	go xc.watch(ch)
+	go xc.janitor()
+	return xc
+}
+
+// watch 服务列表变化之后立即关闭已经被移除的实例的连接，而不是等到下一次调用
+func (xc *XClient) watch(ch <-chan []string) {
+	defer close(xc.watchDone)
+	for servers := range ch {
+		xc.prune(servers)
+	}
+}
+
+// prune 关闭不在 servers 中的实例的连接，并清理它们的熔断器和统计信息
+func (xc *XClient) prune(servers []string) {
+	alive := make(map[string]bool, len(servers))
+	for _, s := range servers {
+		alive[s] = true
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	for rpcAddr, client := range xc.clients {
+		if !alive[rpcAddr] {
+			xc.logger().Debugf("rpc xclient: %s is removed from discovery, close its client", rpcAddr)
+			_ = client.Close()
+			delete(xc.clients, rpcAddr)
+			delete(xc.lastUsed, rpcAddr)
+		}
+	}
+	for rpcAddr := range xc.breakers {
+		if !alive[rpcAddr] {
+			delete(xc.breakers, rpcAddr)
+		}
+	}
+	for rpcAddr := range xc.stats {
+		if !alive[rpcAddr] {
+			delete(xc.stats, rpcAddr)
+		}
+	}
+	for key, s := range xc.sessions {
+		if !alive[s.addr] {
+			delete(xc.sessions, key)
+		}
+	}
+}
+
+// SetLogger 设置 XClient 以及它创建的 Client 使用的 Logger，需要在发起调用之前设置
+func (xc *XClient) SetLogger(logger geerpc.Logger) {
+	opt := *geerpc.DefaultOption
+	if xc.opt != nil {
+		opt = *xc.opt
+	}
+	opt.Logger = logger
+	xc.opt = &opt
 }
 
+func (xc *XClient) logger() geerpc.Logger {
+	if xc.opt == nil || xc.opt.Logger == nil {
+		return geerpc.DefaultLogger
+	}
+	return xc.opt.Logger
+}
+
+// Close 停止后台任务并关闭所有的 Client，之后的调用返回 geerpc.ErrShutdown，可以多次调用
 func (xc *XClient) Close() error {
+	xc.closeOnce.Do(func() {
+		close(xc.closing)
+		xc.cancelWatch()
+		<-xc.watchDone
+		<-xc.janitorDone
+	})
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
+	xc.closed = true
 	for key, client := range xc.clients {
 		_ = client.Close()
 		delete(xc.clients, key)
+		delete(xc.lastUsed, key)
 	}
 	return nil
 }
@@ -35,9 +159,14 @@ func (xc *XClient) Close() error {
 func (xc *XClient) dial(rpcAddr string) (*geerpc.Client, error) {
 	xc.mu.Lock()
 	defer xc.mu.Unlock()
+	if xc.closed {
+		return nil, geerpc.ErrShutdown
+	}
 	client, ok := xc.clients[rpcAddr]
 	if ok && !client.IsAvailable() {
+		xc.logger().Debugf("rpc xclient: client of %s is unavailable, redial", rpcAddr)
 		_ = client.Close()
+
 		delete(xc.clients, rpcAddr)
 		client = nil
 	}
@@ -49,35 +178,104 @@ func (xc *XClient) dial(rpcAddr string) (*geerpc.Client, error) {
 		}
 		xc.clients[rpcAddr] = client
 	}
+	xc.lastUsed[rpcAddr] = time.Now()
 	return client, nil
 }
 
-func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	client, err := xc.dial(rpcAddr)
-	if err != nil {
-		return err
+// getBreaker 返回 rpcAddr 对应的熔断器，没有设置 Option.Breaker 时返回 nil
+func (xc *XClient) getBreaker(rpcAddr string) *geerpc.Breaker {
+	if xc.breaker == nil {
+		return nil
+	}
+	xc.mu.Lock()
+	defer xc.mu.Unlock()
+	b, ok := xc.breakers[rpcAddr]
+	if !ok {
+		b = geerpc.NewBreaker(*xc.breaker)
+		xc.breakers[rpcAddr] = b
 	}
-	return client.Call(ctx, serviceMethod, args, reply)
+	return b
 }
 
-func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	rpcAddr, err := xc.d.Get(xc.mode)
-	if err != nil {
-		return err
+func (xc *XClient) call(rpcAddr string, ctx context.Context, serviceMethod string, args, reply interface{}, opts []geerpc.CallOption) error {
+	stats := xc.getStats(rpcAddr)
+	defer stats.begin()()
+	// 建立连接失败也计入熔断器，打开之后不会再反复尝试连接这个实例
+	err := xc.getBreaker(rpcAddr).Do(func() error {
+		client, err := xc.dial(rpcAddr)
+		if err != nil {
+			return err
+		}
+		return client.Call(ctx, serviceMethod, args, reply, opts...)
+	})
+	if err != nil && geerpc.IsTransient(err) {
+		xc.unbind(ctx, rpcAddr)
 	}
-	return xc.call(rpcAddr, ctx, serviceMethod, args, reply)
+	return err
 }
 
-func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, reply interface{}) error {
-	servers, err := xc.d.GetAll()
+// Call 按照负载均衡策略选择一个实例调用，失败之后的处理方式由 SetFailMode 设置，
+// opts 可以为这一次调用指定哈希 key、tag、失败处理方式和超时时间
+func (xc *XClient) Call(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...CallOption) error {
+	o := newCallOptions(opts)
+	ctx, cancel := o.context(ctx)
+	defer cancel()
+	switch o.mode(xc) {
+	case Failtry:
+		rpcAddr, err := xc.selectFor(ctx, o)
+		if err != nil {
+			return err
+		}
+		return xc.retry.Do(ctx, serviceMethod, func() error {
+			return xc.call(rpcAddr, ctx, serviceMethod, args, reply, o.callOpts)
+		})
+	case Failfast:
+		rpcAddr, err := xc.selectFor(ctx, o)
+		if err != nil {
+			return err
+		}
+		return xc.call(rpcAddr, ctx, serviceMethod, args, reply, o.callOpts)
+	case Failbackup:
+		return xc.backupCall(ctx, serviceMethod, args, reply, o)
+	default:
+		return xc.retry.Do(ctx, serviceMethod, func() error {
+			rpcAddr, err := xc.selectFor(ctx, o)
+			if err != nil {
+				return err
+			}
+			return xc.call(rpcAddr, ctx, serviceMethod, args, reply, o.callOpts)
+		})
+	}
+}
+
+// Invoke 是带有类型检查的 XClient.Call，和 geerpc.Invoke 相同
+func Invoke[Req, Resp any](ctx context.Context, xc *XClient, serviceMethod string, req Req, opts ...CallOption) (Resp, error) {
+	var resp Resp
+	err := xc.Call(ctx, serviceMethod, req, &resp, opts...)
+	return resp, err
+}
+
+// WithFastestSuccess 让 Broadcast 在第一个调用成功时就返回并取消其他调用，只有全部失败时才返回错误，
+// 默认情况下需要所有实例都调用成功，任意一个失败就取消其他调用并返回这个错误
+func WithFastestSuccess() BroadcastOption {
+	return func(o *callOptions) { o.fastest = true }
+}
+
+// Broadcast 并发调用 Discovery.GetAll 返回的所有实例，reply 为第一个成功调用的结果，
+// 结果确定之后取消还没有完成的调用，使用 WithTag 时只调用带有 tag 的实例
+func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, reply interface{}, opts ...BroadcastOption) error {
+	o := newCallOptions(opts)
+	servers, err := xc.broadcastServers(o.tag)
 	if err != nil {
 		return err
 	}
 	var wg sync.WaitGroup
 	var mu sync.Mutex
 	var e error
+	succeeded := false
 	replyDone := reply == nil
-	ctx, cancel := context.WithCancel(ctx)
+	ctx, cancel := o.context(ctx)
+	defer cancel()
 	for _, rpcAddr := range servers {
 		wg.Add(1)
 		go func(rpcAddr string) {
@@ -86,19 +284,50 @@ func (xc *XClient) Broadcast(ctx context.Context, serviceMethod string, args, re
 			if reply != nil {
 				cloneReply = reflect.New(reflect.ValueOf(reply).Elem().Type()).Interface()
 			}
-			err := xc.call(rpcAddr, ctx, serviceMethod, args, cloneReply)
+			err := xc.call(rpcAddr, ctx, serviceMethod, args, cloneReply, o.callOpts)
 			mu.Lock()
-			if err != nil && e == nil {
-				e = err
+			defer mu.Unlock()
+			if err != nil {
+				// 取消之后其他调用返回的错误不需要记录
+				if e == nil && !succeeded {
+					e = err
+					if !o.fastest {
+						cancel()
+					}
+				}
+				return
+			}
+			if o.fastest && !succeeded {
+				succeeded, e = true, nil
 				cancel()
 			}
-			if err == nil && !replyDone {
+			if !replyDone {
 				reflect.ValueOf(reply).Elem().Set(reflect.ValueOf(cloneReply).Elem())
 				replyDone = true
 			}
-			mu.Unlock()
 		}(rpcAddr)
 	}
 	wg.Wait()
 	return e
 }
+
+// broadcastServers 返回 Broadcast 需要调用的实例，tag 不为空时只返回带有 tag 的实例
+func (xc *XClient) broadcastServers(tag string) ([]string, error) {
+	if tag == "" {
+		return xc.d.GetAll()
+	}
+	infos, err := xc.d.GetAllInfo()
+	if err != nil {
+		return nil, err
+	}
+	var servers []string
+	for _, s := range infos {
+		if s.HasTag(tag) {
+			servers = append(servers, s.Addr)
+		}
+	}
+	if len(servers) == 0 {
+		return nil, errors.New("rpc xclient: no server matches the call options")
+	}
+	return servers, nil
+}
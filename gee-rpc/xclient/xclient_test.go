@@ -0,0 +1,416 @@
+package xclient
+
+import (
+	"context"
+	"errors"
+	"geerpc"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startNode 启动一个只有 Node.Name 方法的服务端，方法等待 delay 之后返回 name，fail 为 true 时返回错误
+func startNode(t *testing.T, name string, delay time.Duration, fail bool) string {
+	return startNodeFunc(t, func(ctx context.Context, _ int, reply *string) error {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if fail {
+			return errors.New(name + " failed")
+		}
+		*reply = name
+		return nil
+	})
+}
+
+func startNodeFunc(t *testing.T, fn func(ctx context.Context, _ int, reply *string) error) string {
+	server := geerpc.NewServer()
+	_ = server.RegisterFunc("Node.Name", fn)
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	t.Cleanup(func() { _ = l.Close() })
+	go server.Accept(l)
+	return "tcp@" + l.Addr().String()
+}
+
+func TestXClient_Broadcast(t *testing.T) {
+	fast := startNode(t, "fast", 0, false)
+	slow := startNode(t, "slow", 2*time.Second, false)
+	broken := startNode(t, "broken", 10*time.Millisecond, true)
+
+	t.Run("all must succeed", func(t *testing.T) {
+		xc := NewXClient(NewMultiServersDiscovery([]string{fast, slow, broken}), RandomSelect, nil)
+		defer func() { _ = xc.Close() }()
+		var reply string
+		start := time.Now()
+		err := xc.Broadcast(context.Background(), "Node.Name", 0, &reply)
+		if err == nil || !strings.Contains(err.Error(), "broken failed") {
+			t.Fatalf("expect the error of broken, got %v", err)
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("outstanding calls should be canceled after the first error")
+		}
+	})
+	t.Run("fastest success", func(t *testing.T) {
+		xc := NewXClient(NewMultiServersDiscovery([]string{broken, slow, fast}), RandomSelect, nil)
+		defer func() { _ = xc.Close() }()
+		var reply string
+		start := time.Now()
+		err := xc.Broadcast(context.Background(), "Node.Name", 0, &reply, WithFastestSuccess())
+		if err != nil || reply != "fast" {
+			t.Fatalf("expect fast, got %q %v", reply, err)
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("outstanding calls should be canceled after the first success")
+		}
+	})
+	t.Run("fastest success all failed", func(t *testing.T) {
+		xc := NewXClient(NewMultiServersDiscovery([]string{broken}), RandomSelect, nil)
+		defer func() { _ = xc.Close() }()
+		var reply string
+		if err := xc.Broadcast(context.Background(), "Node.Name", 0, &reply, WithFastestSuccess()); err == nil {
+			t.Fatal("expect an error when every call fails")
+		}
+	})
+}
+
+// flakyNode 奇数次调用失败，偶数次调用成功，calls 记录调用次数
+func flakyNode(t *testing.T, calls *int32) string {
+	return startNodeFunc(t, func(ctx context.Context, _ int, reply *string) error {
+		if atomic.AddInt32(calls, 1)%2 == 1 {
+			return geerpc.ErrServerBusy
+		}
+		*reply = "ok"
+		return nil
+	})
+}
+
+func TestXClient_FailMode(t *testing.T) {
+	retry := &geerpc.RetryPolicy{MaxAttempts: 2, MinBackoff: time.Millisecond, Idempotent: []string{"Node"}}
+	for _, c := range []struct {
+		mode    FailMode
+		success bool
+		calls   []int32 // 排好序的两个实例的调用次数
+	}{
+		{Failover, false, []int32{1, 1}}, // 每个实例的第一次调用都会失败
+		{Failtry, true, []int32{0, 2}},
+		{Failfast, false, []int32{0, 1}},
+	} {
+		t.Run(c.mode.String(), func(t *testing.T) {
+			var a, b int32
+			xc := NewXClient(NewMultiServersDiscovery([]string{flakyNode(t, &a), flakyNode(t, &b)}), RoundRobinSelect, &geerpc.Option{Retry: retry})
+			defer func() { _ = xc.Close() }()
+			xc.SetFailMode(c.mode)
+			var reply string
+			err := xc.Call(context.Background(), "Node.Name", 0, &reply)
+			if (err == nil) != c.success {
+				t.Fatalf("expect success %v, got %v", c.success, err)
+			}
+			calls := []int32{atomic.LoadInt32(&a), atomic.LoadInt32(&b)}
+			sort.Slice(calls, func(i, j int) bool { return calls[i] < calls[j] })
+			if !reflect.DeepEqual(calls, c.calls) {
+				t.Fatalf("expect calls %v, got %v", c.calls, calls)
+			}
+		})
+	}
+	t.Run("failbackup", func(t *testing.T) {
+		slow := startNode(t, "slow", 2*time.Second, false)
+		fast := startNode(t, "fast", 0, false)
+		xc := NewXClient(NewMultiServersDiscovery([]string{slow, fast}), RoundRobinSelect, nil)
+		defer func() { _ = xc.Close() }()
+		xc.SetHedging(20 * time.Millisecond)
+		start := time.Now()
+		for i := 0; i < 2; i++ {
+			var reply string
+			if err := xc.Call(context.Background(), "Node.Name", 0, &reply); err != nil || reply != "fast" {
+				t.Fatalf("expect fast, got %q %v", reply, err)
+			}
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("backup request should be sent when the first one is slow")
+		}
+		// 轮询时两次调用中至少先选中 slow 的那一次需要对冲，并且是备份请求先返回
+		if stats := xc.HedgeStats(); stats.Requests != 2 || stats.BackupWins < 1 || stats.Hedged < stats.BackupWins {
+			t.Fatalf("unexpected hedge stats %+v", stats)
+		}
+	})
+}
+
+func TestXClient_P2CSelect(t *testing.T) {
+	var slowCalls, fastCalls int32
+	node := func(delay time.Duration, calls *int32) string {
+		return startNodeFunc(t, func(ctx context.Context, _ int, reply *string) error {
+			atomic.AddInt32(calls, 1)
+			time.Sleep(delay)
+			return nil
+		})
+	}
+	d := NewMultiServersDiscovery([]string{node(30*time.Millisecond, &slowCalls), node(0, &fastCalls)})
+	xc := NewXClient(d, P2CSelect, nil)
+	defer func() { _ = xc.Close() }()
+	for i := 0; i < 20; i++ {
+		var reply string
+		if err := xc.Call(context.Background(), "Node.Name", 0, &reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if slow := atomic.LoadInt32(&slowCalls); slow > 2 {
+		t.Fatalf("slow server should rarely be picked, got %d of 20 calls", slow)
+	}
+}
+
+func TestXClient_Watch(t *testing.T) {
+	a, b := startNode(t, "a", 0, false), startNode(t, "b", 0, false)
+	d := NewMultiServersDiscovery([]string{a, b})
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	var reply string
+	if err := xc.Broadcast(context.Background(), "Node.Name", 0, &reply); err != nil {
+		t.Fatal(err)
+	}
+
+	_ = d.Update([]string{b})
+	deadline := time.Now().Add(time.Second)
+	for {
+		xc.mu.Lock()
+		_, ok := xc.clients[a]
+		n := len(xc.clients)
+		xc.mu.Unlock()
+		if !ok && n == 1 {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatal("client of the removed server should be closed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestXClient_Routers(t *testing.T) {
+	a := startNode(t, "a", 0, false)
+	b := startNode(t, "b", 0, false)
+	c := startNode(t, "c", 0, false)
+	d := NewMultiServersDiscovery([]string{a + "?zone=bj&version=v1", b + "?zone=sh&version=v1", c + "?zone=sh&version=v2"})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	count := func(n int) map[string]int {
+		counts := make(map[string]int)
+		for i := 0; i < n; i++ {
+			var reply string
+			if err := xc.Call(context.Background(), "Node.Name", 0, &reply); err != nil {
+				t.Fatal(err)
+			}
+			counts[reply]++
+		}
+		return counts
+	}
+
+	xc.SetRouters(PreferZone("sh"), Only(MatchVersion("v1")))
+	if counts := count(4); counts["b"] != 4 {
+		t.Fatalf("expect all calls to b, got %v", counts)
+	}
+	xc.SetRouters(PreferZone("gz"))
+	if counts := count(6); counts["a"] != 2 || counts["b"] != 2 || counts["c"] != 2 {
+		t.Fatalf("expect calls spread over all servers when no server in the zone, got %v", counts)
+	}
+	xc.SetRouters(Canary(MatchVersion("v2"), 20))
+	if counts := count(500); counts["c"] < 50 || counts["c"] > 150 {
+		t.Fatalf("expect about 20%% of calls to v2, got %v", counts)
+	}
+	xc.SetRouters(Only(MatchTag("gpu")))
+	var reply string
+	if err := xc.Call(context.Background(), "Node.Name", 0, &reply); err == nil {
+		t.Fatal("expect an error when no server matches")
+	}
+}
+
+func TestXClient_Affinity(t *testing.T) {
+	nodes := map[string]string{}
+	var servers []string
+	for _, name := range []string{"a", "b", "c"} {
+		addr := startNode(t, name, 0, false)
+		nodes[name] = addr
+		servers = append(servers, addr)
+	}
+	d := NewMultiServersDiscovery(servers)
+	xc := NewXClient(d, RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	xc.SetAffinity(time.Minute)
+
+	sticky := func(ctx context.Context) string {
+		var first string
+		for i := 0; i < 10; i++ {
+			var reply string
+			if err := xc.Call(ctx, "Node.Name", 0, &reply); err != nil {
+				t.Fatal(err)
+			}
+			if first == "" {
+				first = reply
+			} else if reply != first {
+				t.Fatalf("calls of one session should hit the same server, got %s and %s", first, reply)
+			}
+		}
+		return first
+	}
+	ctx := WithSession(context.Background(), "user-1")
+	bound := sticky(ctx)
+
+	// 绑定的实例被移除之后重新选择，并绑定到新的实例
+	var rest []string
+	for name, addr := range nodes {
+		if name != bound {
+			rest = append(rest, addr)
+		}
+	}
+	_ = d.Update(rest)
+	if rebound := sticky(ctx); rebound == bound {
+		t.Fatalf("session should be rebound after %s is removed", bound)
+	}
+}
+
+func TestXClient_ClientCache(t *testing.T) {
+	a, b := startNode(t, "a", 0, false), startNode(t, "b", 0, false)
+	xc := NewXClient(NewMultiServersDiscovery([]string{a, b}), RandomSelect, nil)
+	xc.SetCleanupInterval(5 * time.Millisecond)
+	var reply string
+	if err := xc.Broadcast(context.Background(), "Node.Name", 0, &reply); err != nil {
+		t.Fatal(err)
+	}
+	clients := func() int {
+		xc.mu.Lock()
+		defer xc.mu.Unlock()
+		return len(xc.clients)
+	}
+	waitFor := func(n int, msg string) {
+		deadline := time.Now().Add(time.Second)
+		for clients() != n {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s, expect %d clients, got %d", msg, n, clients())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// 不可用的 Client 会被清理
+	xc.mu.Lock()
+	_ = xc.clients[a].Close()
+	xc.mu.Unlock()
+	waitFor(1, "unavailable client should be evicted")
+
+	// 空闲超时的 Client 会被清理
+	xc.SetMaxIdle(20 * time.Millisecond)
+	waitFor(0, "idle client should be evicted")
+
+	_ = xc.Close()
+	_ = xc.Close()
+	if err := xc.Call(context.Background(), "Node.Name", 0, &reply); !errors.Is(err, geerpc.ErrShutdown) {
+		t.Fatalf("expect ErrShutdown after Close, got %v", err)
+	}
+	if n := clients(); n != 0 {
+		t.Fatalf("no client should be created after Close, got %d", n)
+	}
+}
+
+func TestXClient_CallOptions(t *testing.T) {
+	a, b, c := startNode(t, "a", 0, false), startNode(t, "b", 0, false), startNode(t, "c", 0, false)
+	slow := startNode(t, "slow", 2*time.Second, false)
+	d := NewMultiServersDiscovery([]string{a + "?tags=gpu", b, c + "?tags=gpu"})
+	xc := NewXClient(d, RoundRobinSelect, nil)
+	defer func() { _ = xc.Close() }()
+	name := func(opts ...CallOption) string {
+		var reply string
+		if err := xc.Call(context.Background(), "Node.Name", 0, &reply, opts...); err != nil {
+			t.Fatal(err)
+		}
+		return reply
+	}
+
+	t.Run("hash key", func(t *testing.T) {
+		owners := make(map[string]string)
+		for i := 0; i < 20; i++ {
+			key := "key-" + string(rune('a'+i))
+			owners[key] = name(WithHashKey(key))
+			for j := 0; j < 3; j++ {
+				if got := name(WithHashKey(key)); got != owners[key] {
+					t.Fatalf("expect %s for %s, got %s", owners[key], key, got)
+				}
+			}
+		}
+		used := make(map[string]bool)
+		for _, owner := range owners {
+			used[owner] = true
+		}
+		if !(len(used) > 1) {
+			t.Fatalf("expect keys to be spread over servers, got %v", used)
+		}
+		// 移除 b 之后原来不在 b 上的 key 不受影响
+		_ = d.Update([]string{a + "?tags=gpu", c + "?tags=gpu"})
+		defer func() { _ = d.Update([]string{a + "?tags=gpu", b, c + "?tags=gpu"}) }()
+		for key, owner := range owners {
+			if got := name(WithHashKey(key)); owner != "b" && got != owner {
+				t.Fatalf("expect %s to stay on %s, got %s", key, owner, got)
+			}
+		}
+	})
+	t.Run("tag", func(t *testing.T) {
+		for i := 0; i < 6; i++ {
+			if got := name(WithTag("gpu")); got == "b" {
+				t.Fatal("expect only servers with tag gpu")
+			}
+		}
+		var reply string
+		err := xc.Call(context.Background(), "Node.Name", 0, &reply, WithTag("tpu"))
+		if !(err != nil) {
+			t.Fatalf("expect an error when no server has the tag")
+		}
+		var calls int32
+		err = xc.Broadcast(context.Background(), "Node.Name", 0, nil, WithTag("gpu"), WithCallOptions(func(*geerpc.Call) {
+			atomic.AddInt32(&calls, 1)
+		}))
+		if err != nil || calls != 2 {
+			t.Fatalf("expect broadcast to the 2 gpu servers, got %d %v", calls, err)
+		}
+	})
+	t.Run("timeout and fail mode", func(t *testing.T) {
+		xc := NewXClient(NewMultiServersDiscovery([]string{slow, a}), RoundRobinSelect, nil)
+		defer func() { _ = xc.Close() }()
+		start := time.Now()
+		for i := 0; i < 2; i++ {
+			var reply string
+			err := xc.Call(context.Background(), "Node.Name", 0, &reply, WithTimeout(50*time.Millisecond))
+			if err == nil && reply != "a" {
+				t.Fatalf("expect a or a timeout, got %q", reply)
+			}
+		}
+		if !(time.Since(start) < time.Second) {
+			t.Fatalf("expect the calls to time out")
+		}
+		// 只在这一次调用上使用 Failbackup，不影响 XClient 的设置
+		xc.SetBackupDelay(20 * time.Millisecond)
+		for i := 0; i < 2; i++ {
+			var reply string
+			if err := xc.Call(context.Background(), "Node.Name", 0, &reply, WithFailMode(Failbackup)); err != nil || reply != "a" {
+				t.Fatalf("expect a, got %q %v", reply, err)
+			}
+		}
+		if !(time.Since(start) < time.Second) {
+			t.Fatalf("expect the backup request to return first")
+		}
+		if !(xc.HedgeStats().Requests == 2) {
+			t.Fatalf("expect 2 hedged calls, got %+v", xc.HedgeStats())
+		}
+	})
+}
+
+func TestInvoke(t *testing.T) {
+	xc := NewXClient(NewMultiServersDiscovery([]string{startNode(t, "a", 0, false)}), RandomSelect, nil)
+	defer func() { _ = xc.Close() }()
+	name, err := Invoke[int, string](context.Background(), xc, "Node.Name", 0)
+	if err != nil || name != "a" {
+		t.Fatalf("expect a, got %q %v", name, err)
+	}
+}
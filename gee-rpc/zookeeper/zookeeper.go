@@ -0,0 +1,263 @@
+// Package zookeeper 使用 ZooKeeper 做服务注册和发现，服务端使用 Register 在 /geerpc/<service> 下
+// 创建临时节点，会话断开之后节点自动删除，客户端使用 ZooKeeperDiscovery 监听子节点的变化更新服务列表
+//
+// 节点名是经过 url.PathEscape 转义的服务地址，地址格式和 XDial 相同，可以带 ?weight=N 设置权重
+package zookeeper
+
+import (
+	"errors"
+	"geerpc"
+	"geerpc/xclient"
+	"net/url"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+)
+
+// RootPath 是所有服务的父节点
+const RootPath = "/geerpc"
+
+// retryInterval 是监听或者注册失败之后重试的间隔
+const retryInterval = time.Second
+
+// ServicePath 返回 service 的节点路径
+func ServicePath(service string) string {
+	return path.Join(RootPath, service)
+}
+
+// ZooKeeperDiscovery 监听 /geerpc/<service> 的子节点，子节点变化之后更新服务列表
+type ZooKeeperDiscovery struct {
+	*xclient.MultiServersDiscovery
+	conn *zk.Conn
+	path string
+	stop chan struct{}
+	done chan struct{}
+
+	mu     sync.Mutex
+	logger geerpc.Logger
+}
+
+var _ xclient.Discovery = (*ZooKeeperDiscovery)(nil)
+
+// NewZooKeeperDiscovery 读取 service 的实例并开始监听，读取失败时返回错误，使用完之后需要调用 Close，
+// 服务节点还不存在时服务列表为空，节点创建之后开始更新
+func NewZooKeeperDiscovery(conn *zk.Conn, service string) (*ZooKeeperDiscovery, error) {
+	d := &ZooKeeperDiscovery{
+		MultiServersDiscovery: xclient.NewMultiServersDiscovery(nil),
+		conn:                  conn,
+		path:                  ServicePath(service),
+		stop:                  make(chan struct{}),
+		done:                  make(chan struct{}),
+	}
+	ch, err := d.load()
+	if err != nil {
+		return nil, err
+	}
+	go d.watch(ch)
+	return d, nil
+}
+
+// Refresh 重新读取子节点，正常情况下服务列表由监听更新，不需要调用
+func (d *ZooKeeperDiscovery) Refresh() error {
+	children, _, err := d.conn.Children(d.path)
+	if err != nil && !errors.Is(err, zk.ErrNoNode) {
+		return err
+	}
+	d.update(children)
+	return nil
+}
+
+// SetLogger 设置输出监听错误使用的 Logger，默认使用 geerpc.DefaultLogger
+func (d *ZooKeeperDiscovery) SetLogger(logger geerpc.Logger) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.logger = logger
+}
+
+func (d *ZooKeeperDiscovery) getLogger() geerpc.Logger {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.logger == nil {
+		return geerpc.DefaultLogger
+	}
+	return d.logger
+}
+
+// Close 停止监听，不会关闭 ZooKeeper 的连接
+func (d *ZooKeeperDiscovery) Close() error {
+	close(d.stop)
+	<-d.done
+	return nil
+}
+
+// load 读取子节点并设置监听，服务节点不存在时监听它的创建
+func (d *ZooKeeperDiscovery) load() (<-chan zk.Event, error) {
+	children, _, ch, err := d.conn.ChildrenW(d.path)
+	if errors.Is(err, zk.ErrNoNode) {
+		var exists bool
+		exists, _, ch, err = d.conn.ExistsW(d.path)
+		if err == nil && exists {
+			// 检查期间节点被创建了，重新读取
+			return d.load()
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	d.update(children)
+	return ch, nil
+}
+
+func (d *ZooKeeperDiscovery) update(children []string) {
+	servers := make([]string, 0, len(children))
+	for _, child := range children {
+		addr, err := url.PathUnescape(child)
+		if err != nil {
+			d.getLogger().Errorf("rpc zookeeper: invalid node name: %s", child)
+			continue
+		}
+		servers = append(servers, addr)
+	}
+	sort.Strings(servers) // 保持轮询的顺序稳定
+	_ = d.MultiServersDiscovery.Update(servers)
+}
+
+// watch ZooKeeper 的监听只触发一次，每次触发之后重新读取并设置监听，会话过期时也会触发
+func (d *ZooKeeperDiscovery) watch(ch <-chan zk.Event) {
+	defer close(d.done)
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ch:
+		}
+		for {
+			var err error
+			if ch, err = d.load(); err == nil {
+				break
+			}
+			d.getLogger().Errorf("rpc zookeeper: watch children error: %v", err)
+			select {
+			case <-d.stop:
+				return
+			case <-time.After(retryInterval):
+			}
+		}
+	}
+}
+
+// Registration 表示一个注册在 ZooKeeper 中的实例，Close 之前节点被删除（比如会话过期）时会重新创建
+type Registration struct {
+	conn *zk.Conn
+	path string
+	addr string
+	stop chan struct{}
+	done chan struct{}
+
+	mu     sync.Mutex
+	logger geerpc.Logger
+}
+
+// Register 在 /geerpc/<service> 下为 addr 创建临时节点，父节点不存在时自动创建，
+// 服务端退出之前调用 Close 删除节点
+func Register(conn *zk.Conn, service, addr string) (*Registration, error) {
+	r := &Registration{
+		conn: conn,
+		path: path.Join(ServicePath(service), url.PathEscape(addr)),
+		addr: addr,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	ch, err := r.register()
+	if err != nil {
+		return nil, err
+	}
+	go r.keep(ch)
+	return r, nil
+}
+
+// register 创建父节点和临时节点，并监听临时节点的删除
+func (r *Registration) register() (<-chan zk.Event, error) {
+	if err := createParents(r.conn, path.Dir(r.path)); err != nil {
+		return nil, err
+	}
+	_, err := r.conn.Create(r.path, []byte(r.addr), zk.FlagEphemeral, zk.WorldACL(zk.PermAll))
+	if err != nil && !errors.Is(err, zk.ErrNodeExists) {
+		return nil, err
+	}
+	exists, _, ch, err := r.conn.ExistsW(r.path)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return r.register()
+	}
+	return ch, nil
+}
+
+// createParents 依次创建 p 以及它的所有父节点
+func createParents(conn *zk.Conn, p string) error {
+	if p == "/" {
+		return nil
+	}
+	if err := createParents(conn, path.Dir(p)); err != nil {
+		return err
+	}
+	if _, err := conn.Create(p, nil, 0, zk.WorldACL(zk.PermAll)); err != nil && !errors.Is(err, zk.ErrNodeExists) {
+		return err
+	}
+	return nil
+}
+
+// SetLogger 设置输出注册错误使用的 Logger，默认使用 geerpc.DefaultLogger
+func (r *Registration) SetLogger(logger geerpc.Logger) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.logger = logger
+}
+
+func (r *Registration) getLogger() geerpc.Logger {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.logger == nil {
+		return geerpc.DefaultLogger
+	}
+	return r.logger
+}
+
+func (r *Registration) keep(ch <-chan zk.Event) {
+	defer close(r.done)
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ch:
+			// 节点被删除、数据变化或者会话过期都会触发，register 会在节点不存在时重新创建并重新监听
+		}
+		for {
+			var err error
+			if ch, err = r.register(); err == nil {
+				break
+			}
+			r.getLogger().Errorf("rpc zookeeper: register error: %v", err)
+			select {
+			case <-r.stop:
+				return
+			case <-time.After(retryInterval):
+			}
+		}
+	}
+}
+
+// Close 停止维护注册并删除临时节点
+func (r *Registration) Close() error {
+	close(r.stop)
+	<-r.done
+	if err := r.conn.Delete(r.path, -1); err != nil && !errors.Is(err, zk.ErrNoNode) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package zookeeper
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+)
+
+// 需要一个可用的 ZooKeeper，通过 GEERPC_ZK_SERVERS 设置地址，多个地址用逗号分隔
+func newTestConn(t *testing.T) *zk.Conn {
+	servers := os.Getenv("GEERPC_ZK_SERVERS")
+	if servers == "" {
+		t.Skip("GEERPC_ZK_SERVERS is not set")
+	}
+	conn, _, err := zk.Connect(strings.Split(servers, ","), 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(conn.Close)
+	return conn
+}
+
+func TestZooKeeper(t *testing.T) {
+	conn := newTestConn(t)
+	service := "test-" + t.Name()
+
+	d, err := NewZooKeeperDiscovery(conn, service)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = d.Close() }()
+
+	waitFor := func(expect string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			all, _ := d.GetAll()
+			if got := strings.Join(all, ","); got == expect {
+				return
+			} else if time.Now().After(deadline) {
+				t.Fatalf("expect %q, got %q", expect, got)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	waitFor("")
+
+	a, err := Register(conn, service, "tcp@a:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = a.Close() }()
+	waitFor("tcp@a:1")
+
+	b, err := Register(conn, service, "unix@/tmp/geerpc.sock?weight=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor("tcp@a:1,unix@/tmp/geerpc.sock")
+
+	// 节点被意外删除之后会重新创建
+	_ = conn.Delete(a.path, -1)
+	time.Sleep(100 * time.Millisecond)
+	waitFor("tcp@a:1,unix@/tmp/geerpc.sock")
+
+	_ = b.Close()
+	waitFor("tcp@a:1")
+}